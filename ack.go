@@ -0,0 +1,126 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// pendingAck is a commit which failed and is being retried in the background.
+type pendingAck struct {
+	// offset is the offset to commit, covering every earlier failed commit of the same partition.
+	offset uint64
+	// count is the number of events handled up to offset.
+	count int
+	// attempts is the number of times committing offset has failed.
+	attempts int
+}
+
+// WithAckRetries controls what happens when committing the offset of a handled event fails. Acks are cumulative, so
+// failed commits are retried in the background every interval, along with later commits of the same partition, while
+// the subscription moves on. Failure is only reported to the EventErrHandler once a commit has been attempted attempts
+// times. Setting attempts to 1 reports failures right away and stops consuming the partition, for consumers which
+// would rather stop than risk redelivering events. The default is 5 attempts, one second apart.
+func WithAckRetries(attempts int, interval time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.ackAttempts = attempts
+		o.ackBackoff = ConstantBackoff(interval)
+	}
+}
+
+// retryAck records that committing offset in the partition of topic failed, and makes sure it is retried.
+func (s *Subscription) retryAck(topic string, partition uint32, offset uint64, count int) {
+	tp := topicPartition{topic: topic, partition: partition}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.pendingAcks[tp]; ok {
+		if offset > p.offset {
+			p.offset = offset
+		}
+		p.count += count
+		p.attempts++
+	} else {
+		s.pendingAcks[tp] = &pendingAck{offset: offset, count: count, attempts: 1}
+	}
+	if !s.retryingAcks {
+		s.retryingAcks = true
+		s.goroutine(s.retryAcks)
+	}
+}
+
+// acked records that offset has been committed in the partition of topic, which covers pending commits of lower
+// offsets.
+func (s *Subscription) acked(topic string, partition uint32, offset uint64) {
+	tp := topicPartition{topic: topic, partition: partition}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.pendingAcks[tp]; ok && p.offset <= offset {
+		atomic.AddUint64(&s.processed, uint64(p.count))
+		delete(s.pendingAcks, tp)
+	}
+}
+
+// retryAcks periodically retries pending commits until there are none left, reporting those which failed too many
+// times.
+func (s *Subscription) retryAcks() {
+	for retry := 1; ; retry++ {
+		if err := backoffWait(s.ctx, s.options.ackBackoff, retry); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if len(s.pendingAcks) == 0 {
+			s.retryingAcks = false
+			s.mu.Unlock()
+			return
+		}
+		offsets := make(map[topicPartition]uint64, len(s.pendingAcks))
+		for tp, p := range s.pendingAcks {
+			offsets[tp] = p.offset
+		}
+		s.mu.Unlock()
+
+		for tp, offset := range offsets {
+			err := s.ack(s.ctx, tp.topic, tp.partition, offset)
+			if err == nil {
+				s.acked(tp.topic, tp.partition, offset)
+				s.recordCommitted(tp.topic, tp.partition, offset)
+				continue
+			}
+			if s.ctx.Err() != nil {
+				return
+			}
+			s.mu.Lock()
+			p, ok := s.pendingAcks[tp]
+			exhausted := ok && p.attempts+1 >= s.options.ackAttempts
+			if exhausted {
+				delete(s.pendingAcks, tp)
+			} else if ok {
+				p.attempts++
+			}
+			s.mu.Unlock()
+			if exhausted {
+				err = fmt.Errorf("failed to commit offset %d of partition %d of topic %q after %d attempts: %w", offset, tp.partition, tp.topic, s.options.ackAttempts, err)
+				s.fail(s.errs, s.errorAt(PhaseAck, tp.topic, tp.partition, offset, err))
+			} else {
+				s.client.log.Info("unable to commit offset, retrying", "topic", tp.topic, "group", s.group, "partition", tp.partition, "offset", offset, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// ResetOffsets moves the position of a consumer group in the given partitions of the stream, so that the next message
+// read by the group from each partition is the one at the given offset. Members of the group should be stopped while
+// their offsets are reset, as active subscriptions keep reading from where they are. The group version is the one set
+// by WithGroupVersion among opts, which are otherwise ignored, so that the options the group subscribes with may be
+// passed as is.
+//
+// The position of a group is the offset it last committed, hence it can't be moved back to offset 0: resetting any
+// partition to offset 0 fails with ErrResetToStart, without resetting the other partitions. ResetOffsetsToEarliest
+// moves the group to a new group version instead. Offsets are committed one partition at a time: if some can't be,
+// the others are still committed and a ResetError tells which partitions were reset.
+func (lc *StreamClient) ResetOffsets(ctx context.Context, group string, offsets map[uint32]uint64, opts ...SubscribeOption) error {
+	commits := make(map[uint32]uint64, len(offsets))
+	for partition, offset := range offsets {
+		if offset == 0 {
+			return fmt.Errorf("partition %d of group %q: %w", partition, group, ErrResetToStart)
+		}
+		commits[partition] = offset - 1
+	}
+	return lc.commitOffsets(ctx, group, newSubscribeOptions(opts).groupVersion, commits)
+}
+
+// commitOffsets commits the given offsets of version of group, in every partition even if some fail, returning a
+// ResetError if any did.
+func (lc *StreamClient) commitOffsets(ctx context.Context, group string, version uint32, offsets map[uint32]uint64) error {
+	partitions := make([]uint32, 0, len(offsets))
+	for partition := range offsets {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	resetErr := &ResetError{Group: group}
+	for _, partition := range partitions {
+		if err := lc.ack(ctx, group, version, partition, offsets[partition]); err != nil {
+			if resetErr.Failed == nil {
+				resetErr.Failed = make(map[uint32]error)
+			}
+			resetErr.Failed[partition] = err
+		} else {
+			resetErr.Reset = append(resetErr.Reset, partition)
+		}
+	}
+	if resetErr.Failed != nil {
+		return resetErr
+	}
+	return nil
+}
+
+// ack commits offset in partition of the stream for the given version of group.
+func (lc *StreamClient) ack(ctx context.Context, group string, version uint32, partition uint32, offset uint64) error {
+	request := liiklus.AckRequest{
+		Topic:        lc.TopicName,
+		Group:        group,
+		GroupVersion: version,
+		Partition:    partition,
+		Offset:       offset,
+	}
+	_, err := lc.client.Ack(ctx, &request)
+	return gatewayError("ack", err)
+}
+
+// ResetOffsetsToEarliest moves the position of a consumer group to the beginning of every partition of the stream. As
+// positions can't be moved back to offset 0 in the group version the group reads with, see ResetOffsets, the group is
+// moved to a new version instead: the first version above the one set by WithGroupVersion among opts which has no
+// committed offset. It returns that version, which members of the group must then subscribe with, from the beginning.
+func (lc *StreamClient) ResetOffsetsToEarliest(ctx context.Context, group string, opts ...SubscribeOption) (uint32, error) {
+	return lc.resetToNewVersion(ctx, group, nil, opts)
+}
+
+// resetToNewVersion commits the given offsets of group in the first version above the one set among opts which has no
+// committed offset, leaving the partitions reset to offset 0 without any, and returns that version.
+func (lc *StreamClient) resetToNewVersion(ctx context.Context, group string, offsets map[uint32]uint64, opts []SubscribeOption) (uint32, error) {
+	version := newSubscribeOptions(opts).groupVersion
+	for {
+		if version == math.MaxUint32 {
+			return 0, fmt.Errorf("group %q: no group version left to reset offsets to: %w", group, ErrResetToStart)
+		}
+		version++
+		committed, err := lc.committedOffsets(ctx, lc.TopicName, group, version)
+		if err != nil {
+			return 0, err
+		}
+		if len(committed) == 0 {
+			break
+		}
+	}
+	commits := make(map[uint32]uint64, len(offsets))
+	for partition, offset := range offsets {
+		if offset > 0 {
+			commits[partition] = offset - 1
+		}
+	}
+	return version, lc.commitOffsets(ctx, group, version, commits)
+}
+
+// ResetOffsetsToLatest moves the position of a consumer group to the end of every partition of the stream, skipping
+// every message published so far. See ResetOffsets for opts.
+func (lc *StreamClient) ResetOffsetsToLatest(ctx context.Context, group string, opts ...SubscribeOption) error {
+	ends, err := lc.endOffsets(ctx, lc.TopicName)
+	if err != nil {
+		return err
+	}
+	offsets := make(map[uint32]uint64, len(ends))
+	for partition, end := range ends {
+		offsets[partition] = end + 1
+	}
+	return lc.ResetOffsets(ctx, group, offsets, opts...)
+}
+
+// ResetOffsetsToTime moves the position of a consumer group in every partition of the stream to the first message
+// recorded at or after t. As for OffsetsForTime, partitions are scanned from the beginning. It returns the group
+// version members of the group must then subscribe with: the one set by WithGroupVersion among opts, unless some
+// partition is reset to offset 0, in which case the group is moved to a new version as by ResetOffsetsToEarliest, to
+// be subscribed with from the beginning. See ResetOffsets for partial resets.
+func (lc *StreamClient) ResetOffsetsToTime(ctx context.Context, group string, t time.Time, opts ...SubscribeOption) (uint32, error) {
+	offsets, err := lc.offsetsForTime(ctx, lc.TopicName, t)
+	if err != nil {
+		return 0, err
+	}
+	for _, offset := range offsets {
+		if offset == 0 {
+			return lc.resetToNewVersion(ctx, group, offsets, opts)
+		}
+	}
+	return newSubscribeOptions(opts).groupVersion, lc.ResetOffsets(ctx, group, offsets, opts...)
+}
+
+// OffsetsDocument is the JSON representation of the offsets committed by a consumer group, as written by ExportOffsets.
+type OffsetsDocument struct {
+	// Topic is the name of the liiklus topic the offsets were committed to.
+	Topic string `json:"topic"`
+	// Group is the consumer group that committed the offsets.
+	Group string `json:"group"`
+	// Offsets are the last offsets committed in each partition.
+	Offsets map[uint32]uint64 `json:"offsets"`
+}
+
+// ExportOffsets writes the offsets committed by a consumer group to w, as a JSON OffsetsDocument. Along with
+// ImportOffsets, this supports blue/green migrations of consumers and disaster recovery runbooks. The group version is
+// the one set by WithGroupVersion among opts, as for ResetOffsets.
+func (lc *StreamClient) ExportOffsets(ctx context.Context, group string, w io.Writer, opts ...SubscribeOption) error {
+	offsets, err := lc.committedOffsets(ctx, lc.TopicName, group, newSubscribeOptions(opts).groupVersion)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(OffsetsDocument{
+		Topic:   lc.TopicName,
+		Group:   group,
+		Offsets: offsets,
+	})
+}
+
+// ImportOffsets commits the offsets of the JSON OffsetsDocument read from r on behalf of group, or of the group the
+// offsets were exported from if group is empty. The document must have been exported from the same topic. The group
+// version is the one set by WithGroupVersion among opts, and partial imports are reported, as for ResetOffsets.
+func (lc *StreamClient) ImportOffsets(ctx context.Context, r io.Reader, group string, opts ...SubscribeOption) error {
+	var doc OffsetsDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return err
+	}
+	if doc.Topic != lc.TopicName {
+		return fmt.Errorf("offsets were exported from topic %q, not %q", doc.Topic, lc.TopicName)
+	}
+	if group == "" {
+		group = doc.Group
+	}
+	return lc.commitOffsets(ctx, group, newSubscribeOptions(opts).groupVersion, doc.Offsets)
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"strings"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// Headers named after the CloudEvents attributes of the binary content mode of the HTTP binding set the corresponding
+// attribute of published events, which liiklus carries as first-class event metadata, rather than being carried as
+// extensions. This lets events received over HTTP be republished without double-encoding their attributes.
+const (
+	IDHeader     = "ce-id"
+	SourceHeader = "ce-source"
+	TypeHeader   = "ce-type"
+	TimeHeader   = "ce-time"
+)
+
+// setAttribute sets the attribute of event designated by header to value, reporting whether header designates one.
+// The header name is case-insensitive.
+func setAttribute(event *liiklus.LiiklusEvent, header string, value string) bool {
+	switch strings.ToLower(header) {
+	case IDHeader:
+		event.Id = value
+	case SourceHeader:
+		event.Source = value
+	case TypeHeader:
+		event.Type = value
+	case TimeHeader:
+		event.Time = value
+	default:
+		return false
+	}
+	return true
+}
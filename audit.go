@@ -0,0 +1,115 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// auditWindow is the number of event IDs whose deliveries are counted by a delivery audit.
+const auditWindow = 10000
+
+// Delivery describes the delivery of an event to a subscription, as recorded by a DeliveryAuditor.
+type Delivery struct {
+	// EventID is the ID of the event, or empty for records which don't hold an event.
+	EventID string
+	Topic   string
+	// Group is the consumer group of the subscription, or empty for anonymous subscriptions.
+	Group     string
+	Partition uint32
+	Offset    uint64
+	// DeliveryCount is the number of times the event has been delivered to the subscription, including this one. Events
+	// are told apart by their ID, among the last 10000 IDs delivered, so that copies of an event published more than
+	// once are counted along with redeliveries of the same offset. Deliveries of events without an ID are counted by
+	// offset, as for Message.Attempt. A count greater than 1 denotes a duplicate.
+	DeliveryCount int
+	// Time is the time of the delivery.
+	Time time.Time
+}
+
+// DeliveryAuditor records the deliveries of events, for example to a log, a metrics system or a table, so that
+// duplicate deliveries can be quantified and idempotency assumptions validated in production. Implementations must be
+// safe for concurrent use and should not block, as they are called before each message is handed over to the handler.
+type DeliveryAuditor interface {
+	RecordDelivery(d Delivery)
+}
+
+// DeliveryAuditorFunc adapts a function to a DeliveryAuditor.
+type DeliveryAuditorFunc func(d Delivery)
+
+func (f DeliveryAuditorFunc) RecordDelivery(d Delivery) {
+	f(d)
+}
+
+// WithDeliveryAudit records every delivery of a message to the subscription with a, including the deliveries of
+// messages later dropped as duplicates by WithDeduplication or rejected by filters.
+func WithDeliveryAudit(a DeliveryAuditor) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.auditor = a
+	}
+}
+
+// deliveryCounts counts the deliveries of a bounded number of event IDs, forgetting the oldest ones first.
+type deliveryCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+	ring   []string
+	next   int
+}
+
+func newDeliveryCounts(size int) *deliveryCounts {
+	return &deliveryCounts{
+		counts: make(map[string]int, size),
+		ring:   make([]string, size),
+	}
+}
+
+// add records a delivery of id, returning the number of deliveries of id so far.
+func (c *deliveryCounts) add(id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.counts[id]; ok {
+		c.counts[id] = n + 1
+		return n + 1
+	}
+	delete(c.counts, c.ring[c.next])
+	c.ring[c.next] = id
+	c.counts[id] = 1
+	c.next = (c.next + 1) % len(c.ring)
+	return 1
+}
+
+// audit records the delivery of msg to group with the auditor, if any.
+func (o *subscribeOptions) audit(group string, msg Message) {
+	if o.auditor == nil {
+		return
+	}
+	count := msg.Attempt
+	if msg.ID != "" {
+		count = o.deliveries.add(msg.ID)
+	}
+	o.auditor.RecordDelivery(Delivery{
+		EventID:       msg.ID,
+		Topic:         msg.Topic,
+		Group:         group,
+		Partition:     msg.Partition,
+		Offset:        msg.Offset,
+		DeliveryCount: count,
+		Time:          time.Now(),
+	})
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+)
+
+// Backoff computes how long to wait before retrying an operation which failed. The same strategies are used to retry
+// connecting to the gateway (WithDialBackoff), publishing (WithPublishRetries), committing offsets (WithAckBackoff),
+// handling events (RetryMiddleware) and reopening receive streams (WithReconnectBackoff), each configured on its own.
+// Implementations must be safe for concurrent use.
+type Backoff interface {
+	// Delay returns the time to wait before the given retry, the first retry being 1.
+	Delay(retry int) time.Duration
+}
+
+// ConstantBackoff waits the same time before each retry.
+type ConstantBackoff time.Duration
+
+func (b ConstantBackoff) Delay(retry int) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff waits Initial before the first retry, and Multiplier times longer before each subsequent one, up
+// to Max. Delays are randomized by up to Jitter times their value either way, so that clients failing together don't
+// retry in lockstep.
+type ExponentialBackoff struct {
+	// Initial is the delay before the first retry.
+	Initial time.Duration
+	// Max caps delays, if positive.
+	Max time.Duration
+	// Multiplier is the factor delays grow by, 2 if not set.
+	Multiplier float64
+	// Jitter is the fraction of delays to randomize them by, between 0 and 1.
+	Jitter float64
+}
+
+func (b ExponentialBackoff) Delay(retry int) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	delay := float64(b.Initial) * math.Pow(multiplier, float64(retry-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	delay *= 1 + b.Jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
+
+// FibonacciBackoff waits Unit times the retry-th number of the Fibonacci sequence before each retry, up to Max. Delays
+// grow slower than with an ExponentialBackoff doubling them.
+type FibonacciBackoff struct {
+	// Unit is the delay before the first and second retries.
+	Unit time.Duration
+	// Max caps delays, if positive.
+	Max time.Duration
+}
+
+func (b FibonacciBackoff) Delay(retry int) time.Duration {
+	previous, current := time.Duration(0), b.Unit
+	for i := 1; i < retry; i++ {
+		previous, current = current, previous+current
+		if b.Max > 0 && current > b.Max || current < previous {
+			// also stop before overflowing
+			break
+		}
+	}
+	if b.Max > 0 && current > b.Max {
+		return b.Max
+	}
+	return current
+}
+
+// backoffWait waits the delay b computes before retry, unless ctx is done first, in which case its error is returned.
+func backoffWait(ctx context.Context, b Backoff, retry int) error {
+	timer := time.NewTimer(b.Delay(retry))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WithDialBackoff configures how gRPC waits between attempts at connecting to the gateway, both when the client is
+// created and when the connection has to be re-established. gRPC only supports exponential backoff.
+func WithDialBackoff(b ExponentialBackoff) ClientOption {
+	return WithDialOptions(grpc.WithConnectParams(grpc.ConnectParams{
+		Backoff: backoff.Config{
+			BaseDelay:  b.Initial,
+			Multiplier: b.Multiplier,
+			Jitter:     b.Jitter,
+			MaxDelay:   b.Max,
+		},
+	}))
+}
+
+// WithPublishRetries retries publishing events, up to attempts attempts in total, when the gateway is unavailable,
+// waiting the delays computed by b in between. Publishing is not retried by default, as it doesn't know whether the
+// gateway stored the event before becoming unavailable: retrying may duplicate it.
+func WithPublishRetries(attempts int, b Backoff) ClientOption {
+	return func(lc *StreamClient) {
+		lc.publishAttempts = attempts
+		lc.publishBackoff = b
+	}
+}
+
+// WithAckBackoff retries failed commits with the delays computed by b rather than at a constant interval, see
+// WithAckRetries.
+func WithAckBackoff(b Backoff) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.ackBackoff = b
+	}
+}
+
+// WithReconnectBackoff reopens the receive stream of a partition when the gateway becomes unavailable, instead of
+// failing the subscription, waiting the delays computed by b in between. Failure is reported once the stream failed
+// to be reopened attempts times in a row. Reading resumes after the last message received.
+func WithReconnectBackoff(attempts int, b Backoff) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.reconnectAttempts = attempts
+		o.reconnectBackoff = b
+	}
+}
+
+// RetryMiddleware returns a Middleware invoking the handler again when it fails, up to attempts attempts in total,
+// waiting the delays computed by b in between. The last error is returned once attempts are exhausted. Retrying stops
+// when the context of the handler is done.
+func RetryMiddleware(attempts int, b Backoff) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg Message) error {
+			err := next(ctx, msg)
+			for retry := 1; err != nil && retry < attempts; retry++ {
+				if waitErr := backoffWait(ctx, b, retry); waitErr != nil {
+					return err
+				}
+				err = next(ctx, msg)
+			}
+			return err
+		}
+	}
+}
+
+// isReceiveUnavailable reports whether err was returned for a receive stream the gateway could not serve.
+func isReceiveUnavailable(err error) bool {
+	var gatewayErr *GatewayError
+	return errors.As(err, &gatewayErr) && gatewayErr.Op == "receive" && errors.Is(gatewayErr, ErrGatewayUnavailable)
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchHandler is a function to process several messages read from the stream at once and is passed as a parameter
+// to the SubscribeBatch call. The messages are acknowledged as a unit, once the handler returns without error.
+type BatchHandler = func(ctx context.Context, msgs []Message) error
+
+// SubscribeBatch is like Subscribe, but hands messages over to the BatchHandler in batches of up to maxSize messages.
+// A batch is handled early if maxWait has elapsed since its first message was read. As batches hold messages of every
+// partition, a batch failing cancels the subscription, its error being reported by Err, so that the messages of the
+// failed batch are read again once subscribing again.
+func (lc *StreamClient) SubscribeBatch(ctx context.Context, group string, fromBeginning bool, maxSize int, maxWait time.Duration, f BatchHandler, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	if maxSize < 1 {
+		return nil, fmt.Errorf("batch size must be positive, was %d", maxSize)
+	}
+	options := newSubscribeOptions(opts)
+	b := &batcher{
+		maxSize: maxSize,
+		maxWait: maxWait,
+		handler: f,
+		errs:    e,
+		options: options,
+	}
+	return lc.subscribe(ctx, []string{lc.TopicName}, group, fromBeginning, b.consume, e, options)
+}
+
+// batcher accumulates messages from all partitions of a subscription and hands them over to a BatchHandler.
+type batcher struct {
+	maxSize int
+	maxWait time.Duration
+	handler BatchHandler
+	errs    EventErrHandler
+	options *subscribeOptions
+
+	mu   sync.Mutex
+	msgs []Message
+	// filtered are the highest offsets of messages rejected by filters, deduplication or validation while a batch was
+	// pending, to be committed along with the batch.
+	filtered map[topicPartition]uint64
+	// timer flushes the current batch once maxWait has elapsed, if it is not full by then.
+	timer *time.Timer
+	// failed is set once a batch failed, after which no message is handed over or committed anymore.
+	failed bool
+}
+
+func (b *batcher) consume(ctx context.Context, sub *Subscription, msg Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failed {
+		// the subscription is being cancelled, committing any offset could skip the messages of the failed batch
+		sub.release(1)
+		return errStreamInterrupted
+	}
+	if filtered := !b.options.accept(msg); sub.reportUndecodable(ctx, msg) || filtered || b.options.duplicate(msg) || b.options.expired(msg) || sub.reportInvalid(msg) {
+		defer sub.release(1)
+		if filtered && !b.options.ackFiltered {
+			return nil
+		}
+		if len(b.msgs) == 0 {
+			return sub.commit(ctx, msg.Topic, msg.Partition, msg.Offset, 0)
+		}
+		// committing now would also commit the pending messages of the batch, so filtered, duplicate, expired,
+		// undecodable and invalid messages are committed along with it
+		if b.filtered == nil {
+			b.filtered = make(map[topicPartition]uint64)
+		}
+		b.filtered[topicPartition{topic: msg.Topic, partition: msg.Partition}] = msg.Offset
+		return nil
+	}
+	b.msgs = append(b.msgs, msg)
+	if len(b.msgs) >= b.maxSize {
+		b.stopTimer(sub)
+		return b.flush(ctx, sub)
+	}
+	if b.timer == nil {
+		// keep the subscription alive until the timer has fired, so that draining handles the partial batch
+		sub.wg.Add(1)
+		b.timer = time.AfterFunc(b.maxWait, func() {
+			defer sub.wg.Done()
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.timer = nil
+			if ctx.Err() != nil || b.failed || len(b.msgs) == 0 {
+				return
+			}
+			if err := b.flush(ctx, sub); err != nil {
+				sub.fail(b.errs, err)
+			}
+		})
+	}
+	return nil
+}
+
+// stopTimer disarms the timer, if any. It must be called with mu held.
+func (b *batcher) stopTimer(sub *Subscription) {
+	if b.timer != nil && b.timer.Stop() {
+		sub.wg.Done()
+	}
+	b.timer = nil
+}
+
+// flush hands the current batch over to the handler and commits the highest offset of each partition it contains.
+// As a batch holds messages of several partitions, a failure cancels the whole subscription rather than the partition
+// flushing it, so that no partition commits offsets past the messages of the failed batch. It must be called with mu
+// held.
+func (b *batcher) flush(ctx context.Context, sub *Subscription) error {
+	if err := b.handOver(ctx, sub); err != nil {
+		b.failed = true
+		sub.mu.Lock()
+		if sub.err == nil {
+			sub.err = err
+		}
+		sub.mu.Unlock()
+		sub.cancel()
+		return err
+	}
+	return nil
+}
+
+// handOver hands the current batch over to the handler and commits it. It must be called with mu held.
+func (b *batcher) handOver(ctx context.Context, sub *Subscription) error {
+	msgs, filtered := b.msgs, b.filtered
+	b.msgs, b.filtered = nil, nil
+	defer sub.release(len(msgs))
+	offsets := make(map[topicPartition]uint64)
+	counts := make(map[topicPartition]int)
+	for _, msg := range msgs {
+		tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+		if offset, ok := offsets[tp]; !ok || msg.Offset > offset {
+			offsets[tp] = msg.Offset
+		}
+		counts[tp]++
+	}
+	for tp, offset := range filtered {
+		if offset > offsets[tp] {
+			offsets[tp] = offset
+		}
+	}
+	if b.options.atMostOnce {
+		for tp, offset := range offsets {
+			if err := sub.commit(ctx, tp.topic, tp.partition, offset, 0); err != nil {
+				return err
+			}
+		}
+	}
+	for _, msg := range msgs {
+		b.options.observeLatency(msg)
+	}
+	if err := b.options.handle(ctx, func(ctx context.Context) error {
+		return sub.instrument(ctx, msgs, func(ctx context.Context) error {
+			return b.handler(ctx, msgs)
+		})
+	}); err != nil {
+		return sub.errorAt(PhaseHandle, msgs[0].Topic, msgs[0].Partition, msgs[0].Offset, err)
+	}
+	for _, msg := range msgs {
+		b.options.handled(msg)
+		sub.recordHandled(msg)
+	}
+	if b.options.atMostOnce {
+		sub.handled(len(msgs))
+		return nil
+	}
+	for tp, offset := range offsets {
+		if err := sub.commit(ctx, tp.topic, tp.partition, offset, counts[tp]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+)
+
+// Transform rewrites a message forwarded by a Bridge. Returning false drops the message instead.
+type Transform = func(ctx context.Context, msg Message) (Message, bool, error)
+
+// Bridge forwards the events of a stream to another one, possibly served by a different gateway, for mirroring and
+// migrating streams between clusters. Events keep their ID, source, type, headers and key, unless transformed. The
+// position of the bridge in the source stream is tracked by its own consumer group.
+type Bridge struct {
+	from      *StreamClient
+	to        *StreamClient
+	group     string
+	transform Transform
+}
+
+// NewBridge creates a Bridge forwarding the events of from to to, tracking its position as part of group. transform
+// may be nil to forward events as is.
+func NewBridge(from *StreamClient, to *StreamClient, group string, transform Transform) *Bridge {
+	return &Bridge{
+		from:      from,
+		to:        to,
+		group:     group,
+		transform: transform,
+	}
+}
+
+// Start starts forwarding events, which goes on until the returned Subscription is stopped. Events are forwarded at
+// least once, in order within each partition of the source stream, starting from the beginning of the source stream
+// when the group has no position yet. Optional behavior of the underlying subscription
+// may be configured by passing SubscribeOptions, as for Subscribe.
+func (b *Bridge) Start(ctx context.Context, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	return b.from.SubscribeMessages(ctx, b.group, true, b.forward, e, opts...)
+}
+
+// forward publishes msg to the target stream.
+func (b *Bridge) forward(ctx context.Context, msg Message) error {
+	if b.transform != nil {
+		var keep bool
+		var err error
+		if msg, keep, err = b.transform(ctx, msg); err != nil || !keep {
+			return err
+		}
+	}
+	if err := checkContentType(msg.ContentType, b.to.acceptableContentType); err != nil {
+		return err
+	}
+	_, err := b.to.publish(ctx, msg.event(), msg.Key)
+	return err
+}
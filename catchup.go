@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"sync"
+)
+
+// CatchUp hands over to f the messages of the stream from the offsets committed by group up to the end offsets
+// snapshotted when CatchUp is called, committing their offsets, and returns once they have all been handled. Messages
+// published in the meantime are left for later. This suits jobs which process "everything so far" and exit. Partitions
+// the group never committed an offset of are read from the beginning, and an anonymous catch up, with an empty group,
+// handles every message of the stream. CatchUp returns the first error reported while handling messages, leaving the
+// offsets of the messages following the failed one uncommitted, or ctx.Err() if ctx is done before the catch up
+// completes.
+func (lc *StreamClient) CatchUp(ctx context.Context, group string, f MessageHandler, opts ...SubscribeOption) error {
+	options := newSubscribeOptions(opts)
+	watermarks, err := lc.endOffsets(ctx, lc.TopicName)
+	if err != nil {
+		return err
+	}
+	if group != "" {
+		committed, err := lc.committedOffsets(ctx, lc.TopicName, group, options.groupVersion)
+		if err != nil {
+			return err
+		}
+		for partition, offset := range committed {
+			if end, ok := watermarks[partition]; ok && offset >= end {
+				delete(watermarks, partition)
+			}
+		}
+	}
+	if len(watermarks) == 0 {
+		return nil
+	}
+
+	var mu sync.Mutex
+	remaining := make(map[uint32]bool, len(watermarks))
+	for partition := range watermarks {
+		remaining[partition] = true
+	}
+	caughtUp := make(chan struct{})
+	handle := options.oneByOne(f)
+	consume := func(ctx context.Context, sub *Subscription, msg Message) error {
+		end, ok := watermarks[msg.Partition]
+		if !ok || msg.Offset > end {
+			sub.release(1)
+			return nil
+		}
+		if err := handle(ctx, sub, msg); err != nil {
+			return err
+		}
+		if msg.Offset == end {
+			mu.Lock()
+			defer mu.Unlock()
+			if remaining[msg.Partition] {
+				delete(remaining, msg.Partition)
+				if len(remaining) == 0 {
+					close(caughtUp)
+				}
+			}
+		}
+		return nil
+	}
+
+	var failure error
+	sub, err := lc.subscribe(ctx, []string{lc.TopicName}, group, true, consume, func(cancel context.CancelFunc, err error) {
+		mu.Lock()
+		if failure == nil {
+			failure = err
+		}
+		mu.Unlock()
+		cancel()
+	}, options)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-caughtUp:
+		return sub.Drain(ctx)
+	case <-sub.Done():
+		mu.Lock()
+		defer mu.Unlock()
+		if failure != nil {
+			return failure
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return sub.Err()
+	}
+}
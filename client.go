@@ -17,22 +17,34 @@
 package client
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 
 	"github.com/projectriff/stream-client-go/pkg/liiklus"
 )
 
 // StreamClient allows publishing to a riff stream, through a liiklus gateway and using the riff serialization format.
+//
+// A StreamClient is safe for concurrent use by multiple goroutines: events may be published and subscriptions created
+// concurrently, and setters such as SetValidator or SetStatsHandler may be called at any time, taking effect for
+// subsequent operations. Its exported fields must not be modified once it has been created. Once Close has been
+// called, publishing and subscribing fail with ErrClientClosed.
 type StreamClient struct {
 	// Gateway is the host:port of the liiklus gRPC endpoint.
 	Gateway string
@@ -44,8 +56,55 @@ type StreamClient struct {
 	client liiklus.LiiklusServiceClient
 	// conn is a reference to the underlying connection, kept for proper cleanup.
 	conn *grpc.ClientConn
+
+	// mu guards closed, subscriptions, deliveries, codecs, validator, schemaVersion, tracerProvider and statsHandler.
+	mu sync.Mutex
+	// closed is set once Close has been called.
+	closed bool
+	// subscriptions are the active subscriptions created by this client, stopped on Close.
+	subscriptions map[*Subscription]struct{}
+	// deliveries count the times uncommitted events have been handed over to each consumer group, per partition and
+	// offset.
+	deliveries map[groupPartition]map[uint64]int
+	// codecs are the registered codecs, per media type.
+	codecs map[string]Codec
+	// validator validates payloads, if set.
+	validator Validator
+	// schemaVersion is recorded on published events, if not 0.
+	schemaVersion int
+	// tracerProvider provides the tracer instrumenting the client, if set.
+	tracerProvider trace.TracerProvider
+	// statsHandler is notified of the activity of the client, if set.
+	statsHandler StatsHandler
+
+	// log receives the logs of the client.
+	log Logger
+	// dialOptions are additional options for connecting to the gateway.
+	dialOptions []grpc.DialOption
+	// transportCredentials secure the connection to the gateway, which is insecure if nil.
+	transportCredentials credentials.TransportCredentials
+	// httpClient sends the requests to grpc-web gateways, if set.
+	httpClient *http.Client
+	// dialTransport connects to the gateway instead of gRPC, if set.
+	dialTransport TransportDialer
+	// transport calls the gateway, unless it is called through conn.
+	transport Transport
+	// release is called on Close instead of closing the connection, for clients sharing the connection of a
+	// GatewayClient, which closes it.
+	release func()
+	// publishAttempts is the number of times publishing is attempted while the gateway is unavailable, retries being
+	// delayed by publishBackoff.
+	publishAttempts int
+	publishBackoff  Backoff
+	// topicCreator creates missing topics when publishing, if set.
+	topicCreator TopicCreator
+	// topicPrefix is prepended to the topics sent to the gateway, if set.
+	topicPrefix string
 }
 
+// closeTimeout is how long Close waits for active subscriptions to terminate before closing the connection anyway.
+const closeTimeout = 10 * time.Second
+
 type PublishResult struct {
 	Partition uint32
 	Offset    uint64
@@ -58,29 +117,121 @@ type EventHandler = func(ctx context.Context, payload io.Reader, contentType str
 // EventErrHandler is a function to handle errors while reading subscription messages and
 // is passed as a parameter to the subscribe call.
 // This function may call the passed CancelFunc parameter to cancel the subscription
+// Errors of a subscription are *SubscriptionError, describing the phase, topic, partition and offset they relate to.
 type EventErrHandler = func(cancel context.CancelFunc, err error)
 
+// ClientOption configures optional behavior of a StreamClient created by NewStreamClient.
+type ClientOption func(*StreamClient)
+
+// WithTransportCredentials secures the connection to the gateway with creds, for example with TLS, rather than
+// connecting in plaintext.
+func WithTransportCredentials(creds credentials.TransportCredentials) ClientOption {
+	return func(lc *StreamClient) {
+		lc.transportCredentials = creds
+	}
+}
+
+// WithDialOptions passes additional options to grpc.DialContext when connecting to the gateway, for instance to use a
+// custom dialer or transport credentials.
+func WithDialOptions(opts ...grpc.DialOption) ClientOption {
+	return func(lc *StreamClient) {
+		lc.dialOptions = append(lc.dialOptions, opts...)
+	}
+}
+
 // NewStreamClient creates a new StreamClient for a given stream.
-func NewStreamClient(gateway string, topic string, acceptableContentType string) (*StreamClient, error) {
-	timeout, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
-	defer cancel()
-	conn, err := grpc.DialContext(timeout, gateway, grpc.WithInsecure(), grpc.WithBlock())
-	if err != nil {
+//
+// The gateway is the host:port of a liiklus gRPC server, or the http:// or https:// URL of a grpc-web endpoint proxying
+// it, for environments which block HTTP/2 gRPC traffic. Such endpoints are reached with the client set by
+// WithHTTPClient. An rsocket://host:port gateway is reached through the RSocket API of liiklus instead, for deployments
+// which only expose its RSocket port. Other transports can be plugged in with WithTransport. Gateways which are not
+// reached through a gRPC connection don't support the options configuring it: WithDialOptions,
+// WithTransportCredentials and WithDebugLogging.
+func NewStreamClient(gateway string, topic string, acceptableContentType string, opts ...ClientOption) (*StreamClient, error) {
+	lc := newStreamClient(gateway, topic, acceptableContentType, opts)
+	if err := lc.connect(); err != nil {
 		return nil, err
 	}
-	client := liiklus.NewLiiklusServiceClient(conn)
-	return &StreamClient{
+	return lc, nil
+}
+
+// newStreamClient creates a StreamClient configured by opts, which is not connected to the gateway yet.
+func newStreamClient(gateway string, topic string, acceptableContentType string, opts []ClientOption) *StreamClient {
+	lc := &StreamClient{
 		Gateway:               gateway,
 		TopicName:             topic,
 		acceptableContentType: acceptableContentType,
-		client:                client,
-		conn:                  conn,
-	}, nil
+		subscriptions:         make(map[*Subscription]struct{}),
+		deliveries:            make(map[groupPartition]map[uint64]int),
+		codecs: map[string]Codec{
+			"application/json":  JSONCodec{},
+			ProtobufContentType: ProtoCodec{},
+		},
+		log: nopLogger{},
+	}
+	for _, opt := range opts {
+		opt(lc)
+	}
+	return lc
 }
 
-func (lc *StreamClient) Publish(ctx context.Context, payload io.Reader, key io.Reader, contentType string, headers map[string]string) (PublishResult, error) {
-	if chopContentType(contentType) != chopContentType(lc.acceptableContentType) { // TODO support smarter compatibility (eg subtypes)
-		return PublishResult{}, fmt.Errorf("contentType %q not compatible with expected contentType %q", contentType, lc.acceptableContentType)
+// connect connects lc to its gateway.
+func (lc *StreamClient) connect() error {
+	gateway, topic := lc.Gateway, lc.TopicName
+	timeout, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	if lc.dialTransport != nil || isGRPCWeb(gateway) || isRSocket(gateway) {
+		if len(lc.dialOptions) > 0 || lc.transportCredentials != nil {
+			return errors.New("gRPC connection options don't apply to gateways reached through another transport")
+		}
+		if lc.dialTransport != nil {
+			transport, err := lc.dialTransport(timeout, gateway)
+			if err != nil {
+				lc.log.Error(err, "unable to connect to gateway", "gateway", gateway)
+				return err
+			}
+			lc.transport = transport
+		} else if isRSocket(gateway) {
+			transport, err := dialRSocket(timeout, gateway)
+			if err != nil {
+				lc.log.Error(err, "unable to connect to gateway", "gateway", gateway)
+				return gatewayError("connect", err)
+			}
+			lc.transport = transport
+		} else {
+			lc.transport = newGRPCWebClient(gateway, lc.httpClient)
+		}
+		lc.client = lc.transport
+		lc.log.Info("connected to gateway", "gateway", gateway, "topic", topic)
+		lc.namespace()
+		return nil
+	}
+
+	lc.log.Debug("connecting to gateway", "gateway", gateway)
+	security := grpc.WithInsecure()
+	if lc.transportCredentials != nil {
+		security = grpc.WithTransportCredentials(lc.transportCredentials)
+	}
+	dialOptions := append([]grpc.DialOption{security, grpc.WithBlock()}, lc.dialOptions...)
+	conn, err := grpc.DialContext(timeout, gateway, dialOptions...)
+	if err != nil {
+		lc.log.Error(err, "unable to connect to gateway", "gateway", gateway)
+		return err
+	}
+	lc.log.Info("connected to gateway", "gateway", gateway, "topic", topic)
+	lc.conn = conn
+	lc.client = liiklus.NewLiiklusServiceClient(conn)
+	lc.namespace()
+	return nil
+}
+
+func (lc *StreamClient) Publish(ctx context.Context, payload io.Reader, key io.Reader, contentType string, headers map[string]string, opts ...PublishOption) (PublishResult, error) {
+	if err := checkContentType(contentType, lc.acceptableContentType); err != nil {
+		return PublishResult{}, err
+	}
+	options := newPublishOptions(opts)
+	if options.err != nil {
+		return PublishResult{}, options.err
 	}
 
 	ce := liiklus.LiiklusEvent{Extensions:make(map[string]string, len(headers))}
@@ -88,6 +239,7 @@ func (lc *StreamClient) Publish(ctx context.Context, payload io.Reader, key io.R
 	ce.Source = "source-todo" // TODO
 	ce.Type = "riff-event"    // TODO
 	ce.Id = uuid.New().String()
+	ce.Time = time.Now().UTC().Format(time.RFC3339Nano)
 
 	if bytes, err := ioutil.ReadAll(payload); err != nil {
 		return PublishResult{}, err
@@ -95,8 +247,18 @@ func (lc *StreamClient) Publish(ctx context.Context, payload io.Reader, key io.R
 		ce.Data = bytes
 	}
 	for k, v := range headers {
+		if !setAttribute(&ce, k, v) {
+			ce.Extensions[k] = v
+		}
+	}
+	for k, v := range options.extensions {
 		ce.Extensions[k] = v
 	}
+	lc.mu.Lock()
+	if _, ok := ce.Extensions[SchemaVersionHeader]; !ok && lc.schemaVersion != 0 {
+		ce.Extensions[SchemaVersionHeader] = strconv.Itoa(lc.schemaVersion)
+	}
+	lc.mu.Unlock()
 
 	var err error
 	var kValue []byte
@@ -105,15 +267,73 @@ func (lc *StreamClient) Publish(ctx context.Context, payload io.Reader, key io.R
 			return PublishResult{}, err
 		}
 	}
-	request := liiklus.PublishRequest{
-		Topic: lc.TopicName,
-		Key:   kValue,
-		Event: &liiklus.PublishRequest_LiiklusEvent{LiiklusEvent: &ce},
+	return lc.publish(ctx, &ce, kValue)
+}
+
+// publish publishes event to the stream, under key if not nil.
+func (lc *StreamClient) publish(ctx context.Context, event *liiklus.LiiklusEvent, key []byte) (PublishResult, error) {
+	return lc.publishTo(ctx, lc.TopicName, event, key)
+}
+
+// publishTo publishes event to topic, under key if not nil.
+func (lc *StreamClient) publishTo(ctx context.Context, topic string, event *liiklus.LiiklusEvent, key []byte) (PublishResult, error) {
+	start := time.Now()
+	ctx, span := lc.startPublishSpan(ctx, topic, event)
+	result, err := lc.sendEvent(ctx, topic, event, key)
+	lc.stats().OnPublish(PublishStats{
+		Topic:     topic,
+		Partition: result.Partition,
+		Offset:    result.Offset,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+	if err == nil {
+		span.SetAttributes(
+			attribute.Int64("messaging.partition", int64(result.Partition)),
+			attribute.Int64("messaging.offset", int64(result.Offset)),
+		)
 	}
-	publishReply, err := lc.client.Publish(ctx, &request)
-	if err != nil {
+	endSpan(span, err)
+	return result, err
+}
+
+// sendEvent validates event and sends it to topic.
+func (lc *StreamClient) sendEvent(ctx context.Context, topic string, event *liiklus.LiiklusEvent, key []byte) (PublishResult, error) {
+	if err := lc.validate(event.Id, event.DataContentType, event.Data); err != nil {
 		return PublishResult{}, err
 	}
+	return lc.send(ctx, &liiklus.PublishRequest{
+		Topic: topic,
+		Key:   key,
+		Event: &liiklus.PublishRequest_LiiklusEvent{LiiklusEvent: event},
+	})
+}
+
+// send sends request to the gateway.
+func (lc *StreamClient) send(ctx context.Context, request *liiklus.PublishRequest) (PublishResult, error) {
+	if lc.isClosed() {
+		return PublishResult{}, ErrClientClosed
+	}
+	publishReply, err := lc.client.Publish(ctx, request)
+	for retry := 1; err != nil && retry < lc.publishAttempts && status.Code(err) == codes.Unavailable; retry++ {
+		if backoffWait(ctx, lc.publishBackoff, retry) != nil {
+			break
+		}
+		publishReply, err = lc.client.Publish(ctx, request)
+	}
+	if err != nil {
+		err = gatewayError("publish", err)
+		retry, createErr := lc.createTopicIfMissing(ctx, request.Topic, err)
+		if createErr != nil {
+			return PublishResult{}, fmt.Errorf("%w, and creating it failed: %v", err, createErr)
+		}
+		if !retry {
+			return PublishResult{}, err
+		}
+		if publishReply, err = lc.client.Publish(ctx, request); err != nil {
+			return PublishResult{}, gatewayError("publish", err)
+		}
+	}
 	return PublishResult{Offset: publishReply.Offset, Partition: publishReply.Partition}, nil
 }
 
@@ -125,73 +345,114 @@ func chopContentType(contentType string) string {
 // provided to read from the beginning. The provided EventHandler function will be called for each value.
 // To deal with errors while reading messages, an error handler function should also be provided.
 //
-// The function returns a context.CancelFunc which may be called for cancelling the subscription.
-func (lc *StreamClient) Subscribe(ctx context.Context, group string, fromBeginning bool, f EventHandler, e EventErrHandler) (context.CancelFunc, error) {
-	subContext, cancel := context.WithCancel(ctx)
-	request := liiklus.SubscribeRequest{
-		Topic:           lc.TopicName,
-		Group:           group,
-		AutoOffsetReset: getAutoOffsetReset(fromBeginning),
-	}
-	subscribedClient, err := lc.client.Subscribe(subContext, &request)
-	if err != nil {
-		return cancel, err
-	}
+// An empty group creates an anonymous subscription, which reads the stream independently of other consumers and never
+// commits offsets. This is useful for tools that just want to peek at the stream.
+//
+// The function returns a Subscription which may be used for cancelling the subscription and waiting for it to terminate.
+// Optional behavior may be configured by passing SubscribeOptions.
+func (lc *StreamClient) Subscribe(ctx context.Context, group string, fromBeginning bool, f EventHandler, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	options := newSubscribeOptions(opts)
+	return lc.subscribe(ctx, []string{lc.TopicName}, group, fromBeginning, options.oneByOne(eventHandler(f)), e, options)
+}
 
-	go func() {
-		for {
-			subscribeReply, err := subscribedClient.Recv()
-			if err != nil {
-				e(cancel, err)
-				return
-			}
+// SubscribeMessages is like Subscribe, but hands whole messages over to f, including their position in the stream.
+func (lc *StreamClient) SubscribeMessages(ctx context.Context, group string, fromBeginning bool, f MessageHandler, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	options := newSubscribeOptions(opts)
+	return lc.subscribe(ctx, []string{lc.TopicName}, group, fromBeginning, options.oneByOne(f), e, options)
+}
 
-			receiveRequest := liiklus.ReceiveRequest{
-				Assignment:      subscribeReply.GetAssignment(),
-				Format:          liiklus.ReceiveRequest_LIIKLUS_EVENT,
-			}
-			receiveClient, err := lc.client.Receive(subContext, &receiveRequest)
-			if err != nil {
-				e(cancel, err)
-				return
-			}
+// MultiSubscribe is like Subscribe, but consumes several topics available through the same gateway at once. Messages
+// from all topics are handed over to f, tagged with the topic they were read from.
+func (lc *StreamClient) MultiSubscribe(ctx context.Context, topics []string, group string, fromBeginning bool, f MessageHandler, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	if len(topics) == 0 {
+		return nil, errors.New("at least one topic is required")
+	}
+	options := newSubscribeOptions(opts)
+	return lc.subscribe(ctx, topics, group, fromBeginning, options.oneByOne(f), e, options)
+}
 
-			go func() {
-				for {
-					select {
-					case <-subContext.Done():
-						e(cancel, errors.New("context terminated"))
-						return
-					default:
-					}
-					recvReply, err := receiveClient.Recv()
-					if err != nil {
-						e(cancel, err)
-						return
-					}
+// subscribe backs the various consumption APIs, calling consume for each message read from the given topics.
+func (lc *StreamClient) subscribe(ctx context.Context, topics []string, group string, fromBeginning bool, consume consumer, e EventErrHandler, options *subscribeOptions) (*Subscription, error) {
+	if lc.isClosed() {
+		return nil, ErrClientClosed
+	}
+	anonymous := group == ""
+	if anonymous {
+		group = ephemeralGroup()
+	} else if options.partition != nil {
+		group = fmt.Sprintf("%s-partition-%d", group, *options.partition)
+	}
+	var startOffsets map[topicPartition]uint64
+	if !options.startTime.IsZero() {
+		var err error
+		if startOffsets, err = lc.startOffsets(ctx, topics, options.startTime); err != nil {
+			return nil, err
+		}
+	}
+	subContext, cancel := context.WithCancel(ctx)
+	fetchContext, stopFetching := context.WithCancel(subContext)
+	subscribedClients := make([]liiklus.LiiklusService_SubscribeClient, len(topics))
+	for i, topic := range topics {
+		request := liiklus.SubscribeRequest{
+			Topic:           topic,
+			Group:           group,
+			GroupVersion:    options.groupVersion,
+			AutoOffsetReset: getAutoOffsetReset(fromBeginning || !options.startTime.IsZero()),
+		}
+		subscribedClient, err := lc.client.Subscribe(fetchContext, &request)
+		if err != nil {
+			lc.log.Error(err, "unable to subscribe", "topic", topic, "group", group)
+			stopFetching()
+			cancel()
+			return nil, gatewayError("subscribe", err)
+		}
+		subscribedClients[i] = subscribedClient
+	}
 
-					eventRecord := recvReply.GetLiiklusEventRecord()
-					err = f(subContext, bytes.NewReader(eventRecord.Event.Data), eventRecord.Event.DataContentType, nil /*TODO*/)
-					if err != nil {
-						e(cancel, err)
-						return
-					}
-					ackRequest := liiklus.AckRequest{
-						Topic:  lc.TopicName,
-						Group:  group,
-						Offset: eventRecord.Offset,
-					}
-					_, err = lc.client.Ack(subContext, &ackRequest)
-					if err != nil {
-						e(cancel, err)
-						return
+	sub := newSubscription(lc, topics, group, subContext, cancel, fetchContext, stopFetching, options.regulate(consume), e, options)
+	sub.anonymous = anonymous
+	sub.startOffsets = startOffsets
+	if !lc.track(sub) {
+		stopFetching()
+		cancel()
+		return nil, ErrClientClosed
+	}
+	// notify the hooks before any assignment may be received
+	options.subscribed(topics)
+	if options.idleTimeout > 0 && options.onIdle != nil {
+		sub.goroutine(sub.watchIdle)
+	}
+	for i, topic := range topics {
+		topic, subscribedClient := topic, subscribedClients[i]
+		sub.goroutine(func() {
+			for {
+				subscribeReply, err := subscribedClient.Recv()
+				if err != nil {
+					if !sub.isDraining() {
+						sub.fail(e, sub.errorAt(PhaseSubscribe, topic, 0, 0, gatewayError("subscribe", err)))
 					}
+					return
 				}
-			}()
-		}
-	}()
+				assignment := subscribeReply.GetAssignment()
+				if options.partition != nil && assignment.GetPartition() != *options.partition {
+					continue
+				}
+				options.assignmentReceived(topic, assignment.GetPartition())
+				sub.goroutine(func() {
+					sub.consumePartition(topic, assignment)
+				})
+			}
+		})
+	}
+	sub.wait()
+	lc.log.Info("subscribed", "topics", topics, "group", group)
 
-	return cancel, nil
+	return sub, nil
+}
+
+// ephemeralGroup returns a unique consumer group name, for reading a stream independently of other consumers.
+func ephemeralGroup() string {
+	return "anonymous-" + uuid.New().String()
 }
 
 func getAutoOffsetReset(fromBeginning bool) liiklus.SubscribeRequest_AutoOffsetReset {
@@ -201,7 +462,114 @@ func getAutoOffsetReset(fromBeginning bool) liiklus.SubscribeRequest_AutoOffsetR
 	return liiklus.SubscribeRequest_LATEST
 }
 
-// Close cleans up underlying resources used by this client. The client is then unable to publish.
+// track registers sub as active until it terminates, unless the client has been closed in which case it returns false.
+func (lc *StreamClient) track(sub *Subscription) bool {
+	lc.mu.Lock()
+	if lc.closed {
+		lc.mu.Unlock()
+		return false
+	}
+	lc.subscriptions[sub] = struct{}{}
+	lc.mu.Unlock()
+	go func() {
+		<-sub.Done()
+		lc.log.Info("subscription terminated", "topics", sub.topics, "group", sub.group, "error", sub.Err())
+		lc.mu.Lock()
+		delete(lc.subscriptions, sub)
+		if sub.anonymous {
+			for gp := range lc.deliveries {
+				if gp.group == sub.group {
+					delete(lc.deliveries, gp)
+				}
+			}
+		}
+		lc.mu.Unlock()
+	}()
+	return true
+}
+
+// isClosed reports whether Close has been called.
+func (lc *StreamClient) isClosed() bool {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.closed
+}
+
+// groupPartition designates a partition of a topic, as consumed by a consumer group.
+type groupPartition struct {
+	group     string
+	topic     string
+	partition uint32
+}
+
+// attempt records that msg is being handed over to group, returning the number of times it has been since it was last
+// committed.
+func (lc *StreamClient) attempt(group string, msg Message) int {
+	gp := groupPartition{group: group, topic: msg.Topic, partition: msg.Partition}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	attempts, ok := lc.deliveries[gp]
+	if !ok {
+		attempts = make(map[uint64]int)
+		lc.deliveries[gp] = attempts
+	}
+	attempts[msg.Offset]++
+	return attempts[msg.Offset]
+}
+
+// committed forgets the attempts of events up to offset in the partition of topic, once committed by group.
+func (lc *StreamClient) committed(group string, topic string, partition uint32, offset uint64) {
+	gp := groupPartition{group: group, topic: topic, partition: partition}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	for o := range lc.deliveries[gp] {
+		if o <= offset {
+			delete(lc.deliveries[gp], o)
+		}
+	}
+}
+
+// Close cleans up underlying resources used by this client. Active subscriptions are cancelled and waited for, for a
+// bounded amount of time, before the connection is closed. The client is then unable to publish or subscribe, and
+// further calls to Close do nothing. Clients returned by GatewayClient.Stream leave the connection they share open.
 func (lc *StreamClient) Close() error {
-	return lc.conn.Close()
+	lc.mu.Lock()
+	if lc.closed {
+		lc.mu.Unlock()
+		return nil
+	}
+	lc.closed = true
+	subs := make([]*Subscription, 0, len(lc.subscriptions))
+	for sub := range lc.subscriptions {
+		subs = append(subs, sub)
+	}
+	lc.mu.Unlock()
+
+	lc.log.Info("closing client", "gateway", lc.Gateway, "subscriptions", len(subs))
+	for _, sub := range subs {
+		sub.Cancel()
+	}
+	var err error
+	timeout := time.After(closeTimeout)
+wait:
+	for _, sub := range subs {
+		select {
+		case <-sub.Done():
+		case <-timeout:
+			err = fmt.Errorf("timed out after %s waiting for subscriptions to terminate", closeTimeout)
+			break wait
+		}
+	}
+	if lc.release != nil {
+		lc.release()
+		return err
+	}
+	closer := io.Closer(lc.conn)
+	if lc.transport != nil {
+		closer = lc.transport
+	}
+	if cerr := closer.Close(); cerr != nil {
+		return cerr
+	}
+	return err
 }
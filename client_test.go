@@ -1,16 +1,41 @@
 package client_test
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/ptypes/empty"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
 	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
 )
 
 // This is an integration test meant to be run against a liiklus gateway. Please refer to the CI scripts for
@@ -44,6 +69,15 @@ func publish(c *client.StreamClient, value, contentType, topic string, headers m
 	fmt.Printf("Published: %+v\n", publishResult)
 }
 
+// publishWithKey publishes values sharing the same key to the same partition, so that they are read in order.
+func publishWithKey(c *client.StreamClient, value, key string, t *testing.T) client.PublishResult {
+	publishResult, err := c.Publish(context.Background(), strings.NewReader(value), strings.NewReader(key), "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publishResult
+}
+
 func subscribe(c *client.StreamClient, expectedValue, topic string, fromBeginning bool, headers map[string]string, t *testing.T) {
 
 	var errHandler client.EventErrHandler
@@ -142,70 +176,4436 @@ func TestSubscribeCancel(t *testing.T) {
 	eventErrHandler = func(cancel context.CancelFunc, err error) {
 		result <- expectedError
 	}
-	cancel, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler)
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler)
 	if err != nil {
 		t.Error(err)
 	}
-	cancel()
+	sub.Cancel()
 	v1 := <-result
 	if v1 != expectedError {
 		t.Errorf("expected value: %s, but was: %s", expectedError, v1)
 	}
 }
 
-func TestMultipleSubscribe(t *testing.T) {
+func TestSubscriptionDone(t *testing.T) {
 	now := time.Now()
-	topic1 := topicName(t.Name(), fmt.Sprintf("%d%d%d_1", now.Hour(), now.Minute(), now.Second()))
-	topic2 := topicName(t.Name(), fmt.Sprintf("%d%d%d_2", now.Hour(), now.Minute(), now.Second()))
-
-	c1 := setupStreamingClient(topic1, t)
-	c2 := setupStreamingClient(topic2, t)
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
 
-	testVal1 := "BAR1"
-	testVal2 := "BAR2"
-	result1 := make(chan string)
-	result2 := make(chan string)
+	c := setupStreamingClient(topic, t)
 
-	var eventErrHandler client.EventErrHandler
-	eventErrHandler = func(cancel context.CancelFunc, err error) {
-		panic(err)
-	}
-	var err error
-	_, err = c1.Subscribe(context.Background(), t.Name()+"1", true, func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+	result := make(chan string)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
 		bytes, err := ioutil.ReadAll(payload)
 		if err != nil {
 			return err
 		}
-		result1 <- string(bytes)
+		result <- string(bytes)
 		return nil
-	}, eventErrHandler)
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(c, "BAZ", "text/plain", topic, nil, t)
+	<-result
+
+	sub.Cancel()
+	select {
+	case <-sub.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("subscription did not terminate after being cancelled")
+	}
+	if sub.Err() == nil {
+		t.Error("expected subscription to report why it stopped")
+	}
+}
+
+func TestSubscriptionDrain(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+
+	handling := make(chan struct{})
+	release := make(chan struct{})
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		close(handling)
+		<-release
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {
+		t.Errorf("did not expect an error, got: %v", err)
+	}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(c, "BAZ", "text/plain", topic, nil, t)
+	<-handling
+
+	drained := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		drained <- sub.Drain(ctx)
+	}()
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	if sub.Err() != nil {
+		t.Errorf("expected no error after draining, but was: %v", sub.Err())
+	}
+	if stats := sub.Stats(); stats.Processed != 1 {
+		t.Errorf("expected 1 processed event, but was: %d", stats.Processed)
+	}
+}
+
+func TestCloseStopsSubscriptions(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sub.Done():
+	default:
+		t.Error("expected subscription to be terminated once the client is closed")
+	}
+}
+
+func TestSubscribeHandlerTimeout(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+
+	stuck := make(chan struct{})
+	defer close(stuck)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		<-stuck
+		return nil
+	}
+	errs := make(chan error, 1)
+	eventErrHandler := func(cancel context.CancelFunc, err error) {
+		cancel()
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	_, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler, client.WithHandlerTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(c, "BAZ", "text/plain", topic, nil, t)
+	if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected handler to time out, but got: %v", err)
+	}
+}
+
+func TestSubscribeHandlerPanic(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		panic("boom")
+	}
+	errs := make(chan error, 1)
+	eventErrHandler := func(cancel context.CancelFunc, err error) {
+		cancel()
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	_, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(c, "BAZ", "text/plain", topic, nil, t)
+	var panicErr *client.PanicError
+	if err := <-errs; !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Errorf("expected handler panic to be reported, but got: %v", err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publishWithKey(c, "BAR1", "key", t)
+	publishWithKey(c, "BAR2", "key", t)
+
+	r, err := c.NewReader(t.Name(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, expected := range []string{"BAR1", "BAR2"} {
+		msg, err := r.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Payload) != expected {
+			t.Errorf("expected value: %s, but was: %s", expected, msg.Payload)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(ctx); err != io.EOF {
+		t.Errorf("expected io.EOF from a closed reader, but was: %v", err)
+	}
+}
+
+func TestSubscribeBatch(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	for _, v := range []string{"BAR1", "BAR2", "BAR3"} {
+		publishWithKey(c, v, "key", t)
+	}
+
+	batches := make(chan []string, 2)
+	batchHandler := func(ctx context.Context, msgs []client.Message) error {
+		var values []string
+		for _, msg := range msgs {
+			values = append(values, string(msg.Payload))
+		}
+		batches <- values
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {
+		t.Errorf("did not expect an error, got: %v", err)
+	}
+	sub, err := c.SubscribeBatch(context.Background(), t.Name(), true, 2, 500*time.Millisecond, batchHandler, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := <-batches; !reflect.DeepEqual(b, []string{"BAR1", "BAR2"}) {
+		t.Errorf("expected a full first batch, but was: %v", b)
+	}
+	if b := <-batches; !reflect.DeepEqual(b, []string{"BAR3"}) {
+		t.Errorf("expected a partial second batch, but was: %v", b)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := sub.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stats := sub.Stats(); stats.Processed != 3 {
+		t.Errorf("expected 3 processed events, but was: %d", stats.Processed)
+	}
+}
+
+func TestSubscribeBatchFailureCancelsAllPartitions(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	// messages without a key are spread over both partitions
+	publish(c, "BAR1", "text/plain", topic, nil, t)
+	publish(c, "BAR2", "text/plain", topic, nil, t)
+
+	failed := make(chan []client.Message, 1)
+	var batches int32
+	batchHandler := func(ctx context.Context, msgs []client.Message) error {
+		if atomic.AddInt32(&batches, 1) == 1 {
+			failed <- msgs
+			return errors.New("boom")
+		}
+		return nil
+	}
+	sub, err := c.SubscribeBatch(context.Background(), t.Name(), true, 2, 100*time.Millisecond, batchHandler, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	var batch []client.Message
+	select {
+	case batch = <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the first batch")
+	}
+	// messages of both partitions following the failed batch would be flushed by the timer
+	for _, v := range []string{"BAR3", "BAR4", "BAR5", "BAR6"} {
+		publish(c, v, "text/plain", topic, nil, t)
+	}
+	select {
+	case <-sub.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("expected the failed batch to cancel the subscription")
+	}
+	if err := sub.Err(); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected the subscription to fail with the error of the batch, but was: %v", err)
+	}
+
+	offsets, err := c.CommittedOffsets(context.Background(), t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitions := map[uint32]bool{}
+	for _, msg := range batch {
+		partitions[msg.Partition] = true
+		if offset, ok := offsets[msg.Partition]; ok && offset >= msg.Offset {
+			t.Errorf("expected the offset of partition %d not to move past the failed batch, but was: %d", msg.Partition, offset)
+		}
+	}
+	if len(partitions) != 2 {
+		t.Errorf("expected the failed batch to hold messages of both partitions, but was: %v", batch)
+	}
+}
+
+func TestSubscribeMaxInFlight(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publish(c, "BAR1", "text/plain", topic, nil, t)
+	publish(c, "BAR2", "text/plain", topic, nil, t)
+
+	sizes := make(chan int, 2)
+	batchHandler := func(ctx context.Context, msgs []client.Message) error {
+		sizes <- len(msgs)
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c.SubscribeBatch(context.Background(), t.Name(), true, 2, 100*time.Millisecond, batchHandler, eventErrHandler, client.WithMaxInFlight(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	for i := 0; i < 2; i++ {
+		if size := <-sizes; size != 1 {
+			t.Errorf("expected batches to be limited by the in-flight limit, but got a batch of %d", size)
+		}
+	}
+}
+
+func TestSubscribeFromTime(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publish(c, "BAR1", "text/plain", topic, nil, t)
+	time.Sleep(1 * time.Second)
+	start := time.Now()
+	publish(c, "BAR2", "text/plain", topic, nil, t)
+
+	r, err := c.NewReader(t.Name(), false, client.WithStartTime(start))
 	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := r.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Payload) != "BAR2" {
+		t.Errorf("expected value: %s, but was: %s", "BAR2", msg.Payload)
+	}
+}
+
+func TestSubscribeFromTimeCommitsSkippedMessages(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	publishWithKey(c, "BAR1", "key", t)
+	last := publishWithKey(c, "BAR2", "key", t)
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+
+	handled := make(chan string, 1)
+	sub, err := c.SubscribeMessages(context.Background(), t.Name(), false, func(ctx context.Context, msg client.Message) error {
+		handled <- string(msg.Payload)
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
 		t.Error(err)
+	}, client.WithStartTime(start))
+	if err != nil {
+		t.Fatal(err)
 	}
-	_, err = c2.Subscribe(context.Background(), t.Name()+"2", true, func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+	defer sub.Drain(context.Background())
+	// the messages recorded before the start time are committed without any message after it being handled
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		offsets, err := c.CommittedOffsets(context.Background(), t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset, ok := offsets[last.Partition]; ok && offset == last.Offset {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected offset %d to be committed, got offsets %v", last.Offset, offsets)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	publishWithKey(c, "BAR3", "key", t)
+	select {
+	case payload := <-handled:
+		if payload != "BAR3" {
+			t.Errorf("expected value: %s, but was: %s", "BAR3", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the message published after the start time")
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	ranges := make(map[uint32]client.OffsetRange)
+	for _, v := range []string{"BAR1", "BAR2", "BAR3", "BAR4"} {
+		result := publishWithKey(c, v, "key", t)
+		if v == "BAR2" || v == "BAR3" {
+			r, ok := ranges[result.Partition]
+			if !ok {
+				r.From = result.Offset
+			}
+			r.To = result.Offset + 1
+			ranges[result.Partition] = r
+		}
+	}
+
+	var values []string
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := c.ReadRange(ctx, ranges, func(ctx context.Context, msg client.Message) error {
+		values = append(values, string(msg.Payload))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []string{"BAR2", "BAR3"}) {
+		t.Errorf("expected values: %v, but was: %v", []string{"BAR2", "BAR3"}, values)
+	}
+}
+
+func TestTail(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	for _, v := range []string{"BAR1", "BAR2", "BAR3"} {
+		publishWithKey(c, v, "key", t)
+	}
+
+	var values []string
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := c.Tail(ctx, 2, func(ctx context.Context, msg client.Message) error {
+		values = append(values, string(msg.Payload))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []string{"BAR2", "BAR3"}) {
+		t.Errorf("expected values: %v, but was: %v", []string{"BAR2", "BAR3"}, values)
+	}
+}
+
+func TestSubscriptionSeek(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	var results []client.PublishResult
+	for _, v := range []string{"BAR1", "BAR2", "BAR3"} {
+		results = append(results, publishWithKey(c, v, "key", t))
+	}
+
+	values := make(chan string, 5)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
 		bytes, err := ioutil.ReadAll(payload)
 		if err != nil {
 			return err
 		}
-		result2 <- string(bytes)
+		values <- string(bytes)
 		return nil
-	}, eventErrHandler)
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	publish(c1, testVal1, "text/plain", topic1, nil, t)
-	publish(c2, testVal2, "text/plain", topic1, nil, t)
+	defer sub.Cancel()
+	for i := 0; i < 3; i++ {
+		<-values
+	}
+	if err := sub.Seek(results[1].Partition, results[1].Offset); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"BAR2", "BAR3"} {
+		if v := <-values; v != expected {
+			t.Errorf("expected value: %s, but was: %s", expected, v)
+		}
+	}
+}
 
-	v1 := <-result1
-	if v1 != testVal1 {
-		t.Errorf("expected value: %s, but was: %s", testVal1, v1)
+func TestSubscriptionSeekToStart(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	var results []client.PublishResult
+	for _, v := range []string{"BAR1", "BAR2", "BAR3"} {
+		results = append(results, publishWithKey(c, v, "key", t))
 	}
-	v2 := <-result2
-	if v2 != testVal2 {
+
+	values := make(chan string, 5)
+	sub, err := c.SubscribeMessages(context.Background(), t.Name(), true, func(ctx context.Context, msg client.Message) error {
+		values <- string(msg.Payload)
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	next := func() string {
+		select {
+		case v := <-values:
+			return v
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a message")
+			return ""
+		}
+	}
+	for i := 0; i < 3; i++ {
+		next()
+	}
+	if err := sub.Seek(results[0].Partition, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"BAR1", "BAR2", "BAR3"} {
+		if v := next(); v != expected {
+			t.Errorf("expected value: %s, but was: %s", expected, v)
+		}
+	}
+}
+
+func TestSubscriptionSeekToTime(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	start := time.Now()
+	for _, v := range []string{"BAR1", "BAR2"} {
+		publishWithKey(c, v, "key", t)
+	}
+
+	values := make(chan string, 5)
+	sub, err := c.SubscribeMessages(context.Background(), t.Name(), true, func(ctx context.Context, msg client.Message) error {
+		values <- string(msg.Payload)
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	next := func() string {
+		select {
+		case v := <-values:
+			return v
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a message")
+			return ""
+		}
+	}
+	for i := 0; i < 2; i++ {
+		next()
+	}
+
+	// nothing was recorded after now, hence the position doesn't move
+	if err := sub.SeekToTime(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	publishWithKey(c, "BAR3", "key", t)
+	if v := next(); v != "BAR3" {
+		t.Errorf("expected value: %s, but was: %s", "BAR3", v)
+	}
+
+	// every message was recorded after start, hence the partition is rewound to its beginning
+	if err := sub.SeekToTime(context.Background(), start); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"BAR1", "BAR2", "BAR3"} {
+		if v := next(); v != expected {
+			t.Errorf("expected value: %s, but was: %s", expected, v)
+		}
+	}
+}
+
+func TestAnonymousSubscribe(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publish(c, "BAR", "text/plain", topic, nil, t)
+
+	// anonymous subscriptions are independent of each other
+	for i := 0; i < 2; i++ {
+		r, err := c.NewReader("", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		msg, err := r.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Payload) != "BAR" {
+			t.Errorf("expected value: %s, but was: %s", "BAR", msg.Payload)
+		}
+		r.Close()
+	}
+}
+
+func TestSubscribeGroupVersion(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publish(c, "BAR", "text/plain", topic, nil, t)
+
+	// each version of the group consumes the stream on its own
+	for _, version := range []uint32{1, 2} {
+		r, err := c.NewReader(t.Name(), true, client.WithGroupVersion(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		msg, err := r.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Payload) != "BAR" {
+			t.Errorf("expected value: %s, but was: %s", "BAR", msg.Payload)
+		}
+		r.Close()
+	}
+}
+
+func TestSubscribePartition(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	result := publishWithKey(c, "BAR", "key", t)
+
+	r, err := c.NewReader(t.Name(), true, client.WithPartition(result.Partition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := r.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Partition != result.Partition || msg.Offset != result.Offset {
+		t.Errorf("expected message at %d:%d, but was at %d:%d", result.Partition, result.Offset, msg.Partition, msg.Offset)
+	}
+}
+
+func TestSubscribeFilter(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	for _, v := range []string{"BAR1", "BAZ", "BAR2"} {
+		publishWithKey(c, v, "key", t)
+	}
+
+	r, err := c.NewReader(t.Name(), true, client.WithFilter(func(msg client.Message) bool {
+		return strings.HasPrefix(string(msg.Payload), "BAR")
+	}, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, expected := range []string{"BAR1", "BAR2"} {
+		msg, err := r.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Payload) != expected {
+			t.Errorf("expected value: %s, but was: %s", expected, msg.Payload)
+		}
+	}
+}
+
+func TestSubscribeMiddleware(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publish(c, "bar", "text/plain", topic, nil, t)
+
+	var calls []string
+	tracing := func(name string) client.Middleware {
+		return func(next client.MessageHandler) client.MessageHandler {
+			return func(ctx context.Context, msg client.Message) error {
+				calls = append(calls, name+" before")
+				err := next(ctx, msg)
+				calls = append(calls, name+" after")
+				return err
+			}
+		}
+	}
+	upperCase := func(next client.MessageHandler) client.MessageHandler {
+		return func(ctx context.Context, msg client.Message) error {
+			msg.Payload = []byte(strings.ToUpper(string(msg.Payload)))
+			return next(ctx, msg)
+		}
+	}
+
+	result := make(chan string, 1)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		bytes, err := ioutil.ReadAll(payload)
+		if err != nil {
+			return err
+		}
+		calls = append(calls, "handler")
+		result <- string(bytes)
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler, client.WithMiddleware(tracing("outer"), upperCase, tracing("inner")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := <-result; v != "BAR" {
+		t.Errorf("expected value: %s, but was: %s", "BAR", v)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := sub.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"outer before", "inner before", "handler", "inner after", "outer after"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls: %v, but was: %v", expected, calls)
+	}
+}
+
+func TestSubscribeOnIdle(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+
+	idle := make(chan time.Time, 10)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler, client.WithOnIdle(200*time.Millisecond, func(lastReceived time.Time) {
+		idle <- lastReceived
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	first := <-idle
+	publish(c, "BAR", "text/plain", topic, nil, t)
+	second := <-idle
+	if !second.After(first) {
+		t.Errorf("expected to be notified again after receiving a message, but got %v then %v", first, second)
+	}
+}
+
+func TestSubscribeReceiveDeadline(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+
+	publishWithKey(c, "BAR1", "key", t)
+
+	result := make(chan string, 10)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		bytes, err := ioutil.ReadAll(payload)
+		if err != nil {
+			return err
+		}
+		result <- string(bytes)
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c.Subscribe(context.Background(), "", true, eventHandler, eventErrHandler, client.WithReceiveDeadline(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	if v := <-result; v != "BAR1" {
+		t.Errorf("expected value: %s, but was: %s", "BAR1", v)
+	}
+	// let the receive streams expire a few times
+	time.Sleep(500 * time.Millisecond)
+	publishWithKey(c, "BAR2", "key", t)
+	if v := <-result; v != "BAR2" {
+		t.Errorf("expected value: %s, but was: %s", "BAR2", v)
+	}
+	select {
+	case v := <-result:
+		t.Errorf("unexpected redelivery of %s", v)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestSubscribeAtMostOnce(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+
+	publishWithKey(c, "BAR1", "key", t)
+	publishWithKey(c, "BAR2", "key", t)
+
+	failing := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		return errors.New("boom")
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {
+		cancel()
+	}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, failing, eventErrHandler, client.WithAtMostOnce())
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sub.Done()
+
+	result := make(chan string, 10)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		bytes, err := ioutil.ReadAll(payload)
+		if err != nil {
+			return err
+		}
+		result <- string(bytes)
+		return nil
+	}
+	sub, err = c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler, client.WithAtMostOnce())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	if v := <-result; v != "BAR2" {
+		t.Errorf("expected value: %s, but was: %s", "BAR2", v)
+	}
+}
+
+// offsetStore is an OffsetStore keeping offsets in memory, keyed by partition.
+type offsetStore map[uint32]uint64
+
+func (s offsetStore) Load(ctx context.Context, topic string, group string, partition uint32) (uint64, bool, error) {
+	offset, ok := s[partition]
+	return offset, ok, nil
+}
+
+func TestSubscribeOffsetStore(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+
+	first := publishWithKey(c, "BAR1", "key", t)
+	publishWithKey(c, "BAR2", "key", t)
+
+	result := make(chan string, 10)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		bytes, err := ioutil.ReadAll(payload)
+		if err != nil {
+			return err
+		}
+		result <- string(bytes)
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	store := offsetStore{first.Partition: first.Offset}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler, client.WithOffsetStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	if v := <-result; v != "BAR2" {
+		t.Errorf("expected value: %s, but was: %s", "BAR2", v)
+	}
+}
+
+func TestSubscribeDeduplication(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	first := publishWithKey(c, "BAR1", "key", t)
+	publishWithKey(c, "BAR2", "key", t)
+
+	values := make(chan string, 5)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		bytes, err := ioutil.ReadAll(payload)
+		if err != nil {
+			return err
+		}
+		values <- string(bytes)
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler, client.WithDeduplication(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	for i := 0; i < 2; i++ {
+		<-values
+	}
+	// redeliver the messages already handled
+	if err := sub.Seek(first.Partition, first.Offset); err != nil {
+		t.Fatal(err)
+	}
+	publishWithKey(c, "BAR3", "key", t)
+	if v := <-values; v != "BAR3" {
+		t.Errorf("expected value: %s, but was: %s", "BAR3", v)
+	}
+}
+
+func TestDeliveryAttempt(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publish(c, "BAR", "text/plain", topic, nil, t)
+
+	attempts := make(chan int, 5)
+	failing := func(ctx context.Context, msg client.Message) error {
+		attempts <- msg.Attempt
+		return errors.New("boom")
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {
+		cancel()
+	}
+	sub, err := c.SubscribeMessages(context.Background(), t.Name(), true, failing, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sub.Done()
+
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		attempt, _ := client.AttemptFromContext(ctx)
+		attempts <- attempt
+		return nil
+	}
+	sub, err = c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	for _, expected := range []int{1, 2} {
+		if attempt := <-attempts; attempt != expected {
+			t.Errorf("expected attempt: %d, but was: %d", expected, attempt)
+		}
+	}
+}
+
+func TestContextMetadata(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	published := publishWithKey(c, "BAR", "key", t)
+
+	type metadata struct {
+		partition uint32
+		offset    uint64
+		id        string
+	}
+	result := make(chan metadata, 1)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		var m metadata
+		var ok [3]bool
+		m.partition, ok[0] = client.PartitionFromContext(ctx)
+		m.offset, ok[1] = client.OffsetFromContext(ctx)
+		m.id, ok[2] = client.EventIDFromContext(ctx)
+		if ok != [3]bool{true, true, true} {
+			t.Errorf("expected metadata in context, but got %v", ok)
+		}
+		result <- m
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	m := <-result
+	if m.partition != published.Partition || m.offset != published.Offset {
+		t.Errorf("expected position: %d/%d, but was: %d/%d", published.Partition, published.Offset, m.partition, m.offset)
+	}
+	if m.id == "" {
+		t.Errorf("expected an event id")
+	}
+}
+
+func TestSubscriptionStats(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	published := publishWithKey(c, "BAR", "key", t)
+
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		stats := sub.Stats()
+		if len(stats.Partitions) == 1 && stats.Partitions[0].Committed && stats.Partitions[0].LagKnown {
+			if stats.LastReceived.IsZero() {
+				t.Errorf("expected a receive time")
+			}
+			expected := client.PartitionStats{
+				Topic:         topic,
+				Partition:     published.Partition,
+				LastHandled:   published.Offset,
+				LastCommitted: published.Offset,
+				Committed:     true,
+				Lag:           0,
+				LagKnown:      true,
+			}
+			if stats.Partitions[0] != expected {
+				t.Errorf("expected partition stats: %+v, but was: %+v", expected, stats.Partitions[0])
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for stats, last were: %+v", stats)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestLag(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	published := publishWithKey(c, "BAR1", "key", t)
+	publishWithKey(c, "BAR2", "key", t)
+
+	lag, err := c.Lag(context.Background(), t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag[published.Partition] != 2 {
+		t.Errorf("expected lag: %d, but was: %d", 2, lag[published.Partition])
+	}
+
+	r, err := c.NewReader(t.Name(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lag, err = c.Lag(context.Background(), t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag[published.Partition] != 1 {
+		t.Errorf("expected lag: %d, but was: %d", 1, lag[published.Partition])
+	}
+}
+
+func TestMonitorLag(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+
+	type crossing struct {
+		lag   uint64
+		above bool
+	}
+	crossings := make(chan crossing, 10)
+	m := c.MonitorLag(context.Background(), t.Name(), 50*time.Millisecond, client.WithLagThreshold(1, func(partition uint32, lag uint64, above bool) {
+		crossings <- crossing{lag: lag, above: above}
+	}))
+	defer m.Stop()
+
+	publishWithKey(c, "BAR1", "key", t)
+	publishWithKey(c, "BAR2", "key", t)
+	if c := <-crossings; !c.above || c.lag != 2 {
+		t.Errorf("expected lag to rise above threshold, but got %+v", c)
+	}
+
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	if c := <-crossings; c.above || c.lag > 1 {
+		t.Errorf("expected lag to fall below threshold, but got %+v", c)
+	}
+}
+
+func TestResetOffsets(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	var results []client.PublishResult
+	for _, v := range []string{"BAR1", "BAR2", "BAR3"} {
+		results = append(results, publishWithKey(c, v, "key", t))
+	}
+
+	next := func(opts ...client.SubscribeOption) string {
+		r, err := c.NewReader(t.Name(), true, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		msg, err := r.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(msg.Payload)
+	}
+
+	if err := c.ResetOffsetsToLatest(context.Background(), t.Name()); err != nil {
+		t.Fatal(err)
+	}
+	publishWithKey(c, "BAR4", "key", t)
+	if v := next(); v != "BAR4" {
+		t.Errorf("expected value: %s, but was: %s", "BAR4", v)
+	}
+
+	if err := c.ResetOffsets(context.Background(), t.Name(), map[uint32]uint64{results[1].Partition: results[1].Offset}); err != nil {
+		t.Fatal(err)
+	}
+	if v := next(); v != "BAR2" {
+		t.Errorf("expected value: %s, but was: %s", "BAR2", v)
+	}
+
+	version, err := c.ResetOffsetsToEarliest(context.Background(), t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("expected the group to move to version 1, but was: %d", version)
+	}
+	if v := next(client.WithGroupVersion(version)); v != "BAR1" {
+		t.Errorf("expected value: %s, but was: %s", "BAR1", v)
+	}
+	if err := c.ResetOffsets(context.Background(), "fresh", map[uint32]uint64{results[0].Partition: 0}); !errors.Is(err, client.ErrResetToStart) {
+		t.Errorf("expected resetting to offset 0 to fail with %v, but was: %v", client.ErrResetToStart, err)
+	}
+}
+
+func TestResetOffsetsToTime(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	publishWithKey(c, "BAR1", "key", t)
+	time.Sleep(10 * time.Millisecond)
+	between := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	publishWithKey(c, "BAR2", "key", t)
+
+	next := func(version uint32) string {
+		r, err := c.NewReader(t.Name(), true, client.WithGroupVersion(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		msg, err := r.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(msg.Payload)
+	}
+
+	version, err := c.ResetOffsetsToTime(context.Background(), t.Name(), between)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Errorf("expected the group to keep version 0, but was: %d", version)
+	}
+	if v := next(version); v != "BAR2" {
+		t.Errorf("expected value: %s, but was: %s", "BAR2", v)
+	}
+
+	// the first message is at offset 0, which can only be reset to in a new version
+	version, err = c.ResetOffsetsToTime(context.Background(), t.Name(), between.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("expected the group to move to version 1, but was: %d", version)
+	}
+	if v := next(version); v != "BAR1" {
+		t.Errorf("expected value: %s, but was: %s", "BAR1", v)
+	}
+}
+
+// failingAckTransport calls the gateway through a gRPC connection of its own, failing the acks of a partition.
+type failingAckTransport struct {
+	liiklus.LiiklusServiceClient
+	conn      *grpc.ClientConn
+	partition uint32
+}
+
+func (f *failingAckTransport) Ack(ctx context.Context, in *liiklus.AckRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	if in.Partition == f.partition {
+		return nil, status.Error(codes.Unavailable, "partition leader unreachable")
+	}
+	return f.LiiklusServiceClient.Ack(ctx, in, opts...)
+}
+
+func (f *failingAckTransport) Close() error {
+	return f.conn.Close()
+}
+
+func TestResetOffsetsPartly(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c, err := client.NewStreamClient("failing://gateway", topic, "text/plain", client.WithTransport(func(ctx context.Context, gateway string) (client.Transport, error) {
+		conn, err := grpc.DialContext(ctx, "localhost:6565", grpc.WithInsecure(), grpc.WithBlock())
+		if err != nil {
+			return nil, err
+		}
+		return &failingAckTransport{LiiklusServiceClient: liiklus.NewLiiklusServiceClient(conn), conn: conn, partition: 1}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// messages without a key are spread over both partitions
+	publish(c, "BAR1", "text/plain", topic, nil, t)
+	publish(c, "BAR2", "text/plain", topic, nil, t)
+
+	err = c.ResetOffsetsToLatest(context.Background(), t.Name())
+	var resetErr *client.ResetError
+	if !errors.As(err, &resetErr) {
+		t.Fatalf("expected a ResetError, but was: %v", err)
+	}
+	if !reflect.DeepEqual(resetErr.Reset, []uint32{0}) || len(resetErr.Failed) != 1 || resetErr.Failed[1] == nil {
+		t.Errorf("expected partition 0 to be reset and partition 1 not, but was: %v", resetErr)
+	}
+	if !errors.Is(err, client.ErrGatewayUnavailable) {
+		t.Errorf("expected the error of partition 1 to be unwrapped, but was: %v", err)
+	}
+	offsets, err := c.CommittedOffsets(context.Background(), t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := offsets[0]; !ok || len(offsets) != 1 {
+		t.Errorf("expected only the offset of partition 0 to be committed, but was: %v", offsets)
+	}
+}
+
+func TestResetOffsetsOfGroupVersion(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	first := publishWithKey(c, "BAR1", "key", t)
+	publishWithKey(c, "BAR2", "key", t)
+
+	if err := c.ResetOffsets(context.Background(), t.Name(), map[uint32]uint64{first.Partition: first.Offset + 1}, client.WithGroupVersion(2)); err != nil {
+		t.Fatal(err)
+	}
+	committed := func(version uint32) string {
+		var doc bytes.Buffer
+		if err := c.ExportOffsets(context.Background(), t.Name(), &doc, client.WithGroupVersion(version)); err != nil {
+			t.Fatal(err)
+		}
+		var exported client.OffsetsDocument
+		if err := json.Unmarshal(doc.Bytes(), &exported); err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(exported.Offsets)
+	}
+	if offsets, expected := committed(2), fmt.Sprint(map[uint32]uint64{first.Partition: first.Offset}); offsets != expected {
+		t.Errorf("expected offsets of version 2: %s, but was: %s", expected, offsets)
+	}
+	if offsets := committed(0); offsets != fmt.Sprint(map[uint32]uint64{}) {
+		t.Errorf("expected no offsets for version 0, but was: %s", offsets)
+	}
+
+	r, err := c.NewReader(t.Name(), true, client.WithGroupVersion(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := r.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Payload) != "BAR2" {
+		t.Errorf("expected value: %s, but was: %s", "BAR2", msg.Payload)
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publishWithKey(c, "BAR1", "key", t)
+	last := publishWithKey(c, "BAR2", "key", t)
+
+	ends, err := c.EndOffsets(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok := ends[last.Partition]; !ok || offset != last.Offset {
+		t.Errorf("expected end offset: %d, but was: %d", last.Offset, offset)
+	}
+
+	if err := c.ResetOffsetsToLatest(context.Background(), t.Name()); err != nil {
+		t.Fatal(err)
+	}
+	committed, err := c.CommittedOffsets(context.Background(), t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok := committed[last.Partition]; !ok || offset != last.Offset {
+		t.Errorf("expected committed offset: %d, but was: %d", last.Offset, offset)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	published := publishWithKey(c, "BAR", "key", t)
+
+	metadata, err := c.Metadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Topic != topic {
+		t.Errorf("expected topic: %s, but was: %s", topic, metadata.Topic)
+	}
+	if metadata.Partitions <= published.Partition {
+		t.Errorf("expected more than %d partitions, but was: %d", published.Partition, metadata.Partitions)
+	}
+	expected := map[uint32]uint64{published.Partition: published.Offset}
+	if !reflect.DeepEqual(metadata.EndOffsets, expected) {
+		t.Errorf("expected end offsets: %v, but was: %v", expected, metadata.EndOffsets)
+	}
+}
+
+func TestExportImportOffsets(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publishWithKey(c, "BAR1", "key", t)
+	publishWithKey(c, "BAR2", "key", t)
+	if err := c.ResetOffsetsToLatest(context.Background(), "blue"); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc bytes.Buffer
+	if err := c.ExportOffsets(context.Background(), "blue", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ImportOffsets(context.Background(), &doc, "green"); err != nil {
+		t.Fatal(err)
+	}
+	blue, err := c.CommittedOffsets(context.Background(), "blue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	green, err := c.CommittedOffsets(context.Background(), "green")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(blue, green) {
+		t.Errorf("expected offsets: %v, but was: %v", blue, green)
+	}
+}
+
+func TestExport(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	for _, v := range []string{"BAR1", "BAR2", "BAR3"} {
+		publishWithKey(c, v, "key", t)
+	}
+
+	var out bytes.Buffer
+	if err := c.Export(context.Background(), &out, 1, 10); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 events, but was: %q", out.String())
+	}
+	for i, expected := range []string{"BAR2", "BAR3"} {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event["data"] != expected || event["partitionkey"] != "key" || event["specversion"] != "1.0" {
+			t.Errorf("unexpected event: %v", event)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	now := time.Now()
+	source := topicName(t.Name(), fmt.Sprintf("%d%d%d_src", now.Hour(), now.Minute(), now.Second()))
+	target := topicName(t.Name(), fmt.Sprintf("%d%d%d_dst", now.Hour(), now.Minute(), now.Second()))
+
+	c1 := setupStreamingClient(source, t)
+	c2 := setupStreamingClient(target, t)
+	publishWithKey(c1, "BAR1", "key", t)
+	publishWithKey(c1, "BAR2", "key", t)
+
+	var events bytes.Buffer
+	if err := c1.Export(context.Background(), &events, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Import(context.Background(), bytes.NewReader(events.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	var exported, imported []client.Message
+	for _, c := range []struct {
+		client *client.StreamClient
+		msgs   *[]client.Message
+	}{{c1, &exported}, {c2, &imported}} {
+		msgs := c.msgs
+		if err := c.client.Tail(context.Background(), 10, func(ctx context.Context, msg client.Message) error {
+			*msgs = append(*msgs, msg)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(imported) != len(exported) {
+		t.Fatalf("expected %d events, but was: %d", len(exported), len(imported))
+	}
+	for i := range exported {
+		e, m := exported[i], imported[i]
+		if e.ID != m.ID || string(e.Payload) != string(m.Payload) || string(e.Key) != string(m.Key) || e.ContentType != m.ContentType {
+			t.Errorf("expected event: %+v, but was: %+v", e, m)
+		}
+	}
+}
+
+func TestImportBinaryKeys(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	c1 := setupStreamingClient(topicName(t.Name()+"-src", suffix), t)
+	defer c1.Close()
+	c2 := setupStreamingClient(topicName(t.Name()+"-dst", suffix), t)
+	defer c2.Close()
+	keys := [][]byte{{0xff}, {0xfe, 0x01}, {0x80, 0x81, 0x82}, {0xc3, 0x28}}
+	for i, key := range keys {
+		if _, err := c1.Publish(context.Background(), strings.NewReader(strconv.Itoa(i)), bytes.NewReader(key), "text/plain", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var events bytes.Buffer
+	if err := c1.Export(context.Background(), &events, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Import(context.Background(), bytes.NewReader(events.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	received := func(c *client.StreamClient) map[string]client.Message {
+		msgs := make(map[string]client.Message)
+		if err := c.Tail(context.Background(), 10, func(ctx context.Context, msg client.Message) error {
+			msgs[string(msg.Payload)] = msg
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return msgs
+	}
+	exported, imported := received(c1), received(c2)
+	for i, key := range keys {
+		e, m := exported[strconv.Itoa(i)], imported[strconv.Itoa(i)]
+		if !bytes.Equal(m.Key, key) || m.Partition != e.Partition {
+			t.Errorf("expected event %d with key %x in partition %d, but was key %x in partition %d", i, key, e.Partition, m.Key, m.Partition)
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	now := time.Now()
+	source := topicName(t.Name(), fmt.Sprintf("%d%d%d_src", now.Hour(), now.Minute(), now.Second()))
+	target := topicName(t.Name(), fmt.Sprintf("%d%d%d_dst", now.Hour(), now.Minute(), now.Second()))
+
+	c1 := setupStreamingClient(source, t)
+	c2 := setupStreamingClient(target, t)
+	for _, v := range []string{"bar1", "skip", "bar2"} {
+		publishWithKey(c1, v, "key", t)
+	}
+
+	transform := func(ctx context.Context, msg client.Message) (client.Message, bool, error) {
+		msg.Payload = []byte(strings.ToUpper(string(msg.Payload)))
+		return msg, string(msg.Payload) != "SKIP", nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := client.NewBridge(c1, c2, t.Name(), transform).Start(context.Background(), eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+
+	r, err := c2.NewReader("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, expected := range []string{"BAR1", "BAR2"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		msg, err := r.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Payload) != expected || string(msg.Key) != "key" {
+			t.Errorf("expected value: %s, but was: %s", expected, msg.Payload)
+		}
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	now := time.Now()
+	requestTopic := topicName(t.Name(), fmt.Sprintf("%d%d%d_req", now.Hour(), now.Minute(), now.Second()))
+	replyTopic := topicName(t.Name(), fmt.Sprintf("%d%d%d_rep", now.Hour(), now.Minute(), now.Second()))
+
+	requests := setupStreamingClient(requestTopic, t)
+	replies := setupStreamingClient(replyTopic, t)
+
+	handler := func(ctx context.Context, request client.Message) ([]byte, string, error) {
+		if string(request.Payload) == "fail" {
+			return nil, "", errors.New("boom")
+		}
+		return []byte(strings.ToUpper(string(request.Payload))), "text/plain", nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := requests.Respond(context.Background(), t.Name(), true, handler, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+
+	requester, err := client.NewRequester(context.Background(), requests, replies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer requester.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reply, err := requester.Request(ctx, []byte("bar"), "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply.Payload) != "BAR" {
+		t.Errorf("expected value: %s, but was: %s", "BAR", reply.Payload)
+	}
+	var requestErr *client.RequestError
+	if _, err := requester.Request(ctx, []byte("fail"), "text/plain", nil); !errors.As(err, &requestErr) || requestErr.Message != "boom" {
+		t.Errorf("expected request to fail, but got: %v", err)
+	}
+}
+
+func TestRequesterOutlivesItsCreationContext(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	requests := setupStreamingClient(topicName(t.Name()+"-req", suffix), t)
+	defer requests.Close()
+	replies, err := client.NewStreamClient("localhost:6565", topicName(t.Name()+"-rep", suffix), "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replies.Close()
+
+	sub, err := requests.Respond(context.Background(), t.Name(), true, func(ctx context.Context, request client.Message) ([]byte, string, error) {
+		if string(request.Payload) == "text" {
+			return request.Payload, "text/plain", nil
+		}
+		return []byte(`{"v":1}`), "application/json", nil
+	}, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	created, cancelCreation := context.WithCancel(context.Background())
+	requester, err := client.NewRequester(created, requests, replies)
+	cancelCreation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer requester.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if reply, err := requester.Request(ctx, []byte("json"), "text/plain", nil); err != nil || string(reply.Payload) != `{"v":1}` {
+		t.Errorf("expected a JSON reply, but was: %q, %v", reply.Payload, err)
+	}
+	// replies incompatible with the stream of replies are not published, an error reply is instead
+	var requestErr *client.RequestError
+	if _, err := requester.Request(ctx, []byte("text"), "text/plain", nil); !errors.As(err, &requestErr) || !strings.Contains(requestErr.Message, "text/plain") {
+		t.Errorf("expected the request to fail with a content type error, but got: %v", err)
+	}
+}
+
+func TestRequesterSkipsPastReplies(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	requestTopic := topicName(t.Name()+"-req", suffix)
+	replyTopic := topicName(t.Name()+"-rep", suffix)
+	requests := setupStreamingClient(requestTopic, t)
+	defer requests.Close()
+	replies := setupStreamingClient(replyTopic, t)
+	defer replies.Close()
+	// every non empty partition holds several past replies
+	for _, key := range []string{"a", "b", "c", "d", "a", "b", "c", "d"} {
+		publishWithKey(replies, "past", key, t)
+	}
+	stats := &countingStats{}
+	replies.SetStatsHandler(stats)
+
+	sub, err := requests.Respond(context.Background(), t.Name(), true, func(ctx context.Context, request client.Message) ([]byte, string, error) {
+		return request.Payload, "text/plain", nil
+	}, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	requester, err := client.NewRequester(context.Background(), requests, replies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer requester.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reply, err := requester.Request(ctx, []byte("bar"), "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Source != requestTopic || reply.Type != "riff-event"+client.ReplyTypeSuffix {
+		t.Errorf("expected a reply of source %s and type %s, but was %s and %s", requestTopic, "riff-event"+client.ReplyTypeSuffix, reply.Source, reply.Type)
+	}
+	if received := atomic.LoadInt64(&stats.received); received != 1 {
+		t.Errorf("expected only the reply to be received, but %d messages were", received)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	now := time.Now()
+	source := topicName(t.Name(), fmt.Sprintf("%d%d%d_src", now.Hour(), now.Minute(), now.Second()))
+	even := topicName(t.Name(), fmt.Sprintf("%d%d%d_even", now.Hour(), now.Minute(), now.Second()))
+	odd := topicName(t.Name(), fmt.Sprintf("%d%d%d_odd", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(source, t)
+	for _, v := range []string{"BAR1", "BAR2", "BAR3", "BAR4"} {
+		publishWithKey(c, v, "key", t)
+	}
+
+	r := client.NewRouter(c, t.Name())
+	r.Route(func(msg client.Message) bool {
+		return msg.Offset%2 == 0
+	}, even)
+	r.Fallback(odd)
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := r.Start(context.Background(), eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+
+	for topic, expected := range map[string][]string{even: {"BAR1", "BAR3"}, odd: {"BAR2", "BAR4"}} {
+		reader, err := setupStreamingClient(topic, t).NewReader("", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range expected {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			msg, err := reader.Next(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg.Payload) != e {
+				t.Errorf("expected value: %s, but was: %s", e, msg.Payload)
+			}
+		}
+		reader.Close()
+	}
+}
+
+func TestProcessor(t *testing.T) {
+	now := time.Now()
+	input := topicName(t.Name(), fmt.Sprintf("%d%d%d_in", now.Hour(), now.Minute(), now.Second()))
+	output := topicName(t.Name(), fmt.Sprintf("%d%d%d_out", now.Hour(), now.Minute(), now.Second()))
+
+	in := setupStreamingClient(input, t)
+	out := setupStreamingClient(output, t)
+	publishWithKey(in, "bar1", "key", t)
+	publishWithKey(in, "bar2", "key", t)
+
+	// duplicates each message, upper cased
+	process := func(ctx context.Context, msg client.Message) ([]client.Output, error) {
+		o := client.Output{Payload: []byte(strings.ToUpper(string(msg.Payload))), ContentType: "text/plain", Key: msg.Key}
+		return []client.Output{o, o}, nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := client.NewProcessor(in, out, t.Name(), process).Start(context.Background(), eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+
+	r, err := out.NewReader("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, expected := range []string{"BAR1", "BAR1", "BAR2", "BAR2"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		msg, err := r.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Payload) != expected {
+			t.Errorf("expected value: %s, but was: %s", expected, msg.Payload)
+		}
+	}
+}
+
+func TestStreamWriterReader(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+
+	w := c.NewStreamWriter(context.Background(), "text/plain", bufio.ScanLines)
+	if _, err := io.WriteString(w, "BAR1\nBA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "R2\nBAR3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.NewReader("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := client.NewStreamReader(r, []byte("\n"))
+	defer sr.Close()
+	// events of different partitions may be read in any order
+	lines := make(map[string]bool)
+	scanner := bufio.NewScanner(sr)
+	for len(lines) < 3 && scanner.Scan() {
+		lines[scanner.Text()] = true
+	}
+	expected := map[string]bool{"BAR1": true, "BAR2": true, "BAR3": true}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected lines: %v, but was: %v", expected, lines)
+	}
+}
+
+func TestPublishSubscribeJSON(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c, err := client.NewStreamClient("localhost:6565", topic, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type order struct {
+		ID    string `json:"id"`
+		Total int    `json:"total"`
+	}
+	expected := order{ID: "42", Total: 100}
+	if _, err := client.PublishJSON(context.Background(), c, expected, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan order, 1)
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := client.SubscribeJSON(context.Background(), c, t.Name(), true, func(ctx context.Context, o order, msg client.Message) error {
+		result <- o
+		return nil
+	}, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	if o := <-result; o != expected {
+		t.Errorf("expected value: %+v, but was: %+v", expected, o)
+	}
+}
+
+// upperCodec is a Codec for strings, which are upper cased in payloads.
+type upperCodec struct{}
+
+func (upperCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(string))), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = strings.ToLower(string(data))
+	return nil
+}
+
+func TestCodec(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	c.RegisterCodec("text/plain", upperCodec{})
+	if _, err := c.PublishValue(context.Background(), "bar", nil, "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.NewReader("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := r.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Payload) != "BAR" {
+		t.Errorf("expected payload: %s, but was: %s", "BAR", msg.Payload)
+	}
+	var value string
+	if err := c.Decode(msg, &value); err != nil {
+		t.Fatal(err)
+	}
+	if value != "bar" {
+		t.Errorf("expected value: %s, but was: %s", "bar", value)
+	}
+}
+
+func TestPublishProto(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c, err := client.NewStreamClient("localhost:6565", topic, client.ProtobufContentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// any generated message will do
+	expected := &liiklus.Assignment{SessionId: "42", Partition: 3}
+	if _, err := c.PublishProto(context.Background(), expected, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.NewReader("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := r.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var assignment liiklus.Assignment
+	if err := client.DecodeProto(msg, &assignment); err != nil {
+		t.Fatal(err)
+	}
+	if assignment.SessionId != expected.SessionId || assignment.Partition != expected.Partition {
+		t.Errorf("expected value: %v, but was: %v", expected, &assignment)
+	}
+	if err := client.DecodeProto(msg, &liiklus.AckRequest{}); err == nil {
+		t.Errorf("expected decoding into another message type to fail")
+	}
+}
+
+func TestValidation(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publishWithKey(c, "bar1", "key", t)
+	publishWithKey(c, "BAR2", "key", t)
+
+	upperCase := client.ValidatorFunc(func(contentType string, payload []byte) error {
+		if strings.ToUpper(string(payload)) != string(payload) {
+			return errors.New("not upper case")
+		}
+		return nil
+	})
+	c.SetValidator(upperCase)
+	var validationErr *client.ValidationError
+	if _, err := c.Publish(context.Background(), strings.NewReader("bar3"), nil, "text/plain", nil); !errors.As(err, &validationErr) {
+		t.Errorf("expected publishing to fail validation, but got: %v", err)
+	}
+
+	values := make(chan string, 5)
+	errs := make(chan error, 5)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		bytes, err := ioutil.ReadAll(payload)
+		if err != nil {
+			return err
+		}
+		values <- string(bytes)
+		return nil
+	}
+	eventErrHandler := func(cancel context.CancelFunc, err error) {
+		errs <- err
+	}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, eventErrHandler, client.WithValidation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	if err := <-errs; !errors.As(err, &validationErr) {
+		t.Errorf("expected a validation error, but got: %v", err)
+	}
+	if v := <-values; v != "BAR2" {
+		t.Errorf("expected value: %s, but was: %s", "BAR2", v)
+	}
+}
+
+func TestSchemaVersions(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c, err := client.NewStreamClient("localhost:6565", topic, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type personV1 struct {
+		Name string `json:"name"`
+	}
+	type personV2 struct {
+		First string `json:"first"`
+		Last  string `json:"last"`
+	}
+	key := "key"
+	if _, err := c.PublishValue(context.Background(), personV1{Name: "Ada Lovelace"}, strings.NewReader(key), "application/json", nil); err != nil {
+		t.Fatal(err)
+	}
+	c.SetSchemaVersion(2)
+	if _, err := c.PublishValue(context.Background(), personV2{First: "Grace", Last: "Hopper"}, strings.NewReader(key), "application/json", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := client.NewSchema[personV2]().
+		Decoder(1, client.CodecDecoder[personV1](c)).
+		Decoder(2, client.CodecDecoder[personV2](c)).
+		Upcaster(1, func(v interface{}) (interface{}, error) {
+			names := strings.SplitN(v.(personV1).Name, " ", 2)
+			return personV2{First: names[0], Last: names[1]}, nil
+		})
+
+	r, err := c.NewReader("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i, expected := range []personV2{{First: "Ada", Last: "Lovelace"}, {First: "Grace", Last: "Hopper"}} {
+		msg, err := r.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version, err := client.SchemaVersion(msg); err != nil || version != i+1 {
+			t.Errorf("expected schema version %d, but was: %d (%v)", i+1, version, err)
+		}
+		value, err := schema.Decode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Errorf("expected value: %v, but was: %v", expected, value)
+		}
+	}
+}
+
+func TestPublishSubscribeRaw(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	for _, value := range []string{"raw1", "raw2"} {
+		if _, err := c.PublishRaw(context.Background(), strings.NewReader(value), strings.NewReader("key")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := make(chan client.Message, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := c.SubscribeRaw(ctx, "", true, func(ctx context.Context, msg client.Message) error {
+		messages <- msg
+		return nil
+	}, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"raw1", "raw2"} {
+		select {
+		case msg := <-messages:
+			if string(msg.Payload) != expected || string(msg.Key) != "key" {
+				t.Errorf("expected record %q with key %q, but was: %q with key %q", expected, "key", msg.Payload, msg.Key)
+			}
+			if msg.ID != "" || msg.ContentType != "" {
+				t.Errorf("expected a raw record, but was: %+v", msg)
+			}
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for raw records")
+		}
+	}
+}
+
+func TestUndecodableRecords(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	if _, err := c.PublishRaw(context.Background(), strings.NewReader("foreign"), strings.NewReader("key")); err != nil {
+		t.Fatal(err)
+	}
+	publishWithKey(c, "bar", "key", t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// by default, undecodable records are reported and skipped
+	errs := make(chan error, 2)
+	values := make(chan string, 2)
+	_, err := c.SubscribeMessages(ctx, "", true, func(ctx context.Context, msg client.Message) error {
+		values <- string(msg.Payload)
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		errs <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decodeErr *client.DecodeError
+	select {
+	case err := <-errs:
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("expected a decode error, but got: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for decode error")
+	}
+	select {
+	case value := <-values:
+		if value != "bar" {
+			t.Errorf("expected value: %q, but was: %q", "bar", value)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for event")
+	}
+
+	// with a fallback, they are handed over as synthetic events
+	messages := make(chan client.Message, 2)
+	_, err = c.SubscribeMessages(ctx, "", true, func(ctx context.Context, msg client.Message) error {
+		messages <- msg
+		return nil
+	}, func(cancel context.CancelFunc, err error) {}, client.WithFallbackContentType("text/plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-messages:
+		if string(msg.Payload) != "foreign" || msg.ContentType != "text/plain" || msg.ID == "" {
+			t.Errorf("expected a synthetic text/plain event holding %q, but was: %+v", "foreign", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for synthetic event")
+	}
+
+	// or routed to a decode error handler
+	undecodable := make(chan client.Message, 2)
+	_, err = c.SubscribeMessages(ctx, "", true, func(ctx context.Context, msg client.Message) error {
+		return nil
+	}, func(cancel context.CancelFunc, err error) {}, client.WithDecodeErrorHandler(func(ctx context.Context, msg client.Message, err *client.DecodeError) {
+		undecodable <- msg
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-undecodable:
+		if string(msg.Payload) != "foreign" {
+			t.Errorf("expected undecodable record %q, but was: %q", "foreign", msg.Payload)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for undecodable record")
+	}
+}
+
+func TestEventAttributeHeaders(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	headers := map[string]string{
+		client.IDHeader:     "42",
+		client.SourceHeader: "https://example.com/orders",
+		"Ce-Type":           "com.example.order.created",
+		"tenant":            "acme",
+	}
+	if _, err := c.Publish(context.Background(), strings.NewReader("bar"), nil, "text/plain", headers); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.NewReader("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := r.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != "42" || msg.Source != "https://example.com/orders" || msg.Type != "com.example.order.created" {
+		t.Errorf("expected attributes to be set from headers, but was: %+v", msg)
+	}
+	if len(msg.Headers) != 1 || msg.Headers["tenant"] != "acme" {
+		t.Errorf("expected headers: %v, but was: %v", map[string]string{"tenant": "acme"}, msg.Headers)
+	}
+}
+
+func TestPublishOptions(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	schema := "https://example.com/schemas/order.json"
+	if _, err := c.Publish(context.Background(), strings.NewReader("bar"), nil, "text/plain", nil,
+		client.WithDataSchema(schema), client.WithExtension("tenant", "acme")); err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []client.PublishOption{client.WithDataSchema("not a uri"), client.WithExtension("Tenant", "acme"), client.WithExtension("source", "elsewhere")} {
+		if _, err := c.Publish(context.Background(), strings.NewReader("bar"), nil, "text/plain", nil, opt); err == nil {
+			t.Errorf("expected publishing with an invalid option to fail")
+		}
+	}
+
+	r, err := c.NewReader("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := r.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Headers[client.DataSchemaHeader] != schema || msg.Headers["tenant"] != "acme" {
+		t.Errorf("expected data schema and extension attributes, but got: %v", msg.Headers)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	expected := map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "tenant": "acme"}
+	if _, err := c.Publish(context.Background(), strings.NewReader("bar"), nil, "text/plain", expected); err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan map[string]string, 1)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		result <- headers
+		return nil
+	}
+	sub, err := c.Subscribe(context.Background(), "", true, eventHandler, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	select {
+	case headers := <-result:
+		if !reflect.DeepEqual(headers, expected) {
+			t.Errorf("expected headers: %v, but was: %v", expected, headers)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func TestEventTimeAndLatency(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	before := time.Now()
+	publishWithKey(c, "bar", "key", t)
+
+	type times struct {
+		time, timestamp time.Time
+	}
+	result := make(chan times, 1)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		var ts times
+		var ok [2]bool
+		ts.time, ok[0] = client.TimeFromContext(ctx)
+		ts.timestamp, ok[1] = client.TimestampFromContext(ctx)
+		if ok != [2]bool{true, true} {
+			t.Errorf("expected times in context, but got %v", ok)
+		}
+		result <- ts
+		return nil
+	}
+	histogram := client.NewLatencyHistogram()
+	sub, err := c.Subscribe(context.Background(), "", true, eventHandler, func(cancel context.CancelFunc, err error) {}, client.WithLatencyObserver(histogram))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	select {
+	case ts := <-result:
+		if ts.time.Before(before.Add(-time.Second)) || ts.time.After(time.Now()) {
+			t.Errorf("expected event time around %v, but was: %v", before, ts.time)
+		}
+		if ts.timestamp.IsZero() {
+			t.Errorf("expected a broker timestamp")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	snapshot := histogram.Snapshot()
+	if snapshot.Count != 1 || len(snapshot.Buckets) != len(client.DefaultLatencyBuckets) {
+		t.Errorf("expected a single latency to be observed, but got: %+v", snapshot)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	recorder := tracetest.NewSpanRecorder()
+	c.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	publishWithKey(c, "bar", "key", t)
+
+	handled := make(chan struct{}, 1)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		handled <- struct{}{}
+		return nil
+	}
+	sub, err := c.Subscribe(context.Background(), "", true, eventHandler, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	sub.Cancel()
+	<-sub.Done()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected a producer and a consumer span, but got %d spans", len(spans))
+	}
+	producer, consumer := spans[0], spans[1]
+	if producer.SpanKind() != trace.SpanKindProducer || consumer.SpanKind() != trace.SpanKindConsumer {
+		t.Errorf("expected a producer and a consumer span, but got %v and %v", producer.SpanKind(), consumer.SpanKind())
+	}
+	if consumer.Parent().SpanID() != producer.SpanContext().SpanID() {
+		t.Errorf("expected the consumer span to be a child of the producer span")
+	}
+}
+
+type countingStats struct {
+	client.BaseStatsHandler
+	published, received, handled int64
+}
+
+func (s *countingStats) OnPublish(stats client.PublishStats) {
+	atomic.AddInt64(&s.published, 1)
+}
+
+func (s *countingStats) OnReceive(stats client.ReceiveStats) {
+	atomic.AddInt64(&s.received, 1)
+}
+
+func (s *countingStats) OnHandle(stats client.HandleStats) {
+	atomic.AddInt64(&s.handled, int64(stats.Count))
+}
+
+func TestStatsHandler(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	stats := &countingStats{}
+	c.SetStatsHandler(stats)
+	publishWithKey(c, "bar", "key", t)
+
+	handled := make(chan struct{}, 1)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		handled <- struct{}{}
+		return nil
+	}
+	sub, err := c.Subscribe(context.Background(), "", true, eventHandler, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	sub.Cancel()
+	<-sub.Done()
+	if published, received, handled := atomic.LoadInt64(&stats.published), atomic.LoadInt64(&stats.received), atomic.LoadInt64(&stats.handled); published != 1 || received != 1 || handled != 1 {
+		t.Errorf("expected 1 event published, received and handled, but got %d, %d and %d", published, received, handled)
+	}
+}
+
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+	lines    []string
+}
+
+func (l *recordingLogger) record(msg string, keysAndValues []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, msg)
+	l.lines = append(l.lines, fmt.Sprint(append([]interface{}{msg}, keysAndValues...)...))
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.record(msg, keysAndValues)
+}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.record(msg, keysAndValues)
+}
+
+func (l *recordingLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.record(msg, keysAndValues)
+}
+
+func (l *recordingLogger) logged(msg string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.messages {
+		if m == msg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLogger(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	logger := &recordingLogger{}
+	c, err := client.NewStreamClient("localhost:6565", topic, "text/plain", client.WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled := make(chan struct{}, 1)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		handled <- struct{}{}
+		return nil
+	}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishWithKey(c, "bar", "key", t)
+	select {
+	case <-handled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for sub.Stats().Processed == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-sub.Done()
+	for _, msg := range []string{"connected to gateway", "subscribed", "committed offset", "closing client", "subscription terminated"} {
+		for !logger.logged(msg) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !logger.logged(msg) {
+			t.Errorf("expected %q to be logged", msg)
+		}
+	}
+}
+
+func TestDebugLogging(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	logger := &recordingLogger{}
+	c, err := client.NewStreamClient("localhost:6565", topic, "text/plain", client.WithLogger(logger), client.WithDebugLogging(client.RedactPayloads))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Publish(context.Background(), strings.NewReader("secret"), nil, "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	var logged bool
+	for _, line := range logger.lines {
+		if strings.Contains(line, "secret") {
+			t.Errorf("expected payloads to be redacted, but got: %s", line)
+		}
+		if strings.Contains(line, "/Publish") && strings.Contains(line, "REDACTED") {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Errorf("expected the publish request to be logged, but got: %v", logger.lines)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	_, err := c.Publish(context.Background(), strings.NewReader("{}"), nil, "application/json", nil)
+	var contentTypeErr *client.ContentTypeError
+	if !errors.Is(err, client.ErrIncompatibleContentType) || !errors.As(err, &contentTypeErr) || contentTypeErr.Expected != "text/plain" {
+		t.Errorf("expected an incompatible content type error, but got: %v", err)
+	}
+
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		return nil
+	}
+	sub, err := c.Subscribe(context.Background(), "", true, eventHandler, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Cancel()
+	<-sub.Done()
+	if err := sub.Seek(0, 0); !errors.Is(err, client.ErrSubscriptionClosed) {
+		t.Errorf("expected seeking a closed subscription to fail, but got: %v", err)
+	}
+
+	unavailable := &client.GatewayError{Op: "publish", Err: status.Error(codes.Unavailable, "connection refused")}
+	if !errors.Is(unavailable, client.ErrGatewayUnavailable) {
+		t.Errorf("expected %v to match ErrGatewayUnavailable", unavailable)
+	}
+	if notFound := (&client.GatewayError{Op: "ack", Err: status.Error(codes.NotFound, "unknown")}); errors.Is(notFound, client.ErrGatewayUnavailable) {
+		t.Errorf("expected %v not to match ErrGatewayUnavailable", notFound)
+	}
+}
+
+func TestSubscriptionError(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publishResult, err := c.Publish(context.Background(), strings.NewReader("boom"), nil, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cause := errors.New("boom")
+	errs := make(chan error, 1)
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		return cause
+	}
+	_, err = c.Subscribe(context.Background(), t.Name(), true, eventHandler, func(cancel context.CancelFunc, err error) {
+		cancel()
+		errs <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		var subErr *client.SubscriptionError
+		if !errors.As(err, &subErr) {
+			t.Fatalf("expected a SubscriptionError, but got: %v", err)
+		}
+		if subErr.Phase != client.PhaseHandle || subErr.Topic != topic || subErr.Group != t.Name() ||
+			subErr.Partition != publishResult.Partition || subErr.Offset != publishResult.Offset {
+			t.Errorf("unexpected error context: %+v", subErr)
+		}
+		if !errors.Is(err, cause) {
+			t.Errorf("expected %v to wrap the handler error", err)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("timed out waiting for the handler error")
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	c := setupStreamingClient(topic, t)
+	publishResult, err := c.Publish(context.Background(), strings.NewReader("hello"), nil, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var subscribed, assigned int32
+	committed := make(chan uint64, 10)
+	stopped := make(chan error, 1)
+	hooks := client.LifecycleHooks{
+		OnSubscribed: func(topics []string) {
+			atomic.AddInt32(&subscribed, 1)
+		},
+		OnAssignmentReceived: func(topic string, partition uint32) {
+			atomic.AddInt32(&assigned, 1)
+		},
+		OnCommitted: func(topic string, partition uint32, offset uint64) {
+			if partition == publishResult.Partition {
+				committed <- offset
+			}
+		},
+		OnStopped: func(err error) {
+			stopped <- err
+		},
+	}
+	eventHandler := func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		return nil
+	}
+	sub, err := c.Subscribe(context.Background(), t.Name(), true, eventHandler, func(cancel context.CancelFunc, err error) {}, client.WithLifecycleHooks(hooks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&subscribed) != 1 {
+		t.Errorf("expected OnSubscribed to be called once")
+	}
+
+	select {
+	case offset := <-committed:
+		if offset != publishResult.Offset {
+			t.Errorf("expected offset %d to be committed, got %d", publishResult.Offset, offset)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("timed out waiting for the offset to be committed")
+	}
+	if atomic.LoadInt32(&assigned) == 0 {
+		t.Errorf("expected OnAssignmentReceived to be called")
+	}
+
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("expected the subscription to stop without error, got: %v", err)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("timed out waiting for the subscription to stop")
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	now := time.Now()
+	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
+
+	const publishers, events, subscribers = 10, 20, 3
+	c := setupStreamingClient(topic, t)
+
+	var mu sync.Mutex
+	received := make([]map[string]bool, subscribers)
+	complete := make(chan int, subscribers)
+	var wg sync.WaitGroup
+	for i := 0; i < subscribers; i++ {
+		i := i
+		received[i] = map[string]bool{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Subscribe(context.Background(), fmt.Sprintf("%s%d", t.Name(), i), true, func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+				bytes, err := ioutil.ReadAll(payload)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				received[i][string(bytes)] = true
+				if len(received[i]) == publishers*events {
+					complete <- i
+				}
+				return nil
+			}, func(cancel context.CancelFunc, err error) {})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for p := 0; p < publishers; p++ {
+		p := p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := 0; e < events; e++ {
+				if _, err := c.Publish(context.Background(), strings.NewReader(fmt.Sprintf("%d-%d", p, e)), nil, "text/plain", nil); err != nil {
+					t.Error(err)
+				}
+				c.SetSchemaVersion(e % 2)
+				c.SetStatsHandler(client.BaseStatsHandler{})
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < subscribers; i++ {
+		select {
+		case <-complete:
+		case <-time.After(time.Second * 20):
+			t.Fatal("timed out waiting for all events to be received")
+		}
+	}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- c.Close()
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("expected closing concurrently to succeed, got: %v", err)
+		}
+	}
+	if _, err := c.Publish(context.Background(), strings.NewReader("late"), nil, "text/plain", nil); !errors.Is(err, client.ErrClientClosed) {
+		t.Errorf("expected publishing after Close to fail with ErrClientClosed, got: %v", err)
+	}
+	if _, err := c.Subscribe(context.Background(), t.Name(), true, nil, nil); !errors.Is(err, client.ErrClientClosed) {
+		t.Errorf("expected subscribing after Close to fail with ErrClientClosed, got: %v", err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "stream.yaml")
+	if err := ioutil.WriteFile(yamlFile, []byte(`
+gateway: localhost:6565
+topic: from-file
+contentType: text/plain
+topicPrefix: tenant-a.
+retries:
+  ackAttempts: 3
+  ackInterval: 250ms
+offsets:
+  group: orders
+  fromBeginning: true
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("STREAM_TOPIC", topicName(t.Name(), "env"))
+	t.Setenv("STREAM_GROUP_VERSION", "2")
+
+	config, err := client.LoadConfig(yamlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := client.Config{
+		Gateway:     "localhost:6565",
+		Topic:       topicName(t.Name(), "env"),
+		ContentType: "text/plain",
+		TopicPrefix: "tenant-a.",
+		Retries:     client.RetryConfig{AckAttempts: 3, AckInterval: client.Duration(250 * time.Millisecond)},
+		Offsets:     client.OffsetsConfig{Group: "orders", FromBeginning: true, GroupVersion: 2},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("expected %+v, got %+v", expected, config)
+	}
+	if opts := config.SubscribeOptions(); len(opts) != 2 {
+		t.Errorf("expected ack retries and group version options, got %d options", len(opts))
+	}
+
+	jsonFile := filepath.Join(dir, "stream.json")
+	if err := ioutil.WriteFile(jsonFile, []byte(`{"gateway": "localhost:6565", "contentType": "text/plain", "retries": {"ackInterval": "1s"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err = client.LoadConfig(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Topic != topicName(t.Name(), "env") || time.Duration(config.Retries.AckInterval) != time.Second {
+		t.Errorf("unexpected configuration loaded from JSON: %+v", config)
+	}
+
+	c, err := client.NewStreamClientFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Publish(context.Background(), strings.NewReader("hello"), nil, "text/plain", nil); err != nil {
+		t.Error(err)
+	}
+
+	config.TLS = client.TLSConfig{Enabled: true, CAFile: yamlFile}
+	if _, err := client.NewStreamClientFromConfig(config); err == nil {
+		t.Errorf("expected a CA file without certificates to be rejected")
+	}
+	t.Setenv("STREAM_TLS", "maybe")
+	if _, err := client.LoadConfig(""); err == nil {
+		t.Errorf("expected an invalid boolean to be rejected")
+	}
+}
+
+// rawCodec passes messages through as bytes, for proxying.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error)      { return *v.(*[]byte), nil }
+func (rawCodec) Unmarshal(data []byte, v interface{}) error { *v.(*[]byte) = data; return nil }
+func (rawCodec) Name() string                               { return "raw" }
+
+// grpcWebProxy serves a grpc-web endpoint proxying the gateway, as Envoy does.
+func grpcWebProxy(t *testing.T, gateway string) *httptest.Server {
+	conn, err := grpc.Dial(gateway, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil || len(body) < 5 || r.Header.Get("Content-Type") != "application/grpc-web+proto" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		request := body[5:]
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		writeFrame := func(flags byte, data []byte) {
+			prefix := make([]byte, 5)
+			prefix[0] = flags
+			binary.BigEndian.PutUint32(prefix[1:], uint32(len(data)))
+			w.Write(append(prefix, data...))
+			w.(http.Flusher).Flush()
+		}
+		stream, err := conn.NewStream(r.Context(), &grpc.StreamDesc{ServerStreams: true}, r.URL.Path, grpc.ForceCodec(rawCodec{}))
+		if err == nil {
+			err = stream.SendMsg(&request)
+		}
+		if err == nil {
+			err = stream.CloseSend()
+		}
+		for err == nil {
+			var reply []byte
+			if err = stream.RecvMsg(&reply); err == nil {
+				writeFrame(0, reply)
+			}
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		s := status.Convert(err)
+		writeFrame(0x80, []byte(fmt.Sprintf("grpc-status: %d\r\ngrpc-message: %s\r\n", s.Code(), url.PathEscape(s.Message()))))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGRPCWeb(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	proxy := grpcWebProxy(t, "localhost:6565")
+	c, err := client.NewStreamClient(proxy.URL, topic, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Publish(context.Background(), strings.NewReader("over grpc-web"), nil, "text/plain", map[string]string{"h": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan client.Message, 1)
+	sub, err := c.SubscribeMessages(context.Background(), "web", true, func(ctx context.Context, msg client.Message) error {
+		received <- msg
+		return nil
+	}, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if string(msg.Payload) != "over grpc-web" || msg.Headers["h"] != "v" {
+			t.Errorf("unexpected message %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	offsets, err := c.CommittedOffsets(context.Background(), "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 1 {
+		t.Errorf("expected the offset of the event to be committed, got %v", offsets)
+	}
+
+	if _, err := client.NewStreamClient(proxy.URL, topic, "text/plain", client.WithDialOptions(grpc.WithBlock())); err == nil {
+		t.Error("expected gRPC dial options to be rejected for grpc-web gateways")
+	}
+	unreachable, err := client.NewStreamClient("http://localhost:1", topic, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unreachable.Close()
+	if _, err := unreachable.Publish(context.Background(), strings.NewReader("lost"), nil, "text/plain", nil); !errors.Is(err, client.ErrGatewayUnavailable) {
+		t.Errorf("expected ErrGatewayUnavailable, got %v", err)
+	}
+}
+
+// rsocketProxy serves the RSocket-RPC API of liiklus over TCP, proxying the gateway, honoring the number of messages
+// requested by the client and the cancellation of streams.
+func rsocketProxy(t *testing.T, gateway string) string {
+	conn, err := grpc.Dial(gateway, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	serve := func(nc net.Conn) {
+		defer nc.Close()
+		var writeMu sync.Mutex
+		writeFrame := func(id uint32, typeAndFlags uint16, body []byte) {
+			frame := make([]byte, 9, 9+len(body))
+			length := 6 + len(body)
+			frame[0], frame[1], frame[2] = byte(length>>16), byte(length>>8), byte(length)
+			binary.BigEndian.PutUint32(frame[3:], id)
+			binary.BigEndian.PutUint16(frame[7:], typeAndFlags)
+			writeMu.Lock()
+			nc.Write(append(frame, body...))
+			writeMu.Unlock()
+		}
+		type stream struct {
+			cancel  context.CancelFunc
+			credits chan struct{}
+		}
+		streams := map[uint32]*stream{}
+		var mu sync.Mutex
+		reader := bufio.NewReader(nc)
+		for {
+			length := make([]byte, 3)
+			if _, err := io.ReadFull(reader, length); err != nil {
+				return
+			}
+			frame := make([]byte, int(length[0])<<16|int(length[1])<<8|int(length[2]))
+			if _, err := io.ReadFull(reader, frame); err != nil {
+				return
+			}
+			id := binary.BigEndian.Uint32(frame)
+			frameType, flags, body := frame[4]>>2, frame[5], frame[6:]
+			switch frameType {
+			case 0x03: // KEEPALIVE
+				if flags&0x80 != 0 {
+					writeFrame(0, 0x03<<10, body)
+				}
+			case 0x08: // REQUEST_N
+				mu.Lock()
+				if s := streams[id]; s != nil {
+					for i := binary.BigEndian.Uint32(body); i > 0; i-- {
+						s.credits <- struct{}{}
+					}
+				}
+				mu.Unlock()
+			case 0x09: // CANCEL
+				mu.Lock()
+				if s := streams[id]; s != nil {
+					s.cancel()
+				}
+				mu.Unlock()
+			case 0x04, 0x06: // REQUEST_RESPONSE, REQUEST_STREAM
+				credits := uint32(1)
+				if frameType == 0x06 {
+					credits, body = binary.BigEndian.Uint32(body), body[4:]
+				}
+				mdLength := int(body[0])<<16 | int(body[1])<<8 | int(body[2])
+				md, request := body[3:3+mdLength], body[3+mdLength:]
+				service := md[4 : 4+binary.BigEndian.Uint16(md[2:])]
+				md = md[4+len(service):]
+				method := md[2 : 2+binary.BigEndian.Uint16(md)]
+				ctx, cancel := context.WithCancel(context.Background())
+				s := &stream{cancel: cancel, credits: make(chan struct{}, 1024)}
+				for i := uint32(0); i < credits; i++ {
+					s.credits <- struct{}{}
+				}
+				mu.Lock()
+				streams[id] = s
+				mu.Unlock()
+				go func() {
+					defer cancel()
+					path := "/" + string(service) + "/" + string(method)
+					upstream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, path, grpc.ForceCodec(rawCodec{}))
+					if err == nil {
+						err = upstream.SendMsg(&request)
+					}
+					if err == nil {
+						err = upstream.CloseSend()
+					}
+					for err == nil {
+						var reply []byte
+						if err = upstream.RecvMsg(&reply); err != nil {
+							break
+						}
+						select {
+						case <-s.credits:
+						case <-ctx.Done():
+							return
+						}
+						if frameType == 0x04 {
+							writeFrame(id, 0x0A<<10|0x60, reply) // PAYLOAD, NEXT and COMPLETE
+							return
+						}
+						writeFrame(id, 0x0A<<10|0x20, reply) // PAYLOAD, NEXT
+					}
+					if ctx.Err() != nil {
+						return
+					}
+					if err == io.EOF {
+						writeFrame(id, 0x0A<<10|0x40, nil) // PAYLOAD, COMPLETE
+						return
+					}
+					code := make([]byte, 4)
+					binary.BigEndian.PutUint32(code, 0x201) // APPLICATION_ERROR
+					writeFrame(id, 0x0B<<10, append(code, status.Convert(err).Message()...))
+				}()
+			}
+		}
+	}
+	go func() {
+		for {
+			nc, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(nc)
+		}
+	}()
+	return "rsocket://" + listener.Addr().String()
+}
+
+func TestRSocket(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	proxy := rsocketProxy(t, "localhost:6565")
+	c, err := client.NewStreamClient(proxy, topic, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Publish(context.Background(), strings.NewReader("over rsocket"), nil, "text/plain", map[string]string{"h": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan client.Message, 1)
+	sub, err := c.SubscribeMessages(context.Background(), "rsocket", true, func(ctx context.Context, msg client.Message) error {
+		received <- msg
+		return nil
+	}, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if string(msg.Payload) != "over rsocket" || msg.Headers["h"] != "v" {
+			t.Errorf("unexpected message %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	offsets, err := c.CommittedOffsets(context.Background(), "rsocket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 1 {
+		t.Errorf("expected the offset of the event to be committed, got %v", offsets)
+	}
+
+	if _, err := client.NewStreamClient(proxy, topic, "text/plain", client.WithDialOptions(grpc.WithBlock())); err == nil {
+		t.Error("expected gRPC dial options to be rejected for rsocket gateways")
+	}
+	if _, err := client.NewStreamClient("rsocket://localhost:1", topic, "text/plain"); !errors.Is(err, client.ErrGatewayUnavailable) {
+		t.Errorf("expected ErrGatewayUnavailable, got %v", err)
+	}
+}
+
+func TestRSocketRequestsMoreMessages(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c, err := client.NewStreamClient(rsocketProxy(t, "localhost:6565"), topic, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// more messages than requested at once by the client, on a single partition
+	const count = 600
+	for i := 0; i < count; i++ {
+		publishWithKey(c, strconv.Itoa(i), "key", t)
+	}
+	var received int32
+	done := make(chan struct{})
+	sub, err := c.Subscribe(context.Background(), "", true, func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		if atomic.AddInt32(&received, 1) == count {
+			close(done)
+		}
+		return nil
+	}, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out after receiving %d messages", atomic.LoadInt32(&received))
+	}
+}
+
+// countingTransport calls the gateway through a gRPC connection of its own, counting publish calls.
+type countingTransport struct {
+	liiklus.LiiklusServiceClient
+	conn      *grpc.ClientConn
+	publishes int32
+	closed    int32
+}
+
+func (c *countingTransport) Publish(ctx context.Context, in *liiklus.PublishRequest, opts ...grpc.CallOption) (*liiklus.PublishReply, error) {
+	atomic.AddInt32(&c.publishes, 1)
+	return c.LiiklusServiceClient.Publish(ctx, in, opts...)
+}
+
+func (c *countingTransport) Close() error {
+	atomic.AddInt32(&c.closed, 1)
+	return c.conn.Close()
+}
+
+func TestTransport(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	var transport *countingTransport
+	c, err := client.NewStreamClient("custom://gateway", topic, "text/plain", client.WithTransport(func(ctx context.Context, gateway string) (client.Transport, error) {
+		if gateway != "custom://gateway" {
+			t.Errorf("unexpected gateway %q", gateway)
+		}
+		conn, err := grpc.DialContext(ctx, "localhost:6565", grpc.WithInsecure(), grpc.WithBlock())
+		if err != nil {
+			return nil, err
+		}
+		transport = &countingTransport{LiiklusServiceClient: liiklus.NewLiiklusServiceClient(conn), conn: conn}
+		return transport, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Publish(context.Background(), strings.NewReader("through a custom transport"), nil, "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+	if ends, err := c.EndOffsets(context.Background()); err != nil || len(ends) == 0 {
+		t.Errorf("expected end offsets, got %v, %v", ends, err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&transport.publishes) != 1 || atomic.LoadInt32(&transport.closed) != 1 {
+		t.Errorf("expected the transport to be used and closed, got %d publishes and %d closes", transport.publishes, transport.closed)
+	}
+
+	dialErr := errors.New("no route")
+	if _, err := client.NewStreamClient("custom://gateway", topic, "text/plain", client.WithTransport(func(ctx context.Context, gateway string) (client.Transport, error) {
+		return nil, dialErr
+	})); !errors.Is(err, dialErr) {
+		t.Errorf("expected the dial error, got %v", err)
+	}
+}
+
+func TestGatewayClient(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	g, err := client.NewGatewayClient("localhost:6565")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := g.Stream(topicName(t.Name(), "orders"+suffix), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments, err := g.Stream(topicName(t.Name(), "payments"+suffix), "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orders.Publish(context.Background(), strings.NewReader("order"), nil, "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := payments.Publish(context.Background(), strings.NewReader(`{"amount":1}`), nil, "application/json", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// closing a stream leaves the connection usable by the others
+	if err := orders.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orders.Publish(context.Background(), strings.NewReader("order"), nil, "text/plain", nil); !errors.Is(err, client.ErrClientClosed) {
+		t.Errorf("expected ErrClientClosed, got %v", err)
+	}
+	if _, err := payments.Publish(context.Background(), strings.NewReader(`{"amount":2}`), nil, "application/json", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := payments.Subscribe(context.Background(), "", true, func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		return nil
+	}, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sub.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("expected closing the gateway client to stop the subscriptions of its streams")
+	}
+	if _, err := g.Stream(topicName(t.Name(), "late"), "text/plain"); !errors.Is(err, client.ErrClientClosed) {
+		t.Errorf("expected ErrClientClosed, got %v", err)
+	}
+}
+
+func TestPublishTo(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	g, err := client.NewGatewayClient("localhost:6565")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics := []string{topicName(t.Name(), "a"+suffix), topicName(t.Name(), "b"+suffix)}
+	for _, topic := range topics {
+		if _, err := g.PublishTo(context.Background(), topic, strings.NewReader("routed"), nil, "text/plain", map[string]string{"route": topic}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, topic := range topics {
+		lc, err := g.Stream(topic, "text/plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ends, err := lc.EndOffsets(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var events uint64
+		for _, end := range ends {
+			// end offsets are those of the last event, or the maximum value for empty partitions
+			if end != math.MaxUint64 {
+				events += end + 1
+			}
+		}
+		if events != 1 {
+			t.Errorf("expected 1 event in %s, got end offsets %v", topic, ends)
+		}
+	}
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.PublishTo(context.Background(), topics[0], strings.NewReader("late"), nil, "text/plain", nil); !errors.Is(err, client.ErrClientClosed) {
+		t.Errorf("expected ErrClientClosed, got %v", err)
+	}
+}
+
+func TestSubscriptionIntrospection(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	if _, err := c.Publish(context.Background(), strings.NewReader("inspected"), nil, "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	handled := make(chan struct{}, 1)
+	sub, err := c.Subscribe(context.Background(), "inspectors", true, func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		handled <- struct{}{}
+		return nil
+	}, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	if sub.Group() != "inspectors" {
+		t.Errorf("unexpected group %q", sub.Group())
+	}
+	assignments := sub.Assignments()
+	if len(assignments) == 0 {
+		t.Fatal("expected partitions to be assigned")
+	}
+	for i, a := range assignments {
+		if a.Topic != topic || i > 0 && a.Partition <= assignments[i-1].Partition {
+			t.Errorf("unexpected assignments %v", assignments)
+		}
+	}
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	committed, err := sub.CommittedOffsets(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(committed[topic]) != 1 {
+		t.Errorf("expected the offset of the event to be committed, got %v", committed)
+	}
+
+	anonymous, err := c.Subscribe(context.Background(), "", true, func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		return nil
+	}, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer anonymous.Cancel()
+	if committed, err := anonymous.CommittedOffsets(context.Background()); err != nil || len(committed) != 0 || anonymous.Group() != "" {
+		t.Errorf("expected anonymous subscriptions to have no group nor committed offsets, got %q, %v, %v", anonymous.Group(), committed, err)
+	}
+}
+
+func TestSubscriptionRun(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	if _, err := c.Publish(context.Background(), strings.NewReader("run"), nil, "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	handling := make(chan struct{})
+	release := make(chan struct{})
+	sub, err := c.Subscribe(context.Background(), "runners", true, func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		close(handling)
+		<-release
+		return nil
+	}, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- sub.Run(ctx)
+	}()
+	<-handling
+	cancel()
+	select {
+	case err := <-result:
+		t.Fatalf("expected Run to wait for the event being handled, returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Run to return")
+	}
+	if committed, err := c.CommittedOffsets(context.Background(), "runners"); err != nil || len(committed) != 1 {
+		t.Errorf("expected the offset of the event to be committed, got %v, %v", committed, err)
+	}
+
+	failure := errors.New("fatal")
+	failing, err := c.Subscribe(context.Background(), "failing", true, func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		return failure
+	}, func(cancel context.CancelFunc, err error) {
+		cancel()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := failing.Run(context.Background()); !errors.Is(err, failure) {
+		t.Errorf("expected Run to return the handler error, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		backoff  client.Backoff
+		expected []time.Duration
+	}{
+		{name: "constant", backoff: client.ConstantBackoff(time.Second), expected: []time.Duration{time.Second, time.Second, time.Second}},
+		{name: "exponential", backoff: client.ExponentialBackoff{Initial: time.Second, Max: 5 * time.Second}, expected: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}},
+		{name: "exponential multiplier", backoff: client.ExponentialBackoff{Initial: time.Second, Multiplier: 3}, expected: []time.Duration{time.Second, 3 * time.Second, 9 * time.Second}},
+		{name: "fibonacci", backoff: client.FibonacciBackoff{Unit: time.Second, Max: 6 * time.Second}, expected: []time.Duration{time.Second, time.Second, 2 * time.Second, 3 * time.Second, 5 * time.Second, 6 * time.Second}},
+	} {
+		for i, expected := range tc.expected {
+			if delay := tc.backoff.Delay(i + 1); delay != expected {
+				t.Errorf("%s: expected retry %d to be delayed by %s, got %s", tc.name, i+1, expected, delay)
+			}
+		}
+	}
+	jittered := client.ExponentialBackoff{Initial: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if delay := jittered.Delay(2); delay < time.Second || delay > 3*time.Second {
+			t.Fatalf("expected delay within 50%% of 2s, got %s", delay)
+		}
+	}
+}
+
+func TestRetryMiddleware(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	if _, err := c.Publish(context.Background(), strings.NewReader("flaky"), nil, "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts int32
+	handled := make(chan struct{})
+	sub, err := c.SubscribeMessages(context.Background(), "retriers", true, func(ctx context.Context, msg client.Message) error {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return errors.New("flaky")
+		}
+		close(handled)
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Errorf("expected failures to be retried, got %v", err)
+	}, client.WithMiddleware(client.RetryMiddleware(3, client.ConstantBackoff(10*time.Millisecond))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the handler to succeed")
+	}
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryTopics(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	topic := topicName(t.Name(), suffix)
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	retries := client.NewRetryTopics(c, topicName(t.Name()+"-dlq", suffix),
+		client.RetryTier{Topic: topicName(t.Name()+"-retry1", suffix), Delay: 50 * time.Millisecond},
+		client.RetryTier{Topic: topicName(t.Name()+"-retry2", suffix), Delay: 100 * time.Millisecond},
+	)
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	handled := make(chan string, 10)
+	handler := func(ctx context.Context, msg client.Message) error {
+		mu.Lock()
+		attempts[string(msg.Payload)]++
+		n := attempts[string(msg.Payload)]
+		mu.Unlock()
+		if string(msg.Payload) == "bad" || n < 3 {
+			return fmt.Errorf("attempt %d failed", n)
+		}
+		handled <- string(msg.Payload)
+		return nil
+	}
+	errHandler := func(cancel context.CancelFunc, err error) {
+		t.Errorf("expected failures to be escalated, got %v", err)
+	}
+	sub, err := c.SubscribeMessages(context.Background(), "main", true, handler, errHandler, client.WithMiddleware(retries.Middleware()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	retrySub, err := retries.Start(context.Background(), "retriers", handler, errHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer retrySub.Drain(context.Background())
+
+	for _, payload := range []string{"flaky", "bad"} {
+		if _, err := c.Publish(context.Background(), strings.NewReader(payload), strings.NewReader(payload), "text/plain", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case payload := <-handled:
+		if payload != "flaky" {
+			t.Fatalf("expected the flaky event to be handled, got %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the flaky event to be retried")
+	}
+
+	dlq := setupStreamingClient(topicName(t.Name()+"-dlq", suffix), t)
+	defer dlq.Close()
+	deadLetters := make(chan client.Message, 1)
+	dlqSub, err := dlq.SubscribeMessages(context.Background(), "inspectors", true, func(ctx context.Context, msg client.Message) error {
+		deadLetters <- msg
+		return nil
+	}, errHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dlqSub.Drain(context.Background())
+	select {
+	case msg := <-deadLetters:
+		if string(msg.Payload) != "bad" {
+			t.Errorf("expected the bad event to be dead lettered, got %q", msg.Payload)
+		}
+		if msg.Headers[client.SourceTopicHeader] != topic || msg.Headers[client.SourceOffsetHeader] == "" {
+			t.Errorf("expected the dead letter to record its source, got %v", msg.Headers)
+		}
+		if msg.Headers[client.FailureHeader] != "attempt 3 failed" {
+			t.Errorf("expected the dead letter to record the last failure, got %v", msg.Headers)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the bad event to be dead lettered")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts["bad"] != 3 {
+		t.Errorf("expected the bad event to be attempted 3 times, got %d", attempts["bad"])
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	topic := topicName(t.Name(), suffix)
+	dlqTopic := topicName(t.Name()+"-dlq", suffix)
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	dlq := setupStreamingClient(dlqTopic, t)
+	defer dlq.Close()
+	retries := client.NewRetryTopics(c, dlqTopic)
+	for i, payload := range []string{"bug", "poison"} {
+		msg := client.Message{
+			ID:          payload,
+			Payload:     []byte(payload),
+			ContentType: "text/plain",
+			Headers:     map[string]string{"custom": "value"},
+			Key:         []byte(payload),
+			Topic:       topic,
+			Partition:   1,
+			Offset:      uint64(i),
+		}
+		if err := retries.Escalate(context.Background(), msg, errors.New(payload+" failure")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	browsed := map[string]client.DeadLetter{}
+	if err := dlq.BrowseDeadLetters(context.Background(), func(ctx context.Context, dl client.DeadLetter) error {
+		mu.Lock()
+		defer mu.Unlock()
+		browsed[dl.ID] = dl
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(browsed) != 2 {
+		t.Fatalf("expected 2 dead letters, got %v", browsed)
+	}
+	if dl := browsed["poison"]; dl.SourceTopic != topic || dl.SourcePartition != 1 || dl.SourceOffset != 1 || dl.Failure != "poison failure" {
+		t.Errorf("expected the origin of the dead letter to be decoded, got %+v", dl)
+	}
+
+	redriven, err := dlq.RedriveDeadLetters(context.Background(), func(dl client.DeadLetter) bool {
+		return dl.Failure == "bug failure"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redriven != 1 {
+		t.Fatalf("expected 1 event to be redriven, got %d", redriven)
+	}
+	received := make(chan client.Message, 1)
+	sub, err := c.SubscribeMessages(context.Background(), "", true, func(ctx context.Context, msg client.Message) error {
+		received <- msg
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	select {
+	case msg := <-received:
+		if msg.ID != "bug" || string(msg.Key) != "bug" || msg.Headers["custom"] != "value" {
+			t.Errorf("expected the bug event to be redriven, got %+v", msg)
+		}
+		if _, ok := client.ParseDeadLetter(msg); ok || msg.Headers[client.FailureHeader] != "" {
+			t.Errorf("expected the redriven event not to record its failure, got %v", msg.Headers)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the redriven event")
+	}
+}
+
+func TestDeliveryAudit(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	for _, id := range []string{"duplicated", "single", "duplicated"} {
+		if _, err := c.Publish(context.Background(), strings.NewReader(id), strings.NewReader("key"), "text/plain", map[string]string{client.IDHeader: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries := make(chan client.Delivery, 3)
+	sub, err := c.SubscribeMessages(context.Background(), "auditors", true, func(ctx context.Context, msg client.Message) error {
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	}, client.WithDeliveryAudit(client.DeliveryAuditorFunc(func(d client.Delivery) {
+		deliveries <- d
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	var counts []string
+	for i := 0; i < 3; i++ {
+		select {
+		case d := <-deliveries:
+			if d.Topic != topic || d.Group != "auditors" || d.Offset != uint64(i) {
+				t.Errorf("unexpected delivery %+v", d)
+			}
+			counts = append(counts, fmt.Sprintf("%s:%d", d.EventID, d.DeliveryCount))
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for deliveries")
+		}
+	}
+	if expected := []string{"duplicated:1", "single:1", "duplicated:2"}; !reflect.DeepEqual(counts, expected) {
+		t.Errorf("expected deliveries %v, got %v", expected, counts)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	for _, v := range []string{"a", "b", "c", "d"} {
+		publish(c, v, "text/plain", topic, nil, t)
+	}
+
+	var mu sync.Mutex
+	var handled []string
+	published := false
+	record := func(ctx context.Context, msg client.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, string(msg.Payload))
+		if !published {
+			published = true
+			// published after the snapshot, hence left for the next catch up
+			if _, err := c.Publish(ctx, strings.NewReader("e"), nil, "text/plain", nil); err != nil {
+				t.Error(err)
+			}
+		}
+		return nil
+	}
+	if err := c.CatchUp(context.Background(), "jobs", record); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(handled)
+	if expected := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(handled, expected) {
+		t.Fatalf("expected %v to be handled, got %v", expected, handled)
+	}
+
+	handled = nil
+	if err := c.CatchUp(context.Background(), "jobs", record); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"e"}; !reflect.DeepEqual(handled, expected) {
+		t.Fatalf("expected %v to be handled, got %v", expected, handled)
+	}
+	handled = []string{"caught up"}
+	if err := c.CatchUp(context.Background(), "jobs", record); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 1 {
+		t.Errorf("expected nothing left to catch up with, got %v", handled)
+	}
+
+	failure := errors.New("failed")
+	err := c.CatchUp(context.Background(), "failing", func(ctx context.Context, msg client.Message) error {
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the handler failure to be returned, got %v", err)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	stale := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	var result client.PublishResult
+	for _, headers := range []map[string]string{{client.TimeHeader: stale}, nil, {client.TimeHeader: stale}} {
+		var err error
+		result, err = c.Publish(context.Background(), strings.NewReader(headers[client.TimeHeader]), strings.NewReader("key"), "text/plain", headers)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handled := make(chan string, 3)
+	sub, err := c.SubscribeMessages(context.Background(), "fresh", true, func(ctx context.Context, msg client.Message) error {
+		handled <- string(msg.Payload)
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	}, client.WithMaxAge(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	select {
+	case payload := <-handled:
+		if payload != "" {
+			t.Errorf("expected the stale events to be skipped, got %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the fresh event")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		offsets, err := c.CommittedOffsets(context.Background(), "fresh")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset, ok := offsets[result.Partition]; ok && offset == result.Offset {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the stale events to be committed, got offsets %v", offsets)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case payload := <-handled:
+		t.Errorf("expected the stale events to be skipped, got %q", payload)
+	default:
+	}
+}
+
+func TestReadCompacted(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	for _, kv := range [][2]string{{"a", "a1"}, {"b", "b1"}, {"a", "a2"}, {"", "keyless"}, {"c", "c1"}, {"b", "b2"}} {
+		var key io.Reader
+		if kv[0] != "" {
+			key = strings.NewReader(kv[0])
+		}
+		if _, err := c.Publish(context.Background(), strings.NewReader(kv[1]), key, "text/plain", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.PublishTombstone(context.Background(), strings.NewReader("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	var values []string
+	if err := c.ReadCompacted(context.Background(), func(ctx context.Context, msg client.Message) error {
+		values = append(values, string(msg.Payload))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(values)
+	if expected := []string{"a2", "b2", "keyless"}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected the latest value of each key, got %v", values)
+	}
+}
+
+func TestTable(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	put := func(key, value string) {
+		if _, err := c.Publish(context.Background(), strings.NewReader(value), strings.NewReader(key), "text/plain", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errHandler := func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	}
+	describe := func(change client.TableChange) string {
+		previous, current := "", ""
+		if change.Previous != nil {
+			previous = string(change.Previous.Payload)
+		}
+		if change.Current != nil {
+			current = string(change.Current.Payload)
+		}
+		return fmt.Sprintf("%s:%s->%s", change.Key, previous, current)
+	}
+	awaitChanges := func(changes chan string, expected ...string) {
+		var actual []string
+		for range expected {
+			select {
+			case change := <-changes:
+				actual = append(actual, change)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for changes %v, got %v", expected, actual)
+			}
+		}
+		sort.Strings(actual)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("expected changes %v, got %v", expected, actual)
+		}
+	}
+
+	put("a", "a1")
+	put("b", "b1")
+	changes := make(chan string, 10)
+	table := client.NewTable(c, client.WithTableListener(func(change client.TableChange) {
+		changes <- describe(change)
+	}))
+	sub, err := table.Start(context.Background(), errHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaitChanges(changes, "a:->a1", "b:->b1")
+	put("a", "a2")
+	if _, err := c.PublishTombstone(context.Background(), strings.NewReader("b")); err != nil {
+		t.Fatal(err)
+	}
+	awaitChanges(changes, "a:a1->a2", "b:b1->")
+	var snapshot bytes.Buffer
+	if err := table.Snapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	put("c", "c1")
+	restoredChanges := make(chan string, 10)
+	restored := client.NewTable(c, client.WithTableListener(func(change client.TableChange) {
+		restoredChanges <- describe(change)
+	}))
+	if err := restored.Restore(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	sub, err = restored.Start(context.Background(), errHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	awaitChanges(restoredChanges, "c:->c1")
+	if msg, ok, err := restored.Get("a"); err != nil || !ok || string(msg.Payload) != "a2" {
+		t.Errorf("expected a to be restored, got %q, %v, %v", msg.Payload, ok, err)
+	}
+	if _, ok, err := restored.Get("b"); err != nil || ok {
+		t.Errorf("expected b to be deleted, got %v, %v", ok, err)
+	}
+	select {
+	case change := <-restoredChanges:
+		t.Errorf("expected the restored table to resume after the snapshot, got change %s", change)
+	default:
+	}
+}
+
+func TestWindowedAggregation(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	in := setupStreamingClient(topicName(t.Name(), suffix), t)
+	defer in.Close()
+	counts, err := client.NewStreamClient("localhost:6565", topicName(t.Name()+"-counts", suffix), "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer counts.Close()
+	sums, err := client.NewStreamClient("localhost:6565", topicName(t.Name()+"-sums", suffix), "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sums.Close()
+	errHandler := func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	}
+
+	aggregated := make(chan struct{}, 20)
+	observe := client.WithMiddleware(func(next client.MessageHandler) client.MessageHandler {
+		return func(ctx context.Context, msg client.Message) error {
+			err := next(ctx, msg)
+			aggregated <- struct{}{}
+			return err
+		}
+	})
+	counting := client.NewWindowedAggregation(in, counts, "counters", client.TumblingWindows(time.Minute), client.Count())
+	countSub, err := counting.Start(context.Background(), errHandler, observe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer countSub.Drain(context.Background())
+	summing := client.NewWindowedAggregation(in, sums, "summers", client.SlidingWindows(2*time.Minute, time.Minute), client.Sum(func(msg client.Message) (float64, error) {
+		return strconv.ParseFloat(string(msg.Payload), 64)
+	}))
+	sumSub, err := summing.Start(context.Background(), errHandler, observe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sumSub.Drain(context.Background())
+
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	publishAt := func(key string, offset time.Duration, value string) {
+		headers := map[string]string{client.TimeHeader: base.Add(offset).Format(time.RFC3339)}
+		if _, err := in.Publish(context.Background(), strings.NewReader(value), strings.NewReader(key), "text/plain", headers); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publishAt("x", 0, "1")
+	publishAt("x", 10*time.Second, "2")
+	publishAt("x", 20*time.Second, "3")
+	publishAt("y", 30*time.Second, "5")
+	// events of different partitions are aggregated concurrently, make sure the closing event comes last
+	for i := 0; i < 8; i++ {
+		select {
+		case <-aggregated:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for events to be aggregated")
+		}
+	}
+	publishAt("x", 5*time.Minute, "0")
+
+	await := func(c *client.StreamClient, n int) []string {
+		results := make(chan string, n)
+		sub, err := c.SubscribeMessages(context.Background(), "", true, func(ctx context.Context, msg client.Message) error {
+			var result client.WindowResult[float64]
+			if err := json.Unmarshal(msg.Payload, &result); err != nil {
+				return err
+			}
+			results <- fmt.Sprintf("%s[%s,%s)=%v", result.Key, result.Start.Sub(base), result.End.Sub(base), result.Value)
+			return nil
+		}, errHandler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Drain(context.Background())
+		var actual []string
+		for i := 0; i < n; i++ {
+			select {
+			case result := <-results:
+				actual = append(actual, result)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for results, got %v", actual)
+			}
+		}
+		sort.Strings(actual)
+		return actual
+	}
+	if actual, expected := await(counts, 2), []string{"x[0s,1m0s)=3", "y[0s,1m0s)=1"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected counts %v, got %v", expected, actual)
+	}
+	if actual, expected := await(sums, 4), []string{"x[-1m0s,1m0s)=6", "x[0s,2m0s)=6", "y[-1m0s,1m0s)=5", "y[0s,2m0s)=5"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected sums %v, got %v", expected, actual)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	orders := setupStreamingClient(topicName(t.Name()+"-orders", suffix), t)
+	defer orders.Close()
+	customers := setupStreamingClient(topicName(t.Name()+"-customers", suffix), t)
+	defer customers.Close()
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	publishAt := func(c *client.StreamClient, key string, offset time.Duration, value string) {
+		headers := map[string]string{client.TimeHeader: base.Add(offset).Format(time.RFC3339)}
+		if _, err := c.Publish(context.Background(), strings.NewReader(value), strings.NewReader(key), "text/plain", headers); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pairs := make(chan string, 10)
+	join := client.NewJoin(orders, orders.TopicName, customers.TopicName, "enrichers", time.Minute, func(ctx context.Context, left client.Message, right client.Message) error {
+		pairs <- string(left.Payload) + ":" + string(right.Payload)
+		return nil
+	})
+	sub, err := join.Start(context.Background(), func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	await := func(expected ...string) {
+		var actual []string
+		for range expected {
+			select {
+			case pair := <-pairs:
+				actual = append(actual, pair)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for pairs %v, got %v", expected, actual)
+			}
+		}
+		sort.Strings(actual)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("expected pairs %v, got %v", expected, actual)
+		}
+	}
+
+	publishAt(customers, "alice", 0, "alice")
+	publishAt(orders, "alice", 10*time.Second, "order1")
+	publishAt(orders, "bob", 20*time.Second, "order2")
+	publishAt(customers, "bob", 25*time.Second, "bob")
+	await("order1:alice", "order2:bob")
+
+	publishAt(orders, "alice", 5*time.Minute, "order3")
+	publishAt(customers, "alice", 5*time.Minute+10*time.Second, "alice2")
+	await("order3:alice2")
+	select {
+	case pair := <-pairs:
+		t.Errorf("expected messages too far apart not to be paired, got %s", pair)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMergeByTime(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	a := setupStreamingClient(topicName(t.Name()+"-a", suffix), t)
+	defer a.Close()
+	b := setupStreamingClient(topicName(t.Name()+"-b", suffix), t)
+	defer b.Close()
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 6; i++ {
+		c := a
+		if i%2 == 0 {
+			c = b
+		}
+		headers := map[string]string{client.TimeHeader: base.Add(time.Duration(i) * time.Second).Format(time.RFC3339)}
+		if _, err := c.Publish(context.Background(), strings.NewReader(strconv.Itoa(i)), strings.NewReader("key"), "text/plain", headers); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	merged := make(chan string, 6)
+	sub, err := a.MergeByTime(context.Background(), []string{a.TopicName, b.TopicName}, "mergers", true, 200*time.Millisecond, func(ctx context.Context, msg client.Message) error {
+		merged <- string(msg.Payload)
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	var actual []string
+	for i := 0; i < 6; i++ {
+		select {
+		case v := <-merged:
+			actual = append(actual, v)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for merged messages, got %v", actual)
+		}
+	}
+	if expected := []string{"1", "2", "3", "4", "5", "6"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected messages in chronological order %v, got %v", expected, actual)
+	}
+}
+
+func TestMergeByTimeRejectsNonPositiveLateness(t *testing.T) {
+	c := setupStreamingClient(topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10)), t)
+	defer c.Close()
+	for _, lateness := range []time.Duration{0, -time.Second} {
+		if _, err := c.MergeByTime(context.Background(), []string{c.TopicName}, "mergers", true, lateness, func(ctx context.Context, msg client.Message) error {
+			return nil
+		}, func(cancel context.CancelFunc, err error) {}); err == nil {
+			t.Errorf("expected a lateness of %v to be rejected", lateness)
+		}
+	}
+}
+
+func TestMirror(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	source := setupStreamingClient(topicName(t.Name()+"-source", suffix), t)
+	defer source.Close()
+	replica := setupStreamingClient(topicName(t.Name()+"-replica", suffix), t)
+	defer replica.Close()
+	checkpoints, err := client.NewStreamClient("localhost:6565", topicName(t.Name()+"-checkpoints", suffix), "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoints.Close()
+	publishAll := func(ids ...string) {
+		for _, id := range ids {
+			if _, err := source.Publish(context.Background(), strings.NewReader(id), strings.NewReader("key-"+id), "text/plain", map[string]string{client.IDHeader: id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	errHandler := func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	}
+	mirrored := make(chan client.Message, 10)
+	replicaSub, err := replica.SubscribeMessages(context.Background(), "", true, func(ctx context.Context, msg client.Message) error {
+		mirrored <- msg
+		return nil
+	}, errHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replicaSub.Drain(context.Background())
+	await := func(expected ...string) {
+		var ids []string
+		for range expected {
+			select {
+			case msg := <-mirrored:
+				if string(msg.Key) != "key-"+msg.ID {
+					t.Errorf("expected the key of %s to be preserved, got %q", msg.ID, msg.Key)
+				}
+				ids = append(ids, msg.ID)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for mirrored events %v, got %v", expected, ids)
+			}
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, expected) {
+			t.Fatalf("expected events %v to be mirrored, got %v", expected, ids)
+		}
+	}
+
+	publishAll("a", "b", "c")
+	mirror := client.NewMirror(source, replica, "dr", client.NewTopicCheckpointStore(checkpoints), client.WithCheckpointInterval(10*time.Millisecond))
+	sub, err := mirror.Start(context.Background(), errHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	await("a", "b", "c")
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	publishAll("d", "e")
+	// a new mirror, such as one restarted in another region, resumes from the checkpoints
+	mirror = client.NewMirror(source, replica, "dr", client.NewTopicCheckpointStore(checkpoints))
+	sub, err = mirror.Start(context.Background(), errHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	await("d", "e")
+	select {
+	case msg := <-mirrored:
+		t.Errorf("expected events to be mirrored once, got %s again", msg.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTopicPrefix(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	tenant, err := client.NewStreamClient("localhost:6565", topic, "text/plain", client.WithTopicPrefix("tenant-a."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tenant.Close()
+	shared := setupStreamingClient("tenant-a."+topic, t)
+	defer shared.Close()
+	if _, err := tenant.Publish(context.Background(), strings.NewReader("namespaced"), nil, "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan client.Message, 2)
+	errHandler := func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	}
+	for _, c := range []*client.StreamClient{tenant, shared} {
+		sub, err := c.SubscribeMessages(context.Background(), "", true, func(ctx context.Context, msg client.Message) error {
+			received <- msg
+			return nil
+		}, errHandler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Drain(context.Background())
+		select {
+		case msg := <-received:
+			if string(msg.Payload) != "namespaced" || msg.Topic != c.TopicName {
+				t.Errorf("expected the namespaced event to be read from %s, got %q from %s", c.TopicName, msg.Payload, msg.Topic)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the namespaced event from %s", c.TopicName)
+		}
+	}
+	offsets, err := tenant.EndOffsets(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 1 {
+		t.Errorf("expected the end offsets of the namespaced topic, got %v", offsets)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	topic := topicName(t.Name(), strconv.FormatInt(time.Now().UnixNano(), 10))
+	c := setupStreamingClient(topic, t)
+	defer c.Close()
+	const events = 10
+	for i := 0; i < events; i++ {
+		publish(c, strconv.Itoa(i), "text/plain", topic, nil, t)
+	}
+
+	handled := make(chan struct{}, events)
+	start := time.Now()
+	sub, err := c.SubscribeMessages(context.Background(), "limited", true, func(ctx context.Context, msg client.Message) error {
+		handled <- struct{}{}
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	}, client.WithRateLimit(20, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	for i := 0; i < events; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < (events-1)*50*time.Millisecond {
+		t.Errorf("expected %d events to take at least %v at 20 events per second, took %v", events, (events-1)*50*time.Millisecond, elapsed)
+	}
+}
+
+func TestFairScheduling(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	chattyTopic := topicName(t.Name()+"-chatty", suffix)
+	quietTopic := topicName(t.Name()+"-quiet", suffix)
+	chatty := setupStreamingClient(chattyTopic, t)
+	defer chatty.Close()
+	quiet := setupStreamingClient(quietTopic, t)
+	defer quiet.Close()
+	const backlog = 30
+	for i := 0; i < backlog; i++ {
+		publish(chatty, strconv.Itoa(i), "text/plain", chattyTopic, nil, t)
+	}
+
+	scheduler := client.NewFairScheduler(1)
+	var chattyHandled int32
+	chattySub, err := chatty.SubscribeMessages(context.Background(), "fair", true, func(ctx context.Context, msg client.Message) error {
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&chattyHandled, 1)
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	}, client.WithFairScheduling(scheduler, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chattySub.Drain(context.Background())
+
+	quietHandled := make(chan struct{}, 3)
+	quietSub, err := quiet.SubscribeMessages(context.Background(), "fair", false, func(ctx context.Context, msg client.Message) error {
+		time.Sleep(20 * time.Millisecond)
+		quietHandled <- struct{}{}
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	}, client.WithFairScheduling(scheduler, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer quietSub.Drain(context.Background())
+	for atomic.LoadInt32(&chattyHandled) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		publish(quiet, strconv.Itoa(i), "text/plain", quietTopic, nil, t)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-quietHandled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for quiet event %d", i)
+		}
+	}
+	if n := atomic.LoadInt32(&chattyHandled); n >= backlog/2 {
+		t.Errorf("expected the quiet events to be interleaved with the backlog of the chatty stream, %d of %d were handled first", n, backlog)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	lowTopic := topicName(t.Name()+"-low", suffix)
+	highTopic := topicName(t.Name()+"-high", suffix)
+	low := setupStreamingClient(lowTopic, t)
+	defer low.Close()
+	high := setupStreamingClient(highTopic, t)
+	defer high.Close()
+	const events = 10
+	for i := 0; i < events; i++ {
+		publish(low, strconv.Itoa(i), "text/plain", lowTopic, nil, t)
+		publish(high, strconv.Itoa(i), "text/plain", highTopic, nil, t)
+	}
+
+	handled := make(chan string, 2*events)
+	sub, err := low.MultiSubscribe(context.Background(), []string{lowTopic, highTopic}, "prioritized", true, func(ctx context.Context, msg client.Message) error {
+		time.Sleep(10 * time.Millisecond)
+		handled <- msg.Topic
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	}, client.WithFairScheduling(client.NewFairScheduler(1), 1), client.WithPriority(1, highTopic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+	var lowFirst, highHandled int
+	for highHandled < events {
+		select {
+		case topic := <-handled:
+			if topic == highTopic {
+				highHandled++
+			} else {
+				lowFirst++
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the high priority events, %d were handled", highHandled)
+		}
+	}
+	// a few low priority events may be handed over before the high priority stream is received
+	if lowFirst > 3 {
+		t.Errorf("expected the high priority stream to be drained first, %d low priority events were handled before", lowFirst)
+	}
+}
+
+// mockSubscriber is a mock of client.Subscriber, returning stub subscriptions.
+type mockSubscriber struct {
+	// subscribed receives the group and the stop function of each subscription.
+	subscribed chan stubSubscription
+}
+
+type stubSubscription struct {
+	group string
+	stop  func(err error)
+}
+
+func (m *mockSubscriber) Subscribe(ctx context.Context, group string, fromBeginning bool, f client.EventHandler, e client.EventErrHandler, opts ...client.SubscribeOption) (*client.Subscription, error) {
+	return nil, errors.New("unexpected call to Subscribe")
+}
+
+func (m *mockSubscriber) SubscribeMessages(ctx context.Context, group string, fromBeginning bool, f client.MessageHandler, e client.EventErrHandler, opts ...client.SubscribeOption) (*client.Subscription, error) {
+	sub, stop := client.NewStubSubscription(ctx, group, "orders")
+	m.subscribed <- stubSubscription{group: group, stop: stop}
+	return sub, nil
+}
+
+// consumeOrders is code under test depending on the Subscriber interface.
+func consumeOrders(ctx context.Context, s client.Subscriber) error {
+	sub, err := s.SubscribeMessages(ctx, "orders-processor", true, func(ctx context.Context, msg client.Message) error {
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		cancel()
+	})
+	if err != nil {
+		return err
+	}
+	return sub.Run(ctx)
+}
+
+func TestMockSubscriber(t *testing.T) {
+	mock := &mockSubscriber{subscribed: make(chan stubSubscription, 2)}
+	failed := make(chan error)
+	go func() {
+		failed <- consumeOrders(context.Background(), mock)
+	}()
+	subscription := <-mock.subscribed
+	if subscription.group != "orders-processor" {
+		t.Errorf("expected a subscription of the orders-processor group, but was: %s", subscription.group)
+	}
+	boom := errors.New("boom")
+	subscription.stop(boom)
+	if err := <-failed; !errors.Is(err, boom) {
+		t.Errorf("expected the subscription to fail with %v, but was: %v", boom, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		failed <- consumeOrders(ctx, mock)
+	}()
+	<-mock.subscribed
+	cancel()
+	if err := <-failed; err != nil {
+		t.Errorf("expected the subscription to be drained, but got: %v", err)
+	}
+
+	sub, _ := client.NewStubSubscription(context.Background(), "inspector", "orders")
+	defer sub.Cancel()
+	if stats := sub.Stats(); stats.Processed != 0 || len(stats.Partitions) != 0 {
+		t.Errorf("expected a stub subscription to have no activity, but was: %+v", stats)
+	}
+	if committed, err := sub.CommittedOffsets(context.Background()); err != nil || len(committed) != 0 {
+		t.Errorf("expected a stub subscription to have no committed offsets, but was: %v, %v", committed, err)
+	}
+}
+
+func TestMultipleSubscribe(t *testing.T) {
+	now := time.Now()
+	topic1 := topicName(t.Name(), fmt.Sprintf("%d%d%d_1", now.Hour(), now.Minute(), now.Second()))
+	topic2 := topicName(t.Name(), fmt.Sprintf("%d%d%d_2", now.Hour(), now.Minute(), now.Second()))
+
+	c1 := setupStreamingClient(topic1, t)
+	c2 := setupStreamingClient(topic2, t)
+
+	testVal1 := "BAR1"
+	testVal2 := "BAR2"
+	result1 := make(chan string)
+	result2 := make(chan string)
+
+	var eventErrHandler client.EventErrHandler
+	eventErrHandler = func(cancel context.CancelFunc, err error) {
+		panic(err)
+	}
+	var err error
+	_, err = c1.Subscribe(context.Background(), t.Name()+"1", true, func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		bytes, err := ioutil.ReadAll(payload)
+		if err != nil {
+			return err
+		}
+		result1 <- string(bytes)
+		return nil
+	}, eventErrHandler)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = c2.Subscribe(context.Background(), t.Name()+"2", true, func(ctx context.Context, payload io.Reader, contentType string, headers map[string]string) error {
+		bytes, err := ioutil.ReadAll(payload)
+		if err != nil {
+			return err
+		}
+		result2 <- string(bytes)
+		return nil
+	}, eventErrHandler)
+	if err != nil {
+		t.Error(err)
+	}
+	publish(c1, testVal1, "text/plain", topic1, nil, t)
+	publish(c2, testVal2, "text/plain", topic1, nil, t)
+
+	v1 := <-result1
+	if v1 != testVal1 {
+		t.Errorf("expected value: %s, but was: %s", testVal1, v1)
+	}
+	v2 := <-result2
+	if v2 != testVal2 {
 		t.Errorf("expected value: %s, but was: %s", testVal2, v2)
 	}
 }
 
+func TestMultiSubscribe(t *testing.T) {
+	now := time.Now()
+	topic1 := topicName(t.Name(), fmt.Sprintf("%d%d%d_1", now.Hour(), now.Minute(), now.Second()))
+	topic2 := topicName(t.Name(), fmt.Sprintf("%d%d%d_2", now.Hour(), now.Minute(), now.Second()))
+
+	c1 := setupStreamingClient(topic1, t)
+	c2 := setupStreamingClient(topic2, t)
+	publish(c1, "BAR1", "text/plain", topic1, nil, t)
+	publish(c2, "BAR2", "text/plain", topic2, nil, t)
+
+	results := make(chan client.Message, 2)
+	eventErrHandler := func(cancel context.CancelFunc, err error) {}
+	sub, err := c1.MultiSubscribe(context.Background(), []string{topic1, topic2}, t.Name(), true, func(ctx context.Context, msg client.Message) error {
+		results <- msg
+		return nil
+	}, eventErrHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+
+	values := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		msg := <-results
+		values[msg.Topic] = string(msg.Payload)
+	}
+	expected := map[string]string{topic1: "BAR1", topic2: "BAR2"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected values: %v, but was: %v", expected, values)
+	}
+}
+
 func TestSubscribeFromLatest(t *testing.T) {
 	now := time.Now()
 	topic := topicName(t.Name(), fmt.Sprintf("%d%d%d", now.Hour(), now.Minute(), now.Second()))
@@ -0,0 +1,374 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Command streamctl lets operators publish to, tail and administer riff streams without writing Go.
+//
+// Usage:
+//
+//	streamctl [-config file] [-gateway host:port] [-topic topic] [-content-type type] <command> [flags] [args]
+//
+// The stream is configured as described by client.LoadConfig, from the configuration file and STREAM_* environment
+// variables, which the global flags override. Run streamctl help for the list of commands.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/loadgen"
+)
+
+// clientFactory connects to a stream.
+type clientFactory func(config client.Config, opts ...client.ClientOption) (*client.StreamClient, error)
+
+// command is a streamctl subcommand.
+type command struct {
+	usage string
+	run   func(ctx context.Context, env *env, args []string) error
+}
+
+// env is what commands run with.
+type env struct {
+	client *client.StreamClient
+	// config is the configuration of the stream.
+	config client.Config
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+var commands = map[string]command{
+	"publish":   {"publish [-key key] [-header name=value]... [-lines] [file]: publish the content of file, or stdin, as an event, or one event per line", publish},
+	"subscribe": {"subscribe [-group group] [-from-beginning]: print the events of the stream as pretty-printed CloudEvents until interrupted", subscribe},
+	"lag":       {"lag -group group: print the number of events of each partition the group has yet to handle", lag},
+	"offsets":   {"offsets [-group group]: print the end offset of each partition, or the offsets committed by group", offsets},
+	"export":    {"export [-from offset] [-to offset] [file]: write the events of the stream to file, or stdout, one JSON CloudEvent per line", export},
+	"import":    {"import [-regenerate-ids] [file]: publish the events read from file, or stdin, as written by export", importEvents},
+	"loadgen":   {"loadgen [-rate n] [-count n] [-duration d] [-concurrency n] [-min-size n] [-max-size n] [-keys n]: publish synthetic events and report publish latency", generateLoad},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, client.NewStreamClientFromConfig); err != nil {
+		fmt.Fprintf(os.Stderr, "streamctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command line args, connecting to the stream with newClient.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer, newClient clientFactory) error {
+	flags := flag.NewFlagSet("streamctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "YAML or JSON configuration file")
+	gateway := flags.String("gateway", "", "host:port of the liiklus gateway")
+	topic := flags.String("topic", "", "topic backing the stream")
+	contentType := flags.String("content-type", "", "content type accepted by the stream, application/json by default")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: streamctl [-config file] [-gateway host:port] [-topic topic] [-content-type type] <command> [flags] [args]")
+		flags.PrintDefaults()
+		fmt.Fprintln(stderr, "commands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stderr, "  %s\n", commands[name].usage)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 || flags.Arg(0) == "help" {
+		flags.Usage()
+		return nil
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", flags.Arg(0))
+	}
+	config, err := client.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	for _, override := range []struct{ flag, setting *string }{
+		{gateway, &config.Gateway},
+		{topic, &config.Topic},
+		{contentType, &config.ContentType},
+	} {
+		if *override.flag != "" {
+			*override.setting = *override.flag
+		}
+	}
+	if config.ContentType == "" {
+		config.ContentType = "application/json"
+	}
+	if config.Gateway == "" || config.Topic == "" {
+		return errors.New("a gateway and a topic are required")
+	}
+	c, err := newClient(config)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return cmd.run(ctx, &env{client: c, config: config, stdin: stdin, stdout: stdout}, flags.Args()[1:])
+}
+
+// newFlagSet returns the flag set of a command, reporting errors rather than exiting.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	return flags
+}
+
+// input opens the file named by the only argument of a command, or returns stdin if there is none.
+func input(env *env, args []string) (io.ReadCloser, error) {
+	switch len(args) {
+	case 0:
+		return ioutil.NopCloser(env.stdin), nil
+	case 1:
+		return os.Open(args[0])
+	}
+	return nil, fmt.Errorf("unexpected arguments %v", args[1:])
+}
+
+// headers is a flag accumulating name=value pairs.
+type headers map[string]string
+
+func (h headers) String() string {
+	return fmt.Sprint(map[string]string(h))
+}
+
+func (h headers) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected name=value, got %q", v)
+	}
+	h[parts[0]] = parts[1]
+	return nil
+}
+
+func publish(ctx context.Context, env *env, args []string) error {
+	flags := newFlagSet("publish")
+	key := flags.String("key", "", "key of the events")
+	lines := flags.Bool("lines", false, "publish each line as an event")
+	hdrs := headers{}
+	flags.Var(hdrs, "header", "header of the events, as name=value")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	in, err := input(env, flags.Args())
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	send := func(payload []byte) error {
+		var k io.Reader
+		if *key != "" {
+			k = strings.NewReader(*key)
+		}
+		result, err := env.client.Publish(ctx, bytes.NewReader(payload), k, env.config.ContentType, hdrs)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(env.stdout, "published to partition %d at offset %d\n", result.Partition, result.Offset)
+		return nil
+	}
+	if !*lines {
+		payload, err := ioutil.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		return send(payload)
+	}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if err := send(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func subscribe(ctx context.Context, env *env, args []string) error {
+	flags := newFlagSet("subscribe")
+	group := flags.String("group", env.config.Offsets.Group, "consumer group, an anonymous subscription is used if empty")
+	fromBeginning := flags.Bool("from-beginning", env.config.Offsets.FromBeginning, "read the stream from the beginning rather than from the end")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	errs := make(chan error, 1)
+	sub, err := env.client.SubscribeMessages(ctx, *group, *fromBeginning, func(ctx context.Context, msg client.Message) error {
+		event, err := client.CloudEventJSON(msg)
+		if err != nil {
+			return err
+		}
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, event, "", "  "); err != nil {
+			return err
+		}
+		fmt.Fprintf(env.stdout, "# partition %d, offset %d\n%s\n", msg.Partition, msg.Offset, pretty.Bytes())
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+		cancel()
+	}, env.config.SubscribeOptions()...)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		drainCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return sub.Drain(drainCtx)
+	case err := <-errs:
+		return err
+	}
+}
+
+func lag(ctx context.Context, env *env, args []string) error {
+	flags := newFlagSet("lag")
+	group := flags.String("group", env.config.Offsets.Group, "consumer group")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *group == "" {
+		return errors.New("a group is required")
+	}
+	lags, err := env.client.Lag(ctx, *group)
+	if err != nil {
+		return err
+	}
+	printPartitions(env.stdout, "LAG", lags)
+	return nil
+}
+
+func offsets(ctx context.Context, env *env, args []string) error {
+	flags := newFlagSet("offsets")
+	group := flags.String("group", "", "consumer group whose committed offsets to print")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *group != "" {
+		committed, err := env.client.CommittedOffsets(ctx, *group)
+		if err != nil {
+			return err
+		}
+		printPartitions(env.stdout, "COMMITTED", committed)
+		return nil
+	}
+	ends, err := env.client.EndOffsets(ctx)
+	if err != nil {
+		return err
+	}
+	printPartitions(env.stdout, "END", ends)
+	return nil
+}
+
+// printPartitions prints a table of values per partition, ordered by partition.
+func printPartitions(w io.Writer, header string, values map[uint32]uint64) {
+	partitions := make([]uint32, 0, len(values))
+	for partition := range values {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i] < partitions[j]
+	})
+	fmt.Fprintf(w, "PARTITION\t%s\n", header)
+	for _, partition := range partitions {
+		fmt.Fprintf(w, "%d\t%d\n", partition, values[partition])
+	}
+}
+
+func export(ctx context.Context, env *env, args []string) error {
+	flags := newFlagSet("export")
+	from := flags.Uint64("from", 0, "first offset to export in each partition")
+	to := flags.Uint64("to", ^uint64(0), "offset to stop exporting at in each partition, excluded")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	out := env.stdout
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		f, err := os.Create(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	default:
+		return fmt.Errorf("unexpected arguments %v", flags.Args()[1:])
+	}
+	return env.client.Export(ctx, out, *from, *to)
+}
+
+func importEvents(ctx context.Context, env *env, args []string) error {
+	flags := newFlagSet("import")
+	regenerateIDs := flags.Bool("regenerate-ids", false, "assign new IDs to the imported events")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	in, err := input(env, flags.Args())
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var opts []client.ImportOption
+	if *regenerateIDs {
+		opts = append(opts, client.WithRegeneratedIDs())
+	}
+	return env.client.Import(ctx, in, opts...)
+}
+
+func generateLoad(ctx context.Context, env *env, args []string) error {
+	flags := newFlagSet("loadgen")
+	config := loadgen.Config{ContentType: env.config.ContentType}
+	flags.Float64Var(&config.Rate, "rate", 100, "events per second, 0 for as fast as possible")
+	flags.IntVar(&config.Count, "count", 1000, "number of events to publish")
+	flags.DurationVar(&config.Duration, "duration", 0, "maximum duration of the run")
+	flags.IntVar(&config.Concurrency, "concurrency", 1, "number of events published at once")
+	flags.IntVar(&config.MinSize, "min-size", 100, "minimum payload size in bytes")
+	flags.IntVar(&config.MaxSize, "max-size", 100, "maximum payload size in bytes")
+	flags.IntVar(&config.Keys, "keys", 0, "number of distinct keys, events are published without a key if 0")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	report, err := loadgen.Run(ctx, env.client, config)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(env.stdout, report)
+	return nil
+}
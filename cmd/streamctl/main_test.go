@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/fake"
+)
+
+// streamctl runs the command line args against gateway, returning its output.
+func streamctl(t *testing.T, ctx context.Context, gateway *fake.Gateway, stdin string, args ...string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	newClient := func(config client.Config, opts ...client.ClientOption) (*client.StreamClient, error) {
+		return gateway.NewStreamClient(config.Topic, config.ContentType, opts...)
+	}
+	args = append([]string{"-gateway", "fake", "-topic", "orders", "-content-type", "text/plain"}, args...)
+	if err := run(ctx, args, strings.NewReader(stdin), &stdout, &stderr, newClient); err != nil {
+		t.Fatalf("streamctl %v failed: %v\n%s", args, err, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestCommands(t *testing.T) {
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	ctx := context.Background()
+
+	out := streamctl(t, ctx, gateway, "first\nsecond\n", "publish", "-lines", "-header", "tenant=acme")
+	if strings.Count(out, "published to partition 0") != 2 {
+		t.Errorf("expected two events to be published, got:\n%s", out)
+	}
+	if records := gateway.Records("orders"); len(records) != 2 || records[0].Event.Extensions["tenant"] != "acme" {
+		t.Errorf("expected two records with a tenant header, got %v", records)
+	}
+
+	if out := streamctl(t, ctx, gateway, "", "offsets"); out != "PARTITION\tEND\n0\t1\n" {
+		t.Errorf("unexpected end offsets:\n%s", out)
+	}
+
+	exported := streamctl(t, ctx, gateway, "", "export")
+	if strings.Count(exported, "\n") != 2 || !strings.Contains(exported, `"data":"second"`) {
+		t.Errorf("expected two exported events, got:\n%s", exported)
+	}
+	streamctl(t, ctx, gateway, exported, "import", "-regenerate-ids")
+	if records := gateway.Records("orders"); len(records) != 4 {
+		t.Errorf("expected the exported events to be imported, got %d records", len(records))
+	}
+
+	subscribeCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	out = streamctl(t, subscribeCtx, gateway, "", "subscribe", "-group", "tail", "-from-beginning")
+	if strings.Count(out, "# partition 0") != 4 || !strings.Contains(out, `  "data": "first"`) {
+		t.Errorf("expected four pretty-printed events, got:\n%s", out)
+	}
+	if out := streamctl(t, ctx, gateway, "", "lag", "-group", "tail"); out != "PARTITION\tLAG\n0\t0\n" {
+		t.Errorf("unexpected lag:\n%s", out)
+	}
+
+	out = streamctl(t, ctx, gateway, "", "loadgen", "-rate", "0", "-count", "10")
+	if !strings.Contains(out, "published 10 events") {
+		t.Errorf("unexpected load generation report:\n%s", out)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	var stderr bytes.Buffer
+	err := run(context.Background(), []string{"-gateway", "fake", "-topic", "orders", "frobnicate"}, nil, &bytes.Buffer{}, &stderr, nil)
+	if err == nil || !strings.Contains(stderr.String(), "commands:") {
+		t.Errorf("expected an unknown command to be reported along with usage, got %v", err)
+	}
+}
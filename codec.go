@@ -0,0 +1,91 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Codec converts values to and from event payloads of a given content type. Codecs for formats such as Avro,
+// Protobuf or MessagePack may be registered with StreamClient.RegisterCodec.
+type Codec interface {
+	// Marshal returns the payload representing v.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal stores the value represented by data into v, which is typically a pointer.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec is a Codec using encoding/json, registered by default for application/json, and used for +json media
+// types which have no specific codec.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// RegisterCodec registers c as the Codec for the given content type, replacing any codec previously registered for
+// the same media type. Parameters of the content type are ignored.
+func (lc *StreamClient) RegisterCodec(contentType string, c Codec) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.codecs[chopContentType(contentType)] = c
+}
+
+// codec returns the Codec registered for contentType.
+func (lc *StreamClient) codec(contentType string) (Codec, error) {
+	mediaType := chopContentType(contentType)
+	lc.mu.Lock()
+	c, ok := lc.codecs[mediaType]
+	if !ok && isJSON(mediaType) {
+		c, ok = lc.codecs["application/json"]
+	}
+	lc.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for content type %q", contentType)
+	}
+	return c, nil
+}
+
+// PublishValue is like Publish, but marshals value with the Codec registered for contentType to compute the payload.
+func (lc *StreamClient) PublishValue(ctx context.Context, value interface{}, key io.Reader, contentType string, headers map[string]string, opts ...PublishOption) (PublishResult, error) {
+	c, err := lc.codec(contentType)
+	if err != nil {
+		return PublishResult{}, err
+	}
+	payload, err := c.Marshal(value)
+	if err != nil {
+		return PublishResult{}, err
+	}
+	return lc.Publish(ctx, bytes.NewReader(payload), key, contentType, headers, opts...)
+}
+
+// Decode unmarshals the payload of msg into v, with the Codec registered for the content type of msg.
+func (lc *StreamClient) Decode(msg Message, v interface{}) error {
+	c, err := lc.codec(msg.ContentType)
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(msg.Payload, v)
+}
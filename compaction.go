@@ -0,0 +1,118 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// PublishTombstone publishes a record under key with no value, marking the previous values published under key as
+// deleted. Compacted topics eventually drop the values a tombstone supersedes, then the tombstone itself.
+func (lc *StreamClient) PublishTombstone(ctx context.Context, key io.Reader) (PublishResult, error) {
+	kValue, err := ioutil.ReadAll(key)
+	if err != nil {
+		return PublishResult{}, err
+	}
+	return lc.send(ctx, &liiklus.PublishRequest{
+		Topic: lc.TopicName,
+		Key:   kValue,
+	})
+}
+
+// IsTombstone reports whether msg is a tombstone, that is a record under a key with no value which doesn't hold an
+// event, as published by PublishTombstone.
+func IsTombstone(msg Message) bool {
+	return len(msg.Key) > 0 && len(msg.Payload) == 0 && msg.Type == ""
+}
+
+// WithTombstones hands tombstones over to the handler, as messages for which IsTombstone returns true, rather than
+// reporting them as records which don't hold an event.
+func WithTombstones() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.tombstones = true
+	}
+}
+
+// ReadCompacted materializes the latest value of each key of the stream, as of the time ReadCompacted is called, and
+// hands it over to f, as a compacted topic would hold it once fully compacted: messages superseded by a later message
+// under the same key are skipped, and so are keys whose latest message is a tombstone. Messages without a key are all
+// handed over. Messages are handed over one at a time, in partition and offset order, once the stream has been read,
+// so that f sees a consistent snapshot. ReadCompacted holds a message per key in memory, and returns once f has been
+// called for all of them, or as soon as it returns an error. As for ReadRange, no offsets are committed.
+func (lc *StreamClient) ReadCompacted(ctx context.Context, f MessageHandler, opts ...SubscribeOption) error {
+	endOffsets, err := lc.endOffsets(ctx, lc.TopicName)
+	if err != nil {
+		return err
+	}
+	ranges := make(map[uint32]OffsetRange, len(endOffsets))
+	for partition, end := range endOffsets {
+		ranges[partition] = OffsetRange{To: end + 1}
+	}
+
+	options := newSubscribeOptions(opts)
+	// middlewares apply to the messages handed over to f, not to those being collected
+	reading := *options
+	reading.middlewares = nil
+	var mu sync.Mutex
+	latest := make(map[string]Message)
+	var keyless []Message
+	if err := lc.readRange(ctx, lc.TopicName, ranges, func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(msg.Key) == 0 {
+			keyless = append(keyless, msg)
+		} else if previous, ok := latest[string(msg.Key)]; !ok || supersedes(msg, previous) {
+			latest[string(msg.Key)] = msg
+		}
+		return nil
+	}, &reading); err != nil {
+		return err
+	}
+
+	snapshot := keyless
+	for _, msg := range latest {
+		if !IsTombstone(msg) {
+			snapshot = append(snapshot, msg)
+		}
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		a, b := snapshot[i], snapshot[j]
+		return a.Partition < b.Partition || a.Partition == b.Partition && a.Offset < b.Offset
+	})
+	f = options.wrap(f)
+	for _, msg := range snapshot {
+		if err := f(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// supersedes reports whether msg was published after previous, under the same key. Messages sharing a key normally
+// belong to the same partition, unless the number of partitions changed, in which case broker timestamps tell.
+func supersedes(msg Message, previous Message) bool {
+	if msg.Partition != previous.Partition {
+		return !msg.Timestamp.Before(previous.Timestamp)
+	}
+	return msg.Offset > previous.Offset
+}
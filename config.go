@@ -0,0 +1,273 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes how to connect to a stream and consume it, so that services and tools share one configuration
+// path. It can be loaded from a YAML or JSON file and from environment variables with LoadConfig, and turned into a
+// client with NewStreamClientFromConfig.
+type Config struct {
+	// Gateway is the host:port of the liiklus gateway, or the URL of a grpc-web endpoint, from STREAM_GATEWAY.
+	Gateway string `json:"gateway,omitempty" yaml:"gateway,omitempty"`
+	// Topic is the topic backing the stream, from STREAM_TOPIC.
+	Topic string `json:"topic,omitempty" yaml:"topic,omitempty"`
+	// ContentType is the content type accepted by the stream, from STREAM_CONTENT_TYPE.
+	ContentType string `json:"contentType,omitempty" yaml:"contentType,omitempty"`
+	// TopicPrefix namespaces the topics of the client, from STREAM_TOPIC_PREFIX, see WithTopicPrefix.
+	TopicPrefix string `json:"topicPrefix,omitempty" yaml:"topicPrefix,omitempty"`
+	// TLS secures the connection to the gateway.
+	TLS TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+	// Retries controls how failures to commit offsets are retried.
+	Retries RetryConfig `json:"retries,omitempty" yaml:"retries,omitempty"`
+	// Offsets controls where subscriptions start from.
+	Offsets OffsetsConfig `json:"offsets,omitempty" yaml:"offsets,omitempty"`
+}
+
+// TLSConfig describes how the connection to the gateway is secured.
+type TLSConfig struct {
+	// Enabled connects to the gateway with TLS rather than in plaintext, from STREAM_TLS.
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// CAFile is a PEM file of the certificate authorities trusted to sign the certificate of the gateway, from
+	// STREAM_TLS_CA_FILE. The system pool is used if empty.
+	CAFile string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	// CertFile and KeyFile are PEM files of the client certificate and key, for mutual TLS, from STREAM_TLS_CERT_FILE
+	// and STREAM_TLS_KEY_FILE.
+	CertFile string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	// ServerName overrides the name the certificate of the gateway is checked against, from STREAM_TLS_SERVER_NAME.
+	ServerName string `json:"serverName,omitempty" yaml:"serverName,omitempty"`
+	// InsecureSkipVerify disables checking the certificate of the gateway, from STREAM_TLS_INSECURE_SKIP_VERIFY. It
+	// is meant for testing only.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+}
+
+// RetryConfig describes how failures to commit offsets are retried, see WithAckRetries.
+type RetryConfig struct {
+	// AckAttempts is the number of times committing an offset is attempted, from STREAM_ACK_ATTEMPTS. The default of
+	// WithAckRetries applies if 0.
+	AckAttempts int `json:"ackAttempts,omitempty" yaml:"ackAttempts,omitempty"`
+	// AckInterval is the time between attempts, from STREAM_ACK_INTERVAL.
+	AckInterval Duration `json:"ackInterval,omitempty" yaml:"ackInterval,omitempty"`
+}
+
+// OffsetsConfig describes where subscriptions start from.
+type OffsetsConfig struct {
+	// Group is the consumer group of subscriptions, from STREAM_GROUP.
+	Group string `json:"group,omitempty" yaml:"group,omitempty"`
+	// FromBeginning starts groups without committed offsets from the beginning of the stream rather than from its end,
+	// from STREAM_FROM_BEGINNING.
+	FromBeginning bool `json:"fromBeginning,omitempty" yaml:"fromBeginning,omitempty"`
+	// GroupVersion is the version of the consumer group, from STREAM_GROUP_VERSION, see WithGroupVersion.
+	GroupVersion uint32 `json:"groupVersion,omitempty" yaml:"groupVersion,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "1.5s" in configuration files.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+// parse sets d to the duration represented by s.
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadConfig reads the configuration file at path, if not empty, then overrides its settings with the environment
+// variables which are set. Files are read as JSON if their name ends with .json, and as YAML otherwise.
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		if filepath.Ext(path) == ".json" {
+			err = json.Unmarshal(b, &c)
+		} else {
+			err = yaml.Unmarshal(b, &c)
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid configuration file %s: %w", path, err)
+		}
+	}
+	if err := c.applyEnv(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// applyEnv overrides the settings of c with the environment variables which are set.
+func (c *Config) applyEnv() error {
+	settings := map[string]*string{
+		"STREAM_GATEWAY":         &c.Gateway,
+		"STREAM_TOPIC":           &c.Topic,
+		"STREAM_CONTENT_TYPE":    &c.ContentType,
+		"STREAM_TOPIC_PREFIX":    &c.TopicPrefix,
+		"STREAM_TLS_CA_FILE":     &c.TLS.CAFile,
+		"STREAM_TLS_CERT_FILE":   &c.TLS.CertFile,
+		"STREAM_TLS_KEY_FILE":    &c.TLS.KeyFile,
+		"STREAM_TLS_SERVER_NAME": &c.TLS.ServerName,
+		"STREAM_GROUP":           &c.Offsets.Group,
+	}
+	for name, field := range settings {
+		if v, ok := os.LookupEnv(name); ok {
+			*field = v
+		}
+	}
+	bools := map[string]*bool{
+		"STREAM_TLS":                      &c.TLS.Enabled,
+		"STREAM_TLS_INSECURE_SKIP_VERIFY": &c.TLS.InsecureSkipVerify,
+		"STREAM_FROM_BEGINNING":           &c.Offsets.FromBeginning,
+	}
+	for name, field := range bools {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*field = b
+		}
+	}
+	if v, ok := os.LookupEnv("STREAM_ACK_ATTEMPTS"); ok {
+		attempts, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid STREAM_ACK_ATTEMPTS: %w", err)
+		}
+		c.Retries.AckAttempts = attempts
+	}
+	if v, ok := os.LookupEnv("STREAM_ACK_INTERVAL"); ok {
+		if err := c.Retries.AckInterval.parse(v); err != nil {
+			return fmt.Errorf("invalid STREAM_ACK_INTERVAL: %w", err)
+		}
+	}
+	if v, ok := os.LookupEnv("STREAM_GROUP_VERSION"); ok {
+		version, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid STREAM_GROUP_VERSION: %w", err)
+		}
+		c.Offsets.GroupVersion = uint32(version)
+	}
+	return nil
+}
+
+// NewStreamClientFromConfig creates a client as described by c. opts are applied after the options derived from c.
+func NewStreamClientFromConfig(c Config, opts ...ClientOption) (*StreamClient, error) {
+	if c.Gateway == "" || c.Topic == "" || c.ContentType == "" {
+		return nil, errors.New("a gateway, a topic and a content type are required")
+	}
+	var options []ClientOption
+	if c.TopicPrefix != "" {
+		options = append(options, WithTopicPrefix(c.TopicPrefix))
+	}
+	if c.TLS.Enabled {
+		tlsConfig, err := c.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		if isGRPCWeb(c.Gateway) {
+			options = append(options, WithHTTPClient(&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}))
+		} else {
+			options = append(options, WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+		}
+	}
+	return NewStreamClient(c.Gateway, c.Topic, c.ContentType, append(options, opts...)...)
+}
+
+// config returns the tls.Config described by c.
+func (c TLSConfig) config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// SubscribeOptions returns the options of subscriptions described by c, to be passed to Subscribe along with
+// c.Offsets.Group and c.Offsets.FromBeginning.
+func (c Config) SubscribeOptions() []SubscribeOption {
+	var opts []SubscribeOption
+	if c.Retries.AckAttempts > 0 {
+		interval := time.Duration(c.Retries.AckInterval)
+		if interval <= 0 {
+			interval = time.Second
+		}
+		opts = append(opts, WithAckRetries(c.Retries.AckAttempts, interval))
+	}
+	if c.Offsets.GroupVersion != 0 {
+		opts = append(opts, WithGroupVersion(c.Offsets.GroupVersion))
+	}
+	return opts
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+)
+
+// DeadLetter is an event of a dead letter stream, along with where it was originally read from and why it was dead
+// lettered, as recorded by RetryTopics.
+type DeadLetter struct {
+	Message
+	// SourceTopic is the topic the event was originally read from.
+	SourceTopic string
+	// SourcePartition is the partition the event was originally read from.
+	SourcePartition uint32
+	// SourceOffset is the offset of the event in the partition it was originally read from.
+	SourceOffset uint64
+	// Failure is the last error the event was handled with.
+	Failure string
+}
+
+// ParseDeadLetter decodes the extension attributes recording the origin of a dead lettered event. It returns false if
+// msg has no SourceTopicHeader, hence doesn't come from RetryTopics or alike.
+func ParseDeadLetter(msg Message) (DeadLetter, bool) {
+	dl := DeadLetter{
+		Message:     msg,
+		SourceTopic: msg.Headers[SourceTopicHeader],
+		Failure:     msg.Headers[FailureHeader],
+	}
+	if partition, err := strconv.ParseUint(msg.Headers[SourcePartitionHeader], 10, 32); err == nil {
+		dl.SourcePartition = uint32(partition)
+	}
+	if offset, err := strconv.ParseUint(msg.Headers[SourceOffsetHeader], 10, 64); err == nil {
+		dl.SourceOffset = offset
+	}
+	return dl, dl.SourceTopic != ""
+}
+
+// BrowseDeadLetters calls f for every event of the stream, taken as a dead letter stream, as of the time
+// BrowseDeadLetters is called, and returns once they have all been handled, or as soon as f returns an error. Events
+// which don't record their origin are skipped. As for ReadRange, no offsets are committed.
+func (lc *StreamClient) BrowseDeadLetters(ctx context.Context, f func(ctx context.Context, dl DeadLetter) error, opts ...SubscribeOption) error {
+	endOffsets, err := lc.endOffsets(ctx, lc.TopicName)
+	if err != nil {
+		return err
+	}
+	ranges := make(map[uint32]OffsetRange, len(endOffsets))
+	for partition, end := range endOffsets {
+		ranges[partition] = OffsetRange{To: end + 1}
+	}
+	return lc.ReadRange(ctx, ranges, func(ctx context.Context, msg Message) error {
+		dl, ok := ParseDeadLetter(msg)
+		if !ok {
+			return nil
+		}
+		return f(ctx, dl)
+	}, opts...)
+}
+
+// Redrive publishes dl back to the topic it was originally read from, under the same key and without the extension
+// attributes recording its failure, so that it is handled anew. The source topic must be available through the
+// gateway of the stream client.
+func (lc *StreamClient) Redrive(ctx context.Context, dl DeadLetter) (PublishResult, error) {
+	msg := dl.Message
+	msg.Headers = make(map[string]string, len(dl.Headers))
+	for k, v := range dl.Headers {
+		switch k {
+		case SourceTopicHeader, SourcePartitionHeader, SourceOffsetHeader, FailureHeader, RetryAttemptHeader, NotBeforeHeader:
+		default:
+			msg.Headers[k] = v
+		}
+	}
+	return lc.publishTo(ctx, dl.SourceTopic, msg.event(), msg.Key)
+}
+
+// RedriveDeadLetters browses the dead letter stream, as BrowseDeadLetters does, and redrives the events for which
+// selected returns true. It returns the number of events redriven. Redriven events remain in the dead letter stream.
+func (lc *StreamClient) RedriveDeadLetters(ctx context.Context, selected func(dl DeadLetter) bool) (int, error) {
+	var redriven int64
+	err := lc.BrowseDeadLetters(ctx, func(ctx context.Context, dl DeadLetter) error {
+		if !selected(dl) {
+			return nil
+		}
+		if _, err := lc.Redrive(ctx, dl); err != nil {
+			return err
+		}
+		atomic.AddInt64(&redriven, 1)
+		return nil
+	})
+	return int(redriven), err
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// DecodeError is reported for records which don't hold an event, for example because they were written by producers
+// foreign to riff, unless the subscription falls back to synthetic events with WithFallbackContentType.
+type DecodeError struct {
+	// Topic is the topic the record was read from.
+	Topic string
+	// Partition is the partition the record was read from.
+	Partition uint32
+	// Offset is the position of the record in its partition.
+	Offset uint64
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("record at offset %d of partition %d of topic %q does not hold an event", e.Offset, e.Partition, e.Topic)
+}
+
+// WithFallbackContentType makes the subscription hand records which don't hold an event over to the handler as
+// synthetic events, whose payload is the record and whose content type is contentType. Their ID is derived from their
+// position in the stream.
+func WithFallbackContentType(contentType string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.fallbackContentType = contentType
+	}
+}
+
+// WithDecodeErrorHandler routes records which don't hold an event to f rather than to the EventErrHandler. f is given
+// the record as a Message with only its payload, key and position set. Such records are skipped and committed.
+func WithDecodeErrorHandler(f func(ctx context.Context, msg Message, err *DecodeError)) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.onDecodeError = f
+	}
+}
+
+// decode returns the message held by reply. Records which don't hold an event are converted to synthetic events if
+// the subscription has a fallback content type, and flagged as undecodable otherwise, unless they are tombstones the
+// subscription hands over as such.
+func (s *Subscription) decode(topic string, partition uint32, reply *liiklus.ReceiveReply) Message {
+	var msg Message
+	if record := reply.GetRecord(); record != nil {
+		msg = newRawMessage(topic, partition, record)
+	} else if record := reply.GetLiiklusEventRecord(); record.GetEvent() == nil {
+		msg = newMessage(topic, partition, record)
+	} else {
+		return newMessage(topic, partition, record)
+	}
+	if s.options.raw || s.options.tombstones && IsTombstone(msg) {
+		return msg
+	}
+	if s.options.fallbackContentType != "" {
+		msg.ID = fmt.Sprintf("%s-%d-%d", topic, partition, msg.Offset)
+		msg.ContentType = s.options.fallbackContentType
+		return msg
+	}
+	msg.undecodable = true
+	return msg
+}
+
+// reportUndecodable reports whether msg doesn't hold an event, in which case it has been reported to the decode error
+// handler, or to the EventErrHandler.
+func (s *Subscription) reportUndecodable(ctx context.Context, msg Message) bool {
+	if !msg.undecodable {
+		return false
+	}
+	err := &DecodeError{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}
+	if s.options.onDecodeError != nil {
+		s.options.onDecodeError(ctx, msg, err)
+	} else {
+		s.fail(s.errs, s.errorAt(PhaseDecode, msg.Topic, msg.Partition, msg.Offset, err))
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"sync"
+)
+
+// WithDeduplication drops messages whose event ID is among the IDs of the last window messages handled by the
+// subscription, committing their offsets without handing them over to the handler. This prevents events redelivered
+// after reconnects or rebalances from being handled twice, as long as they are redelivered within the window.
+// Messages without an event ID are never considered duplicates.
+func WithDeduplication(window int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.dedupWindow = window
+	}
+}
+
+// dedupWindow remembers a bounded number of event IDs, forgetting the oldest ones first.
+type dedupWindow struct {
+	mu   sync.Mutex
+	ids  map[string]struct{}
+	ring []string
+	next int
+}
+
+func newDedupWindow(size int) *dedupWindow {
+	return &dedupWindow{
+		ids:  make(map[string]struct{}, size),
+		ring: make([]string, size),
+	}
+}
+
+// contains reports whether id is remembered.
+func (w *dedupWindow) contains(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.ids[id]
+	return ok
+}
+
+// add remembers id, forgetting the oldest id if the window is full.
+func (w *dedupWindow) add(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.ids[id]; ok {
+		return
+	}
+	delete(w.ids, w.ring[w.next])
+	w.ring[w.next] = id
+	w.ids[id] = struct{}{}
+	w.next = (w.next + 1) % len(w.ring)
+}
+
+// duplicate reports whether msg has already been handled, according to the deduplication window.
+func (o *subscribeOptions) duplicate(msg Message) bool {
+	return o.dedup != nil && msg.ID != "" && o.dedup.contains(msg.ID)
+}
+
+// handled records that msg has been handled, for the purpose of deduplication.
+func (o *subscribeOptions) handled(msg Message) {
+	if o.dedup != nil && msg.ID != "" {
+		o.dedup.add(msg.ID)
+	}
+}
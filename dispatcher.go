@@ -0,0 +1,67 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"sync"
+)
+
+// Dispatcher routes messages to handlers registered per event type, as an alternative to a single handler switching
+// on the type of events. Its Dispatch method is a MessageHandler, to be passed to the subscribe calls.
+type Dispatcher struct {
+	mu       sync.RWMutex
+	handlers map[string]MessageHandler
+	fallback MessageHandler
+}
+
+// NewDispatcher creates a Dispatcher without any registered handler.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		handlers: make(map[string]MessageHandler),
+	}
+}
+
+// Handle registers h as the handler of messages of the given event type, replacing any handler previously registered
+// for that type.
+func (d *Dispatcher) Handle(eventType string, h MessageHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[eventType] = h
+}
+
+// Fallback registers h as the handler of messages whose type has no registered handler. Without a fallback handler,
+// such messages are dropped and considered handled.
+func (d *Dispatcher) Fallback(h MessageHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fallback = h
+}
+
+// Dispatch hands msg over to the handler registered for its type.
+func (d *Dispatcher) Dispatch(ctx context.Context, msg Message) error {
+	d.mu.RLock()
+	h, ok := d.handlers[msg.Type]
+	if !ok {
+		h = d.fallback
+	}
+	d.mu.RUnlock()
+	if h == nil {
+		return nil
+	}
+	return h(ctx, msg)
+}
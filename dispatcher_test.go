@@ -0,0 +1,41 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+
+	client "github.com/projectriff/stream-client-go"
+)
+
+func TestDispatcher(t *testing.T) {
+	var handled []string
+	handler := func(name string) client.MessageHandler {
+		return func(ctx context.Context, msg client.Message) error {
+			handled = append(handled, name)
+			return nil
+		}
+	}
+
+	d := client.NewDispatcher()
+	d.Handle("order.created", handler("created"))
+	d.Handle("order.cancelled", handler("cancelled"))
+	for _, eventType := range []string{"order.created", "order.cancelled", "order.unknown"} {
+		if err := d.Dispatch(context.Background(), client.Message{Type: eventType}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Fallback(handler("fallback"))
+	if err := d.Dispatch(context.Background(), client.Message{Type: "order.unknown"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"created", "cancelled", "fallback"}
+	if len(handled) != len(expected) {
+		t.Fatalf("expected handlers %v to be called, but was: %v", expected, handled)
+	}
+	for i := range expected {
+		if handled[i] != expected[i] {
+			t.Errorf("expected handlers %v to be called, but was: %v", expected, handled)
+		}
+	}
+}
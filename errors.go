@@ -0,0 +1,205 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrIncompatibleContentType matches errors caused by an event whose content type is not accepted by the stream,
+	// see ContentTypeError.
+	ErrIncompatibleContentType = errors.New("incompatible content type")
+	// ErrSubscriptionClosed matches errors caused by using a subscription, or an API built on one, once it has
+	// terminated.
+	ErrSubscriptionClosed = errors.New("subscription closed")
+	// ErrClientClosed is returned when publishing or subscribing through a StreamClient which has been closed.
+	ErrClientClosed = errors.New("client closed")
+	// ErrGatewayUnavailable matches errors caused by the gateway being unreachable, see GatewayError.
+	ErrGatewayUnavailable = errors.New("gateway unavailable")
+	// ErrTopicNotFound matches errors caused by the topic not existing in the storage backing the gateway, see
+	// GatewayError and WithAutoCreateTopic.
+	ErrTopicNotFound = errors.New("topic not found")
+	// ErrResetToStart is returned when resetting the position of a consumer group to offset 0 of a partition, which
+	// can't be done by committing an offset, see ResetOffsets and ResetOffsetsToEarliest.
+	ErrResetToStart = errors.New("cannot reset offsets to the start of a partition")
+)
+
+// ContentTypeError is returned when publishing an event whose content type is not accepted by the stream. It matches
+// ErrIncompatibleContentType.
+type ContentTypeError struct {
+	// ContentType is the content type of the event.
+	ContentType string
+	// Expected is the content type accepted by the stream.
+	Expected string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("contentType %q not compatible with expected contentType %q", e.ContentType, e.Expected)
+}
+
+func (e *ContentTypeError) Is(target error) bool {
+	return target == ErrIncompatibleContentType
+}
+
+// checkContentType returns a ContentTypeError if contentType is not compatible with expected.
+func checkContentType(contentType string, expected string) error {
+	if chopContentType(contentType) != chopContentType(expected) { // TODO support smarter compatibility (eg subtypes)
+		return &ContentTypeError{ContentType: contentType, Expected: expected}
+	}
+	return nil
+}
+
+// ResetError is returned when the offsets of a consumer group could not be committed in some partitions, the offsets
+// of the other partitions having been committed: the group is then partly reset. It unwraps to the error of the first
+// partition which was not reset.
+type ResetError struct {
+	// Group is the consumer group whose offsets were being reset.
+	Group string
+	// Reset lists the partitions whose offsets were committed, in ascending order.
+	Reset []uint32
+	// Failed holds the error which prevented committing the offset of each other partition.
+	Failed map[uint32]error
+}
+
+func (e *ResetError) Error() string {
+	failed := e.failed()
+	return fmt.Sprintf("offsets of group %q not reset in partitions %v, reset in partitions %v: %v", e.Group, failed, e.Reset, e.Failed[failed[0]])
+}
+
+func (e *ResetError) Unwrap() error {
+	return e.Failed[e.failed()[0]]
+}
+
+// failed returns the partitions which were not reset, in ascending order.
+func (e *ResetError) failed() []uint32 {
+	partitions := make([]uint32, 0, len(e.Failed))
+	for partition := range e.Failed {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	return partitions
+}
+
+// GatewayError wraps the gRPC error returned by the gateway for an operation. It matches ErrGatewayUnavailable if the
+// gateway could not be reached, and ErrTopicNotFound if the topic does not exist.
+type GatewayError struct {
+	// Op describes the operation which failed, such as "publish" or "ack".
+	Op string
+	// Err is the gRPC error, whose status can be obtained with status.FromError.
+	Err error
+}
+
+func (e *GatewayError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *GatewayError) Unwrap() error {
+	return e.Err
+}
+
+func (e *GatewayError) Is(target error) bool {
+	switch target {
+	case ErrGatewayUnavailable:
+		return status.Code(e.Err) == codes.Unavailable
+	case ErrTopicNotFound:
+		return isTopicNotFound(e.Err)
+	}
+	return false
+}
+
+// isTopicNotFound reports whether err, returned by the gateway, is caused by a missing topic. Liiklus doesn't map
+// Kafka errors to specific codes, hence the messages of the Kafka client are looked for as well.
+func isTopicNotFound(err error) bool {
+	s := status.Convert(err)
+	if s.Code() == codes.NotFound {
+		return true
+	}
+	return strings.Contains(s.Message(), "UnknownTopicOrPartition") || strings.Contains(s.Message(), "not present in metadata")
+}
+
+// gatewayError wraps err, returned by the gateway for op, in a GatewayError, unless it is nil.
+func gatewayError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &GatewayError{Op: op, Err: err}
+}
+
+// Phase is the stage of the processing of a subscription a SubscriptionError occurred in.
+type Phase string
+
+const (
+	// PhaseSubscribe is joining the consumer group of a topic and receiving partition assignments.
+	PhaseSubscribe Phase = "subscribe"
+	// PhaseReceive is reading records from a partition assigned to the subscription.
+	PhaseReceive Phase = "receive"
+	// PhaseDecode is turning a record into a Message, including validating its payload.
+	PhaseDecode Phase = "decode"
+	// PhaseHandle is invoking the handler of the subscription.
+	PhaseHandle Phase = "handle"
+	// PhaseAck is committing the offset of a message.
+	PhaseAck Phase = "ack"
+)
+
+// SubscriptionError is passed to the EventErrHandler of a subscription, describing where the error occurred. The
+// cause can be inspected with errors.Is and errors.As, as SubscriptionError unwraps to it.
+type SubscriptionError struct {
+	// Phase is the stage of processing the error occurred in.
+	Phase Phase
+	// Topic is the topic the error relates to.
+	Topic string
+	// Group is the consumer group of the subscription, empty for anonymous subscriptions.
+	Group string
+	// Partition is the partition the error relates to. It is not meaningful in PhaseSubscribe.
+	Partition uint32
+	// Offset is the offset of the message the error relates to, the first one of the batch for BatchHandlers. It is
+	// not meaningful in PhaseSubscribe and PhaseReceive.
+	Offset uint64
+	// Err is the cause of the error.
+	Err error
+}
+
+func (e *SubscriptionError) Error() string {
+	switch e.Phase {
+	case PhaseSubscribe:
+		return fmt.Sprintf("%s topic %q: %v", e.Phase, e.Topic, e.Err)
+	case PhaseReceive:
+		return fmt.Sprintf("%s partition %d of topic %q: %v", e.Phase, e.Partition, e.Topic, e.Err)
+	}
+	return fmt.Sprintf("%s offset %d of partition %d of topic %q: %v", e.Phase, e.Offset, e.Partition, e.Topic, e.Err)
+}
+
+func (e *SubscriptionError) Unwrap() error {
+	return e.Err
+}
+
+// errorAt wraps err, which occurred in phase while processing offset of the partition of topic, in a
+// SubscriptionError, unless it is nil or one already.
+func (s *Subscription) errorAt(phase Phase, topic string, partition uint32, offset uint64, err error) error {
+	var subErr *SubscriptionError
+	if err == nil || errors.As(err, &subErr) {
+		return err
+	}
+	return &SubscriptionError{Phase: phase, Topic: topic, Group: s.statsGroup(), Partition: partition, Offset: offset, Err: err}
+}
@@ -0,0 +1,236 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// Export writes the events of the stream whose offset in their partition is at least fromOffset and less than
+// toOffset to w, one JSON encoded CloudEvent per line. This is meant for debugging, auditing and seeding test
+// environments. The key of each event, if any, is written as the partitionkey extension attribute, or base64 encoded
+// as the partitionkey_base64 attribute if it is not valid UTF-8, as JSON strings can't hold arbitrary bytes. Events of
+// different partitions are interleaved.
+func (lc *StreamClient) Export(ctx context.Context, w io.Writer, fromOffset uint64, toOffset uint64) error {
+	ends, err := lc.endOffsets(ctx, lc.TopicName)
+	if err != nil {
+		return err
+	}
+	ranges := make(map[uint32]OffsetRange, len(ends))
+	for partition, end := range ends {
+		to := toOffset
+		if end+1 < to {
+			to = end + 1
+		}
+		ranges[partition] = OffsetRange{From: fromOffset, To: to}
+	}
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return lc.ReadRange(ctx, ranges, func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return encoder.Encode(encodeEvent(msg))
+	})
+}
+
+// CloudEventJSON returns the representation of msg as a CloudEvent in JSON format, as written by Export.
+func CloudEventJSON(msg Message) ([]byte, error) {
+	return json.Marshal(encodeEvent(msg))
+}
+
+// ParseCloudEventJSON parses a CloudEvent in JSON format, such as written by CloudEventJSON, into a Message. Only
+// the attributes of the event are set: the key is taken from the partitionkey or partitionkey_base64 extension
+// attribute, if any, and other extension attributes become headers.
+func ParseCloudEventJSON(data []byte) (Message, error) {
+	event, key, err := decodeEvent(data)
+	if err != nil {
+		return Message{}, err
+	}
+	msg := Message{
+		ID:          event.Id,
+		Source:      event.Source,
+		Type:        event.Type,
+		Payload:     event.Data,
+		ContentType: event.DataContentType,
+		Headers:     event.Extensions,
+		Key:         key,
+	}
+	if event.Time != "" {
+		if msg.Time, err = time.Parse(time.RFC3339Nano, event.Time); err != nil {
+			return Message{}, fmt.Errorf("invalid time: %w", err)
+		}
+	}
+	return msg, nil
+}
+
+// encodeEvent represents msg as a CloudEvent in JSON format. Extension attributes are inlined, hence the map.
+func encodeEvent(msg Message) map[string]interface{} {
+	event := make(map[string]interface{}, len(msg.Headers)+7)
+	for k, v := range msg.Headers {
+		event[k] = v
+	}
+	event["specversion"] = "1.0"
+	event["id"] = msg.ID
+	event["source"] = msg.Source
+	event["type"] = msg.Type
+	if msg.ContentType != "" {
+		event["datacontenttype"] = msg.ContentType
+	}
+	if !msg.Time.IsZero() {
+		event["time"] = msg.Time.Format(time.RFC3339Nano)
+	}
+	switch {
+	case len(msg.Key) == 0:
+	case utf8.Valid(msg.Key):
+		event["partitionkey"] = string(msg.Key)
+	default:
+		event["partitionkey_base64"] = base64.StdEncoding.EncodeToString(msg.Key)
+	}
+	switch {
+	case isJSON(msg.ContentType) && json.Valid(msg.Payload):
+		event["data"] = json.RawMessage(msg.Payload)
+	case strings.HasPrefix(msg.ContentType, "text/") && utf8.Valid(msg.Payload):
+		event["data"] = string(msg.Payload)
+	default:
+		event["data_base64"] = base64.StdEncoding.EncodeToString(msg.Payload)
+	}
+	return event
+}
+
+// isJSON reports whether contentType designates JSON content.
+func isJSON(contentType string) bool {
+	mediaType := chopContentType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// ImportOption configures optional behavior of StreamClient.Import.
+type ImportOption func(*importOptions)
+
+type importOptions struct {
+	// regenerateIDs assigns new IDs to imported events.
+	regenerateIDs bool
+}
+
+// WithRegeneratedIDs assigns new IDs to imported events rather than preserving the ones they were exported with, for
+// example when importing the same events several times into the same stream.
+func WithRegeneratedIDs() ImportOption {
+	return func(o *importOptions) {
+		o.regenerateIDs = true
+	}
+}
+
+// Import publishes the events read from r, one JSON encoded CloudEvent per line as written by Export, to the stream.
+// This allows captured traffic to be replayed, possibly into a different stream. Events are published under the key
+// held by their partitionkey or partitionkey_base64 extension attribute, if any, and their content type must be compatible with the stream.
+func (lc *StreamClient) Import(ctx context.Context, r io.Reader, opts ...ImportOption) error {
+	options := &importOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		event, key, err := decodeEvent(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := checkContentType(event.DataContentType, lc.acceptableContentType); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if options.regenerateIDs || event.Id == "" {
+			event.Id = uuid.New().String()
+		}
+		if _, err := lc.publish(ctx, event, key); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// decodeEvent parses a CloudEvent in JSON format, as written by encodeEvent, returning the event and its key.
+func decodeEvent(data []byte) (*liiklus.LiiklusEvent, []byte, error) {
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil, nil, err
+	}
+	event := &liiklus.LiiklusEvent{Extensions: make(map[string]string)}
+	if contentType, ok := attributes["datacontenttype"]; ok {
+		if err := json.Unmarshal(contentType, &event.DataContentType); err != nil {
+			return nil, nil, fmt.Errorf("invalid datacontenttype: %w", err)
+		}
+	}
+	var key []byte
+	for name, value := range attributes {
+		var s string
+		isString := json.Unmarshal(value, &s) == nil
+		switch name {
+		case "specversion", "datacontenttype":
+		case "id":
+			event.Id = s
+		case "source":
+			event.Source = s
+		case "type":
+			event.Type = s
+		case "time":
+			event.Time = s
+		case "partitionkey":
+			key = []byte(s)
+		case "partitionkey_base64":
+			k, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid partitionkey_base64: %w", err)
+			}
+			key = k
+		case "data_base64":
+			payload, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid data_base64: %w", err)
+			}
+			event.Data = payload
+		case "data":
+			// data is raw JSON for JSON content, otherwise it is a string
+			if isString && !isJSON(event.DataContentType) {
+				event.Data = []byte(s)
+			} else {
+				event.Data = value
+			}
+		default:
+			if isString {
+				event.Extensions[name] = s
+			} else {
+				event.Extensions[name] = string(value)
+			}
+		}
+	}
+	return event, key, nil
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// GatewayClient is a connection to a gateway shared by the clients of several streams. This spares services which
+// discover topics at runtime the cost of connecting to the gateway for each of them. A GatewayClient is safe for
+// concurrent use by multiple goroutines.
+type GatewayClient struct {
+	// Gateway is the gateway connected to, as passed to NewGatewayClient.
+	Gateway string
+
+	// base holds the connection, and the options streams are configured with.
+	base *StreamClient
+	opts []ClientOption
+	// mu guards streams.
+	mu sync.Mutex
+	// streams are the clients returned by Stream, closed on Close.
+	streams map[*StreamClient]struct{}
+}
+
+// NewGatewayClient connects to gateway, which is as for NewStreamClient. The options configure both the connection and
+// the clients returned by Stream.
+func NewGatewayClient(gateway string, opts ...ClientOption) (*GatewayClient, error) {
+	base := newStreamClient(gateway, "", "", opts)
+	if err := base.connect(); err != nil {
+		return nil, err
+	}
+	return &GatewayClient{
+		Gateway: gateway,
+		base:    base,
+		opts:    opts,
+		streams: make(map[*StreamClient]struct{}),
+	}, nil
+}
+
+// Stream returns a client of the stream backed by topic, sharing the connection of g. Closing the returned client
+// stops its subscriptions but leaves the connection open. It fails with ErrClientClosed once g has been closed.
+func (g *GatewayClient) Stream(topic string, acceptableContentType string) (*StreamClient, error) {
+	lc := g.newStreamClient(topic, acceptableContentType)
+	lc.release = func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		delete(g.streams, lc)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.streams == nil {
+		return nil, ErrClientClosed
+	}
+	g.streams[lc] = struct{}{}
+	return lc, nil
+}
+
+// PublishTo publishes an event to the stream backed by topic, as StreamClient.Publish does, for services such as
+// routers which can't enumerate the topics they publish to up front. The content type of the event is not checked
+// against the one accepted by the stream, which the gateway doesn't know about. It fails with ErrClientClosed once g
+// has been closed.
+func (g *GatewayClient) PublishTo(ctx context.Context, topic string, payload io.Reader, key io.Reader, contentType string, headers map[string]string, opts ...PublishOption) (PublishResult, error) {
+	g.mu.Lock()
+	closed := g.streams == nil
+	g.mu.Unlock()
+	if closed {
+		return PublishResult{}, ErrClientClosed
+	}
+	return g.newStreamClient(topic, contentType).Publish(ctx, payload, key, contentType, headers, opts...)
+}
+
+// newStreamClient returns a client of the stream backed by topic using the connection of g.
+func (g *GatewayClient) newStreamClient(topic string, acceptableContentType string) *StreamClient {
+	lc := newStreamClient(g.Gateway, topic, acceptableContentType, g.opts)
+	lc.conn, lc.transport, lc.client = g.base.conn, g.base.transport, g.base.client
+	return lc
+}
+
+// Close closes the clients returned by Stream, then the connection to the gateway. Further calls to Close do nothing.
+func (g *GatewayClient) Close() error {
+	g.mu.Lock()
+	streams := g.streams
+	g.streams = nil
+	g.mu.Unlock()
+	if streams == nil {
+		return nil
+	}
+	var err error
+	for lc := range streams {
+		if cerr := lc.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("closing client of topic %s: %w", lc.TopicName, cerr)
+		}
+	}
+	if cerr := g.base.Close(); cerr != nil {
+		return cerr
+	}
+	return err
+}
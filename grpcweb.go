@@ -0,0 +1,374 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// liiklusService is the full name of the gRPC service of liiklus, prefixing the paths of its methods.
+const liiklusService = "/com.github.bsideup.liiklus.LiiklusService/"
+
+// WithHTTPClient sends the requests of clients connecting to the gateway with grpc-web with c, rather than
+// http.DefaultClient, for example to go through a proxy or to trust a custom certificate authority.
+func WithHTTPClient(c *http.Client) ClientOption {
+	return func(lc *StreamClient) {
+		lc.httpClient = c
+	}
+}
+
+// isGRPCWeb reports whether gateway is the URL of a grpc-web endpoint rather than the address of a gRPC server.
+func isGRPCWeb(gateway string) bool {
+	return strings.HasPrefix(gateway, "http://") || strings.HasPrefix(gateway, "https://")
+}
+
+// grpcWebClient calls liiklus through a grpc-web endpoint, such as one exposed by Envoy in front of liiklus, over
+// HTTP/1.1. This works behind proxies which don't let HTTP/2 gRPC traffic through, at the cost of the server streams
+// only ending when the response does.
+type grpcWebClient struct {
+	url  string
+	http *http.Client
+}
+
+func newGRPCWebClient(url string, httpClient *http.Client) *grpcWebClient {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &grpcWebClient{url: strings.TrimSuffix(url, "/"), http: httpClient}
+}
+
+func (c *grpcWebClient) Publish(ctx context.Context, in *liiklus.PublishRequest, opts ...grpc.CallOption) (*liiklus.PublishReply, error) {
+	out := new(liiklus.PublishReply)
+	return out, c.invoke(ctx, "Publish", in, out)
+}
+
+func (c *grpcWebClient) Subscribe(ctx context.Context, in *liiklus.SubscribeRequest, opts ...grpc.CallOption) (liiklus.LiiklusService_SubscribeClient, error) {
+	stream, err := c.call(ctx, "Subscribe", in)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcWebSubscribeClient{stream}, nil
+}
+
+func (c *grpcWebClient) Receive(ctx context.Context, in *liiklus.ReceiveRequest, opts ...grpc.CallOption) (liiklus.LiiklusService_ReceiveClient, error) {
+	stream, err := c.call(ctx, "Receive", in)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcWebReceiveClient{stream}, nil
+}
+
+func (c *grpcWebClient) Ack(ctx context.Context, in *liiklus.AckRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	return out, c.invoke(ctx, "Ack", in, out)
+}
+
+func (c *grpcWebClient) GetOffsets(ctx context.Context, in *liiklus.GetOffsetsRequest, opts ...grpc.CallOption) (*liiklus.GetOffsetsReply, error) {
+	out := new(liiklus.GetOffsetsReply)
+	return out, c.invoke(ctx, "GetOffsets", in, out)
+}
+
+func (c *grpcWebClient) GetEndOffsets(ctx context.Context, in *liiklus.GetEndOffsetsRequest, opts ...grpc.CallOption) (*liiklus.GetEndOffsetsReply, error) {
+	out := new(liiklus.GetEndOffsetsReply)
+	return out, c.invoke(ctx, "GetEndOffsets", in, out)
+}
+
+// Close releases the idle connections to the endpoint.
+func (c *grpcWebClient) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+// invoke calls a unary method.
+func (c *grpcWebClient) invoke(ctx context.Context, method string, in proto.Message, out proto.Message) error {
+	stream, err := c.call(ctx, method, in)
+	if err != nil {
+		return err
+	}
+	defer stream.body.Close()
+	if err := stream.RecvMsg(out); err != nil {
+		if err == io.EOF {
+			return status.Error(codes.Internal, "grpc-web: no response message")
+		}
+		return err
+	}
+	return nil
+}
+
+// call sends a request to method, returning the stream of responses.
+func (c *grpcWebClient) call(ctx context.Context, method string, in proto.Message) (*grpcWebStream, error) {
+	data, err := proto.Marshal(in)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	frame = append(frame, data...)
+
+	// the context of the request bounds the whole stream of responses
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+liiklusService+method, bytes.NewReader(frame))
+	if err != nil {
+		cancel()
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set("Accept", "application/grpc-web+proto")
+	req.Header.Set("X-Grpc-Web", "1")
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for k, values := range md {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		defer cancel()
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if err := httpStatusError(resp); err != nil {
+		resp.Body.Close()
+		cancel()
+		return nil, err
+	}
+	// trailers-only responses carry the status in the headers
+	if err := grpcStatus(resp.Header); err != nil {
+		resp.Body.Close()
+		cancel()
+		return nil, err
+	}
+	return &grpcWebStream{
+		ctx:    ctx,
+		cancel: cancel,
+		header: metadata.MD(lowerKeys(resp.Header)),
+		body:   resp.Body,
+		reader: bufio.NewReader(resp.Body),
+	}, nil
+}
+
+// grpcWebStream implements grpc.ClientStream over the body of a grpc-web response.
+type grpcWebStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	header metadata.MD
+	body   io.ReadCloser
+	reader *bufio.Reader
+	// mu guards trailer and err.
+	mu      sync.Mutex
+	trailer metadata.MD
+	// err is the error ending the stream, once it has ended.
+	err error
+}
+
+func (s *grpcWebStream) Header() (metadata.MD, error) { return s.header, nil }
+func (s *grpcWebStream) CloseSend() error             { return nil }
+func (s *grpcWebStream) Context() context.Context     { return s.ctx }
+func (s *grpcWebStream) SendMsg(m interface{}) error {
+	return status.Error(codes.Unimplemented, "grpc-web: client streaming is not supported")
+}
+
+func (s *grpcWebStream) Trailer() metadata.MD {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trailer
+}
+
+// RecvMsg reads the next message of the stream into m. It returns io.EOF once the stream ended successfully.
+func (s *grpcWebStream) RecvMsg(m interface{}) error {
+	s.mu.Lock()
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for {
+		flags, data, err := s.readFrame()
+		if err == nil && flags&0x80 != 0 {
+			err = s.readTrailer(data)
+		} else if err == nil {
+			if err = proto.Unmarshal(data, m.(proto.Message)); err != nil {
+				err = status.Error(codes.Internal, err.Error())
+			}
+			if err == nil {
+				return nil
+			}
+		}
+		return s.end(err)
+	}
+}
+
+// readFrame reads a length-prefixed frame of the response.
+func (s *grpcWebStream) readFrame() (byte, []byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(s.reader, prefix[:]); err != nil {
+		if s.ctx.Err() != nil {
+			return 0, nil, status.FromContextError(s.ctx.Err()).Err()
+		}
+		if err == io.EOF {
+			return 0, nil, status.Error(codes.Internal, "grpc-web: stream ended without a status")
+		}
+		return 0, nil, status.Error(codes.Unavailable, err.Error())
+	}
+	data := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.ReadFull(s.reader, data); err != nil {
+		if s.ctx.Err() != nil {
+			return 0, nil, status.FromContextError(s.ctx.Err()).Err()
+		}
+		return 0, nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return prefix[0], data, nil
+}
+
+// readTrailer parses the trailer frame ending the stream, returning io.EOF if the call succeeded.
+func (s *grpcWebStream) readTrailer(data []byte) error {
+	reader := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(data), strings.NewReader("\r\n"))))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return status.Error(codes.Internal, fmt.Sprintf("grpc-web: malformed trailer: %v", err))
+	}
+	s.mu.Lock()
+	s.trailer = metadata.MD(lowerKeys(http.Header(header)))
+	s.mu.Unlock()
+	if err := grpcStatus(http.Header(header)); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// end ends the stream with err, releasing the response.
+func (s *grpcWebStream) end(err error) error {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	err = s.err
+	s.mu.Unlock()
+	s.cancel()
+	s.body.Close()
+	return err
+}
+
+type grpcWebSubscribeClient struct {
+	*grpcWebStream
+}
+
+func (x *grpcWebSubscribeClient) Recv() (*liiklus.SubscribeReply, error) {
+	m := new(liiklus.SubscribeReply)
+	if err := x.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type grpcWebReceiveClient struct {
+	*grpcWebStream
+}
+
+func (x *grpcWebReceiveClient) Recv() (*liiklus.ReceiveReply, error) {
+	m := new(liiklus.ReceiveReply)
+	if err := x.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// grpcStatus returns the error described by the grpc-status and grpc-message headers, if any.
+func grpcStatus(header http.Header) error {
+	value := header.Get("Grpc-Status")
+	if value == "" {
+		return nil
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return status.Error(codes.Internal, fmt.Sprintf("grpc-web: malformed grpc-status %q", value))
+	}
+	if code == int(codes.OK) {
+		return nil
+	}
+	return status.Error(codes.Code(code), decodeGRPCMessage(header.Get("Grpc-Message")))
+}
+
+// decodeGRPCMessage decodes the percent-encoded grpc-message header.
+func decodeGRPCMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if msg[i] == '%' && i+2 < len(msg) {
+			if c, err := strconv.ParseUint(msg[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(msg[i])
+	}
+	return b.String()
+}
+
+// httpStatusError maps an unsuccessful HTTP response to a gRPC error, as for responses of proxies which are not
+// grpc-web aware.
+func httpStatusError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	msg := fmt.Sprintf("grpc-web: unexpected HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		return status.Error(codes.Internal, msg)
+	case http.StatusUnauthorized:
+		return status.Error(codes.Unauthenticated, msg)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, msg)
+	case http.StatusNotFound:
+		return status.Error(codes.Unimplemented, msg)
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return status.Error(codes.Unavailable, msg)
+	}
+	return status.Error(codes.Unknown, msg)
+}
+
+// lowerKeys returns the headers keyed by their lower case names, as metadata is.
+func lowerKeys(header http.Header) map[string][]string {
+	md := make(map[string][]string, len(header))
+	for k, v := range header {
+		md[strings.ToLower(k)] = v
+	}
+	return md
+}
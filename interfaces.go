@@ -0,0 +1,76 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"io"
+)
+
+// Publisher publishes events to a stream. It is satisfied by *StreamClient, so that code publishing events may depend
+// on it and be tested with a mock.
+type Publisher interface {
+	Publish(ctx context.Context, payload io.Reader, key io.Reader, contentType string, headers map[string]string, opts ...PublishOption) (PublishResult, error)
+}
+
+// Subscriber subscribes to the events of a stream. It is satisfied by *StreamClient, so that code consuming events
+// may depend on it and be tested with a mock, returning subscriptions created by NewStubSubscription.
+type Subscriber interface {
+	Subscribe(ctx context.Context, group string, fromBeginning bool, f EventHandler, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error)
+	SubscribeMessages(ctx context.Context, group string, fromBeginning bool, f MessageHandler, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error)
+}
+
+// NewStubSubscription returns a Subscription of group to topics which isn't backed by a gateway, for mocks of
+// Subscriber to return. It reads nothing and has no partitions assigned. It terminates once cancelled or drained,
+// once ctx is done, or once stop is called, in which case its Err is err.
+func NewStubSubscription(ctx context.Context, group string, topics ...string) (sub *Subscription, stop func(err error)) {
+	subContext, cancel := context.WithCancel(ctx)
+	fetchContext, stopFetching := context.WithCancel(subContext)
+	s := newSubscription(nil, topics, group, subContext, cancel, fetchContext, stopFetching, nil, func(cancel context.CancelFunc, err error) {}, newSubscribeOptions(nil))
+	s.goroutine(func() {
+		<-fetchContext.Done()
+	})
+	s.wait()
+	return s, func(err error) {
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+// Client publishes and subscribes to a stream, and releases its resources once closed.
+type Client interface {
+	Publisher
+	Subscriber
+	io.Closer
+}
+
+var _ Client = (*StreamClient)(nil)
+
+// NewClient is like NewStreamClient, but returns the client as a Client, for code depending on interfaces rather than
+// on *StreamClient.
+func NewClient(gateway string, topic string, acceptableContentType string, opts ...ClientOption) (Client, error) {
+	lc, err := NewStreamClient(gateway, topic, acceptableContentType, opts...)
+	if err != nil {
+		// avoid returning a non-nil interface holding a nil pointer
+		return nil, err
+	}
+	return lc, nil
+}
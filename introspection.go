@@ -0,0 +1,69 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"sort"
+)
+
+// TopicPartition designates a partition of a topic.
+type TopicPartition struct {
+	// Topic is the name of the topic.
+	Topic string
+	// Partition is the partition number.
+	Partition uint32
+}
+
+// Group returns the consumer group of the subscription, or the empty string for anonymous subscriptions.
+func (s *Subscription) Group() string {
+	return s.statsGroup()
+}
+
+// Assignments returns the partitions currently assigned to the subscription, ordered by topic and partition. This
+// answers "what is this instance consuming right now?", partitions being spread over the members of the consumer group.
+func (s *Subscription) Assignments() []TopicPartition {
+	s.mu.Lock()
+	assignments := make([]TopicPartition, 0, len(s.partitions))
+	for tp := range s.partitions {
+		assignments = append(assignments, TopicPartition{Topic: tp.topic, Partition: tp.partition})
+	}
+	s.mu.Unlock()
+	sort.Slice(assignments, func(i, j int) bool {
+		a, b := assignments[i], assignments[j]
+		return a.Topic < b.Topic || a.Topic == b.Topic && a.Partition < b.Partition
+	})
+	return assignments
+}
+
+// CommittedOffsets returns the offsets committed by the consumer group of the subscription in the partitions of each
+// topic it consumes, including those assigned to other members of the group, as recorded by the gateway. It is empty
+// for anonymous subscriptions, which don't commit offsets, and for stubs.
+func (s *Subscription) CommittedOffsets(ctx context.Context) (map[string]map[uint32]uint64, error) {
+	committed := make(map[string]map[uint32]uint64, len(s.topics))
+	if s.anonymous || s.client == nil {
+		return committed, nil
+	}
+	for _, topic := range s.topics {
+		offsets, err := s.client.committedOffsets(ctx, topic, s.group, s.options.groupVersion)
+		if err != nil {
+			return nil, err
+		}
+		committed[topic] = offsets
+	}
+	return committed, nil
+}
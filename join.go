@@ -0,0 +1,149 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// JoinHandler handles a pair of messages sharing the same key, one of each stream of a Join.
+type JoinHandler = func(ctx context.Context, left Message, right Message) error
+
+// Join matches the messages of two co-partitioned streams, that is streams whose messages are partitioned by the same
+// keys into the same number of partitions, covering the enrichment pattern: each message is paired with the messages
+// of the other stream sharing its key which happened within the join window of it, according to the time of their
+// event, or the time they were recorded by the broker if they have none. Messages are buffered for the duration of the
+// window after the latest event time read, so that the messages of a pair may be read in any order. Messages without
+// a key are ignored. Buffers are held in memory and offsets are committed as messages are buffered, so pairs whose
+// first message was read before a restart are missed.
+type Join struct {
+	client *StreamClient
+	topics [2]string
+	group  string
+	window time.Duration
+	f      JoinHandler
+
+	mu sync.Mutex
+	// buffers hold the messages of the left and right streams which may still be paired, by key.
+	buffers   [2]map[string][]Message
+	watermark time.Time
+	// swept is the watermark as of the last time expired messages were evicted from buffers.
+	swept time.Time
+}
+
+// NewJoin creates a Join invoking f with the pairs of messages of the left and right topics sharing a key and which
+// happened at most window apart, tracking its position in both topics as part of group. Both topics must be available
+// through the gateway of lc.
+func NewJoin(lc *StreamClient, left string, right string, group string, window time.Duration, f JoinHandler) *Join {
+	return &Join{
+		client:  lc,
+		topics:  [2]string{left, right},
+		group:   group,
+		window:  window,
+		f:       f,
+		buffers: [2]map[string][]Message{make(map[string][]Message), make(map[string][]Message)},
+	}
+}
+
+// Start starts joining the streams, which goes on until the returned Subscription is stopped. Joining starts from the
+// beginning of the streams when the group has no position yet. Optional behavior of the underlying subscription may be
+// configured by passing SubscribeOptions, as for Subscribe. Partitions of both streams must be assigned to the same
+// instances of the group for every pair to be matched, which is the case of a group with a single member.
+func (j *Join) Start(ctx context.Context, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	if j.topics[0] == j.topics[1] {
+		return nil, errors.New("the joined streams must be different")
+	}
+	return j.client.MultiSubscribe(ctx, j.topics[:], j.group, true, j.join, e, opts...)
+}
+
+// join pairs msg with the buffered messages of the other stream, and buffers it.
+func (j *Join) join(ctx context.Context, msg Message) error {
+	if len(msg.Key) == 0 {
+		return nil
+	}
+	side := 0
+	if msg.Topic == j.topics[1] {
+		side = 1
+	}
+	key := string(msg.Key)
+	happened := msg.happened()
+
+	j.mu.Lock()
+	if happened.After(j.watermark) {
+		j.watermark = happened
+	}
+	horizon := j.watermark.Add(-j.window)
+	var matches []Message
+	others := j.unexpired(j.buffers[1-side][key], horizon)
+	for _, other := range others {
+		if d := other.happened().Sub(happened); d <= j.window && d >= -j.window {
+			matches = append(matches, other)
+		}
+	}
+	j.store(1-side, key, others)
+	if !happened.Before(horizon) {
+		j.store(side, key, append(j.unexpired(j.buffers[side][key], horizon), msg))
+	}
+	if j.watermark.Sub(j.swept) >= j.window {
+		j.sweep(horizon)
+	}
+	j.mu.Unlock()
+
+	for _, other := range matches {
+		left, right := msg, other
+		if side == 1 {
+			left, right = other, msg
+		}
+		if err := j.f(ctx, left, right); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unexpired returns the messages of buffer which happened at or after horizon.
+func (j *Join) unexpired(buffer []Message, horizon time.Time) []Message {
+	kept := buffer[:0]
+	for _, msg := range buffer {
+		if !msg.happened().Before(horizon) {
+			kept = append(kept, msg)
+		}
+	}
+	return kept
+}
+
+// store sets the buffered messages of key for the given side.
+func (j *Join) store(side int, key string, buffer []Message) {
+	if len(buffer) == 0 {
+		delete(j.buffers[side], key)
+	} else {
+		j.buffers[side][key] = buffer
+	}
+}
+
+// sweep evicts the messages which happened before horizon from all buffers.
+func (j *Join) sweep(horizon time.Time) {
+	for side, buffers := range j.buffers {
+		for key, buffer := range buffers {
+			j.store(side, key, j.unexpired(buffer, horizon))
+		}
+	}
+	j.swept = j.watermark
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// PublishJSON publishes value to the stream of lc, marshaled as JSON. The stream must accept JSON content, such as
+// application/json or a +json media type, which the event is published as.
+func PublishJSON[T any](ctx context.Context, lc *StreamClient, value T, key io.Reader, headers map[string]string, opts ...PublishOption) (PublishResult, error) {
+	if !isJSON(lc.acceptableContentType) {
+		return PublishResult{}, fmt.Errorf("stream content type %q is not JSON", lc.acceptableContentType)
+	}
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return PublishResult{}, err
+	}
+	return lc.Publish(ctx, bytes.NewReader(payload), key, lc.acceptableContentType, headers, opts...)
+}
+
+// SubscribeJSON is like SubscribeMessages, but unmarshals the JSON payload of each message into a value of type T
+// before handing it over to f, along with the message it was read from. Messages whose content type is not JSON, or
+// whose payload can't be unmarshaled, are reported as errors.
+func SubscribeJSON[T any](ctx context.Context, lc *StreamClient, group string, fromBeginning bool, f func(ctx context.Context, value T, msg Message) error, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	return lc.SubscribeMessages(ctx, group, fromBeginning, func(ctx context.Context, msg Message) error {
+		if !isJSON(msg.ContentType) {
+			return fmt.Errorf("content type %q of event %s is not JSON", msg.ContentType, msg.ID)
+		}
+		var value T
+		if err := json.Unmarshal(msg.Payload, &value); err != nil {
+			return fmt.Errorf("unable to unmarshal event %s: %w", msg.ID, err)
+		}
+		return f(ctx, value, msg)
+	}, e, opts...)
+}
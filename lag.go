@@ -0,0 +1,48 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+)
+
+// Lag returns how many events of the stream remain to be handled by the given consumer group, per partition. Events
+// count as handled once their offsets have been committed. Partitions the group never committed an offset of lag by
+// their whole content.
+func (lc *StreamClient) Lag(ctx context.Context, group string) (map[uint32]uint64, error) {
+	ends, err := lc.endOffsets(ctx, lc.TopicName)
+	if err != nil {
+		return nil, err
+	}
+	committed, err := lc.committedOffsets(ctx, lc.TopicName, group, 0)
+	if err != nil {
+		return nil, err
+	}
+	lag := make(map[uint32]uint64, len(ends))
+	for partition, end := range ends {
+		offset, ok := committed[partition]
+		switch {
+		case !ok:
+			lag[partition] = end + 1
+		case end > offset:
+			lag[partition] = end - offset
+		default:
+			lag[partition] = 0
+		}
+	}
+	return lag, nil
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// LagMonitor periodically samples the lag of a consumer group, as reported by StreamClient.Lag, so that operators can
+// be alerted about consumers falling behind.
+type LagMonitor struct {
+	client     *StreamClient
+	group      string
+	interval   time.Duration
+	thresholds []lagThreshold
+	onSample   func(lag map[uint32]uint64)
+	onError    func(err error)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// lagThreshold is a threshold watched by a LagMonitor, along with the partitions currently above it.
+type lagThreshold struct {
+	threshold uint64
+	f         func(partition uint32, lag uint64, above bool)
+	above     map[uint32]bool
+}
+
+// LagMonitorOption configures a LagMonitor.
+type LagMonitorOption func(*LagMonitor)
+
+// WithLagThreshold calls f whenever the lag of a partition rises above threshold, with above set, and when it gets back
+// to threshold or below, with above unset. Several thresholds may be watched, for example to tell warnings apart from
+// critical conditions.
+func WithLagThreshold(threshold uint64, f func(partition uint32, lag uint64, above bool)) LagMonitorOption {
+	return func(m *LagMonitor) {
+		m.thresholds = append(m.thresholds, lagThreshold{threshold: threshold, f: f, above: make(map[uint32]bool)})
+	}
+}
+
+// WithLagSample calls f with every lag sample, for example to update a gauge.
+func WithLagSample(f func(lag map[uint32]uint64)) LagMonitorOption {
+	return func(m *LagMonitor) {
+		m.onSample = f
+	}
+}
+
+// WithLagError calls f when sampling the lag fails. Sampling is attempted again at the next interval regardless.
+func WithLagError(f func(err error)) LagMonitorOption {
+	return func(m *LagMonitor) {
+		m.onError = f
+	}
+}
+
+// MonitorLag starts sampling the lag of a consumer group every interval, until ctx is done or the returned LagMonitor
+// is stopped.
+func (lc *StreamClient) MonitorLag(ctx context.Context, group string, interval time.Duration, opts ...LagMonitorOption) *LagMonitor {
+	ctx, cancel := context.WithCancel(ctx)
+	m := &LagMonitor{
+		client:   lc,
+		group:    group,
+		interval: interval,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	go m.run(ctx)
+	return m
+}
+
+// Stop stops sampling and waits for the monitor to terminate.
+func (m *LagMonitor) Stop() {
+	m.cancel()
+	<-m.done
+}
+
+// Done returns a channel that is closed once the monitor has terminated.
+func (m *LagMonitor) Done() <-chan struct{} {
+	return m.done
+}
+
+func (m *LagMonitor) run(ctx context.Context) {
+	defer close(m.done)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.sample(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sample fetches the lag of the group and notifies the callbacks.
+func (m *LagMonitor) sample(ctx context.Context) {
+	lag, err := m.client.Lag(ctx, m.group)
+	if err != nil {
+		if m.onError != nil && ctx.Err() == nil {
+			m.onError(err)
+		}
+		return
+	}
+	if m.onSample != nil {
+		m.onSample(lag)
+	}
+	for _, t := range m.thresholds {
+		for partition, l := range lag {
+			if above := l > t.threshold; above != t.above[partition] {
+				t.above[partition] = above
+				t.f(partition, l, above)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyObserver is notified of the end-to-end latency of the events handled by a subscription, that is the time
+// elapsed between the publication of an event and the moment it is handed over to the handler.
+type LatencyObserver interface {
+	ObserveLatency(latency time.Duration)
+}
+
+// WithLatencyObserver notifies o of the end-to-end latency of each event handed over to the handler, so that pipelines
+// can measure their freshness. Latency is measured from the time of the event, or from the time it was recorded by
+// the broker if the event has none, and is subject to clock skew between producers and consumers.
+func WithLatencyObserver(o LatencyObserver) SubscribeOption {
+	return func(options *subscribeOptions) {
+		options.latencyObserver = o
+	}
+}
+
+// observeLatency notifies the latency observer, if any, of the latency of msg.
+func (o *subscribeOptions) observeLatency(msg Message) {
+	if o.latencyObserver == nil {
+		return
+	}
+	published := msg.happened()
+	if published.IsZero() {
+		return
+	}
+	o.latencyObserver.ObserveLatency(time.Since(published))
+}
+
+// DefaultLatencyBuckets are the upper bounds of the buckets of a LatencyHistogram created without explicit buckets.
+var DefaultLatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyHistogram is a LatencyObserver counting latencies into buckets. It is safe for concurrent use, and may be
+// shared by several subscriptions.
+type LatencyHistogram struct {
+	mu     sync.Mutex
+	bounds []time.Duration
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+}
+
+// LatencySnapshot is a point in time snapshot of a LatencyHistogram.
+type LatencySnapshot struct {
+	// Buckets count the latencies observed, cumulatively: each bucket counts the latencies lower than or equal to its
+	// upper bound.
+	Buckets []LatencyBucket
+	// Count is the total number of latencies observed, including those exceeding the upper bound of every bucket.
+	Count uint64
+	// Sum is the sum of the latencies observed.
+	Sum time.Duration
+}
+
+// LatencyBucket is a bucket of a LatencySnapshot.
+type LatencyBucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+// NewLatencyHistogram creates a LatencyHistogram with buckets of the given upper bounds, or DefaultLatencyBuckets if
+// none is given.
+func NewLatencyHistogram(bounds ...time.Duration) *LatencyHistogram {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBuckets
+	}
+	sorted := append([]time.Duration(nil), bounds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &LatencyHistogram{bounds: sorted, counts: make([]uint64, len(sorted))}
+}
+
+func (h *LatencyHistogram) ObserveLatency(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i := sort.Search(len(h.bounds), func(i int) bool { return latency <= h.bounds[i] }); i < len(h.bounds) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += latency
+}
+
+// Snapshot returns the latencies observed so far.
+func (h *LatencyHistogram) Snapshot() LatencySnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snapshot := LatencySnapshot{Buckets: make([]LatencyBucket, len(h.bounds)), Count: h.count, Sum: h.sum}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		snapshot.Buckets[i] = LatencyBucket{UpperBound: bound, Count: cumulative}
+	}
+	return snapshot
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+// LifecycleHooks are notified of the lifecycle of a subscription, so that applications and metrics systems can
+// observe it without scraping logs. Any hook may be left nil. Hooks are called synchronously from the goroutines of
+// the subscription and must not block.
+type LifecycleHooks struct {
+	// OnSubscribed is called once the subscription has joined the consumer group of each of its topics.
+	OnSubscribed func(topics []string)
+	// OnAssignmentReceived is called whenever a partition of a topic is assigned to the subscription.
+	OnAssignmentReceived func(topic string, partition uint32)
+	// OnCommitted is called whenever an offset has been committed to the gateway on behalf of the subscription.
+	// Anonymous subscriptions and subscriptions using an OffsetStore never commit offsets.
+	OnCommitted func(topic string, partition uint32, offset uint64)
+	// OnReconnected is called whenever the receive stream of a partition is re-established, after a seek, once the
+	// receive deadline has expired or after the gateway was unavailable.
+	OnReconnected func(topic string, partition uint32)
+	// OnStopped is called once the subscription has terminated, with the error that caused termination, if any.
+	OnStopped func(err error)
+}
+
+// WithLifecycleHooks registers hooks notified of the lifecycle of the subscription. The option may be given several
+// times, all hooks being called in order.
+func WithLifecycleHooks(hooks LifecycleHooks) SubscribeOption {
+	return func(options *subscribeOptions) {
+		options.hooks = append(options.hooks, hooks)
+	}
+}
+
+// subscribed notifies the hooks that the subscription has joined the consumer group of topics.
+func (o *subscribeOptions) subscribed(topics []string) {
+	for _, h := range o.hooks {
+		if h.OnSubscribed != nil {
+			h.OnSubscribed(topics)
+		}
+	}
+}
+
+// assignmentReceived notifies the hooks that partition of topic has been assigned to the subscription.
+func (o *subscribeOptions) assignmentReceived(topic string, partition uint32) {
+	for _, h := range o.hooks {
+		if h.OnAssignmentReceived != nil {
+			h.OnAssignmentReceived(topic, partition)
+		}
+	}
+}
+
+// committed notifies the hooks that offset has been committed in the partition of topic.
+func (o *subscribeOptions) committed(topic string, partition uint32, offset uint64) {
+	for _, h := range o.hooks {
+		if h.OnCommitted != nil {
+			h.OnCommitted(topic, partition, offset)
+		}
+	}
+}
+
+// reconnected notifies the hooks that the receive stream of the partition of topic has been re-established.
+func (o *subscribeOptions) reconnected(topic string, partition uint32) {
+	for _, h := range o.hooks {
+		if h.OnReconnected != nil {
+			h.OnReconnected(topic, partition)
+		}
+	}
+}
+
+// stopped notifies the hooks that the subscription has terminated because of err, if not nil.
+func (o *subscribeOptions) stopped(err error) {
+	for _, h := range o.hooks {
+		if h.OnStopped != nil {
+			h.OnStopped(err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"github.com/go-logr/logr"
+)
+
+// Logger receives the leveled, structured logs of a StreamClient, about connecting to the gateway, the lifecycle of
+// subscriptions, retries, acks and errors. Key/value pairs follow the conventions of github.com/go-logr/logr, and
+// adapters are provided for logr (LogrLogger) and, with Go 1.21 or later, log/slog (SlogLogger).
+type Logger interface {
+	// Debug logs a message useful when diagnosing the behavior of the client, such as each commit of an offset.
+	Debug(msg string, keysAndValues ...interface{})
+	// Info logs a notable event in the lifecycle of the client or its subscriptions.
+	Info(msg string, keysAndValues ...interface{})
+	// Error logs an error, typically also reported to an EventErrHandler or returned to the caller.
+	Error(err error, msg string, keysAndValues ...interface{})
+}
+
+// WithLogger makes the client log to l. The client does not log by default.
+func WithLogger(l Logger) ClientOption {
+	return func(lc *StreamClient) {
+		lc.log = l
+	}
+}
+
+// nopLogger is a Logger discarding everything.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{})        {}
+func (nopLogger) Info(string, ...interface{})         {}
+func (nopLogger) Error(error, string, ...interface{}) {}
+
+// LogrLogger adapts a logr.Logger to a Logger, logging debug messages at verbosity 1.
+func LogrLogger(l logr.Logger) Logger {
+	return logrLogger{l}
+}
+
+type logrLogger struct {
+	l logr.Logger
+}
+
+func (l logrLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.l.V(1).Info(msg, keysAndValues...)
+}
+
+func (l logrLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.l.Info(msg, keysAndValues...)
+}
+
+func (l logrLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.l.Error(err, msg, keysAndValues...)
+}
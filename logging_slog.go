@@ -0,0 +1,45 @@
+//go:build go1.21
+
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLogger adapts a slog.Logger to a Logger.
+func SlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (l slogLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.l.Debug(msg, keysAndValues...)
+}
+
+func (l slogLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.l.Info(msg, keysAndValues...)
+}
+
+func (l slogLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.l.Log(context.Background(), slog.LevelError, msg, append([]interface{}{"error", err}, keysAndValues...)...)
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// MergeByTime is like MultiSubscribe, but hands the messages of all topics over to f one at a time, in the order of
+// the time their event happened at, or the time they were recorded by the broker if they have none, giving a merged
+// chronological view of several streams. Each partition being read in order, the next message of each partition is
+// held until it is known to be the earliest one: once every partition assigned to the subscription has a message
+// pending, once a pending message happened lateness after it, or once it has been pending for lateness. Messages of
+// idle partitions, or of partitions lagging by more than lateness, may hence be handed over out of order, and messages
+// are delayed by up to lateness while some partition is idle. Offsets are committed as messages are handled. Handler
+// timeouts, if configured, include the time messages are pending.
+func (lc *StreamClient) MergeByTime(ctx context.Context, topics []string, group string, fromBeginning bool, lateness time.Duration, f MessageHandler, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	if len(topics) == 0 {
+		return nil, errors.New("at least one topic is required")
+	}
+	if lateness <= 0 {
+		return nil, errors.New("lateness must be positive")
+	}
+	m := &merger{
+		f:        f,
+		lateness: lateness,
+		pending:  make(map[topicPartition]*pendingMessage),
+		wake:     make(chan struct{}, 1),
+	}
+	options := newSubscribeOptions(opts)
+	sub, err := lc.subscribe(ctx, topics, group, fromBeginning, options.oneByOne(m.handle), e, options)
+	if err != nil {
+		return nil, err
+	}
+	go m.dispatch(sub)
+	return sub, nil
+}
+
+// merger releases the pending messages of the partitions of a subscription in chronological order.
+type merger struct {
+	f        MessageHandler
+	lateness time.Duration
+
+	mu sync.Mutex
+	// pending holds the message of each partition waiting to be released.
+	pending map[topicPartition]*pendingMessage
+	// wake notifies the dispatcher of a new pending message.
+	wake chan struct{}
+}
+
+// pendingMessage is a message waiting to be released to the handler.
+type pendingMessage struct {
+	msg      Message
+	since    time.Time
+	released chan struct{}
+	handled  chan struct{}
+}
+
+// handle waits until msg is released, then hands it over to the handler.
+func (m *merger) handle(ctx context.Context, msg Message) error {
+	tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	p := &pendingMessage{
+		msg:      msg,
+		since:    time.Now(),
+		released: make(chan struct{}),
+		handled:  make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.pending[tp] = p
+	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-p.released:
+	case <-ctx.Done():
+		m.mu.Lock()
+		withdrawn := m.pending[tp] == p
+		if withdrawn {
+			delete(m.pending, tp)
+		}
+		m.mu.Unlock()
+		if withdrawn {
+			return ctx.Err()
+		}
+		<-p.released
+	}
+	defer close(p.handled)
+	return m.f(ctx, msg)
+}
+
+// dispatch releases pending messages, one at a time, until sub terminates.
+func (m *merger) dispatch(sub *Subscription) {
+	timer := time.NewTimer(m.lateness)
+	defer timer.Stop()
+	for {
+		p, wait := m.next(sub.Assignments(), sub.isDraining())
+		if p != nil {
+			close(p.released)
+			select {
+			case <-p.handled:
+			case <-sub.Done():
+				return
+			}
+			continue
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-m.wake:
+		case <-timer.C:
+		case <-sub.Done():
+			return
+		}
+	}
+}
+
+// next returns the pending message to release, if any, or how long to wait before one may be released otherwise.
+// Pending messages are released right away while draining, as no more messages are read.
+func (m *merger) next(assignments []TopicPartition, draining bool) (*pendingMessage, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var earliest *pendingMessage
+	var earliestPartition topicPartition
+	var latest time.Time
+	for tp, p := range m.pending {
+		happened := p.msg.happened()
+		if earliest == nil || happened.Before(earliest.msg.happened()) {
+			earliest, earliestPartition = p, tp
+		}
+		if happened.After(latest) {
+			latest = happened
+		}
+	}
+	if earliest == nil {
+		return nil, m.lateness
+	}
+	complete := len(assignments) > 0
+	for _, a := range assignments {
+		if _, ok := m.pending[topicPartition{topic: a.Topic, partition: a.Partition}]; !ok {
+			complete = false
+			break
+		}
+	}
+	waited := time.Since(earliest.since)
+	if complete || draining || !earliest.msg.happened().Add(m.lateness).After(latest) || waited >= m.lateness {
+		delete(m.pending, earliestPartition)
+		return earliest, 0
+	}
+	return nil, m.lateness - waited
+}
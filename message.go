@@ -0,0 +1,194 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// Message is a single event read from a stream, along with its position in the stream.
+type Message struct {
+	// ID identifies the event.
+	ID string
+	// Source identifies the context in which the event happened.
+	Source string
+	// Type describes the kind of event.
+	Type string
+	// Payload is the content of the event.
+	Payload []byte
+	// ContentType describes how to interpret the payload.
+	ContentType string
+	// Headers are the custom headers the event was published with. They travel as extension attributes of the event,
+	// which the gateway stores as metadata of the underlying record rather than in its payload.
+	Headers map[string]string
+	// Key is the key the event was published with, if any.
+	Key []byte
+	// Topic is the name of the topic the event was read from.
+	Topic string
+	// Partition is the partition of the stream the event was read from.
+	Partition uint32
+	// Offset is the position of the event in its partition.
+	Offset uint64
+	// Time is the time at which the event happened, as recorded by its producer, or the zero time if unknown.
+	Time time.Time
+	// Timestamp is the time at which the event was recorded by the broker.
+	Timestamp time.Time
+	// Attempt is the number of times the event has been handed over to a handler of the consumer group by this
+	// client since its offset was last committed, including this one. It is 1 for the first delivery and grows as the
+	// event is redelivered, for example after a handler failed and the group resubscribed, so that handlers can
+	// implement their own escalation logic. Attempts are not tracked across processes.
+	Attempt int
+
+	// undecodable is set for records which don't hold an event.
+	undecodable bool
+}
+
+// MessageHandler is a function to process the messages read from the stream, with access to their position in the
+// stream.
+type MessageHandler = func(ctx context.Context, msg Message) error
+
+func newMessage(topic string, partition uint32, record *liiklus.ReceiveReply_LiiklusEventRecord) Message {
+	msg := Message{
+		ID:          record.GetEvent().GetId(),
+		Source:      record.GetEvent().GetSource(),
+		Type:        record.GetEvent().GetType(),
+		Payload:     record.GetEvent().GetData(),
+		ContentType: record.GetEvent().GetDataContentType(),
+		Headers:     record.GetEvent().GetExtensions(),
+		Key:         record.GetKey(),
+		Topic:       topic,
+		Partition:   partition,
+		Offset:      record.GetOffset(),
+	}
+	if timestamp, err := ptypes.Timestamp(record.GetTimestamp()); err == nil {
+		msg.Timestamp = timestamp
+	}
+	if t, err := time.Parse(time.RFC3339Nano, record.GetEvent().GetTime()); err == nil {
+		msg.Time = t
+	}
+	return msg
+}
+
+func newRawMessage(topic string, partition uint32, record *liiklus.ReceiveReply_Record) Message {
+	msg := Message{
+		Payload:   record.GetValue(),
+		Key:       record.GetKey(),
+		Topic:     topic,
+		Partition: partition,
+		Offset:    record.GetOffset(),
+	}
+	if timestamp, err := ptypes.Timestamp(record.GetTimestamp()); err == nil {
+		msg.Timestamp = timestamp
+	}
+	return msg
+}
+
+// event returns the event carried by msg, for publishing it.
+func (msg Message) event() *liiklus.LiiklusEvent {
+	event := &liiklus.LiiklusEvent{
+		Id:              msg.ID,
+		Source:          msg.Source,
+		Type:            msg.Type,
+		DataContentType: msg.ContentType,
+		Data:            msg.Payload,
+		Extensions:      msg.Headers,
+	}
+	if !msg.Time.IsZero() {
+		event.Time = msg.Time.Format(time.RFC3339Nano)
+	}
+	return event
+}
+
+// happened returns the time at which the event happened, or the time it was recorded by the broker if it has none.
+func (msg Message) happened() time.Time {
+	if msg.Time.IsZero() {
+		return msg.Timestamp
+	}
+	return msg.Time
+}
+
+// eventHandler adapts an EventHandler to a MessageHandler.
+func eventHandler(f EventHandler) MessageHandler {
+	return func(ctx context.Context, msg Message) error {
+		ctx = context.WithValue(ctx, eventIDKey, msg.ID)
+		ctx = context.WithValue(ctx, partitionKey, msg.Partition)
+		ctx = context.WithValue(ctx, offsetKey, msg.Offset)
+		ctx = context.WithValue(ctx, attemptKey, msg.Attempt)
+		ctx = context.WithValue(ctx, timeKey, msg.Time)
+		ctx = context.WithValue(ctx, timestampKey, msg.Timestamp)
+		return f(ctx, bytes.NewReader(msg.Payload), msg.ContentType, msg.Headers)
+	}
+}
+
+// contextKey is the type of the keys of values injected by this package into the context passed to handlers.
+type contextKey int
+
+const (
+	eventIDKey contextKey = iota
+	partitionKey
+	offsetKey
+	attemptKey
+	timeKey
+	timestampKey
+)
+
+// EventIDFromContext returns the ID of the event being handled, from the context passed to an EventHandler.
+func EventIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(eventIDKey).(string)
+	return id, ok
+}
+
+// PartitionFromContext returns the partition the event being handled was read from, from the context passed to an
+// EventHandler.
+func PartitionFromContext(ctx context.Context) (uint32, bool) {
+	partition, ok := ctx.Value(partitionKey).(uint32)
+	return partition, ok
+}
+
+// OffsetFromContext returns the offset of the event being handled in its partition, from the context passed to an
+// EventHandler.
+func OffsetFromContext(ctx context.Context) (uint64, bool) {
+	offset, ok := ctx.Value(offsetKey).(uint64)
+	return offset, ok
+}
+
+// AttemptFromContext returns the delivery attempt of the event being handled, as described by Message.Attempt, from
+// the context passed to an EventHandler.
+func AttemptFromContext(ctx context.Context) (int, bool) {
+	attempt, ok := ctx.Value(attemptKey).(int)
+	return attempt, ok
+}
+
+// TimeFromContext returns the time at which the event being handled happened, as described by Message.Time, from the
+// context passed to an EventHandler.
+func TimeFromContext(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(timeKey).(time.Time)
+	return t, ok
+}
+
+// TimestampFromContext returns the time at which the event being handled was recorded by the broker, from the context
+// passed to an EventHandler.
+func TimestampFromContext(ctx context.Context) (time.Time, bool) {
+	timestamp, ok := ctx.Value(timestampKey).(time.Time)
+	return timestamp, ok
+}
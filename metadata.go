@@ -0,0 +1,43 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import "context"
+
+// TopicMetadata describes the shape of a stream.
+type TopicMetadata struct {
+	// Topic is the name of the liiklus topic backing the stream.
+	Topic string
+	// Partitions is the number of partitions of the stream, as reported by the gateway. Gateways that don't report
+	// empty partitions may cause trailing empty partitions not to be counted.
+	Partitions uint32
+	// EndOffsets are the offsets of the last message of each non empty partition.
+	EndOffsets map[uint32]uint64
+}
+
+// Metadata describes the stream, for example to size a pool of workers after its number of partitions.
+func (lc *StreamClient) Metadata(ctx context.Context) (TopicMetadata, error) {
+	offsets, partitions, err := lc.partitionEnds(ctx, lc.TopicName)
+	if err != nil {
+		return TopicMetadata{}, err
+	}
+	return TopicMetadata{
+		Topic:      lc.TopicName,
+		Partitions: partitions,
+		EndOffsets: offsets,
+	}, nil
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckpointStore holds the positions of Mirrors in the partitions of their source stream.
+type CheckpointStore interface {
+	OffsetStore
+	// Save records offset as the offset of the last message of the partition of topic mirrored by group.
+	Save(ctx context.Context, topic string, group string, partition uint32, offset uint64) error
+}
+
+// NewTopicCheckpointStore returns a CheckpointStore recording checkpoints as JSON events to the stream of lc, which
+// must accept application/json content and is best compacted. Checkpoints are loaded once, by reading the whole
+// stream, the first time they are needed. Storing checkpoints along with the mirrored streams, in the target cluster,
+// allows mirroring to resume from wherever it is restarted.
+func NewTopicCheckpointStore(lc *StreamClient) CheckpointStore {
+	return &topicCheckpointStore{client: lc}
+}
+
+type topicCheckpointStore struct {
+	client *StreamClient
+
+	mu          sync.Mutex
+	checkpoints map[string]uint64
+}
+
+// checkpoint is the payload of the events recorded by a topic checkpoint store.
+type checkpoint struct {
+	Offset uint64 `json:"offset"`
+}
+
+func (s *topicCheckpointStore) Load(ctx context.Context, topic string, group string, partition uint32) (uint64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkpoints == nil {
+		checkpoints := make(map[string]uint64)
+		if err := s.client.ReadCompacted(ctx, func(ctx context.Context, msg Message) error {
+			var c checkpoint
+			if err := json.Unmarshal(msg.Payload, &c); err != nil {
+				return fmt.Errorf("invalid checkpoint %s: %w", msg.ID, err)
+			}
+			checkpoints[string(msg.Key)] = c.Offset
+			return nil
+		}); err != nil {
+			return 0, false, err
+		}
+		s.checkpoints = checkpoints
+	}
+	offset, ok := s.checkpoints[checkpointKey(topic, group, partition)]
+	return offset, ok, nil
+}
+
+func (s *topicCheckpointStore) Save(ctx context.Context, topic string, group string, partition uint32, offset uint64) error {
+	key := checkpointKey(topic, group, partition)
+	if _, err := s.client.PublishValue(ctx, checkpoint{Offset: offset}, strings.NewReader(key), "application/json", nil); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkpoints != nil {
+		s.checkpoints[key] = offset
+	}
+	return nil
+}
+
+// checkpointKey is the key of the checkpoints of a partition.
+func checkpointKey(topic string, group string, partition uint32) string {
+	return fmt.Sprintf("%s/%s/%d", group, topic, partition)
+}
+
+// MirrorOption configures optional behavior of a Mirror.
+type MirrorOption func(*Mirror)
+
+// WithCheckpointInterval saves checkpoints every interval, rather than after every mirrored message. Up to an interval
+// worth of messages may then be mirrored again after a crash. Checkpoints are also saved when mirroring stops.
+func WithCheckpointInterval(interval time.Duration) MirrorOption {
+	return func(m *Mirror) {
+		m.interval = interval
+	}
+}
+
+// Mirror replicates a stream to another one, typically served by a gateway of another cluster, for disaster recovery.
+// Unlike a Bridge, which tracks its position with a consumer group of the source gateway, a Mirror records its
+// position to its own CheckpointStore, for example in the target cluster, so that replication can resume from either
+// side. Events keep their ID, source, type, time, headers and key.
+type Mirror struct {
+	from        *StreamClient
+	to          *StreamClient
+	name        string
+	checkpoints CheckpointStore
+	interval    time.Duration
+
+	mu sync.Mutex
+	// mirrored holds the offset of the last message mirrored in each partition, until it is checkpointed.
+	mirrored map[uint32]uint64
+	// saving serializes saves, so that checkpoints which failed to be saved are retried by the next save.
+	saving sync.Mutex
+}
+
+// NewMirror creates a Mirror replicating the stream of from to the stream of to, recording its position under name to
+// checkpoints.
+func NewMirror(from *StreamClient, to *StreamClient, name string, checkpoints CheckpointStore, opts ...MirrorOption) *Mirror {
+	m := &Mirror{
+		from:        from,
+		to:          to,
+		name:        name,
+		checkpoints: checkpoints,
+		mirrored:    make(map[uint32]uint64),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Start starts mirroring, from the checkpoints of the mirror or from the beginning of the source stream, which goes on
+// until the returned Subscription is stopped. Events are mirrored at least once, in order within each partition.
+// Optional behavior of the underlying anonymous subscription may be configured by passing SubscribeOptions, as for
+// Subscribe. Failures to save checkpoints are reported to e.
+func (m *Mirror) Start(ctx context.Context, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	opts = append(opts, WithOffsetStore(mirrorPositions{m}), func(o *subscribeOptions) {
+		o.finalizers = append(o.finalizers, m.flush)
+	})
+	sub, err := m.from.SubscribeMessages(ctx, "", true, m.mirror, e, opts...)
+	if err != nil {
+		return nil, err
+	}
+	go m.checkpoint(sub, e)
+	return sub, nil
+}
+
+// mirror publishes msg to the target stream.
+func (m *Mirror) mirror(ctx context.Context, msg Message) error {
+	if _, err := m.to.publish(ctx, msg.event(), msg.Key); err != nil {
+		return err
+	}
+	if m.interval <= 0 {
+		return m.checkpoints.Save(ctx, m.from.TopicName, m.name, msg.Partition, msg.Offset)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mirrored[msg.Partition] = msg.Offset
+	return nil
+}
+
+// checkpoint saves checkpoints every interval, until sub terminates.
+func (m *Mirror) checkpoint(sub *Subscription, e EventErrHandler) {
+	if m.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.save(sub.ctx); err != nil && sub.ctx.Err() == nil {
+				e(sub.Cancel, err)
+			}
+		case <-sub.Done():
+			return
+		}
+	}
+}
+
+// flush saves the last checkpoints once the subscription has terminated.
+func (m *Mirror) flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	if err := m.save(ctx); err != nil {
+		m.from.log.Error(err, "unable to save the checkpoints of mirror", "name", m.name)
+	}
+}
+
+// save saves the offsets mirrored since the last checkpoint.
+func (m *Mirror) save(ctx context.Context) error {
+	m.saving.Lock()
+	defer m.saving.Unlock()
+	m.mu.Lock()
+	mirrored := m.mirrored
+	m.mirrored = make(map[uint32]uint64)
+	m.mu.Unlock()
+	for partition, offset := range mirrored {
+		if err := m.checkpoints.Save(ctx, m.from.TopicName, m.name, partition, offset); err != nil {
+			m.mu.Lock()
+			for p, o := range mirrored {
+				if _, ok := m.mirrored[p]; !ok {
+					m.mirrored[p] = o
+				}
+			}
+			m.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// mirrorPositions resumes the subscription of a Mirror from its checkpoints.
+type mirrorPositions struct {
+	mirror *Mirror
+}
+
+func (p mirrorPositions) Load(ctx context.Context, topic string, group string, partition uint32) (uint64, bool, error) {
+	return p.mirror.checkpoints.Load(ctx, topic, p.mirror.name, partition)
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"math"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// EndOffsets returns the offset of the last message of each non empty partition of the stream.
+func (lc *StreamClient) EndOffsets(ctx context.Context) (map[uint32]uint64, error) {
+	return lc.endOffsets(ctx, lc.TopicName)
+}
+
+// endOffsets returns the offset of the last message of each non empty partition of topic.
+func (lc *StreamClient) endOffsets(ctx context.Context, topic string) (map[uint32]uint64, error) {
+	offsets, _, err := lc.partitionEnds(ctx, topic)
+	return offsets, err
+}
+
+// partitionEnds returns the offset of the last message of each non empty partition of topic, along with the number of
+// partitions reported by the gateway, empty or not.
+func (lc *StreamClient) partitionEnds(ctx context.Context, topic string) (map[uint32]uint64, uint32, error) {
+	reply, err := lc.client.GetEndOffsets(ctx, &liiklus.GetEndOffsetsRequest{Topic: topic})
+	if err != nil {
+		return nil, 0, gatewayError("get end offsets", err)
+	}
+	offsets := make(map[uint32]uint64, len(reply.Offsets))
+	var partitions uint32
+	for partition, offset := range reply.Offsets {
+		if partition >= partitions {
+			partitions = partition + 1
+		}
+		// empty partitions may be reported with an offset of -1
+		if offset != math.MaxUint64 {
+			offsets[partition] = offset
+		}
+	}
+	return offsets, partitions, nil
+}
+
+// CommittedOffsets returns the offset last committed by a consumer group in each partition of the stream. Partitions
+// the group never committed an offset of are absent.
+func (lc *StreamClient) CommittedOffsets(ctx context.Context, group string) (map[uint32]uint64, error) {
+	return lc.committedOffsets(ctx, lc.TopicName, group, 0)
+}
+
+// committedOffsets returns the offsets last committed by a consumer group in each partition of topic.
+func (lc *StreamClient) committedOffsets(ctx context.Context, topic string, group string, groupVersion uint32) (map[uint32]uint64, error) {
+	reply, err := lc.client.GetOffsets(ctx, &liiklus.GetOffsetsRequest{
+		Topic:        topic,
+		Group:        group,
+		GroupVersion: groupVersion,
+	})
+	if err != nil {
+		return nil, gatewayError("get offsets", err)
+	}
+	return reply.Offsets, nil
+}
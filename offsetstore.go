@@ -0,0 +1,39 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+)
+
+// OffsetStore holds the positions of consumer groups outside of liiklus. This allows for exactly-once sinks, whose
+// handlers record the offset of each message they handle in the same transaction as its side effects (for example in
+// the same SQL transaction as the corresponding insert), so that positions and side effects can't get out of sync.
+type OffsetStore interface {
+	// Load returns the offset of the last message handled by group in the partition of topic, and whether there is
+	// one.
+	Load(ctx context.Context, topic string, group string, partition uint32) (offset uint64, ok bool, err error)
+}
+
+// WithOffsetStore resumes each partition assigned to the subscription from the position held by store, rather than
+// from the offsets committed to liiklus, which are then left alone. Handlers are responsible for recording the offsets
+// of the messages they handle to store. Partitions for which store has no position start according to fromBeginning.
+func WithOffsetStore(store OffsetStore) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.offsetStore = store
+	}
+}
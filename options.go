@@ -0,0 +1,269 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// SubscribeOption configures optional behavior of a subscription created by StreamClient.Subscribe.
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	// handlerTimeout bounds the time spent handling a single event, if positive.
+	handlerTimeout time.Duration
+	// recoverPanics converts panics raised by the handler into errors.
+	recoverPanics bool
+	// maxInFlight bounds the number of messages read but not yet committed, if positive.
+	maxInFlight int
+	// startTime is the time before which messages are skipped, if not zero.
+	startTime time.Time
+	// maxAge is the age beyond which messages are skipped and committed, if positive.
+	maxAge time.Duration
+	// lastKnownOffsets are the offsets after which to start reading each partition, overriding committed offsets.
+	lastKnownOffsets map[uint32]uint64
+	// raw reads plain records rather than events.
+	raw bool
+	// groupVersion is the version of the consumer group.
+	groupVersion uint32
+	// partition is the only partition to consume, if set.
+	partition *uint32
+	// filters select the messages handed over to the handler.
+	filters []func(Message) bool
+	// ackFiltered commits the offsets of messages rejected by filters.
+	ackFiltered bool
+	// middlewares wrap the handler, outermost first.
+	middlewares []Middleware
+	// idleTimeout is the time without receiving messages after which onIdle is called, if positive.
+	idleTimeout time.Duration
+	onIdle      func(lastReceived time.Time)
+	// receiveDeadline is the time to wait for a message before re-establishing a receive stream, if positive.
+	receiveDeadline time.Duration
+	// ackAttempts is the number of times a commit is attempted before failure is reported, retries being delayed by
+	// ackBackoff.
+	ackAttempts int
+	ackBackoff  Backoff
+	// reconnectAttempts is the number of times in a row a receive stream is reopened when the gateway is unavailable,
+	// retries being delayed by reconnectBackoff, if set.
+	reconnectAttempts int
+	reconnectBackoff  Backoff
+	// atMostOnce commits offsets before handing messages over to the handler.
+	atMostOnce bool
+	// offsetStore holds the positions to resume partitions from, instead of liiklus, if set.
+	offsetStore OffsetStore
+	// dedupWindow is the number of event IDs remembered by dedup, if positive.
+	dedupWindow int
+	dedup       *dedupWindow
+	// validate rejects messages with an invalid payload.
+	validate bool
+	// fallbackContentType is the content type of synthetic events wrapping records which don't hold an event, if set.
+	fallbackContentType string
+	// tombstones hands tombstones over to the handler rather than treating them as records which don't hold an event.
+	tombstones bool
+	// onDecodeError is called for records which don't hold an event, if set.
+	onDecodeError func(ctx context.Context, msg Message, err *DecodeError)
+	// latencyObserver is notified of the end-to-end latency of handled events, if set.
+	latencyObserver LatencyObserver
+	// hooks are notified of the lifecycle of the subscription.
+	hooks []LifecycleHooks
+	// limiter bounds the rate at which messages are handled, if set.
+	limiter *rateLimiter
+	// scheduler grants handler invocations to the subscription in proportion to weight, if set, by priority.
+	scheduler *FairScheduler
+	weight    int
+	// priority is the scheduling priority of the topics which are not in priorities.
+	priority   int
+	priorities map[string]int
+	// finalizers are called once the goroutines of the subscription have returned, before it is reported as done.
+	finalizers []func()
+	// auditor records the deliveries of messages, counted by deliveries, if set.
+	auditor    DeliveryAuditor
+	deliveries *deliveryCounts
+}
+
+func newSubscribeOptions(opts []SubscribeOption) *subscribeOptions {
+	o := &subscribeOptions{
+		recoverPanics: true,
+		ackAttempts:   5,
+		ackBackoff:    ConstantBackoff(time.Second),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.dedupWindow > 0 {
+		o.dedup = newDedupWindow(o.dedupWindow)
+	}
+	if o.auditor != nil {
+		o.deliveries = newDeliveryCounts(auditWindow)
+	}
+	return o
+}
+
+// WithHandlerTimeout bounds the time the EventHandler may spend on a single event. The context passed to the handler
+// carries the corresponding deadline, and a handler that has not returned once it expires is considered to have
+// failed with context.DeadlineExceeded, so that a stuck handler can't freeze the subscription.
+func WithHandlerTimeout(d time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.handlerTimeout = d
+	}
+}
+
+// WithPanicRecovery controls whether panics raised by the EventHandler are recovered and reported as a PanicError to
+// the EventErrHandler, which is the default. Disabling recovery lets the panic crash the process, which may be
+// preferable while debugging.
+func WithPanicRecovery(enabled bool) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.recoverPanics = enabled
+	}
+}
+
+// WithMaxInFlight bounds the number of messages that have been read from the stream but not yet handled and committed.
+// Once the limit is reached, reading from the stream is paused until a message is committed, so that slow handlers
+// don't cause messages to pile up in memory. Note that batches handed over to a BatchHandler are limited to that many
+// messages.
+func WithMaxInFlight(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.maxInFlight = n
+	}
+}
+
+// WithStartTime starts the subscription from the first messages recorded at or after t, skipping older messages in
+// every partition. As for OffsetsForTime, t is resolved to an offset per partition when subscribing, which requires
+// scanning partitions from the beginning. The offsets preceding those are committed on behalf of the group, unless it
+// is past them already, so that older messages are not read again after a restart.
+func WithStartTime(t time.Time) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.startTime = t
+	}
+}
+
+// WithMaxAge skips the messages of events which happened more than d ago, committing their offsets without handing them
+// over to the handler, so that consumers recovering from a long outage can discard stale work such as expired
+// notifications. The age of an event is based on its time attribute, or on the time it was recorded by the broker if
+// the event has none, and is subject to clock skew between producers and consumers.
+func WithMaxAge(d time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.maxAge = d
+	}
+}
+
+// expired reports whether msg is older than the maximum age of messages.
+func (o *subscribeOptions) expired(msg Message) bool {
+	if o.maxAge <= 0 {
+		return false
+	}
+	happened := msg.happened()
+	return !happened.IsZero() && time.Since(happened) > o.maxAge
+}
+
+// skip reports whether msg should not be handed over to the handler at all, such as messages recorded before a start
+// time which is in the future.
+func (o *subscribeOptions) skip(msg Message) bool {
+	return !o.startTime.IsZero() && msg.Timestamp.Before(o.startTime)
+}
+
+// WithGroupVersion sets the version of the consumer group, which liiklus uses to tell generations of a consumer
+// deployment apart. Members of the group sharing the same version keep their committed offsets and partition
+// assignments across restarts, so that rolling restarts don't trigger full rebalances and duplicate processing.
+// Bumping the version starts a new generation of the group.
+func WithGroupVersion(version uint32) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.groupVersion = version
+	}
+}
+
+// WithPartition pins the subscription to a single partition, bypassing group assignment. The subscription then
+// commits its offsets under a consumer group derived from the one passed to Subscribe and the partition, which it is
+// the only member of. This allows for deterministic per-partition inspection or partition-parallel jobs managed
+// externally.
+func WithPartition(partition uint32) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.partition = &partition
+	}
+}
+
+// WithFilter only hands messages for which keep returns true over to the handler, so that consumers interested in a
+// subset of the events don't pay the handler overhead for the others. If ackFiltered is set, the offsets of rejected
+// messages are committed as if they had been handled, otherwise they are only committed along with subsequent messages.
+// Several filters may be combined, in which case messages must be kept by all of them.
+func WithFilter(keep func(Message) bool, ackFiltered bool) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.filters = append(o.filters, keep)
+		o.ackFiltered = o.ackFiltered || ackFiltered
+	}
+}
+
+// accept reports whether msg passes all filters.
+func (o *subscribeOptions) accept(msg Message) bool {
+	for _, keep := range o.filters {
+		if !keep(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware intercepts the invocations of a MessageHandler, to implement cross-cutting concerns such as logging,
+// metrics, tracing, decryption or schema validation. A Middleware returns a handler which typically does some work
+// before and after calling next.
+type Middleware = func(next MessageHandler) MessageHandler
+
+// WithMiddleware wraps the handler of the subscription with the given middlewares, the first one being the outermost.
+// Middlewares apply to handlers invoked for each message, not to BatchHandlers.
+func WithMiddleware(middlewares ...Middleware) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.middlewares = append(o.middlewares, middlewares...)
+	}
+}
+
+// wrap applies the configured middlewares to h.
+func (o *subscribeOptions) wrap(h MessageHandler) MessageHandler {
+	for i := len(o.middlewares) - 1; i >= 0; i-- {
+		h = o.middlewares[i](h)
+	}
+	return h
+}
+
+// WithOnIdle calls f whenever no message has been received for d, passing the time the last message was received (or
+// the subscription was created, if none was). It is called once per idle period, so that operators can tell a quiet
+// stream apart from a subscription which is silently broken.
+func WithOnIdle(d time.Duration, f func(lastReceived time.Time)) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.idleTimeout = d
+		o.onIdle = f
+	}
+}
+
+// WithReceiveDeadline bounds the time spent waiting for the next message of a partition. Once it expires, the receive
+// stream is torn down and re-established from the last message received, so that a stream hanging on a half-open
+// connection is eventually recovered. On quiet streams, d should be large enough for reconnections to be cheap.
+func WithReceiveDeadline(d time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.receiveDeadline = d
+	}
+}
+
+// WithAtMostOnce commits the offset of each message before handing it over to the handler, rather than after it has
+// been handled. A message whose handling fails or is interrupted is then never redelivered, which suits workloads where
+// duplicate processing is worse than occasional loss, such as notification fan-out. The default is at-least-once
+// delivery.
+func WithAtMostOnce() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.atMostOnce = true
+	}
+}
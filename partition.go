@@ -0,0 +1,452 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// topicPartition designates a partition of a given topic.
+type topicPartition struct {
+	topic     string
+	partition uint32
+}
+
+// partitionReader tracks the consumption of a partition assigned to a subscription.
+type partitionReader struct {
+	assignment *liiklus.Assignment
+
+	mu sync.Mutex
+	// seekTo is the offset to restart reading from, if a seek has been requested.
+	seekTo *uint64
+	// cancelStream interrupts the current receive stream.
+	cancelStream context.CancelFunc
+	// stale is set when the current receive stream was interrupted for exceeding the receive deadline.
+	stale bool
+
+	// received is set once a message has been received since the position was last set. next is then the offset of
+	// the message following it. These are only accessed by the goroutine consuming the partition.
+	received bool
+	next     uint64
+}
+
+// seek requests reading to restart from offset.
+func (p *partitionReader) seek(offset uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seekTo = &offset
+	if p.cancelStream != nil {
+		p.cancelStream()
+	}
+}
+
+// expire interrupts the current receive stream for exceeding the receive deadline.
+func (p *partitionReader) expire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stale = true
+	if p.cancelStream != nil {
+		p.cancelStream()
+	}
+}
+
+// takeStale reports whether the current receive stream expired, and clears that state.
+func (p *partitionReader) takeStale() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stale := p.stale
+	p.stale = false
+	return stale
+}
+
+// interrupted reports whether the current receive stream was interrupted by a seek or for exceeding the receive
+// deadline.
+func (p *partitionReader) interrupted() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.seekTo != nil || p.stale
+}
+
+// takeSeek returns the pending seek request, if any, and clears it.
+func (p *partitionReader) takeSeek() *uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	offset := p.seekTo
+	p.seekTo = nil
+	return offset
+}
+
+// receive opens a receive stream for the partition, which is interrupted when a seek is requested.
+func (p *partitionReader) receive(s *Subscription, lastKnownOffset uint64) (liiklus.LiiklusService_ReceiveClient, context.CancelFunc, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	streamCtx, cancel := context.WithCancel(s.fetchCtx)
+	receiveRequest := liiklus.ReceiveRequest{
+		Assignment:      p.assignment,
+		LastKnownOffset: lastKnownOffset,
+		Format:          liiklus.ReceiveRequest_LIIKLUS_EVENT,
+	}
+	if s.options.raw {
+		receiveRequest.Format = liiklus.ReceiveRequest_BINARY
+	}
+	receiveClient, err := s.client.client.Receive(streamCtx, &receiveRequest)
+	if err != nil {
+		cancel()
+		return nil, nil, gatewayError("receive", err)
+	}
+	if p.seekTo != nil {
+		// a seek raced with opening the stream
+		cancel()
+	}
+	p.stale = false
+	p.cancelStream = cancel
+	return receiveClient, cancel, nil
+}
+
+// consumePartition reads the partition of topic designated by assignment until the subscription terminates, handing
+// messages over to the consumer of the subscription.
+func (s *Subscription) consumePartition(topic string, assignment *liiklus.Assignment) {
+	p := &partitionReader{assignment: assignment}
+	partition := assignment.GetPartition()
+	tp := topicPartition{topic: topic, partition: partition}
+	s.mu.Lock()
+	s.partitions[tp] = p
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.partitions[tp] == p {
+			delete(s.partitions, tp)
+		}
+		s.mu.Unlock()
+	}()
+
+	s.client.log.Debug("partition assigned", "topic", topic, "group", s.group, "partition", partition)
+	lastKnownOffset := s.options.lastKnownOffsets[partition]
+	var skipBelow uint64
+	if s.options.offsetStore != nil {
+		offset, ok, err := s.options.offsetStore.Load(s.fetchCtx, topic, s.group, partition)
+		if err != nil {
+			if !s.isDraining() {
+				s.fail(s.errs, s.errorAt(PhaseReceive, topic, partition, 0, err))
+			}
+			return
+		}
+		if ok {
+			lastKnownOffset, skipBelow = offset, offset+1
+		}
+	}
+	if start := s.startOffsets[tp]; start > skipBelow {
+		if err := s.skipTo(topic, partition, start); err != nil {
+			if !s.isDraining() {
+				s.fail(s.errs, err)
+			}
+			return
+		}
+		if start-1 > lastKnownOffset {
+			lastKnownOffset = start - 1
+		}
+		skipBelow = start
+	}
+	// failures counts the receive streams which failed in a row, for WithReconnectBackoff
+	var failures int
+	for {
+		receiveClient, cancelStream, err := p.receive(s, lastKnownOffset)
+		if err != nil {
+			failures++
+			if s.reconnect(topic, partition, failures, err) {
+				continue
+			}
+			if !s.isDraining() {
+				s.fail(s.errs, s.errorAt(PhaseReceive, topic, partition, 0, err))
+			}
+			return
+		}
+		received, next := p.received, p.next
+		err = s.consumeStream(receiveClient, p, topic, partition, skipBelow)
+		cancelStream()
+		if p.received && (!received || p.next != next) {
+			failures = 0
+		}
+		if errors.Is(err, errStreamInterrupted) {
+			if offset := p.takeSeek(); offset != nil && s.fetchCtx.Err() == nil {
+				// move the committed position as well, for gateways resuming from it rather than the last known offset
+				if *offset > 0 {
+					if err := s.commit(s.ctx, topic, partition, *offset-1, 0); err != nil {
+						s.fail(s.errs, err)
+						return
+					}
+				}
+				lastKnownOffset, skipBelow = 0, *offset
+				if *offset > 0 {
+					lastKnownOffset = *offset - 1
+				} else if skipBelow, err = s.rewind(p, topic, partition); err != nil {
+					if !errors.Is(err, errStreamInterrupted) && !s.isDraining() {
+						s.fail(s.errs, err)
+					}
+					return
+				}
+				p.received = false
+				s.client.log.Debug("reopening receive stream after seek", "topic", topic, "group", s.group, "partition", partition, "offset", *offset)
+				s.client.stats().OnReconnect(ReconnectStats{Topic: topic, Group: s.statsGroup(), Partition: partition})
+				s.options.reconnected(topic, partition)
+				continue
+			}
+			if p.takeStale() && s.fetchCtx.Err() == nil {
+				if p.received {
+					lastKnownOffset, skipBelow = p.next-1, p.next
+				}
+				s.client.log.Info("receive deadline exceeded, reopening receive stream", "topic", topic, "group", s.group, "partition", partition)
+				s.client.stats().OnReconnect(ReconnectStats{Topic: topic, Group: s.statsGroup(), Partition: partition})
+				s.options.reconnected(topic, partition)
+				continue
+			}
+			if !s.isDraining() {
+				s.fail(s.errs, s.errorAt(PhaseReceive, topic, partition, 0, fmt.Errorf("context terminated: %w", ErrSubscriptionClosed)))
+			}
+			return
+		}
+		if err != nil {
+			failures++
+			if s.reconnect(topic, partition, failures, err) {
+				if p.received {
+					lastKnownOffset, skipBelow = p.next-1, p.next
+				}
+				continue
+			}
+			// errors of the consumer are already classified, the others come from reading the stream
+			s.fail(s.errs, s.errorAt(PhaseReceive, topic, partition, 0, err))
+		}
+		return
+	}
+}
+
+// reconnect waits before reopening the receive stream of the partition of topic, after it failed with err for the
+// given number of times in a row, reporting whether to. It only does when configured with WithReconnectBackoff and
+// the gateway is unavailable.
+func (s *Subscription) reconnect(topic string, partition uint32, failures int, err error) bool {
+	if s.options.reconnectBackoff == nil || failures > s.options.reconnectAttempts || !isReceiveUnavailable(err) {
+		return false
+	}
+	s.client.log.Info("gateway unavailable, reopening receive stream", "topic", topic, "group", s.group, "partition", partition, "attempt", failures, "error", err)
+	if backoffWait(s.fetchCtx, s.options.reconnectBackoff, failures) != nil {
+		return false
+	}
+	s.client.stats().OnReconnect(ReconnectStats{Topic: topic, Group: s.statsGroup(), Partition: partition})
+	s.options.reconnected(topic, partition)
+	return true
+}
+
+// rewind hands the first message of the partition of topic over to the consumer and commits its offset, as there is no
+// offset to commit for the position of the group to move back to the very beginning of a partition. The message is
+// read through an anonymous group. It returns the offset following the message, or 0 if the partition is empty.
+func (s *Subscription) rewind(p *partitionReader, topic string, partition uint32) (uint64, error) {
+	ends, err := s.client.endOffsets(s.fetchCtx, topic)
+	if err != nil {
+		return 0, s.errorAt(PhaseReceive, topic, partition, 0, err)
+	}
+	if _, ok := ends[partition]; !ok {
+		return 0, nil
+	}
+	ctx, cancel := context.WithCancel(s.fetchCtx)
+	defer cancel()
+	subscribeClient, err := s.client.client.Subscribe(ctx, &liiklus.SubscribeRequest{
+		Topic:           topic,
+		Group:           ephemeralGroup(),
+		AutoOffsetReset: liiklus.SubscribeRequest_EARLIEST,
+	})
+	if err != nil {
+		return 0, s.rewindError(topic, partition, gatewayError("subscribe", err))
+	}
+	var assignment *liiklus.Assignment
+	for assignment == nil || assignment.GetPartition() != partition {
+		reply, err := subscribeClient.Recv()
+		if err != nil {
+			return 0, s.rewindError(topic, partition, gatewayError("subscribe", err))
+		}
+		assignment = reply.GetAssignment()
+	}
+	format := liiklus.ReceiveRequest_LIIKLUS_EVENT
+	if s.options.raw {
+		format = liiklus.ReceiveRequest_BINARY
+	}
+	receiveClient, err := s.client.client.Receive(ctx, &liiklus.ReceiveRequest{Assignment: assignment, Format: format})
+	if err != nil {
+		return 0, s.rewindError(topic, partition, gatewayError("receive", err))
+	}
+	if err := s.acquire(s.fetchCtx); err != nil {
+		return 0, errStreamInterrupted
+	}
+	reply, err := receiveClient.Recv()
+	if err != nil {
+		s.release(1)
+		return 0, s.rewindError(topic, partition, gatewayError("receive", err))
+	}
+	s.markReceived()
+	msg := s.decode(topic, partition, reply)
+	s.client.stats().OnReceive(ReceiveStats{Topic: topic, Group: s.statsGroup(), Partition: partition, Offset: msg.Offset})
+	p.received, p.next = true, msg.Offset+1
+	msg.Attempt = s.client.attempt(s.group, msg)
+	s.options.audit(s.statsGroup(), msg)
+	if err := s.consume(s.ctx, s, msg); err != nil {
+		return 0, err
+	}
+	// consumers may not have committed the message yet, as for batches
+	if err := s.commit(s.ctx, topic, partition, msg.Offset, 0); err != nil {
+		return 0, err
+	}
+	return msg.Offset + 1, nil
+}
+
+// rewindError returns err as a receive error, unless the subscription is terminating.
+func (s *Subscription) rewindError(topic string, partition uint32, err error) error {
+	if s.fetchCtx.Err() != nil {
+		return errStreamInterrupted
+	}
+	return s.errorAt(PhaseReceive, topic, partition, 0, err)
+}
+
+// skipTo commits the offset preceding start in the partition of topic, unless the group is past it already, so that the
+// messages recorded before the start time of the subscription are not read again should it be restarted.
+func (s *Subscription) skipTo(topic string, partition uint32, start uint64) error {
+	if s.anonymous || s.options.offsetStore != nil {
+		return nil
+	}
+	committed, err := s.client.committedOffsets(s.fetchCtx, topic, s.group, s.options.groupVersion)
+	if err != nil {
+		return s.errorAt(PhaseReceive, topic, partition, 0, err)
+	}
+	if offset, ok := committed[partition]; ok && offset+1 >= start {
+		return nil
+	}
+	return s.commit(s.ctx, topic, partition, start-1, 0)
+}
+
+// errStreamInterrupted signals that a receive stream ended because its context was cancelled.
+var errStreamInterrupted = errors.New("receive stream interrupted")
+
+// consumeStream hands messages read from receiveClient over to the consumer of the subscription, skipping messages
+// below skipBelow. The stream is interrupted if a message takes longer than the receive deadline to arrive.
+func (s *Subscription) consumeStream(receiveClient liiklus.LiiklusService_ReceiveClient, p *partitionReader, topic string, partition uint32, skipBelow uint64) error {
+	var deadline *time.Timer
+	if s.options.receiveDeadline > 0 {
+		deadline = time.AfterFunc(s.options.receiveDeadline, p.expire)
+		deadline.Stop()
+		defer deadline.Stop()
+	}
+	for {
+		if s.fetchCtx.Err() != nil {
+			return errStreamInterrupted
+		}
+		if err := s.acquire(s.fetchCtx); err != nil {
+			return errStreamInterrupted
+		}
+		if deadline != nil {
+			deadline.Reset(s.options.receiveDeadline)
+		}
+		recvReply, err := receiveClient.Recv()
+		if deadline != nil {
+			deadline.Stop()
+		}
+		if err != nil {
+			s.release(1)
+			// the context of the stream is also cancelled once the gateway ended it with an error
+			if s.fetchCtx.Err() != nil || p.interrupted() {
+				return errStreamInterrupted
+			}
+			return gatewayError("receive", err)
+		}
+		s.markReceived()
+
+		msg := s.decode(topic, partition, recvReply)
+		s.client.stats().OnReceive(ReceiveStats{Topic: topic, Group: s.statsGroup(), Partition: partition, Offset: msg.Offset})
+		p.received, p.next = true, msg.Offset+1
+		if msg.Offset < skipBelow || s.options.skip(msg) {
+			s.release(1)
+			continue
+		}
+		msg.Attempt = s.client.attempt(s.group, msg)
+		s.options.audit(s.statsGroup(), msg)
+		if err := s.consume(s.ctx, s, msg); err != nil {
+			return err
+		}
+	}
+}
+
+// Seek moves the position of the subscription in partition, so that the next message handed over to the handler for
+// that partition is the one at offset. Messages being handled are not affected. An error is returned if partition is
+// not currently assigned to the subscription. Subscriptions to several topics should use SeekTopic instead.
+//
+// As no offset can be committed before the first message of a partition, seeking to offset 0 reads that message
+// through an anonymous group, then commits it once handled.
+func (s *Subscription) Seek(partition uint32, offset uint64) error {
+	if len(s.topics) != 1 {
+		return errors.New("subscription consumes several topics, use SeekTopic")
+	}
+	return s.SeekTopic(s.topics[0], partition, offset)
+}
+
+// SeekTopic is like Seek, for a partition of one of the topics consumed by the subscription.
+func (s *Subscription) SeekTopic(topic string, partition uint32, offset uint64) error {
+	if s.ctx.Err() != nil {
+		return ErrSubscriptionClosed
+	}
+	s.mu.Lock()
+	p, ok := s.partitions[topicPartition{topic: topic, partition: partition}]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("partition %d of topic %q is not assigned to this subscription", partition, topic)
+	}
+	p.seek(offset)
+	return nil
+}
+
+// SeekToTime moves the position of the subscription in every partition currently assigned to it, so that the next
+// message handed over to the handler is the first one recorded at or after t. Resolving t to offsets requires reading
+// the partitions from the beginning.
+func (s *Subscription) SeekToTime(ctx context.Context, t time.Time) error {
+	if s.client == nil {
+		// stubs have no partitions to seek
+		return nil
+	}
+	for _, topic := range s.topics {
+		offsets, err := s.client.offsetsForTime(ctx, topic, t)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		partitions := make(map[uint32]*partitionReader, len(s.partitions))
+		for tp, p := range s.partitions {
+			if tp.topic == topic {
+				partitions[tp.partition] = p
+			}
+		}
+		s.mu.Unlock()
+		for partition, p := range partitions {
+			// partitions missing from offsets were empty, hence have nothing recorded before t to skip
+			if offset, ok := offsets[partition]; ok {
+				p.seek(offset)
+			}
+		}
+	}
+	return nil
+}
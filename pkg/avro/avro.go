@@ -0,0 +1,205 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package avro implements a client.Codec for Avro payloads, using schemas held by a Confluent compatible schema
+// registry, so that riff streams interoperate with Kafka Avro producers and consumers. Payloads follow the Confluent
+// wire format: a zero magic byte, the big endian 4 bytes ID of the schema in the registry, then the Avro binary
+// encoding of the value.
+//
+// Values are represented as expected by github.com/linkedin/goavro, records being maps from field names to values.
+package avro
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// ContentType is the content type of payloads in the Confluent wire format.
+const ContentType = "application/vnd.confluent.avro"
+
+// Registry is a client of a Confluent compatible schema registry, caching the schemas it fetches.
+type Registry struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+
+	mu sync.Mutex
+	// schemas are the schemas fetched so far, by ID.
+	schemas map[int]*goavro.Codec
+}
+
+// RegistryOption configures a Registry.
+type RegistryOption func(*Registry)
+
+// WithBasicAuth authenticates requests to the registry with the given credentials.
+func WithBasicAuth(username string, password string) RegistryOption {
+	return func(r *Registry) {
+		r.username = username
+		r.password = password
+	}
+}
+
+// WithHTTPClient sends requests to the registry with client, rather than http.DefaultClient.
+func WithHTTPClient(client *http.Client) RegistryOption {
+	return func(r *Registry) {
+		r.client = client
+	}
+}
+
+// NewRegistry creates a client of the schema registry at the given base URL.
+func NewRegistry(url string, opts ...RegistryOption) *Registry {
+	r := &Registry{
+		url:     strings.TrimSuffix(url, "/"),
+		client:  http.DefaultClient,
+		schemas: make(map[int]*goavro.Codec),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// schemaResponse is the representation of a schema returned by the registry.
+type schemaResponse struct {
+	ID     int    `json:"id"`
+	Schema string `json:"schema"`
+}
+
+// get fetches path from the registry and decodes the JSON response into v.
+func (r *Registry) get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, r.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("schema registry returned %s for %s", res.Status, path)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// latest returns the ID and codec of the latest version of the schema registered under subject.
+func (r *Registry) latest(subject string) (int, *goavro.Codec, error) {
+	var s schemaResponse
+	if err := r.get("/subjects/"+url.PathEscape(subject)+"/versions/latest", &s); err != nil {
+		return 0, nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if codec, ok := r.schemas[s.ID]; ok {
+		return s.ID, codec, nil
+	}
+	codec, err := goavro.NewCodec(s.Schema)
+	if err != nil {
+		return 0, nil, err
+	}
+	r.schemas[s.ID] = codec
+	return s.ID, codec, nil
+}
+
+// schema returns the codec of the schema with the given ID.
+func (r *Registry) schema(id int) (*goavro.Codec, error) {
+	r.mu.Lock()
+	codec, ok := r.schemas[id]
+	r.mu.Unlock()
+	if ok {
+		return codec, nil
+	}
+	var s schemaResponse
+	if err := r.get(fmt.Sprintf("/schemas/ids/%d", id), &s); err != nil {
+		return nil, err
+	}
+	codec, err := goavro.NewCodec(s.Schema)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas[id] = codec
+	return codec, nil
+}
+
+// Codec marshals values against the latest schema registered under a subject, and unmarshals payloads against the
+// schema they were marshaled with. It is meant to be registered for ContentType with client.StreamClient.RegisterCodec.
+type Codec struct {
+	registry *Registry
+	subject  string
+}
+
+// NewCodec creates a Codec using the schemas held by registry, marshaling values against the latest schema registered
+// under subject.
+func NewCodec(registry *Registry, subject string) *Codec {
+	return &Codec{
+		registry: registry,
+		subject:  subject,
+	}
+}
+
+// Marshal encodes v against the latest schema of the subject. The schema is looked up on every call, so that new
+// versions are picked up.
+func (c *Codec) Marshal(v interface{}) ([]byte, error) {
+	id, codec, err := c.registry.latest(c.subject)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(id))
+	return codec.BinaryFromNative(header, v)
+}
+
+// Unmarshal decodes data into v, which must be a pointer to an interface{} or to a map[string]interface{} for records.
+func (c *Codec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) < 5 || data[0] != 0 {
+		return fmt.Errorf("payload is not in the Confluent wire format")
+	}
+	codec, err := c.registry.schema(int(binary.BigEndian.Uint32(data[1:5])))
+	if err != nil {
+		return err
+	}
+	native, _, err := codec.NativeFromBinary(data[5:])
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case *interface{}:
+		*v = native
+	case *map[string]interface{}:
+		record, ok := native.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("payload is not a record, but %T", native)
+		}
+		*v = record
+	default:
+		return fmt.Errorf("unsupported value type %T, expecting *interface{} or *map[string]interface{}", v)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package avro_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/avro"
+)
+
+const schema = `{"type": "record", "name": "Order", "fields": [{"name": "id", "type": "string"}, {"name": "total", "type": "long"}]}`
+
+func TestCodec(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/subjects/orders-value/versions/latest", "/schemas/ids/7":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 7, "schema": schema})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+
+	var codec client.Codec = avro.NewCodec(avro.NewRegistry(registry.URL, avro.WithBasicAuth("user", "secret")), "orders-value")
+	order := map[string]interface{}{"id": "42", "total": int64(100)}
+	payload, err := codec.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(payload[:5], []byte{0, 0, 0, 0, 7}) {
+		t.Errorf("expected payload to start with schema id 7, but was: %v", payload[:5])
+	}
+
+	// use another registry client, so that the schema is fetched by id
+	codec = avro.NewCodec(avro.NewRegistry(registry.URL, avro.WithBasicAuth("user", "secret")), "orders-value")
+	var decoded map[string]interface{}
+	if err := codec.Unmarshal(payload, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, order) {
+		t.Errorf("expected value: %v, but was: %v", order, decoded)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cehttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	client "github.com/projectriff/stream-client-go"
+)
+
+// Extension attributes set on the events a Forwarder sends to its dead letter stream, recording where they come from
+// and why they could not be delivered.
+const (
+	SourceTopicHeader     = client.SourceTopicHeader
+	SourcePartitionHeader = client.SourcePartitionHeader
+	SourceOffsetHeader    = client.SourceOffsetHeader
+	DeliveryErrorHeader   = client.FailureHeader
+)
+
+// Forwarder delivers the events of a stream to an HTTP sink, POSTing them as CloudEvents in the binary content mode,
+// which covers the common "stream to webhook" integration. A delivery succeeds when the sink replies with a 2xx
+// status. Failed deliveries are retried, unless the sink rejected the event with a 4xx status other than 408 and 429,
+// and events which can't be delivered are sent to a dead letter stream, if any. The position of the forwarder in the
+// stream is tracked by its own consumer group.
+type Forwarder struct {
+	subscriber client.Subscriber
+	group      string
+	sink       string
+	httpClient *http.Client
+	attempts   int
+	backoff    client.Backoff
+	deadLetter client.Publisher
+}
+
+// ForwarderOption configures optional behavior of a Forwarder.
+type ForwarderOption func(*Forwarder)
+
+// WithHTTPClient sends the requests to the sink with c, rather than http.DefaultClient.
+func WithHTTPClient(c *http.Client) ForwarderOption {
+	return func(f *Forwarder) {
+		f.httpClient = c
+	}
+}
+
+// WithRetries makes up to attempts attempts at delivering each event, waiting interval after the first failure and
+// twice as long after each subsequent one. The default is 3 attempts, one second apart at first.
+func WithRetries(attempts int, interval time.Duration) ForwarderOption {
+	return WithRetryBackoff(attempts, client.ExponentialBackoff{Initial: interval})
+}
+
+// WithRetryBackoff makes up to attempts attempts at delivering each event, waiting the delays computed by b in between.
+func WithRetryBackoff(attempts int, b client.Backoff) ForwarderOption {
+	return func(f *Forwarder) {
+		f.attempts = attempts
+		f.backoff = b
+	}
+}
+
+// WithDeadLetter publishes the events which can't be delivered with p, along with the SourceTopicHeader,
+// SourcePartitionHeader, SourceOffsetHeader and DeliveryErrorHeader extension attributes, and moves on to the next
+// event. Without a dead letter stream, failing to deliver an event is reported as a failure of the subscription
+// handler.
+func WithDeadLetter(p client.Publisher) ForwarderOption {
+	return func(f *Forwarder) {
+		f.deadLetter = p
+	}
+}
+
+// NewForwarder creates a Forwarder delivering the events read by s to the sink URL, tracking its position as part of
+// group.
+func NewForwarder(s client.Subscriber, group string, sink string, opts ...ForwarderOption) *Forwarder {
+	f := &Forwarder{
+		subscriber: s,
+		group:      group,
+		sink:       sink,
+		httpClient: http.DefaultClient,
+		attempts:   3,
+		backoff:    client.ExponentialBackoff{Initial: time.Second},
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Start starts delivering events, which goes on until the returned Subscription is stopped. Events are delivered at
+// least once, in order within each partition, starting from the beginning of the stream when the group has no
+// position yet. Optional behavior of the underlying subscription may be configured by passing SubscribeOptions, as
+// for Subscribe.
+func (f *Forwarder) Start(ctx context.Context, e client.EventErrHandler, opts ...client.SubscribeOption) (*client.Subscription, error) {
+	return f.subscriber.SubscribeMessages(ctx, f.group, true, f.forward, e, opts...)
+}
+
+// forward delivers msg to the sink, retrying and falling back to the dead letter stream as configured.
+func (f *Forwarder) forward(ctx context.Context, msg client.Message) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		if retry, err = f.deliver(ctx, msg); err == nil {
+			return nil
+		}
+		if !retry || attempt >= f.attempts {
+			break
+		}
+		timer := time.NewTimer(f.backoff.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if f.deadLetter == nil || ctx.Err() != nil {
+		return err
+	}
+	headers := attributes(msg)
+	headers[SourceTopicHeader] = msg.Topic
+	headers[SourcePartitionHeader] = strconv.FormatUint(uint64(msg.Partition), 10)
+	headers[SourceOffsetHeader] = strconv.FormatUint(msg.Offset, 10)
+	headers[DeliveryErrorHeader] = err.Error()
+	var key io.Reader
+	if msg.Key != nil {
+		key = bytes.NewReader(msg.Key)
+	}
+	if _, dlqErr := f.deadLetter.Publish(ctx, bytes.NewReader(msg.Payload), key, msg.ContentType, headers); dlqErr != nil {
+		return fmt.Errorf("%w, and sending it to the dead letter stream failed: %v", err, dlqErr)
+	}
+	return nil
+}
+
+// deliver POSTs msg to the sink, reporting whether a failed delivery is worth retrying.
+func (f *Forwarder) deliver(ctx context.Context, msg client.Message) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.sink, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return false, err
+	}
+	if msg.ContentType != "" {
+		req.Header.Set("Content-Type", msg.ContentType)
+	}
+	for name, value := range msg.Headers {
+		req.Header.Set(headerPrefix+name, encodeHeader(value))
+	}
+	req.Header.Set(headerPrefix+"specversion", "1.0")
+	req.Header.Set(client.IDHeader, encodeHeader(msg.ID))
+	req.Header.Set(client.SourceHeader, encodeHeader(msg.Source))
+	req.Header.Set(client.TypeHeader, encodeHeader(msg.Type))
+	if !msg.Time.IsZero() {
+		req.Header.Set(client.TimeHeader, msg.Time.Format(time.RFC3339Nano))
+	}
+	if len(msg.Key) > 0 {
+		req.Header.Set(headerPrefix+partitionKey, encodeHeader(string(msg.Key)))
+	}
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("delivering event %s: %w", msg.ID, err)
+	}
+	defer resp.Body.Close()
+	// drain the body so that the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode/100 != 4 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("delivering event %s: sink replied %s", msg.ID, resp.Status)
+}
+
+// encodeHeader percent-encodes the characters of an attribute value which are not allowed in a header, as required by
+// the HTTP binding of CloudEvents.
+func encodeHeader(value string) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package cehttp_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/projectriff/stream-client-go/pkg/cehttp"
+	"github.com/projectriff/stream-client-go/pkg/fake"
+)
+
+func TestForwarder(t *testing.T) {
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("orders", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	dlq, err := gateway.NewStreamClient("orders-dlq", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dlq.Close()
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	delivered := make(chan *http.Request, 10)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		attempts[string(body)]++
+		n := attempts[string(body)]
+		mu.Unlock()
+		switch {
+		case string(body) == "rejected":
+			w.WriteHeader(http.StatusBadRequest)
+		case n == 1:
+			// fails the first attempt of other events, which is retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			delivered <- r
+		}
+	}))
+	defer sink.Close()
+
+	for _, payload := range []string{"accepted", "rejected"} {
+		headers := map[string]string{"region": "eu west"}
+		if _, err := c.Publish(context.Background(), strings.NewReader(payload), strings.NewReader("customer-1"), "text/plain", headers); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := cehttp.NewForwarder(c, "forwarder", sink.URL, cehttp.WithRetries(3, 10*time.Millisecond), cehttp.WithDeadLetter(dlq))
+	sub, err := f.Start(context.Background(), func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Drain(context.Background())
+
+	select {
+	case r := <-delivered:
+		if r.Header.Get("Ce-Specversion") != "1.0" || r.Header.Get("Ce-Id") == "" || r.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		if r.Header.Get("Ce-Region") != "eu%20west" || r.Header.Get("Ce-Partitionkey") != "customer-1" {
+			t.Errorf("expected extensions and key to be sent as headers, got %v", r.Header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for delivery")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(gateway.Records("orders-dlq")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the rejected event to be dead lettered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	dead := gateway.Records("orders-dlq")[0]
+	if string(dead.Event.Data) != "rejected" || string(dead.Key) != "customer-1" {
+		t.Errorf("unexpected dead letter %v", dead.Event)
+	}
+	if dead.Event.Extensions[cehttp.SourceTopicHeader] != "orders" || dead.Event.Extensions[cehttp.SourceOffsetHeader] != "1" ||
+		!strings.Contains(dead.Event.Extensions[cehttp.DeliveryErrorHeader], "400") {
+		t.Errorf("unexpected dead letter extensions %v", dead.Event.Extensions)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts["rejected"] != 1 {
+		t.Errorf("expected an event rejected by the sink not to be retried, got %d attempts", attempts["rejected"])
+	}
+}
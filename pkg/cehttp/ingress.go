@@ -0,0 +1,200 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cehttp bridges riff streams and the HTTP protocol binding of CloudEvents, in both binary and structured
+// content modes. Ingress publishes the events it is sent, so that webhooks and Knative sources can feed a stream:
+//
+//	http.Handle("/", cehttp.NewIngress(streamClient))
+//
+// Forwarder does the opposite, delivering the events of a stream to an HTTP sink.
+package cehttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	client "github.com/projectriff/stream-client-go"
+)
+
+const (
+	// StructuredContentType is the media type of events sent in the structured content mode, as a JSON object
+	// holding both the attributes and the data of the event.
+	StructuredContentType = "application/cloudevents+json"
+	// DefaultMaxBodySize is the largest request body accepted by an Ingress unless configured otherwise.
+	DefaultMaxBodySize = 4 * 1024 * 1024
+)
+
+// headerPrefix prefixes the names of the headers holding event attributes in the binary content mode.
+const headerPrefix = "ce-"
+
+// partitionKey is the extension attribute holding the key events are published under.
+const partitionKey = "partitionkey"
+
+// Ingress is an http.Handler publishing the CloudEvents POSTed to it. In the binary content mode, the body of the
+// request is the data of the event, its Content-Type header is the content type of the data and the other attributes
+// are held by ce- prefixed headers. In the structured content mode, the body is the event in JSON format. Missing
+// attributes are defaulted by the Publisher. Events are published under the key held by their partitionkey extension
+// attribute, if any.
+//
+// Ingress replies 202 once the event is published, 400 for malformed events, 413 for oversized ones, 415 for events
+// whose content type is not accepted by the stream, and 503 or 502 when the gateway is unavailable or fails.
+type Ingress struct {
+	publisher   client.Publisher
+	maxBodySize int64
+}
+
+// IngressOption configures optional behavior of an Ingress.
+type IngressOption func(*Ingress)
+
+// WithMaxBodySize rejects requests whose body is larger than n bytes, instead of DefaultMaxBodySize.
+func WithMaxBodySize(n int64) IngressOption {
+	return func(i *Ingress) {
+		i.maxBodySize = n
+	}
+}
+
+// NewIngress returns an Ingress publishing events with p.
+func NewIngress(p client.Publisher, opts ...IngressOption) *Ingress {
+	i := &Ingress{
+		publisher:   p,
+		maxBodySize: DefaultMaxBodySize,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+func (i *Ingress) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, i.maxBodySize+1))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("reading event: %v", err), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > i.maxBodySize {
+		http.Error(w, fmt.Sprintf("event larger than %d bytes", i.maxBodySize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var msg client.Message
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == StructuredContentType:
+		if msg, err = client.ParseCloudEventJSON(body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid event: %v", err), http.StatusBadRequest)
+			return
+		}
+	case strings.HasPrefix(mediaType, "application/cloudevents"):
+		// batched mode, or structured mode with a format other than JSON
+		http.Error(w, fmt.Sprintf("unsupported event format %q", mediaType), http.StatusUnsupportedMediaType)
+		return
+	default:
+		if msg, err = parseBinary(r.Header, body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid event: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var key io.Reader
+	if msg.Key != nil {
+		key = bytes.NewReader(msg.Key)
+	}
+	_, err = i.publisher.Publish(r.Context(), bytes.NewReader(msg.Payload), key, msg.ContentType, attributes(msg))
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusAccepted)
+	case errors.Is(err, client.ErrIncompatibleContentType):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.As(err, new(*client.ValidationError)):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, client.ErrGatewayUnavailable), errors.Is(err, client.ErrClientClosed):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+// parseBinary reads an event sent in the binary content mode.
+func parseBinary(header http.Header, body []byte) (client.Message, error) {
+	msg := client.Message{
+		Payload:     body,
+		ContentType: header.Get("Content-Type"),
+		Headers:     make(map[string]string),
+	}
+	for name, values := range header {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, headerPrefix) || len(values) == 0 {
+			continue
+		}
+		// values are percent-encoded when they hold characters not allowed in headers
+		value, err := url.PathUnescape(values[0])
+		if err != nil {
+			value = values[0]
+		}
+		switch attribute := strings.TrimPrefix(name, headerPrefix); attribute {
+		case "specversion":
+		case "id":
+			msg.ID = value
+		case "source":
+			msg.Source = value
+		case "type":
+			msg.Type = value
+		case "time":
+			if msg.Time, err = time.Parse(time.RFC3339Nano, value); err != nil {
+				return client.Message{}, fmt.Errorf("invalid time: %w", err)
+			}
+		case partitionKey:
+			msg.Key = []byte(value)
+		default:
+			msg.Headers[attribute] = value
+		}
+	}
+	return msg, nil
+}
+
+// attributes returns the headers to publish msg with, so that it keeps its attributes.
+func attributes(msg client.Message) map[string]string {
+	headers := make(map[string]string, len(msg.Headers)+4)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	if msg.ID != "" {
+		headers[client.IDHeader] = msg.ID
+	}
+	if msg.Source != "" {
+		headers[client.SourceHeader] = msg.Source
+	}
+	if msg.Type != "" {
+		headers[client.TypeHeader] = msg.Type
+	}
+	if !msg.Time.IsZero() {
+		headers[client.TimeHeader] = msg.Time.Format(time.RFC3339Nano)
+	}
+	return headers
+}
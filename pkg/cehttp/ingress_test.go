@@ -0,0 +1,100 @@
+package cehttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/projectriff/stream-client-go/pkg/cehttp"
+	"github.com/projectriff/stream-client-go/pkg/fake"
+)
+
+func TestIngress(t *testing.T) {
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("orders", "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	server := httptest.NewServer(cehttp.NewIngress(c, cehttp.WithMaxBodySize(256)))
+	defer server.Close()
+
+	post := func(contentType string, headers map[string]string, body string) int {
+		req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	binary := map[string]string{
+		"ce-specversion":  "1.0",
+		"ce-id":           "1",
+		"ce-source":       "/webhooks",
+		"ce-type":         "order.created",
+		"ce-time":         "2019-06-01T10:00:00Z",
+		"ce-partitionkey": "customer-1",
+		"ce-region":       "eu%20west",
+	}
+	if code := post("application/json", binary, `{"order":1}`); code != http.StatusAccepted {
+		t.Fatalf("expected binary event to be accepted, got %d", code)
+	}
+	structured := `{"specversion":"1.0","id":"2","source":"/webhooks","type":"order.created","datacontenttype":"application/json","data":{"order":2}}`
+	if code := post(cehttp.StructuredContentType, nil, structured); code != http.StatusAccepted {
+		t.Fatalf("expected structured event to be accepted, got %d", code)
+	}
+
+	records := gateway.Records("orders")
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	first := records[0]
+	if first.Event.Id != "1" || first.Event.Source != "/webhooks" || first.Event.Type != "order.created" || first.Event.Time != "2019-06-01T10:00:00Z" {
+		t.Errorf("unexpected attributes for binary event: %v", first.Event)
+	}
+	if string(first.Key) != "customer-1" || first.Event.Extensions["region"] != "eu west" || string(first.Event.Data) != `{"order":1}` {
+		t.Errorf("unexpected binary event: key %q, %v", first.Key, first.Event)
+	}
+	if second := records[1]; second.Event.Id != "2" || second.Event.DataContentType != "application/json" || string(second.Event.Data) != `{"order":2}` {
+		t.Errorf("unexpected structured event: %v", second.Event)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+	}{
+		{name: "incompatible content type", contentType: "text/plain", body: "hello", code: http.StatusUnsupportedMediaType},
+		{name: "malformed structured event", contentType: cehttp.StructuredContentType, body: "{", code: http.StatusBadRequest},
+		{name: "batch", contentType: "application/cloudevents-batch+json", body: "[]", code: http.StatusUnsupportedMediaType},
+		{name: "oversized", contentType: "application/json", body: strings.Repeat(" ", 257), code: http.StatusRequestEntityTooLarge},
+	} {
+		if code := post(tc.contentType, nil, tc.body); code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.code, code)
+		}
+	}
+	if len(gateway.Records("orders")) != 2 {
+		t.Error("expected rejected events not to be published")
+	}
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be rejected, got %d", resp.StatusCode)
+	}
+}
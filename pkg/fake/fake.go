@@ -0,0 +1,445 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package fake provides an in-memory liiklus gateway, so that applications using the stream client can be unit
+// tested without a Kafka and liiklus stack.
+//
+// Clients created by a Gateway are regular client.StreamClients talking gRPC to the gateway over an in-process
+// connection, so that every feature of the client behaves as it would against liiklus:
+//
+//	gateway := fake.NewGateway(fake.WithPartitions(2))
+//	defer gateway.Close()
+//	streamClient, err := gateway.NewStreamClient("orders", "application/json")
+package fake
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// bufferSize is the size of the in-process connection buffers.
+const bufferSize = 1024 * 1024
+
+// Record is a record stored by the gateway.
+type Record struct {
+	// Topic is the topic the record was published to.
+	Topic string
+	// Partition is the partition the record was stored in.
+	Partition uint32
+	// Offset is the position of the record in its partition.
+	Offset uint64
+	// Key is the key the record was published with, if any.
+	Key []byte
+	// Event is the event held by the record, nil for records published as a raw value.
+	Event *liiklus.LiiklusEvent
+	// Value is the raw value of the record, for records which don't hold an event.
+	Value []byte
+	// Timestamp is the time the record was stored.
+	Timestamp time.Time
+}
+
+// Gateway is an in-memory implementation of the liiklus gRPC API. Topics are created on first use, records are
+// spread over partitions by key, or round robin for records without a key, and the offsets committed by consumer
+// groups are remembered. Each subscription is assigned every partition of its topic. Faults can be injected with
+// WithFaults, SetFaults and FailNext.
+type Gateway struct {
+	partitions int
+	// strictTopics disables the creation of topics on first use.
+	strictTopics bool
+	listener     *bufconn.Listener
+	server       *grpc.Server
+
+	// mu guards the fields below.
+	mu sync.Mutex
+	// topics hold the records of each partition of each topic.
+	topics map[string][][]Record
+	// committed are the offsets committed by each consumer group, per partition.
+	committed map[groupVersion]map[uint32]uint64
+	// sessions are the partition assignments handed out to subscriptions, by session ID.
+	sessions map[string]session
+	// lastSession is the ID of the last session handed out.
+	lastSession int
+	// roundRobin is the number of records published without a key.
+	roundRobin int
+	// published is closed and replaced whenever a record is published, waking up receive streams.
+	published chan struct{}
+	// faults are the faults injected, drawn from random.
+	faults Faults
+	random *rand.Rand
+	// failures are the codes the next calls to each method fail with.
+	failures map[string][]codes.Code
+}
+
+// groupVersion designates a consumer group of a topic.
+type groupVersion struct {
+	topic   string
+	group   string
+	version uint32
+}
+
+// session is the assignment of a partition to a subscription.
+type session struct {
+	groupVersion
+	reset     liiklus.SubscribeRequest_AutoOffsetReset
+	partition uint32
+}
+
+// Option configures a Gateway.
+type Option func(*Gateway)
+
+// WithStrictTopics makes publishing and subscribing to topics which have not been created with CreateTopic fail with
+// codes.NotFound, as liiklus does when Kafka doesn't create topics automatically.
+func WithStrictTopics() Option {
+	return func(g *Gateway) {
+		g.strictTopics = true
+	}
+}
+
+// WithPartitions sets the number of partitions of the topics of the gateway, 1 by default.
+func WithPartitions(n int) Option {
+	return func(g *Gateway) {
+		g.partitions = n
+	}
+}
+
+// NewGateway creates a gateway and starts serving clients created with NewStreamClient. Close must be called once it
+// is no longer needed.
+func NewGateway(opts ...Option) *Gateway {
+	g := &Gateway{
+		partitions: 1,
+		listener:   bufconn.Listen(bufferSize),
+		server:     grpc.NewServer(),
+		topics:     make(map[string][][]Record),
+		committed:  make(map[groupVersion]map[uint32]uint64),
+		sessions:   make(map[string]session),
+		published:  make(chan struct{}),
+		random:     rand.New(rand.NewSource(0)),
+		failures:   make(map[string][]codes.Code),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	liiklus.RegisterLiiklusServiceServer(g.server, &server{g})
+	go g.server.Serve(g.listener)
+	return g
+}
+
+// NewStreamClient creates a client for topic, connected to the gateway.
+func (g *Gateway) NewStreamClient(topic string, acceptableContentType string, opts ...client.ClientOption) (*client.StreamClient, error) {
+	dialer := grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return g.listener.Dial()
+	})
+	return client.NewStreamClient("fake", topic, acceptableContentType, append([]client.ClientOption{client.WithDialOptions(dialer)}, opts...)...)
+}
+
+// NewClient is like NewStreamClient, but returns the client as a client.Client.
+func (g *Gateway) NewClient(topic string, acceptableContentType string, opts ...client.ClientOption) (client.Client, error) {
+	lc, err := g.NewStreamClient(topic, acceptableContentType, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return lc, nil
+}
+
+// Close stops the gateway, terminating the streams of its clients.
+func (g *Gateway) Close() {
+	g.server.Stop()
+}
+
+// Records returns the records of topic, ordered by partition and offset.
+func (g *Gateway) Records(topic string) []Record {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var records []Record
+	for _, partition := range g.topic(topic) {
+		records = append(records, partition...)
+	}
+	return records
+}
+
+// Committed returns the offsets committed in each partition of topic by group, with group version 0.
+func (g *Gateway) Committed(topic string, group string) map[uint32]uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	offsets := make(map[uint32]uint64)
+	for partition, offset := range g.committed[groupVersion{topic: topic, group: group}] {
+		offsets[partition] = offset
+	}
+	return offsets
+}
+
+// CreateTopic creates topic, if it doesn't exist yet. It implements client.TopicCreator, for use with
+// client.WithAutoCreateTopic.
+func (g *Gateway) CreateTopic(ctx context.Context, topic string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.topic(topic)
+	return nil
+}
+
+// exists returns a NotFound error if topic doesn't exist and topics are not created on first use. It must be called
+// with mu held.
+func (g *Gateway) exists(topic string) error {
+	if _, ok := g.topics[topic]; !ok && g.strictTopics {
+		return status.Errorf(codes.NotFound, "topic %q does not exist", topic)
+	}
+	return nil
+}
+
+// topic returns the partitions of topic, creating it if needed. It must be called with mu held.
+func (g *Gateway) topic(name string) [][]Record {
+	partitions, ok := g.topics[name]
+	if !ok {
+		partitions = make([][]Record, g.partitions)
+		g.topics[name] = partitions
+	}
+	return partitions
+}
+
+// server implements the gRPC API on behalf of a gateway.
+type server struct {
+	g *Gateway
+}
+
+func (s *server) Publish(ctx context.Context, request *liiklus.PublishRequest) (*liiklus.PublishReply, error) {
+	g := s.g
+	if err := g.inject(ctx, Publish, true); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.exists(request.Topic); err != nil {
+		return nil, err
+	}
+	partitions := g.topic(request.Topic)
+	var partition int
+	if len(request.Key) > 0 {
+		h := fnv.New32a()
+		h.Write(request.Key)
+		partition = int(h.Sum32() % uint32(len(partitions)))
+	} else {
+		partition = g.roundRobin % len(partitions)
+		g.roundRobin++
+	}
+	record := Record{
+		Topic:     request.Topic,
+		Partition: uint32(partition),
+		Offset:    uint64(len(partitions[partition])),
+		Key:       request.Key,
+		Event:     request.GetLiiklusEvent(),
+		Value:     request.Value,
+		Timestamp: time.Now(),
+	}
+	partitions[partition] = append(partitions[partition], record)
+	close(g.published)
+	g.published = make(chan struct{})
+	return &liiklus.PublishReply{Topic: record.Topic, Partition: record.Partition, Offset: record.Offset}, nil
+}
+
+func (s *server) Subscribe(request *liiklus.SubscribeRequest, stream liiklus.LiiklusService_SubscribeServer) error {
+	g := s.g
+	if err := g.inject(stream.Context(), Subscribe, false); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	if err := g.exists(request.Topic); err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	var assignments []*liiklus.Assignment
+	for partition := range g.topic(request.Topic) {
+		g.lastSession++
+		id := strconv.Itoa(g.lastSession)
+		g.sessions[id] = session{
+			groupVersion: groupVersion{topic: request.Topic, group: request.Group, version: request.GroupVersion},
+			reset:        request.AutoOffsetReset,
+			partition:    uint32(partition),
+		}
+		assignments = append(assignments, &liiklus.Assignment{SessionId: id, Partition: uint32(partition)})
+	}
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		for _, assignment := range assignments {
+			delete(g.sessions, assignment.SessionId)
+		}
+		g.mu.Unlock()
+	}()
+
+	for _, assignment := range assignments {
+		if err := stream.Send(&liiklus.SubscribeReply{Reply: &liiklus.SubscribeReply_Assignment{Assignment: assignment}}); err != nil {
+			return err
+		}
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func (s *server) Receive(request *liiklus.ReceiveRequest, stream liiklus.LiiklusService_ReceiveServer) error {
+	g := s.g
+	if err := g.inject(stream.Context(), Receive, false); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	sess, ok := g.sessions[request.Assignment.GetSessionId()]
+	if !ok {
+		g.mu.Unlock()
+		return status.Errorf(codes.NotFound, "unknown session %q", request.Assignment.GetSessionId())
+	}
+	var next uint64
+	if offset, ok := g.committed[sess.groupVersion][sess.partition]; ok {
+		next = offset + 1
+	} else if sess.reset == liiklus.SubscribeRequest_LATEST {
+		next = uint64(len(g.topic(sess.topic)[sess.partition]))
+	}
+	if request.LastKnownOffset > 0 && request.LastKnownOffset+1 > next {
+		next = request.LastKnownOffset + 1
+	}
+	g.mu.Unlock()
+
+	for {
+		g.mu.Lock()
+		records := g.topic(sess.topic)[sess.partition]
+		var pending []Record
+		if next < uint64(len(records)) {
+			pending = records[next:]
+		}
+		published := g.published
+		g.mu.Unlock()
+
+		for _, record := range pending {
+			reply, err := receiveReply(record, request.Format)
+			if err != nil {
+				return err
+			}
+			deliveries, err := g.deliveries(stream.Context())
+			if err != nil {
+				return nil
+			}
+			for i := 0; i < deliveries; i++ {
+				if err := stream.Send(reply); err != nil {
+					return err
+				}
+			}
+			next++
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-published:
+		}
+	}
+}
+
+// receiveReply converts record to a reply in the given format.
+func receiveReply(record Record, format liiklus.ReceiveRequest_ContentFormat) (*liiklus.ReceiveReply, error) {
+	timestamp, err := ptypes.TimestampProto(record.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if format == liiklus.ReceiveRequest_LIIKLUS_EVENT && record.Event != nil {
+		return &liiklus.ReceiveReply{Reply: &liiklus.ReceiveReply_LiiklusEventRecord_{LiiklusEventRecord: &liiklus.ReceiveReply_LiiklusEventRecord{
+			Offset:    record.Offset,
+			Key:       record.Key,
+			Event:     record.Event,
+			Timestamp: timestamp,
+		}}}, nil
+	}
+	value := record.Value
+	if record.Event != nil {
+		value = record.Event.GetData()
+	}
+	return &liiklus.ReceiveReply{Reply: &liiklus.ReceiveReply_Record_{Record: &liiklus.ReceiveReply_Record{
+		Offset:    record.Offset,
+		Key:       record.Key,
+		Value:     value,
+		Timestamp: timestamp,
+	}}}, nil
+}
+
+func (s *server) Ack(ctx context.Context, request *liiklus.AckRequest) (*empty.Empty, error) {
+	g := s.g
+	if err := g.inject(ctx, Ack, true); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gv := groupVersion{topic: request.Topic, group: request.Group, version: request.GroupVersion}
+	if request.Assignment != nil {
+		sess, ok := g.sessions[request.Assignment.SessionId]
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "unknown session %q", request.Assignment.SessionId)
+		}
+		gv, request.Partition = sess.groupVersion, sess.partition
+	}
+	if gv.topic == "" {
+		return nil, status.Error(codes.InvalidArgument, "topic is required")
+	}
+	if g.committed[gv] == nil {
+		g.committed[gv] = make(map[uint32]uint64)
+	}
+	g.committed[gv][request.Partition] = request.Offset
+	return &empty.Empty{}, nil
+}
+
+func (s *server) GetOffsets(ctx context.Context, request *liiklus.GetOffsetsRequest) (*liiklus.GetOffsetsReply, error) {
+	g := s.g
+	if err := g.inject(ctx, GetOffsets, true); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	offsets := make(map[uint32]uint64)
+	for partition, offset := range g.committed[groupVersion{topic: request.Topic, group: request.Group, version: request.GroupVersion}] {
+		offsets[partition] = offset
+	}
+	return &liiklus.GetOffsetsReply{Offsets: offsets}, nil
+}
+
+func (s *server) GetEndOffsets(ctx context.Context, request *liiklus.GetEndOffsetsRequest) (*liiklus.GetEndOffsetsReply, error) {
+	g := s.g
+	if err := g.inject(ctx, GetEndOffsets, true); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	offsets := make(map[uint32]uint64)
+	for partition, records := range g.topic(request.Topic) {
+		// like liiklus, report empty partitions with an offset of -1
+		offset := uint64(math.MaxUint64)
+		if len(records) > 0 {
+			offset = uint64(len(records) - 1)
+		}
+		offsets[uint32(partition)] = offset
+	}
+	return &liiklus.GetEndOffsetsReply{Offsets: offsets}, nil
+}
@@ -0,0 +1,185 @@
+package fake_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/fake"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	gateway := fake.NewGateway(fake.WithPartitions(2))
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("orders", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, payload := range []string{"a", "b", "c"} {
+		if _, err := c.Publish(context.Background(), strings.NewReader(payload), nil, "text/plain", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if records := gateway.Records("orders"); len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+
+	received := make(chan client.Message, 3)
+	sub, err := c.SubscribeMessages(context.Background(), "group", true, func(ctx context.Context, msg client.Message) error {
+		received <- msg
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Error(err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloads := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-received:
+			payloads[string(msg.Payload)] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+	}
+	if len(payloads) != 3 {
+		t.Errorf("expected 3 distinct events, got %v", payloads)
+	}
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	committed := gateway.Committed("orders", "group")
+	if committed[0]+committed[1] != 1 || len(committed) != 2 {
+		t.Errorf("expected the last offset of each partition to be committed, got %v", committed)
+	}
+}
+
+func TestResumeFromCommittedOffset(t *testing.T) {
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("orders", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	received := make(chan string, 10)
+	subscribe := func() *client.Subscription {
+		sub, err := c.SubscribeMessages(context.Background(), "group", true, func(ctx context.Context, msg client.Message) error {
+			received <- string(msg.Payload)
+			return nil
+		}, func(cancel context.CancelFunc, err error) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sub
+	}
+	publish := func(payload string) {
+		if _, err := c.Publish(context.Background(), strings.NewReader(payload), nil, "text/plain", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	await := func(expected string) {
+		select {
+		case payload := <-received:
+			if payload != expected {
+				t.Errorf("expected %q, got %q", expected, payload)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+
+	sub := subscribe()
+	publish("first")
+	await("first")
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	publish("second")
+	sub = subscribe()
+	await("second")
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// notify is code under test depending on the Publisher interface.
+func notify(ctx context.Context, p client.Publisher, text string) error {
+	_, err := p.Publish(ctx, strings.NewReader(text), nil, "text/plain", nil)
+	return err
+}
+
+// stubPublisher records the payloads published through it.
+type stubPublisher struct {
+	published []string
+}
+
+func (p *stubPublisher) Publish(ctx context.Context, payload io.Reader, key io.Reader, contentType string, headers map[string]string, opts ...client.PublishOption) (client.PublishResult, error) {
+	b, err := ioutil.ReadAll(payload)
+	if err != nil {
+		return client.PublishResult{}, err
+	}
+	p.published = append(p.published, string(b))
+	return client.PublishResult{Offset: uint64(len(p.published) - 1)}, nil
+}
+
+func TestClientInterfaces(t *testing.T) {
+	stub := &stubPublisher{}
+	if err := notify(context.Background(), stub, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.published) != 1 || stub.published[0] != "hello" {
+		t.Errorf("expected the stub to record the event, got %v", stub.published)
+	}
+
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	c, err := gateway.NewClient("notifications", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := notify(context.Background(), c, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if records := gateway.Records("notifications"); len(records) != 1 || string(records[0].Event.GetData()) != "hello" {
+		t.Errorf("expected the event to be published, got %v", records)
+	}
+}
+
+func TestAutoCreateTopic(t *testing.T) {
+	gateway := fake.NewGateway(fake.WithStrictTopics())
+	defer gateway.Close()
+
+	c, err := gateway.NewStreamClient("orders", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Publish(context.Background(), strings.NewReader("a"), nil, "text/plain", nil); !errors.Is(err, client.ErrTopicNotFound) {
+		t.Errorf("expected publishing to a missing topic to fail with ErrTopicNotFound, got: %v", err)
+	}
+
+	c, err = gateway.NewStreamClient("orders", "text/plain", client.WithAutoCreateTopic(gateway))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Publish(context.Background(), strings.NewReader("a"), nil, "text/plain", nil); err != nil {
+		t.Errorf("expected the topic to be created, got: %v", err)
+	}
+	if records := gateway.Records("orders"); len(records) != 1 {
+		t.Errorf("expected the event to be published once the topic was created, got %d records", len(records))
+	}
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fake
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The methods of the liiklus API, as passed to FailNext.
+const (
+	Publish       = "Publish"
+	Subscribe     = "Subscribe"
+	Receive       = "Receive"
+	Ack           = "Ack"
+	GetOffsets    = "GetOffsets"
+	GetEndOffsets = "GetEndOffsets"
+)
+
+// Faults describe the failures injected by a gateway, so that applications can test their retry and deduplication
+// logic against realistic failure modes.
+type Faults struct {
+	// Latency delays every call, and every record delivered to a receive stream.
+	Latency time.Duration
+	// ErrorRate is the probability, between 0 and 1, for a unary call such as Publish or Ack to fail with a transient
+	// codes.Unavailable error.
+	ErrorRate float64
+	// DropRate is the probability for a record not to be delivered to a receive stream. The record is still stored
+	// and may be delivered to other streams.
+	DropRate float64
+	// DuplicateRate is the probability for a record to be delivered twice in a row to a receive stream.
+	DuplicateRate float64
+	// Seed seeds the random draws, making them reproducible from run to run.
+	Seed int64
+}
+
+// WithFaults makes the gateway inject faults from the start.
+func WithFaults(f Faults) Option {
+	return func(g *Gateway) {
+		g.setFaults(f)
+	}
+}
+
+// SetFaults changes the faults injected by the gateway, for example to start or stop injecting them at some point of
+// a test. The zero Faults disables injection.
+func (g *Gateway) SetFaults(f Faults) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.setFaults(f)
+}
+
+// setFaults changes the faults injected by the gateway. It must be called with mu held.
+func (g *Gateway) setFaults(f Faults) {
+	g.faults = f
+	g.random = rand.New(rand.NewSource(f.Seed))
+}
+
+// FailNext makes the next n calls to method, one of Publish, Subscribe, Receive, Ack, GetOffsets or GetEndOffsets,
+// fail with code, regardless of the faults set.
+func (g *Gateway) FailNext(method string, n int, code codes.Code) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i := 0; i < n; i++ {
+		g.failures[method] = append(g.failures[method], code)
+	}
+}
+
+// inject applies the faults to a call to method, returning the error it must fail with, if any. unary tells whether
+// the call is subject to ErrorRate.
+func (g *Gateway) inject(ctx context.Context, method string, unary bool) error {
+	g.mu.Lock()
+	var err error
+	if failures := g.failures[method]; len(failures) > 0 {
+		err = status.Errorf(failures[0], "injected failure of %s", method)
+		g.failures[method] = failures[1:]
+	} else if unary && g.draw(g.faults.ErrorRate) {
+		err = status.Errorf(codes.Unavailable, "injected transient failure of %s", method)
+	}
+	latency := g.faults.Latency
+	g.mu.Unlock()
+	if sleepErr := sleep(ctx, latency); sleepErr != nil {
+		return sleepErr
+	}
+	return err
+}
+
+// deliveries returns how many times a record is to be delivered to a receive stream, after waiting for the latency.
+func (g *Gateway) deliveries(ctx context.Context) (int, error) {
+	g.mu.Lock()
+	n := 1
+	if g.draw(g.faults.DropRate) {
+		n = 0
+	} else if g.draw(g.faults.DuplicateRate) {
+		n = 2
+	}
+	latency := g.faults.Latency
+	g.mu.Unlock()
+	return n, sleep(ctx, latency)
+}
+
+// draw returns true with probability p. It must be called with mu held.
+func (g *Gateway) draw(p float64) bool {
+	return p > 0 && g.random.Float64() < p
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
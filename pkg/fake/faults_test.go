@@ -0,0 +1,191 @@
+package fake_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/fake"
+)
+
+func TestFailNext(t *testing.T) {
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("orders", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	gateway.FailNext(fake.Publish, 2, codes.Unavailable)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Publish(context.Background(), strings.NewReader("a"), nil, "text/plain", nil); !errors.Is(err, client.ErrGatewayUnavailable) {
+			t.Errorf("expected attempt %d to fail with an unavailable gateway, got: %v", i, err)
+		}
+	}
+	if _, err := c.Publish(context.Background(), strings.NewReader("a"), nil, "text/plain", nil); err != nil {
+		t.Errorf("expected publishing to succeed once the failures are exhausted, got: %v", err)
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	gateway := fake.NewGateway(fake.WithFaults(fake.Faults{ErrorRate: 0.5, Seed: 1}))
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("orders", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	failed := 0
+	for i := 0; i < 100; i++ {
+		if _, err := c.Publish(context.Background(), strings.NewReader("a"), nil, "text/plain", nil); err != nil {
+			failed++
+		}
+	}
+	if failed < 25 || failed > 75 {
+		t.Errorf("expected about half of the calls to fail, %d did", failed)
+	}
+	if stored := len(gateway.Records("orders")); stored != 100-failed {
+		t.Errorf("expected %d records to be stored, got %d", 100-failed, stored)
+	}
+
+	gateway.SetFaults(fake.Faults{})
+	if _, err := c.Publish(context.Background(), strings.NewReader("a"), nil, "text/plain", nil); err != nil {
+		t.Errorf("expected publishing to succeed once faults are disabled, got: %v", err)
+	}
+}
+
+func TestDuplicateAndDroppedRecords(t *testing.T) {
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("orders", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, payload := range []string{"a", "b"} {
+		if _, err := c.Publish(context.Background(), strings.NewReader(payload), nil, "text/plain", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	receive := func(faults fake.Faults) []string {
+		gateway.SetFaults(faults)
+		received := make(chan string, 10)
+		sub, err := c.SubscribeMessages(context.Background(), "", true, func(ctx context.Context, msg client.Message) error {
+			received <- string(msg.Payload)
+			return nil
+		}, func(cancel context.CancelFunc, err error) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Cancel()
+		var payloads []string
+		timeout := time.After(500 * time.Millisecond)
+		for {
+			select {
+			case payload := <-received:
+				payloads = append(payloads, payload)
+			case <-timeout:
+				return payloads
+			}
+		}
+	}
+
+	if payloads := receive(fake.Faults{DuplicateRate: 1}); strings.Join(payloads, "") != "aabb" {
+		t.Errorf("expected every record to be delivered twice, got %v", payloads)
+	}
+	if payloads := receive(fake.Faults{DropRate: 1}); len(payloads) != 0 {
+		t.Errorf("expected every record to be dropped, got %v", payloads)
+	}
+	if payloads := receive(fake.Faults{Latency: 10 * time.Millisecond}); strings.Join(payloads, "") != "ab" {
+		t.Errorf("expected every record to be delivered after some latency, got %v", payloads)
+	}
+}
+
+func TestPublishRetries(t *testing.T) {
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("orders", "text/plain", client.WithPublishRetries(3, client.ConstantBackoff(time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	gateway.FailNext(fake.Publish, 2, codes.Unavailable)
+	if _, err := c.Publish(context.Background(), strings.NewReader("a"), nil, "text/plain", nil); err != nil {
+		t.Errorf("expected publishing to be retried, got: %v", err)
+	}
+	gateway.FailNext(fake.Publish, 3, codes.Unavailable)
+	if _, err := c.Publish(context.Background(), strings.NewReader("b"), nil, "text/plain", nil); !errors.Is(err, client.ErrGatewayUnavailable) {
+		t.Errorf("expected publishing to fail once attempts are exhausted, got: %v", err)
+	}
+	gateway.FailNext(fake.Publish, 1, codes.InvalidArgument)
+	if _, err := c.Publish(context.Background(), strings.NewReader("c"), nil, "text/plain", nil); status.Code(errors.Unwrap(err)) != codes.InvalidArgument {
+		t.Errorf("expected other errors not to be retried, got: %v", err)
+	}
+	if records := gateway.Records("orders"); len(records) != 1 {
+		t.Errorf("expected 1 record, got %d", len(records))
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("orders", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Publish(context.Background(), strings.NewReader("a"), nil, "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway.FailNext(fake.Receive, 2, codes.Unavailable)
+	received := make(chan string, 1)
+	sub, err := c.SubscribeMessages(context.Background(), "group", true, func(ctx context.Context, msg client.Message) error {
+		received <- string(msg.Payload)
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		t.Errorf("expected the receive stream to be reopened, got: %v", err)
+	}, client.WithReconnectBackoff(2, client.ConstantBackoff(time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case payload := <-received:
+		if payload != "a" {
+			t.Errorf("unexpected payload %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	gateway.FailNext(fake.Receive, 3, codes.Unavailable)
+	failed, err := c.SubscribeMessages(context.Background(), "other", true, func(ctx context.Context, msg client.Message) error {
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		cancel()
+	}, client.WithReconnectBackoff(2, client.ConstantBackoff(time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-failed.Done():
+		if !errors.Is(failed.Err(), client.ErrGatewayUnavailable) {
+			t.Errorf("expected the subscription to fail with an unavailable gateway, got: %v", failed.Err())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the subscription to fail once reconnection attempts are exhausted")
+	}
+}
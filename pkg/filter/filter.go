@@ -0,0 +1,524 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package filter implements a small expression language for selecting messages read from a riff stream, so that
+// filtering rules can come from configuration rather than compiled code. Its syntax is a subset of CEL.
+//
+// Expressions are evaluated against the attributes of a message:
+//
+//	id, source, type, contenttype, key, topic, partition, offset    attributes of the event
+//	headers.<name> or headers["<name>"]                             custom headers
+//	data.<field>... or data["<field>"]...                           fields of a JSON payload
+//
+// and support string, number, boolean and null literals, comparisons (== != < <= > >=), boolean operators
+// (&& || !), parentheses, and the string methods startsWith, endsWith, contains and matches. For example:
+//
+//	type == "order.created" && data.total > 100 && headers.tenant.startsWith("acme")
+//
+// Missing headers and payload fields evaluate to null, comparisons involving JSON objects or arrays evaluate to false,
+// and a message is selected only if the expression evaluates to true.
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	client "github.com/projectriff/stream-client-go"
+)
+
+// Expression is a compiled filter expression.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression, which may then be used to select messages.
+func Compile(expression string) (*Expression, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	return &Expression{source: expression, root: root}, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be parsed.
+func MustCompile(expression string) *Expression {
+	e, err := Compile(expression)
+	if err != nil {
+		panic(fmt.Sprintf("filter: Compile(%q): %v", expression, err))
+	}
+	return e
+}
+
+// String returns the source text of the expression.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Match reports whether msg is selected by the expression. It has the signature expected by client.WithFilter.
+func (e *Expression) Match(msg client.Message) bool {
+	v := e.root.eval(&env{msg: msg})
+	b, ok := v.(bool)
+	return ok && b
+}
+
+// env is the evaluation environment of an expression for a given message.
+type env struct {
+	msg client.Message
+	// data is the decoded JSON payload, once decoded.
+	data    interface{}
+	decoded bool
+}
+
+func (e *env) payload() interface{} {
+	if !e.decoded {
+		e.decoded = true
+		if err := json.Unmarshal(e.msg.Payload, &e.data); err != nil {
+			e.data = nil
+		}
+	}
+	return e.data
+}
+
+// node is an element of the syntax tree of an expression.
+type node interface {
+	eval(e *env) interface{}
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n literal) eval(e *env) interface{} {
+	return n.value
+}
+
+// roots are the attributes of a message an expression may refer to.
+var roots = map[string]func(e *env) interface{}{
+	"id":          func(e *env) interface{} { return e.msg.ID },
+	"source":      func(e *env) interface{} { return e.msg.Source },
+	"type":        func(e *env) interface{} { return e.msg.Type },
+	"contenttype": func(e *env) interface{} { return e.msg.ContentType },
+	"key":         func(e *env) interface{} { return string(e.msg.Key) },
+	"topic":       func(e *env) interface{} { return e.msg.Topic },
+	"partition":   func(e *env) interface{} { return float64(e.msg.Partition) },
+	"offset":      func(e *env) interface{} { return float64(e.msg.Offset) },
+	"headers": func(e *env) interface{} {
+		headers := make(map[string]interface{}, len(e.msg.Headers))
+		for k, v := range e.msg.Headers {
+			headers[k] = v
+		}
+		return headers
+	},
+	"data": func(e *env) interface{} { return e.payload() },
+}
+
+type path struct {
+	root   string
+	fields []string
+}
+
+func (n path) eval(e *env) interface{} {
+	v := roots[n.root](e)
+	for _, field := range n.fields {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[field]
+	}
+	return v
+}
+
+type not struct {
+	operand node
+}
+
+func (n not) eval(e *env) interface{} {
+	b, ok := n.operand.eval(e).(bool)
+	return ok && !b
+}
+
+type logical struct {
+	op          string
+	left, right node
+}
+
+func (n logical) eval(e *env) interface{} {
+	l, _ := n.left.eval(e).(bool)
+	if n.op == "&&" && !l {
+		return false
+	}
+	if n.op == "||" && l {
+		return true
+	}
+	r, _ := n.right.eval(e).(bool)
+	return r
+}
+
+// scalar reports whether v is a JSON scalar, which are the only comparable values.
+func scalar(v interface{}) bool {
+	switch v.(type) {
+	case nil, bool, float64, string:
+		return true
+	}
+	return false
+}
+
+type comparison struct {
+	op          string
+	left, right node
+}
+
+func (n comparison) eval(e *env) interface{} {
+	l, r := n.left.eval(e), n.right.eval(e)
+	if !scalar(l) || !scalar(r) {
+		// objects and arrays are neither equal nor ordered
+		return false
+	}
+	switch n.op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	}
+	var c int
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case lv < rv:
+			c = -1
+		case lv > rv:
+			c = 1
+		}
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(lv, rv)
+	default:
+		return false
+	}
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+type method struct {
+	name   string
+	target node
+	arg    node
+	// re is the compiled pattern of matches calls with a literal argument.
+	re *regexp.Regexp
+}
+
+// methods are the string methods an expression may call, all of which take a single string argument.
+var methods = map[string]func(s, arg string) bool{
+	"startsWith": strings.HasPrefix,
+	"endsWith":   strings.HasSuffix,
+	"contains":   strings.Contains,
+	"matches": func(s, arg string) bool {
+		matched, err := regexp.MatchString(arg, s)
+		return err == nil && matched
+	},
+}
+
+func (n method) eval(e *env) interface{} {
+	s, ok := n.target.eval(e).(string)
+	if !ok {
+		return false
+	}
+	if n.re != nil {
+		return n.re.MatchString(s)
+	}
+	arg, ok := n.arg.eval(e).(string)
+	if !ok {
+		return false
+	}
+	return methods[n.name](s, arg)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+	// value is the decoded value of string literals.
+	value string
+}
+
+var puncts = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", ".", ",", "[", "]"}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	i := 0
+tokens:
+	for i < len(s) {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(s) && (s[i] == '_' || unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: s[start:i], pos: start})
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1]))):
+			start := i
+			i++
+			for i < len(s) && (unicode.IsDigit(rune(s[i])) || s[i] == '.' || s[i] == 'e' || s[i] == 'E') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: s[start:i], pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			var b strings.Builder
+			for i < len(s) && rune(s[i]) != c {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: s[start:i], pos: start, value: b.String()})
+		default:
+			for _, p := range puncts {
+				if strings.HasPrefix(s[i:], p) {
+					tokens = append(tokens, token{kind: tokenPunct, text: p, pos: i})
+					i += len(p)
+					continue tokens
+				}
+			}
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, text: "end of expression", pos: len(s)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(punct string) bool {
+	if t := p.peek(); t.kind == tokenPunct && t.text == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.accept(punct) {
+		t := p.peek()
+		return fmt.Errorf("expected %q but found %q at position %d", punct, t.text, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return comparison{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return literal{value: t.value}, nil
+	case tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return literal{value: f}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return literal{value: true}, nil
+		case "false":
+			return literal{value: false}, nil
+		case "null":
+			return literal{value: nil}, nil
+		}
+		if _, ok := roots[t.text]; !ok {
+			return nil, fmt.Errorf("unknown attribute %q at position %d", t.text, t.pos)
+		}
+		return p.parsePath(path{root: t.text})
+	case tokenPunct:
+		if t.text == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+// parsePath parses field accesses following the root of a path, and an optional trailing method call.
+func (p *parser) parsePath(n path) (node, error) {
+	for {
+		switch {
+		case p.accept("["):
+			t := p.next()
+			if t.kind != tokenString {
+				return nil, fmt.Errorf("expected a string but found %q at position %d", t.text, t.pos)
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n.fields = append(n.fields, t.value)
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokenIdent {
+				return nil, fmt.Errorf("expected a name but found %q at position %d", t.text, t.pos)
+			}
+			if p.accept("(") {
+				return p.parseMethod(t, n)
+			}
+			n.fields = append(n.fields, t.text)
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) parseMethod(name token, target node) (node, error) {
+	if _, ok := methods[name.text]; !ok {
+		return nil, fmt.Errorf("unknown method %q at position %d", name.text, name.pos)
+	}
+	arg, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	m := method{name: name.text, target: target, arg: arg}
+	if l, ok := arg.(literal); ok && name.text == "matches" {
+		pattern, _ := l.value.(string)
+		if m.re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern for %q at position %d: %v", name.text, name.pos, err)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,99 @@
+package filter_test
+
+import (
+	"testing"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/filter"
+)
+
+func TestMatch(t *testing.T) {
+	msg := client.Message{
+		ID:          "1234",
+		Type:        "order.created",
+		Source:      "shop",
+		ContentType: "application/json",
+		Payload:     []byte(`{"total": 150, "customer": {"name": "acme corp"}, "tags": ["a"]}`),
+		Headers:     map[string]string{"tenant": "acme", "x-region": "eu"},
+		Topic:       "orders",
+		Partition:   1,
+		Offset:      42,
+	}
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{`type == "order.created"`, true},
+		{`type != "order.created"`, false},
+		{`type == 'order.created' && data.total > 100`, true},
+		{`data.total >= 150 && data.total <= 150`, true},
+		{`data.total < 100 || headers.tenant == "acme"`, true},
+		{`!(data.total < 100)`, true},
+		{`data.customer.name.startsWith("acme")`, true},
+		{`data["customer"]["name"].endsWith("corp")`, true},
+		{`headers["x-region"] == "eu"`, true},
+		{`headers.missing == null`, true},
+		{`data.missing.deeper == null`, true},
+		{`data.missing > 1`, false},
+		{`source.contains("ho")`, true},
+		{`id.matches("^[0-9]+$")`, true},
+		{`topic == "orders" && partition == 1 && offset > 41`, true},
+		{`contenttype == "text/plain"`, false},
+		{`data.total`, false},
+		{`true`, true},
+	}
+	for _, test := range tests {
+		e, err := filter.Compile(test.expression)
+		if err != nil {
+			t.Errorf("unexpected error compiling %s: %v", test.expression, err)
+			continue
+		}
+		if actual := e.Match(msg); actual != test.expected {
+			t.Errorf("expected %s to evaluate to %v, but was %v", test.expression, test.expected, actual)
+		}
+	}
+}
+
+func TestMatchNonJSONPayload(t *testing.T) {
+	e := filter.MustCompile(`data.total > 1`)
+	if e.Match(client.Message{Payload: []byte("not json")}) {
+		t.Error("expected a non JSON payload not to match")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expression := range []string{
+		`unknown == 1`,
+		`type ==`,
+		`type == "unterminated`,
+		`(type == "a"`,
+		`type.unknown("a")`,
+		`id.matches("[")`,
+		`type == "a" "b"`,
+		`type # 1`,
+	} {
+		if _, err := filter.Compile(expression); err == nil {
+			t.Errorf("expected an error compiling %s", expression)
+		}
+	}
+}
+
+func TestMatchNonScalarOperands(t *testing.T) {
+	msg := client.Message{
+		ContentType: "application/json",
+		Payload:     []byte(`{"a": {"x": 1}, "b": {"x": 1}, "c": [1, 2], "d": [1, 2]}`),
+	}
+	for _, expression := range []string{
+		`data.a == data.b`,
+		`data.a != data.b`,
+		`data.c == data.d`,
+		`data.c != data.d`,
+		`data.a == data.c`,
+		`data.a == null`,
+		`data.c > 1`,
+	} {
+		if filter.MustCompile(expression).Match(msg) {
+			t.Errorf("expected %s not to match", expression)
+		}
+	}
+}
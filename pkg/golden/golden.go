@@ -0,0 +1,415 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package golden records the gRPC interactions of a client.StreamClient with its gateway to a golden file, and serves
+// them back later, so that publish and subscribe logic can be tested deterministically without a live gateway.
+//
+// In tests, NewStreamClient replays the golden file, unless the STREAM_GOLDEN_UPDATE environment variable is set to
+// true, in which case it talks to the gateway and records the interactions to the golden file once the test
+// completes:
+//
+//	c := golden.NewStreamClient(t, "testdata/orders.json", "localhost:6565", "orders", "application/json")
+package golden
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// UpdateEnv is the environment variable which, set to true, makes NewStreamClient record rather than replay.
+const UpdateEnv = "STREAM_GOLDEN_UPDATE"
+
+// The methods of the liiklus API.
+const (
+	publishMethod       = "/com.github.bsideup.liiklus.LiiklusService/Publish"
+	subscribeMethod     = "/com.github.bsideup.liiklus.LiiklusService/Subscribe"
+	receiveMethod       = "/com.github.bsideup.liiklus.LiiklusService/Receive"
+	ackMethod           = "/com.github.bsideup.liiklus.LiiklusService/Ack"
+	getOffsetsMethod    = "/com.github.bsideup.liiklus.LiiklusService/GetOffsets"
+	getEndOffsetsMethod = "/com.github.bsideup.liiklus.LiiklusService/GetEndOffsets"
+)
+
+// bufferSize is the size of the in-process connection buffers of a Replayer.
+const bufferSize = 1024 * 1024
+
+// file is the content of a golden file.
+type file struct {
+	Interactions []*interaction `json:"interactions"`
+}
+
+// interaction is a call to the gateway, with the messages exchanged and how it ended.
+type interaction struct {
+	Method    string            `json:"method"`
+	Requests  []json.RawMessage `json:"requests"`
+	Responses []json.RawMessage `json:"responses"`
+	// Code is the status code the call ended with.
+	Code    uint32 `json:"code"`
+	Message string `json:"message,omitempty"`
+
+	// used is set once the interaction has been replayed.
+	used bool
+}
+
+// err returns the error the interaction ended with, if any.
+func (i *interaction) err() error {
+	if codes.Code(i.Code) == codes.OK {
+		return nil
+	}
+	return status.Error(codes.Code(i.Code), i.Message)
+}
+
+// marshaler encodes recorded messages.
+var marshaler = jsonpb.Marshaler{OrigName: true}
+
+// encode returns the JSON representation of m.
+func encode(m interface{}) (json.RawMessage, error) {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message of type %T", m)
+	}
+	s, err := marshaler.MarshalToString(pm)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(s), nil
+}
+
+// Recorder records the interactions of clients with their gateway.
+type Recorder struct {
+	// mu guards interactions and err.
+	mu           sync.Mutex
+	interactions []*interaction
+	// err is the first error encountered while recording, reported by Save.
+	err error
+}
+
+// NewRecorder creates an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// ClientOption returns an option making a client record its interactions with the gateway to r.
+func (r *Recorder) ClientOption() client.ClientOption {
+	return client.WithDialOptions(
+		grpc.WithChainUnaryInterceptor(r.unary),
+		grpc.WithChainStreamInterceptor(r.stream),
+	)
+}
+
+// Save writes the interactions recorded so far to the golden file at path.
+func (r *Recorder) Save(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	b, err := json.MarshalIndent(file{Interactions: r.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// start records the beginning of a call to method. Streams are recorded as cancelled by the client until they end, so
+// that streams still open when the interactions are saved are kept open when replayed.
+func (r *Recorder) start(method string, stream bool) *interaction {
+	i := &interaction{Method: method, Requests: []json.RawMessage{}, Responses: []json.RawMessage{}}
+	if stream {
+		i.Code = uint32(codes.Canceled)
+	}
+	r.mu.Lock()
+	r.interactions = append(r.interactions, i)
+	r.mu.Unlock()
+	return i
+}
+
+// record appends m to the requests, or responses, of i.
+func (r *Recorder) record(i *interaction, m interface{}, request bool) {
+	msg, err := encode(m)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		return
+	}
+	if request {
+		i.Requests = append(i.Requests, msg)
+	} else {
+		i.Responses = append(i.Responses, msg)
+	}
+}
+
+// end records the error i ended with, if any.
+func (r *Recorder) end(i *interaction, err error) {
+	s := status.Convert(err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i.Code, i.Message = uint32(s.Code()), s.Message()
+}
+
+func (r *Recorder) unary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	i := r.start(method, false)
+	r.record(i, req, true)
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil {
+		r.record(i, reply, false)
+	}
+	r.end(i, err)
+	return err
+}
+
+func (r *Recorder) stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	i := r.start(method, true)
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		r.end(i, err)
+		return nil, err
+	}
+	return &recordedStream{ClientStream: s, recorder: r, interaction: i}, nil
+}
+
+// recordedStream is a grpc.ClientStream recording the messages it exchanges.
+type recordedStream struct {
+	grpc.ClientStream
+	recorder    *Recorder
+	interaction *interaction
+}
+
+func (s *recordedStream) SendMsg(m interface{}) error {
+	s.recorder.record(s.interaction, m, true)
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *recordedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		s.recorder.record(s.interaction, m, false)
+	case err == io.EOF:
+		s.recorder.end(s.interaction, nil)
+	default:
+		s.recorder.end(s.interaction, err)
+	}
+	return err
+}
+
+// Replayer serves recorded interactions back to clients. Each call is answered with the first interaction not
+// replayed yet of the same method whose requests are equal, or failing that with the first interaction not replayed
+// yet of the same method, as requests may hold values such as event IDs and times which differ from run to run.
+type Replayer struct {
+	listener *bufconn.Listener
+	server   *grpc.Server
+
+	// mu guards the used flag of interactions.
+	mu           sync.Mutex
+	interactions []*interaction
+}
+
+// Load reads the golden file at path and starts serving its interactions to clients created with NewStreamClient.
+// Close must be called once the replayer is no longer needed.
+func Load(path string) (*Replayer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("invalid golden file %s: %w", path, err)
+	}
+	r := &Replayer{
+		listener:     bufconn.Listen(bufferSize),
+		server:       grpc.NewServer(),
+		interactions: f.Interactions,
+	}
+	liiklus.RegisterLiiklusServiceServer(r.server, &replayServer{r})
+	go r.server.Serve(r.listener)
+	return r, nil
+}
+
+// NewStreamClient creates a client for topic, served by the replayer.
+func (r *Replayer) NewStreamClient(topic string, acceptableContentType string, opts ...client.ClientOption) (*client.StreamClient, error) {
+	dialer := grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return r.listener.Dial()
+	})
+	return client.NewStreamClient("golden", topic, acceptableContentType, append([]client.ClientOption{client.WithDialOptions(dialer)}, opts...)...)
+}
+
+// Close stops serving interactions.
+func (r *Replayer) Close() {
+	r.server.Stop()
+}
+
+// take returns the interaction answering a call to method with req, marking it as replayed.
+func (r *Replayer) take(method string, req proto.Message) (*interaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var fallback *interaction
+	for _, i := range r.interactions {
+		if i.used || i.Method != method {
+			continue
+		}
+		if fallback == nil {
+			fallback = i
+		}
+		if len(i.Requests) > 0 {
+			recorded := proto.Clone(req)
+			recorded.Reset()
+			if jsonpb.UnmarshalString(string(i.Requests[0]), recorded) == nil && proto.Equal(recorded, req) {
+				i.used = true
+				return i, nil
+			}
+		}
+	}
+	if fallback == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "no recorded interaction left for %s", method)
+	}
+	fallback.used = true
+	return fallback, nil
+}
+
+// replayServer implements the gRPC API on behalf of a replayer.
+type replayServer struct {
+	r *Replayer
+}
+
+// unary answers a call to method with req by filling reply with the recorded response.
+func (s *replayServer) unary(method string, req proto.Message, reply proto.Message) error {
+	i, err := s.r.take(method, req)
+	if err != nil {
+		return err
+	}
+	if len(i.Responses) > 0 {
+		if err := jsonpb.UnmarshalString(string(i.Responses[0]), reply); err != nil {
+			return status.Errorf(codes.Internal, "invalid recorded response for %s: %v", method, err)
+		}
+	}
+	return i.err()
+}
+
+// stream answers a streaming call to method with req by sending the recorded responses, created with newReply.
+func (s *replayServer) stream(method string, req proto.Message, stream grpc.ServerStream, newReply func() proto.Message) error {
+	i, err := s.r.take(method, req)
+	if err != nil {
+		return err
+	}
+	for _, response := range i.Responses {
+		reply := newReply()
+		if err := jsonpb.UnmarshalString(string(response), reply); err != nil {
+			return status.Errorf(codes.Internal, "invalid recorded response for %s: %v", method, err)
+		}
+		if err := stream.SendMsg(reply); err != nil {
+			return err
+		}
+	}
+	switch codes.Code(i.Code) {
+	case codes.Canceled, codes.DeadlineExceeded:
+		// the client ended the call while recording, keep the stream open until it does again
+		<-stream.Context().Done()
+		return nil
+	}
+	return i.err()
+}
+
+func (s *replayServer) Publish(ctx context.Context, request *liiklus.PublishRequest) (*liiklus.PublishReply, error) {
+	reply := &liiklus.PublishReply{}
+	return reply, s.unary(publishMethod, request, reply)
+}
+
+func (s *replayServer) Subscribe(request *liiklus.SubscribeRequest, stream liiklus.LiiklusService_SubscribeServer) error {
+	return s.stream(subscribeMethod, request, stream, func() proto.Message {
+		return &liiklus.SubscribeReply{}
+	})
+}
+
+func (s *replayServer) Receive(request *liiklus.ReceiveRequest, stream liiklus.LiiklusService_ReceiveServer) error {
+	return s.stream(receiveMethod, request, stream, func() proto.Message {
+		return &liiklus.ReceiveReply{}
+	})
+}
+
+func (s *replayServer) Ack(ctx context.Context, request *liiklus.AckRequest) (*empty.Empty, error) {
+	reply := &empty.Empty{}
+	return reply, s.unary(ackMethod, request, reply)
+}
+
+func (s *replayServer) GetOffsets(ctx context.Context, request *liiklus.GetOffsetsRequest) (*liiklus.GetOffsetsReply, error) {
+	reply := &liiklus.GetOffsetsReply{}
+	return reply, s.unary(getOffsetsMethod, request, reply)
+}
+
+func (s *replayServer) GetEndOffsets(ctx context.Context, request *liiklus.GetEndOffsetsRequest) (*liiklus.GetEndOffsetsReply, error) {
+	reply := &liiklus.GetEndOffsetsReply{}
+	return reply, s.unary(getEndOffsetsMethod, request, reply)
+}
+
+// NewStreamClient returns a client for topic replaying the golden file at path, or, if UpdateEnv is set to true,
+// connected to gateway and recording its interactions to the golden file once t completes.
+func NewStreamClient(t testing.TB, path string, gateway string, topic string, acceptableContentType string, opts ...client.ClientOption) *client.StreamClient {
+	t.Helper()
+	if update, _ := strconv.ParseBool(os.Getenv(UpdateEnv)); update {
+		recorder := NewRecorder()
+		c, err := client.NewStreamClient(gateway, topic, acceptableContentType, append(opts, recorder.ClientOption())...)
+		if err != nil {
+			t.Fatalf("unable to connect to %s: %v", gateway, err)
+		}
+		// cleanups run last in first out, the client is closed before the interactions are saved
+		t.Cleanup(func() {
+			if err := recorder.Save(path); err != nil {
+				t.Errorf("unable to save golden file %s: %v", path, err)
+			}
+		})
+		t.Cleanup(func() {
+			c.Close()
+		})
+		return c
+	}
+
+	replayer, err := Load(path)
+	if err != nil {
+		t.Fatalf("unable to load golden file: %v", err)
+	}
+	c, err := replayer.NewStreamClient(topic, acceptableContentType, opts...)
+	if err != nil {
+		replayer.Close()
+		t.Fatalf("unable to connect to the replayer: %v", err)
+	}
+	t.Cleanup(replayer.Close)
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return c
+}
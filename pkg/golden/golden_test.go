@@ -0,0 +1,96 @@
+package golden_test
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/fake"
+	"github.com/projectriff/stream-client-go/pkg/golden"
+)
+
+// exercise publishes two events and consumes them, returning the offsets they were published at and the payloads
+// received.
+func exercise(t *testing.T, c *client.StreamClient) ([]uint64, []string) {
+	var offsets []uint64
+	for _, payload := range []string{"a", "b"} {
+		result, err := c.Publish(context.Background(), strings.NewReader(payload), nil, "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, result.Offset)
+	}
+
+	received := make(chan string, 2)
+	sub, err := c.SubscribeMessages(context.Background(), "group", true, func(ctx context.Context, msg client.Message) error {
+		received <- string(msg.Payload)
+		return nil
+	}, func(cancel context.CancelFunc, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payloads []string
+	for len(payloads) < 2 {
+		select {
+		case payload := <-received:
+			payloads = append(payloads, payload)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events, got %v", payloads)
+		}
+	}
+	if err := sub.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(payloads)
+	return offsets, payloads
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golden.json")
+
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	recorder := golden.NewRecorder()
+	c, err := gateway.NewStreamClient("orders", "text/plain", recorder.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordedOffsets, recordedPayloads := exercise(t, c)
+	c.Close()
+	if err := recorder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := golden.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+	c, err = replayer.NewStreamClient("orders", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	offsets, payloads := exercise(t, c)
+	if len(offsets) != 2 || offsets[0] != recordedOffsets[0] || offsets[1] != recordedOffsets[1] {
+		t.Errorf("expected offsets %v to be replayed, got %v", recordedOffsets, offsets)
+	}
+	if strings.Join(payloads, ",") != strings.Join(recordedPayloads, ",") {
+		t.Errorf("expected events %v to be replayed, got %v", recordedPayloads, payloads)
+	}
+}
+
+func TestReplayUnrecordedCall(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golden.json")
+	if err := golden.NewRecorder().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	c := golden.NewStreamClient(t, path, "localhost:6565", "orders", "text/plain")
+	if _, err := c.Publish(context.Background(), strings.NewReader("a"), nil, "text/plain", nil); err == nil {
+		t.Errorf("expected publishing without a recorded interaction to fail")
+	}
+}
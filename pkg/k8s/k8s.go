@@ -0,0 +1,149 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package k8s resolves riff Stream custom resources to the gateway address, topic and content type of the stream, so
+// that applications can reference streams by namespace and name rather than hardcoding liiklus endpoints:
+//
+//	resolver, err := k8s.InClusterResolver()
+//	streamClient, err := resolver.NewStreamClient(ctx, "default", "orders")
+//
+// The Kubernetes API is called directly over HTTPS, with the credentials of the service account of the pod, which must
+// be allowed to get streams.
+package k8s
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	client "github.com/projectriff/stream-client-go"
+)
+
+// serviceAccountDir is where the credentials of the service account are mounted in pods.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// streamsPath is the path of the streams of a namespace in the Kubernetes API.
+const streamsPath = "/apis/streaming.projectriff.io/v1alpha1/namespaces/%s/streams/%s"
+
+// ErrStreamNotReady is returned when resolving a stream whose address is not known yet.
+var ErrStreamNotReady = errors.New("stream not ready")
+
+// Resolver resolves streams through the Kubernetes API.
+type Resolver struct {
+	// Host is the base URL of the Kubernetes API, such as https://10.0.0.1:443.
+	Host string
+	// Token is the bearer token authenticating requests, if not empty.
+	Token string
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// InClusterResolver returns a resolver using the service account of the pod it runs in.
+func InClusterResolver() (*Resolver, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificate found in the CA of the service account")
+	}
+	return &Resolver{
+		Host:  "https://" + net.JoinHostPort(host, port),
+		Token: strings.TrimSpace(string(token)),
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}},
+	}, nil
+}
+
+// stream is the subset of the Stream resource the resolver needs.
+type stream struct {
+	Spec struct {
+		ContentType string `json:"contentType"`
+	} `json:"spec"`
+	Status struct {
+		Address struct {
+			Gateway string `json:"gateway"`
+			Topic   string `json:"topic"`
+		} `json:"address"`
+	} `json:"status"`
+}
+
+// Resolve returns the configuration of the stream name in namespace. It returns ErrStreamNotReady if the stream has
+// no address yet.
+func (r *Resolver) Resolve(ctx context.Context, namespace string, name string) (client.Config, error) {
+	u := strings.TrimSuffix(r.Host, "/") + fmt.Sprintf(streamsPath, url.PathEscape(namespace), url.PathEscape(name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return client.Config{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+	httpClient := r.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return client.Config{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return client.Config{}, fmt.Errorf("unable to get stream %s/%s: %s: %s", namespace, name, res.Status, strings.TrimSpace(string(body)))
+	}
+	var s stream
+	if err := json.NewDecoder(res.Body).Decode(&s); err != nil {
+		return client.Config{}, fmt.Errorf("invalid stream %s/%s: %w", namespace, name, err)
+	}
+	if s.Status.Address.Gateway == "" || s.Status.Address.Topic == "" {
+		return client.Config{}, fmt.Errorf("stream %s/%s: %w", namespace, name, ErrStreamNotReady)
+	}
+	return client.Config{
+		Gateway:     s.Status.Address.Gateway,
+		Topic:       s.Status.Address.Topic,
+		ContentType: s.Spec.ContentType,
+	}, nil
+}
+
+// NewStreamClient resolves the stream name in namespace and connects to it.
+func (r *Resolver) NewStreamClient(ctx context.Context, namespace string, name string, opts ...client.ClientOption) (*client.StreamClient, error) {
+	config, err := r.Resolve(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewStreamClientFromConfig(config, opts...)
+}
@@ -0,0 +1,50 @@
+package k8s_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/k8s"
+)
+
+func TestResolve(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/apis/streaming.projectriff.io/v1alpha1/namespaces/default/streams/orders":
+			w.Write([]byte(`{"spec": {"contentType": "application/json"}, "status": {"address": {"gateway": "orders-gateway.default.svc.cluster.local:6565", "topic": "default_orders"}}}`))
+		case "/apis/streaming.projectriff.io/v1alpha1/namespaces/default/streams/pending":
+			w.Write([]byte(`{"spec": {"contentType": "application/json"}, "status": {}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+	resolver := &k8s.Resolver{Host: api.URL, Token: "secret"}
+
+	config, err := resolver.Resolve(context.Background(), "default", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := client.Config{Gateway: "orders-gateway.default.svc.cluster.local:6565", Topic: "default_orders", ContentType: "application/json"}
+	if config != expected {
+		t.Errorf("expected %+v, got %+v", expected, config)
+	}
+
+	if _, err := resolver.Resolve(context.Background(), "default", "pending"); !errors.Is(err, k8s.ErrStreamNotReady) {
+		t.Errorf("expected a stream without address not to be ready, got: %v", err)
+	}
+	if _, err := resolver.Resolve(context.Background(), "default", "missing"); err == nil {
+		t.Errorf("expected resolving a missing stream to fail")
+	}
+	if _, err := (&k8s.Resolver{Host: api.URL}).Resolve(context.Background(), "default", "orders"); err == nil {
+		t.Errorf("expected an unauthenticated request to fail")
+	}
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package loadgen publishes synthetic events at a target rate and size distribution, and reports the latency of
+// publishing them, for capacity testing liiklus gateways:
+//
+//	report, err := loadgen.Run(ctx, streamClient, loadgen.Config{Rate: 500, Count: 10000, MinSize: 100, MaxSize: 1000})
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	client "github.com/projectriff/stream-client-go"
+)
+
+// Config describes the load to generate.
+type Config struct {
+	// Rate is the target number of events published per second. A Rate of 0 publishes as fast as possible.
+	Rate float64
+	// Count is the number of events to publish. Publishing stops after Duration if it is reached first.
+	Count int
+	// Duration bounds the time spent publishing, if positive.
+	Duration time.Duration
+	// Concurrency is the number of events that may be being published at once, 1 by default.
+	Concurrency int
+	// MinSize and MaxSize bound the size of payloads in bytes, sizes being uniformly distributed between them. A
+	// MaxSize lower than MinSize is taken to be MinSize.
+	MinSize int
+	MaxSize int
+	// Keys is the number of distinct keys events are published with, spreading them over partitions. Events are
+	// published without a key if 0.
+	Keys int
+	// ContentType is the content type of events, text/plain by default.
+	ContentType string
+	// Seed seeds the generation of payloads and keys.
+	Seed int64
+}
+
+// Report summarizes a run.
+type Report struct {
+	// Published is the number of events successfully published.
+	Published int
+	// Errors is the number of events which failed to be published.
+	Errors int
+	// FirstError is the first publishing error, if any.
+	FirstError error
+	// Bytes is the total size of the payloads successfully published.
+	Bytes int64
+	// Elapsed is the duration of the run.
+	Elapsed time.Duration
+	// Latencies are percentiles of the time spent publishing each event successfully.
+	Latencies Percentiles
+}
+
+// Percentiles of a distribution of latencies.
+type Percentiles struct {
+	P50, P90, P99, Max time.Duration
+}
+
+// Throughput is the number of events successfully published per second.
+func (r Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Published) / r.Elapsed.Seconds()
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "published %d events (%d bytes) in %s, %.1f events/s, %d errors\n", r.Published, r.Bytes, r.Elapsed.Round(time.Millisecond), r.Throughput(), r.Errors)
+	fmt.Fprintf(&b, "latency p50 %s, p90 %s, p99 %s, max %s", r.Latencies.P50, r.Latencies.P90, r.Latencies.P99, r.Latencies.Max)
+	if r.FirstError != nil {
+		fmt.Fprintf(&b, "\nfirst error: %v", r.FirstError)
+	}
+	return b.String()
+}
+
+// event is an event to publish.
+type event struct {
+	payload []byte
+	key     []byte
+}
+
+// letters make up payloads, so that they are valid for any textual content type.
+const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// Run publishes events through p as described by config, until done or ctx is done.
+func Run(ctx context.Context, p client.Publisher, config Config) (Report, error) {
+	if config.Count <= 0 && config.Duration <= 0 {
+		return Report{}, errors.New("either a count or a duration is required")
+	}
+	if config.Rate < 0 || config.MinSize < 0 {
+		return Report{}, errors.New("rate and sizes must not be negative")
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	if config.MaxSize < config.MinSize {
+		config.MaxSize = config.MinSize
+	}
+	if config.ContentType == "" {
+		config.ContentType = "text/plain"
+	}
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+
+	events := make(chan event)
+	go generate(ctx, config, events)
+
+	var mu sync.Mutex
+	var report Report
+	var latencies []time.Duration
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range events {
+				var key io.Reader
+				if e.key != nil {
+					key = bytes.NewReader(e.key)
+				}
+				begin := time.Now()
+				_, err := p.Publish(ctx, bytes.NewReader(e.payload), key, config.ContentType, nil)
+				latency := time.Since(begin)
+				mu.Lock()
+				if err != nil {
+					if ctx.Err() == nil {
+						report.Errors++
+						if report.FirstError == nil {
+							report.FirstError = err
+						}
+					}
+				} else {
+					report.Published++
+					report.Bytes += int64(len(e.payload))
+					latencies = append(latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	report.Latencies = percentiles(latencies)
+	return report, nil
+}
+
+// generate sends the events to publish to events, paced at the target rate, and closes it once done.
+func generate(ctx context.Context, config Config, events chan<- event) {
+	defer close(events)
+	random := rand.New(rand.NewSource(config.Seed))
+	var interval time.Duration
+	if config.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / config.Rate)
+	}
+	start := time.Now()
+	for i := 0; config.Count <= 0 || i < config.Count; i++ {
+		if interval > 0 {
+			// pace against the start time rather than the previous event, so that delays don't accumulate
+			if wait := time.Until(start.Add(time.Duration(i) * interval)); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+			}
+		}
+		size := config.MinSize
+		if config.MaxSize > config.MinSize {
+			size += random.Intn(config.MaxSize - config.MinSize + 1)
+		}
+		e := event{payload: make([]byte, size)}
+		for j := range e.payload {
+			e.payload[j] = letters[random.Intn(len(letters))]
+		}
+		if config.Keys > 0 {
+			e.key = []byte(fmt.Sprintf("key-%d", random.Intn(config.Keys)))
+		}
+		select {
+		case events <- e:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// percentiles computes the percentiles of latencies, using the nearest rank method.
+func percentiles(latencies []time.Duration) Percentiles {
+	if len(latencies) == 0 {
+		return Percentiles{}
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	rank := func(p float64) time.Duration {
+		i := int(p*float64(len(latencies))+0.5) - 1
+		if i < 0 {
+			i = 0
+		}
+		if i >= len(latencies) {
+			i = len(latencies) - 1
+		}
+		return latencies[i]
+	}
+	return Percentiles{P50: rank(0.5), P90: rank(0.9), P99: rank(0.99), Max: latencies[len(latencies)-1]}
+}
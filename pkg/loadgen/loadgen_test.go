@@ -0,0 +1,80 @@
+package loadgen_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/projectriff/stream-client-go/pkg/fake"
+	"github.com/projectriff/stream-client-go/pkg/loadgen"
+)
+
+func TestRun(t *testing.T) {
+	gateway := fake.NewGateway(fake.WithPartitions(2))
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("load", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	report, err := loadgen.Run(context.Background(), c, loadgen.Config{
+		Rate:        500,
+		Count:       50,
+		Concurrency: 4,
+		MinSize:     10,
+		MaxSize:     20,
+		Keys:        3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Published != 50 || report.Errors != 0 {
+		t.Fatalf("expected 50 events to be published without error, got %v", report)
+	}
+	if report.Bytes < 500 || report.Bytes > 1000 {
+		t.Errorf("expected payloads of 10 to 20 bytes, got %d bytes in total", report.Bytes)
+	}
+	// 50 events at 500 per second take about 100ms
+	if report.Elapsed < 90*time.Millisecond {
+		t.Errorf("expected publishing to be paced, it took %s", report.Elapsed)
+	}
+	l := report.Latencies
+	if l.P50 <= 0 || l.P50 > l.P90 || l.P90 > l.P99 || l.P99 > l.Max {
+		t.Errorf("expected ordered percentiles, got %+v", l)
+	}
+
+	records := gateway.Records("load")
+	if len(records) != 50 {
+		t.Fatalf("expected 50 records, got %d", len(records))
+	}
+	keys := map[string]bool{}
+	for _, record := range records {
+		keys[string(record.Key)] = true
+	}
+	if len(keys) > 3 {
+		t.Errorf("expected at most 3 distinct keys, got %v", keys)
+	}
+}
+
+func TestRunDuration(t *testing.T) {
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("load", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	report, err := loadgen.Run(context.Background(), c, loadgen.Config{Rate: 100, Duration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Published < 10 || report.Published > 25 || report.Errors != 0 {
+		t.Errorf("expected about 20 events to be published, got %v", report)
+	}
+
+	if _, err := loadgen.Run(context.Background(), c, loadgen.Config{Rate: 100}); err == nil {
+		t.Errorf("expected a run without count nor duration to be rejected")
+	}
+}
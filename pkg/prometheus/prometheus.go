@@ -0,0 +1,190 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package prometheus exports the activity of a client.StreamClient as Prometheus metrics.
+//
+// A Collector is both a prometheus.Collector, to be registered on the registry of the application, and a
+// client.StatsHandler, to be installed on the clients it reports on:
+//
+//	collector := prometheus.NewCollector(prometheus.WithLag(streamClient, "my-group"))
+//	streamClient.SetStatsHandler(collector)
+//	registry.MustRegister(collector)
+package prometheus
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	client "github.com/projectriff/stream-client-go"
+)
+
+// lagTimeout bounds the time spent fetching the lag of consumer groups when collecting metrics.
+const lagTimeout = 5 * time.Second
+
+// Collector collects metrics about publishing, consuming, handling and committing events, as well as the lag of
+// consumer groups.
+type Collector struct {
+	published       *prom.CounterVec
+	publishErrors   *prom.CounterVec
+	publishDuration *prom.HistogramVec
+	received        *prom.CounterVec
+	handled         *prom.CounterVec
+	handlerErrors   *prom.CounterVec
+	handlerDuration *prom.HistogramVec
+	ackFailures     *prom.CounterVec
+	reconnects      *prom.CounterVec
+	errors          *prom.CounterVec
+	lag             *prom.Desc
+
+	namespace string
+	lagClient *client.StreamClient
+	lagGroups []string
+}
+
+// Option configures a Collector.
+type Option func(*Collector)
+
+// WithNamespace prefixes the names of the metrics with namespace, rather than the default "stream_client".
+func WithNamespace(namespace string) Option {
+	return func(c *Collector) {
+		c.namespace = namespace
+	}
+}
+
+// WithLag reports the lag of the given consumer groups on the stream of lc, per partition. The lag is fetched from the
+// gateway each time metrics are collected.
+func WithLag(lc *client.StreamClient, groups ...string) Option {
+	return func(c *Collector) {
+		c.lagClient = lc
+		c.lagGroups = groups
+	}
+}
+
+// NewCollector creates a Collector.
+func NewCollector(opts ...Option) *Collector {
+	c := &Collector{namespace: "stream_client"}
+	for _, opt := range opts {
+		opt(c)
+	}
+	topic, group := []string{"topic"}, []string{"topic", "group"}
+	c.published = prom.NewCounterVec(prom.CounterOpts{
+		Namespace: c.namespace, Name: "published_total", Help: "Number of events published.",
+	}, topic)
+	c.publishErrors = prom.NewCounterVec(prom.CounterOpts{
+		Namespace: c.namespace, Name: "publish_errors_total", Help: "Number of events which failed to be published.",
+	}, topic)
+	c.publishDuration = prom.NewHistogramVec(prom.HistogramOpts{
+		Namespace: c.namespace, Name: "publish_duration_seconds", Help: "Time spent publishing events.",
+	}, topic)
+	c.received = prom.NewCounterVec(prom.CounterOpts{
+		Namespace: c.namespace, Name: "received_total", Help: "Number of messages received by subscriptions.",
+	}, group)
+	c.handled = prom.NewCounterVec(prom.CounterOpts{
+		Namespace: c.namespace, Name: "handled_total", Help: "Number of messages handed over to handlers.",
+	}, group)
+	c.handlerErrors = prom.NewCounterVec(prom.CounterOpts{
+		Namespace: c.namespace, Name: "handler_errors_total", Help: "Number of handler invocations which failed.",
+	}, group)
+	c.handlerDuration = prom.NewHistogramVec(prom.HistogramOpts{
+		Namespace: c.namespace, Name: "handler_duration_seconds", Help: "Time spent in handler invocations.",
+	}, group)
+	c.ackFailures = prom.NewCounterVec(prom.CounterOpts{
+		Namespace: c.namespace, Name: "ack_failures_total", Help: "Number of offset commits which failed.",
+	}, group)
+	c.reconnects = prom.NewCounterVec(prom.CounterOpts{
+		Namespace: c.namespace, Name: "reconnects_total", Help: "Number of receive streams re-established.",
+	}, group)
+	c.errors = prom.NewCounterVec(prom.CounterOpts{
+		Namespace: c.namespace, Name: "errors_total", Help: "Number of errors reported to subscription error handlers.",
+	}, []string{"group"})
+	c.lag = prom.NewDesc(prom.BuildFQName(c.namespace, "", "lag"),
+		"Number of events of a partition not yet committed by a consumer group.", []string{"topic", "group", "partition"}, nil)
+	return c
+}
+
+// collectors are the metrics maintained by c as the client reports its activity.
+func (c *Collector) collectors() []prom.Collector {
+	return []prom.Collector{
+		c.published, c.publishErrors, c.publishDuration, c.received, c.handled, c.handlerErrors, c.handlerDuration,
+		c.ackFailures, c.reconnects, c.errors,
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+	ch <- c.lag
+}
+
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+	if c.lagClient == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lagTimeout)
+	defer cancel()
+	for _, group := range c.lagGroups {
+		lag, err := c.lagClient.Lag(ctx, group)
+		if err != nil {
+			ch <- prom.NewInvalidMetric(c.lag, err)
+			continue
+		}
+		for partition, l := range lag {
+			ch <- prom.MustNewConstMetric(c.lag, prom.GaugeValue, float64(l), c.lagClient.TopicName, group, strconv.Itoa(int(partition)))
+		}
+	}
+}
+
+func (c *Collector) OnPublish(stats client.PublishStats) {
+	if stats.Err != nil {
+		c.publishErrors.WithLabelValues(stats.Topic).Inc()
+		return
+	}
+	c.published.WithLabelValues(stats.Topic).Inc()
+	c.publishDuration.WithLabelValues(stats.Topic).Observe(stats.Duration.Seconds())
+}
+
+func (c *Collector) OnReceive(stats client.ReceiveStats) {
+	c.received.WithLabelValues(stats.Topic, stats.Group).Inc()
+}
+
+func (c *Collector) OnHandle(stats client.HandleStats) {
+	c.handled.WithLabelValues(stats.Topic, stats.Group).Add(float64(stats.Count))
+	c.handlerDuration.WithLabelValues(stats.Topic, stats.Group).Observe(stats.Duration.Seconds())
+	if stats.Err != nil {
+		c.handlerErrors.WithLabelValues(stats.Topic, stats.Group).Inc()
+	}
+}
+
+func (c *Collector) OnAck(stats client.AckStats) {
+	if stats.Err != nil {
+		c.ackFailures.WithLabelValues(stats.Topic, stats.Group).Inc()
+	}
+}
+
+func (c *Collector) OnReconnect(stats client.ReconnectStats) {
+	c.reconnects.WithLabelValues(stats.Topic, stats.Group).Inc()
+}
+
+func (c *Collector) OnError(stats client.ErrorStats) {
+	c.errors.WithLabelValues(stats.Group).Inc()
+}
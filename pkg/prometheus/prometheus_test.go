@@ -0,0 +1,88 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prometheus_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/prometheus"
+)
+
+var _ client.StatsHandler = &prometheus.Collector{}
+
+func TestCollector(t *testing.T) {
+	c := prometheus.NewCollector(prometheus.WithNamespace("test"))
+	registry := prom.NewPedanticRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.OnPublish(client.PublishStats{Topic: "orders", Duration: 10 * time.Millisecond})
+	c.OnPublish(client.PublishStats{Topic: "orders", Duration: 10 * time.Millisecond, Err: errors.New("unavailable")})
+	c.OnReceive(client.ReceiveStats{Topic: "orders", Group: "billing"})
+	c.OnReceive(client.ReceiveStats{Topic: "orders", Group: "billing"})
+	c.OnHandle(client.HandleStats{Topic: "orders", Group: "billing", Count: 2, Duration: 5 * time.Millisecond})
+	c.OnHandle(client.HandleStats{Topic: "orders", Group: "billing", Count: 1, Duration: 5 * time.Millisecond, Err: errors.New("boom")})
+	c.OnAck(client.AckStats{Topic: "orders", Group: "billing"})
+	c.OnAck(client.AckStats{Topic: "orders", Group: "billing", Err: errors.New("unavailable")})
+	c.OnReconnect(client.ReconnectStats{Topic: "orders", Group: "billing"})
+	c.OnError(client.ErrorStats{Group: "billing", Err: errors.New("boom")})
+
+	expected := `
+# HELP test_ack_failures_total Number of offset commits which failed.
+# TYPE test_ack_failures_total counter
+test_ack_failures_total{group="billing",topic="orders"} 1
+# HELP test_errors_total Number of errors reported to subscription error handlers.
+# TYPE test_errors_total counter
+test_errors_total{group="billing"} 1
+# HELP test_handled_total Number of messages handed over to handlers.
+# TYPE test_handled_total counter
+test_handled_total{group="billing",topic="orders"} 3
+# HELP test_handler_errors_total Number of handler invocations which failed.
+# TYPE test_handler_errors_total counter
+test_handler_errors_total{group="billing",topic="orders"} 1
+# HELP test_publish_errors_total Number of events which failed to be published.
+# TYPE test_publish_errors_total counter
+test_publish_errors_total{topic="orders"} 1
+# HELP test_published_total Number of events published.
+# TYPE test_published_total counter
+test_published_total{topic="orders"} 1
+# HELP test_received_total Number of messages received by subscriptions.
+# TYPE test_received_total counter
+test_received_total{group="billing",topic="orders"} 2
+# HELP test_reconnects_total Number of receive streams re-established.
+# TYPE test_reconnects_total counter
+test_reconnects_total{group="billing",topic="orders"} 1
+`
+	names := []string{
+		"test_ack_failures_total", "test_errors_total", "test_handled_total", "test_handler_errors_total", "test_publish_errors_total",
+		"test_published_total", "test_received_total", "test_reconnects_total",
+	}
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+	if count := testutil.CollectAndCount(c); count != 10 {
+		t.Errorf("expected 10 metrics, but got %d", count)
+	}
+}
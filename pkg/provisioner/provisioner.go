@@ -0,0 +1,116 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package provisioner creates and looks up streams through the REST endpoint of a riff stream provisioner, so that
+// end-to-end tests and tools can create streams on demand:
+//
+//	p := provisioner.New("http://kafka-provisioner.riff-system.svc.cluster.local")
+//	streamClient, err := p.NewStreamClient(ctx, "default", "orders", "application/json")
+//
+// The provisioner creates the topic backing a stream when sent PUT /namespace/name, and describes the stream in
+// response to GET /namespace/name, both replying with the address of the stream as a JSON object holding its gateway
+// and topic.
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	client "github.com/projectriff/stream-client-go"
+)
+
+// ErrNotFound is returned when looking up a stream the provisioner doesn't know about.
+var ErrNotFound = errors.New("stream not found")
+
+// Address locates the topic backing a stream.
+type Address struct {
+	// Gateway is the host:port of the liiklus gateway serving the stream.
+	Gateway string `json:"gateway"`
+	// Topic is the topic backing the stream.
+	Topic string `json:"topic"`
+}
+
+// Provisioner is a client of the REST endpoint of a stream provisioner.
+type Provisioner struct {
+	// URL is the base URL of the provisioner.
+	URL string
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// New returns a client of the provisioner at url.
+func New(url string) *Provisioner {
+	return &Provisioner{URL: url}
+}
+
+// Create provisions the stream name in namespace, if it doesn't exist yet, and returns its address.
+func (p *Provisioner) Create(ctx context.Context, namespace string, name string) (Address, error) {
+	return p.do(ctx, http.MethodPut, namespace, name)
+}
+
+// Lookup returns the address of the stream name in namespace, or ErrNotFound if it has not been provisioned.
+func (p *Provisioner) Lookup(ctx context.Context, namespace string, name string) (Address, error) {
+	return p.do(ctx, http.MethodGet, namespace, name)
+}
+
+// NewStreamClient provisions the stream name in namespace, if needed, and connects to it.
+func (p *Provisioner) NewStreamClient(ctx context.Context, namespace string, name string, contentType string, opts ...client.ClientOption) (*client.StreamClient, error) {
+	address, err := p.Create(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewStreamClient(address.Gateway, address.Topic, contentType, opts...)
+}
+
+// do sends a request for the stream name in namespace, returning the address in the response.
+func (p *Provisioner) do(ctx context.Context, method string, namespace string, name string) (Address, error) {
+	u := fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(p.URL, "/"), url.PathEscape(namespace), url.PathEscape(name))
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return Address{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return Address{}, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound && method == http.MethodGet:
+		return Address{}, fmt.Errorf("%s/%s: %w", namespace, name, ErrNotFound)
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		body, _ := ioutil.ReadAll(res.Body)
+		return Address{}, fmt.Errorf("unable to provision stream %s/%s: %s: %s", namespace, name, res.Status, strings.TrimSpace(string(body)))
+	}
+	var address Address
+	if err := json.NewDecoder(res.Body).Decode(&address); err != nil {
+		return Address{}, fmt.Errorf("invalid address of stream %s/%s: %w", namespace, name, err)
+	}
+	if address.Gateway == "" || address.Topic == "" {
+		return Address{}, fmt.Errorf("incomplete address of stream %s/%s: %+v", namespace, name, address)
+	}
+	return address, nil
+}
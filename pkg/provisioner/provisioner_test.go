@@ -0,0 +1,62 @@
+package provisioner_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/projectriff/stream-client-go/pkg/provisioner"
+)
+
+func TestProvisioner(t *testing.T) {
+	var mu sync.Mutex
+	streams := map[string]provisioner.Address{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			streams[r.URL.Path] = provisioner.Address{Gateway: "liiklus:6565", Topic: parts[0] + "_" + parts[1]}
+		case http.MethodGet:
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		address, ok := streams[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(address)
+	}))
+	defer server.Close()
+	p := provisioner.New(server.URL)
+
+	if _, err := p.Lookup(context.Background(), "default", "orders"); !errors.Is(err, provisioner.ErrNotFound) {
+		t.Errorf("expected looking up a stream not provisioned yet to fail with ErrNotFound, got: %v", err)
+	}
+	address, err := p.Create(context.Background(), "default", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := provisioner.Address{Gateway: "liiklus:6565", Topic: "default_orders"}
+	if address != expected {
+		t.Errorf("expected %+v, got %+v", expected, address)
+	}
+	if address, err := p.Lookup(context.Background(), "default", "orders"); err != nil || address != expected {
+		t.Errorf("expected %+v to be looked up, got %+v, %v", expected, address, err)
+	}
+	if _, err := p.Create(context.Background(), "default", "a/b"); err == nil {
+		t.Errorf("expected the provisioner to reject the request")
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package streamtest helps writing integration tests against a real liiklus gateway. It runs liiklus, with in-memory
+// record and position storage, in a Docker container, so that tests can run locally without any other setup:
+//
+//	func TestOrders(t *testing.T) {
+//		h := streamtest.New(t)
+//		c := h.Client("text/plain")
+//		streamtest.PublishString(t, c, "hello")
+//		msg := streamtest.AwaitEvent(t, c, 10*time.Second, nil)
+//	}
+//
+// Setting the STREAM_GATEWAY environment variable to the host:port of a running gateway uses it instead of starting a
+// container. Tests are skipped when neither is available.
+package streamtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	client "github.com/projectriff/stream-client-go"
+)
+
+// GatewayEnv is the environment variable holding the host:port of a gateway to use rather than starting a container.
+const GatewayEnv = "STREAM_GATEWAY"
+
+// DefaultImage is the liiklus image started by Start.
+const DefaultImage = "bsideup/liiklus:0.10.0-rc1"
+
+// startTimeout bounds the time spent starting a container.
+const startTimeout = 2 * time.Minute
+
+// Container is a liiklus gateway running in a Docker container.
+type Container struct {
+	// ID is the ID of the container.
+	ID string
+	// Gateway is the host:port the gRPC API of the gateway is exposed on.
+	Gateway string
+}
+
+// Start runs image, or DefaultImage if empty, in a new container with in-memory storage. The container must be
+// stopped with Stop once no longer needed.
+func Start(ctx context.Context, image string) (*Container, error) {
+	if image == "" {
+		image = DefaultImage
+	}
+	out, err := docker(ctx, "run", "--detach", "--rm", "--publish", "127.0.0.1::6565",
+		"--env", "storage_positions_type=MEMORY",
+		"--env", "storage_records_type=MEMORY",
+		image)
+	if err != nil {
+		return nil, err
+	}
+	c := &Container{ID: out}
+	port, err := docker(ctx, "port", c.ID, "6565/tcp")
+	if err != nil {
+		c.Stop()
+		return nil, err
+	}
+	// the port may be reported for several interfaces, one per line
+	c.Gateway = strings.SplitN(port, "\n", 2)[0]
+	return c, nil
+}
+
+// Stop removes the container.
+func (c *Container) Stop() error {
+	_, err := docker(context.Background(), "rm", "--force", c.ID)
+	return err
+}
+
+// docker runs the docker CLI with args, returning its trimmed output.
+func docker(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Harness gives a test access to a gateway, which is released when the test completes.
+type Harness struct {
+	t       testing.TB
+	gateway string
+}
+
+// New returns a harness for t, using the gateway designated by GatewayEnv if set, or else starting a container which
+// is stopped when t completes. t is skipped if no gateway is available.
+func New(t testing.TB) *Harness {
+	t.Helper()
+	if gateway := os.Getenv(GatewayEnv); gateway != "" {
+		return &Harness{t: t, gateway: gateway}
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skipf("neither %s nor docker is available", GatewayEnv)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+	c, err := Start(ctx, "")
+	if err != nil {
+		t.Fatalf("unable to start liiklus: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := c.Stop(); err != nil {
+			t.Errorf("unable to stop liiklus: %v", err)
+		}
+	})
+	return &Harness{t: t, gateway: c.Gateway}
+}
+
+// Gateway returns the host:port of the gateway.
+func (h *Harness) Gateway() string {
+	return h.gateway
+}
+
+// Topic returns a topic name unique to this test. Liiklus creates topics on first use, so no further provisioning is
+// needed.
+func (h *Harness) Topic() string {
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(h.t.Name())
+	return fmt.Sprintf("%s_%s", name, uuid.New().String()[:8])
+}
+
+// Client connects to a new topic, as returned by Topic, accepting contentType. The client is closed when the test
+// completes.
+func (h *Harness) Client(contentType string, opts ...client.ClientOption) *client.StreamClient {
+	h.t.Helper()
+	c, err := client.NewStreamClient(h.gateway, h.Topic(), contentType, opts...)
+	if err != nil {
+		h.t.Fatalf("unable to connect to %s: %v", h.gateway, err)
+	}
+	h.t.Cleanup(func() {
+		c.Close()
+	})
+	return c
+}
+
+// PublishString publishes payload as a text/plain event through p, failing t if it can't.
+func PublishString(t testing.TB, p client.Publisher, payload string) client.PublishResult {
+	t.Helper()
+	result, err := p.Publish(context.Background(), strings.NewReader(payload), nil, "text/plain", nil)
+	if err != nil {
+		t.Fatalf("unable to publish %q: %v", payload, err)
+	}
+	return result
+}
+
+// AwaitEvent reads the stream of s from the beginning, through an anonymous subscription, until a message matching
+// match is found, which is returned. A nil match accepts any message. t fails if none is found within timeout.
+func AwaitEvent(t testing.TB, s client.Subscriber, timeout time.Duration, match func(client.Message) bool) client.Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	found := make(chan client.Message, 1)
+	errs := make(chan error, 1)
+	sub, err := s.SubscribeMessages(ctx, "", true, func(ctx context.Context, msg client.Message) error {
+		if match == nil || match(msg) {
+			select {
+			case found <- msg:
+			default:
+			}
+		}
+		return nil
+	}, func(cancel context.CancelFunc, err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatalf("unable to subscribe: %v", err)
+	}
+	defer func() {
+		sub.Cancel()
+		<-sub.Done()
+	}()
+	select {
+	case msg := <-found:
+		return msg
+	case err := <-errs:
+		if ctx.Err() == nil {
+			t.Fatalf("subscription failed while awaiting event: %v", err)
+		}
+	case <-ctx.Done():
+	}
+	t.Fatalf("no matching event received within %s", timeout)
+	return client.Message{}
+}
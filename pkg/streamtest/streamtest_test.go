@@ -0,0 +1,23 @@
+package streamtest_test
+
+import (
+	"testing"
+	"time"
+
+	client "github.com/projectriff/stream-client-go"
+	"github.com/projectriff/stream-client-go/pkg/streamtest"
+)
+
+func TestHarness(t *testing.T) {
+	h := streamtest.New(t)
+	c := h.Client("text/plain")
+
+	streamtest.PublishString(t, c, "first")
+	result := streamtest.PublishString(t, c, "second")
+	msg := streamtest.AwaitEvent(t, c, 10*time.Second, func(msg client.Message) bool {
+		return string(msg.Payload) == "second"
+	})
+	if msg.Partition != result.Partition || msg.Offset != result.Offset {
+		t.Errorf("expected the event published at %d/%d, got %d/%d", result.Partition, result.Offset, msg.Partition, msg.Offset)
+	}
+}
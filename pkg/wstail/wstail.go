@@ -0,0 +1,220 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package wstail streams the events of a riff stream to WebSocket clients such as browsers, for building live
+// dashboards:
+//
+//	http.Handle("/tail", wstail.New(streamClient))
+//
+// Each connection tails the stream on its own, as an anonymous subscription starting from the end of the stream, or
+// from its beginning when connecting with the from=beginning query parameter. Events are sent as text messages holding
+// a JSON encoded Frame. The Resume token of the last frame received can be passed back with the resume query parameter
+// when reconnecting, to pick up where the previous connection left off.
+package wstail
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	client "github.com/projectriff/stream-client-go"
+)
+
+// Frame is the message sent to WebSocket clients for each event.
+type Frame struct {
+	// Partition is the partition of the stream the event was read from.
+	Partition uint32 `json:"partition"`
+	// Offset is the position of the event in its partition.
+	Offset uint64 `json:"offset"`
+	// Resume is an opaque token resuming the stream right after this event when passed as the resume query
+	// parameter.
+	Resume string `json:"resume"`
+	// Event is the event as a CloudEvent in JSON format, as written by client.CloudEventJSON.
+	Event json.RawMessage `json:"event"`
+}
+
+// Tailer is an http.Handler upgrading requests to WebSocket connections which are sent the events of a stream.
+type Tailer struct {
+	subscriber   client.Subscriber
+	upgrader     websocket.Upgrader
+	writeTimeout time.Duration
+	opts         []client.SubscribeOption
+}
+
+// Option configures optional behavior of a Tailer.
+type Option func(*Tailer)
+
+// WithCheckOrigin accepts the connections for which check returns true. By default, only connections from pages
+// served by the same host are accepted.
+func WithCheckOrigin(check func(r *http.Request) bool) Option {
+	return func(t *Tailer) {
+		t.upgrader.CheckOrigin = check
+	}
+}
+
+// WithWriteTimeout closes connections which don't accept an event within d, 10 seconds by default, so that
+// unresponsive clients don't hold on to their subscription.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(t *Tailer) {
+		t.writeTimeout = d
+	}
+}
+
+// WithSubscribeOptions configures optional behavior of the subscription of each connection, as for Subscribe.
+func WithSubscribeOptions(opts ...client.SubscribeOption) Option {
+	return func(t *Tailer) {
+		t.opts = append(t.opts, opts...)
+	}
+}
+
+// New returns a Tailer sending the events read by s.
+func New(s client.Subscriber, opts ...Option) *Tailer {
+	t := &Tailer{
+		subscriber:   s,
+		writeTimeout: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func (t *Tailer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	offsets, err := parseResumeToken(r.URL.Query().Get("resume"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// missing events is worse than sending them twice, so partitions the token knows nothing of are read from the
+	// beginning when resuming
+	fromBeginning := r.URL.Query().Get("from") == "beginning" || len(offsets) > 0
+	conn, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader replied with an error already
+		return
+	}
+	defer conn.Close()
+
+	c := &connection{
+		conn:         conn,
+		writeTimeout: t.writeTimeout,
+		offsets:      make(map[uint32]uint64, len(offsets)),
+	}
+	for partition, offset := range offsets {
+		c.offsets[partition] = offset
+	}
+	opts := append(t.opts[:len(t.opts):len(t.opts)], client.WithOffsetStore(resumeStore(offsets)))
+	sub, err := t.subscriber.SubscribeMessages(context.Background(), "", fromBeginning, c.send, func(cancel context.CancelFunc, err error) {
+		cancel()
+	}, opts...)
+	if err != nil {
+		c.close(websocket.CloseInternalServerErr, err.Error())
+		return
+	}
+	defer sub.Cancel()
+
+	// reading is required for control messages to be processed, and notices when the client goes away
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				sub.Cancel()
+				return
+			}
+		}
+	}()
+	<-sub.Done()
+	c.close(websocket.CloseGoingAway, "")
+}
+
+// connection is a WebSocket connection tailing the stream.
+type connection struct {
+	conn         *websocket.Conn
+	writeTimeout time.Duration
+	// mu guards writes to conn and offsets.
+	mu sync.Mutex
+	// offsets are the offsets of the last event sent in each partition.
+	offsets map[uint32]uint64
+}
+
+// send sends msg to the client.
+func (c *connection) send(ctx context.Context, msg client.Message) error {
+	event, err := client.CloudEventJSON(msg)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offsets[msg.Partition] = msg.Offset
+	token, err := resumeToken(c.offsets)
+	if err != nil {
+		return err
+	}
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+		return err
+	}
+	return c.conn.WriteJSON(Frame{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Resume:    token,
+		Event:     event,
+	})
+}
+
+// close sends a close message to the client, on a best effort basis.
+func (c *connection) close(code int, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+}
+
+// resumeStore resumes partitions right after the offsets held by a resume token.
+type resumeStore map[uint32]uint64
+
+func (s resumeStore) Load(ctx context.Context, topic string, group string, partition uint32) (uint64, bool, error) {
+	offset, ok := s[partition]
+	return offset, ok, nil
+}
+
+// resumeToken encodes the offsets of the last events sent in each partition.
+func resumeToken(offsets map[uint32]uint64) (string, error) {
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// parseResumeToken decodes a token returned by resumeToken. The empty token holds no offsets.
+func parseResumeToken(token string) (map[uint32]uint64, error) {
+	offsets := make(map[uint32]uint64)
+	if token == "" {
+		return offsets, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &offsets)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid resume token: %w", err)
+	}
+	return offsets, nil
+}
@@ -0,0 +1,77 @@
+package wstail_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/projectriff/stream-client-go/pkg/fake"
+	"github.com/projectriff/stream-client-go/pkg/wstail"
+)
+
+func TestTailer(t *testing.T) {
+	gateway := fake.NewGateway()
+	defer gateway.Close()
+	c, err := gateway.NewStreamClient("orders", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	server := httptest.NewServer(wstail.New(c))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	publish := func(payload string) {
+		if _, err := c.Publish(context.Background(), strings.NewReader(payload), nil, "text/plain", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(conn *websocket.Conn) (wstail.Frame, string) {
+		var frame wstail.Frame
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatal(err)
+		}
+		var event struct {
+			Data string `json:"data"`
+		}
+		if err := json.Unmarshal(frame.Event, &event); err != nil {
+			t.Fatal(err)
+		}
+		return frame, event.Data
+	}
+
+	publish("a")
+	publish("b")
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?from=beginning", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, data := read(conn); data != "a" {
+		t.Errorf("expected a, got %q", data)
+	}
+	frame, data := read(conn)
+	if data != "b" || frame.Offset != 1 || frame.Resume == "" {
+		t.Errorf("unexpected frame %+v for %q", frame, data)
+	}
+	conn.Close()
+
+	publish("c")
+	conn, _, err = websocket.DefaultDialer.Dial(url+"?resume="+frame.Resume, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if frame, data := read(conn); data != "c" || frame.Offset != 2 {
+		t.Errorf("expected to resume with c, got %+v for %q", frame, data)
+	}
+
+	if _, _, err := websocket.DefaultDialer.Dial(url+"?resume=garbage", nil); err == nil {
+		t.Error("expected an invalid resume token to be rejected")
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"strings"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// WithTopicPrefix namespaces the topics the client deals with, so that tenants sharing a gateway are isolated from
+// each other: prefix is prepended to the name of every topic sent to the gateway, whether to publish, subscribe,
+// commit or look offsets up, and to the topics created by a TopicCreator. Topics are otherwise referred to by their
+// name within the namespace, including in the TopicName of the client and in the Topic of messages.
+func WithTopicPrefix(prefix string) ClientOption {
+	return func(lc *StreamClient) {
+		lc.topicPrefix = prefix
+	}
+}
+
+// namespace makes the client prepend the topic prefix, if any, to the topics sent to the gateway.
+func (lc *StreamClient) namespace() {
+	if lc.topicPrefix != "" {
+		lc.client = prefixedClient{LiiklusServiceClient: lc.client, prefix: lc.topicPrefix}
+	}
+}
+
+// prefixedClient prepends a prefix to the topics of the requests sent to the gateway.
+type prefixedClient struct {
+	liiklus.LiiklusServiceClient
+	prefix string
+}
+
+func (c prefixedClient) Publish(ctx context.Context, in *liiklus.PublishRequest, opts ...grpc.CallOption) (*liiklus.PublishReply, error) {
+	request := *in
+	request.Topic = c.prefix + in.Topic
+	reply, err := c.LiiklusServiceClient.Publish(ctx, &request, opts...)
+	if reply != nil {
+		unprefixed := *reply
+		unprefixed.Topic = strings.TrimPrefix(reply.Topic, c.prefix)
+		reply = &unprefixed
+	}
+	return reply, err
+}
+
+func (c prefixedClient) Subscribe(ctx context.Context, in *liiklus.SubscribeRequest, opts ...grpc.CallOption) (liiklus.LiiklusService_SubscribeClient, error) {
+	request := *in
+	request.Topic = c.prefix + in.Topic
+	return c.LiiklusServiceClient.Subscribe(ctx, &request, opts...)
+}
+
+func (c prefixedClient) Ack(ctx context.Context, in *liiklus.AckRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	request := *in
+	request.Topic = c.prefix + in.Topic
+	return c.LiiklusServiceClient.Ack(ctx, &request, opts...)
+}
+
+func (c prefixedClient) GetOffsets(ctx context.Context, in *liiklus.GetOffsetsRequest, opts ...grpc.CallOption) (*liiklus.GetOffsetsReply, error) {
+	request := *in
+	request.Topic = c.prefix + in.Topic
+	return c.LiiklusServiceClient.GetOffsets(ctx, &request, opts...)
+}
+
+func (c prefixedClient) GetEndOffsets(ctx context.Context, in *liiklus.GetEndOffsetsRequest, opts ...grpc.CallOption) (*liiklus.GetEndOffsetsReply, error) {
+	request := *in
+	request.Topic = c.prefix + in.Topic
+	return c.LiiklusServiceClient.GetEndOffsets(ctx, &request, opts...)
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// Output is an event to be published by a Processor.
+type Output struct {
+	// Payload is the content of the event.
+	Payload []byte
+	// ContentType describes how to interpret the payload. It must be compatible with the output stream.
+	ContentType string
+	// Key is the key to publish the event under, if any.
+	Key []byte
+	// Headers are custom headers to publish the event with.
+	Headers map[string]string
+}
+
+// ProcessFunc computes the events to publish to the output stream of a Processor for a message of its input stream.
+// It may return no event at all, for example to filter the input stream.
+type ProcessFunc = func(ctx context.Context, msg Message) ([]Output, error)
+
+// Processor is a stage of a pipeline, publishing the events computed by a ProcessFunc for each message of an input
+// stream to an output stream. The offset of a message is only committed once all of its outputs have been published,
+// providing at-least-once semantics from one stream to the other.
+type Processor struct {
+	in    *StreamClient
+	out   *StreamClient
+	group string
+	f     ProcessFunc
+}
+
+// NewProcessor creates a Processor applying f to the messages of in and publishing its outputs to out, tracking its
+// position in in as part of group.
+func NewProcessor(in *StreamClient, out *StreamClient, group string, f ProcessFunc) *Processor {
+	return &Processor{
+		in:    in,
+		out:   out,
+		group: group,
+		f:     f,
+	}
+}
+
+// Start starts processing messages, which goes on until the returned Subscription is stopped. Processing starts from
+// the beginning of the input stream when the group has no position yet. Optional behavior of the underlying
+// subscription may be configured by passing SubscribeOptions, as for Subscribe, though WithAtMostOnce defeats the
+// purpose of a Processor.
+func (p *Processor) Start(ctx context.Context, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	return p.in.SubscribeMessages(ctx, p.group, true, p.process, e, opts...)
+}
+
+// process publishes the outputs computed for msg.
+func (p *Processor) process(ctx context.Context, msg Message) error {
+	outputs, err := p.f(ctx, msg)
+	if err != nil {
+		return err
+	}
+	for _, o := range outputs {
+		var key io.Reader
+		if o.Key != nil {
+			key = bytes.NewReader(o.Key)
+		}
+		if _, err := p.out.Publish(ctx, bytes.NewReader(o.Payload), key, o.ContentType, o.Headers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
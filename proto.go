@@ -0,0 +1,81 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// ProtobufContentType is the content type of protocol buffers payloads.
+	ProtobufContentType = "application/protobuf"
+	// ProtoMessageHeader is the header holding the fully qualified name of the message type of protocol buffers
+	// payloads.
+	ProtoMessageHeader = "protomessage"
+)
+
+// ProtoCodec is a Codec for protocol buffers messages, registered by default for ProtobufContentType. Values must
+// implement proto.Message.
+type ProtoCodec struct{}
+
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// PublishProto publishes m to the stream as a protocol buffers payload, recording its message type in the
+// ProtoMessageHeader header. The stream must accept ProtobufContentType.
+func (lc *StreamClient) PublishProto(ctx context.Context, m proto.Message, key io.Reader, headers map[string]string, opts ...PublishOption) (PublishResult, error) {
+	payload, err := proto.Marshal(m)
+	if err != nil {
+		return PublishResult{}, err
+	}
+	protoHeaders := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		protoHeaders[k] = v
+	}
+	protoHeaders[ProtoMessageHeader] = proto.MessageName(m)
+	return lc.Publish(ctx, bytes.NewReader(payload), key, ProtobufContentType, protoHeaders, opts...)
+}
+
+// DecodeProto unmarshals the protocol buffers payload of msg into m. An error is returned if msg records a message
+// type other than the one of m.
+func DecodeProto(msg Message, m proto.Message) error {
+	if chopContentType(msg.ContentType) != ProtobufContentType {
+		return fmt.Errorf("content type %q of event %s is not %s", msg.ContentType, msg.ID, ProtobufContentType)
+	}
+	if name, ok := msg.Headers[ProtoMessageHeader]; ok && name != proto.MessageName(m) {
+		return fmt.Errorf("event %s holds a %s message, not a %s", msg.ID, name, proto.MessageName(m))
+	}
+	return proto.Unmarshal(msg.Payload, m)
+}
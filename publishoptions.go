@@ -0,0 +1,91 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// DataSchemaHeader is the extension attribute holding the URI of the schema the payload of an event adheres to.
+const DataSchemaHeader = "dataschema"
+
+// PublishOption configures optional attributes of an event published by StreamClient.Publish.
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	// extensions are the extension attributes to set on the event.
+	extensions map[string]string
+	// err is the first invalid option encountered, if any.
+	err error
+}
+
+func newPublishOptions(opts []PublishOption) *publishOptions {
+	options := &publishOptions{extensions: make(map[string]string)}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// extensionName matches valid names of CloudEvents extension attributes.
+var extensionName = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// reservedAttributes are the names of the CloudEvents context attributes which can't be set as extensions.
+var reservedAttributes = map[string]bool{
+	"id":              true,
+	"source":          true,
+	"specversion":     true,
+	"type":            true,
+	"datacontenttype": true,
+	"subject":         true,
+	"time":            true,
+	"data":            true,
+}
+
+// WithDataSchema points the event at the schema its payload adheres to, recorded in the DataSchemaHeader extension.
+// Publishing fails if uri is not a valid URI.
+func WithDataSchema(uri string) PublishOption {
+	return func(o *publishOptions) {
+		if _, err := url.ParseRequestURI(uri); err != nil {
+			o.fail(fmt.Errorf("invalid data schema %q: %w", uri, err))
+			return
+		}
+		o.extensions[DataSchemaHeader] = uri
+	}
+}
+
+// WithExtension sets a custom extension attribute on the event, which downstream consumers such as routers and
+// Knative triggers can filter on. Publishing fails if name is not a valid CloudEvents attribute name, made of lower
+// case letters and digits, or if it designates a context attribute.
+func WithExtension(name string, value string) PublishOption {
+	return func(o *publishOptions) {
+		if !extensionName.MatchString(name) || reservedAttributes[name] {
+			o.fail(fmt.Errorf("invalid extension attribute name %q", name))
+			return
+		}
+		o.extensions[name] = value
+	}
+}
+
+// fail records err, unless an invalid option was already encountered.
+func (o *publishOptions) fail(err error) {
+	if o.err == nil {
+		o.err = err
+	}
+}
@@ -0,0 +1,303 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WithRateLimit bounds the rate at which messages are handed over to the handler to eventsPerSecond, allowing bursts
+// of up to burst messages, so that a subscription can't use more than its share of the resources of the process or
+// of the systems its handler calls. Messages are not read from the stream faster than they are handed over.
+func WithRateLimit(eventsPerSecond float64, burst int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if burst < 1 {
+			burst = 1
+		}
+		o.limiter = &rateLimiter{rate: eventsPerSecond, burst: float64(burst), tokens: float64(burst)}
+	}
+}
+
+// rateLimiter is a token bucket.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// wait takes a token from the bucket, waiting for one to be available if needed.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	// tokens may go negative, reserving tokens for the callers already waiting
+	l.tokens--
+	deficit := -l.tokens
+	l.mu.Unlock()
+	if deficit <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(deficit / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// FairScheduler shares a bounded number of concurrent handler invocations between the subscriptions of a process,
+// in proportion to their weight, so that a chatty stream can't starve the others. While invocations are available,
+// messages are handled right away; once they are all in use, the next available one goes to the waiting subscription
+// which received the least in proportion to its weight. Subscriptions opt in with WithFairScheduling.
+//
+// Subscriptions, or some of their topics, may also be given a priority with WithPriority: once all invocations are in
+// use, messages of higher priority are handed over before any message of lower priority, whatever the weights. As the
+// messages of a partition are handed over one at a time, an invocation returned by a higher priority stream is kept
+// for it for a short while rather than granted to a lower priority one right away, for its next message to take.
+type FairScheduler struct {
+	mu sync.Mutex
+	// available is the number of invocations which may start right away.
+	available int
+	// waiting are the messages waiting for an invocation, in arrival order.
+	waiting []*fairWaiter
+	// clock is the virtual time of the scheduler, the pass of the last share granted an invocation.
+	clock float64
+	// reserved are the invocations kept for higher priority streams.
+	reserved []*reservation
+}
+
+// priorityAnticipation is how long an invocation returned by a stream is kept for its next message rather than granted
+// to a lower priority stream.
+const priorityAnticipation = 10 * time.Millisecond
+
+// reservation is an invocation kept for messages of at least priority.
+type reservation struct {
+	priority int
+	timer    *time.Timer
+}
+
+// NewFairScheduler creates a FairScheduler allowing up to concurrency handler invocations at once.
+func NewFairScheduler(concurrency int) *FairScheduler {
+	return &FairScheduler{available: concurrency}
+}
+
+// WithFairScheduling hands messages over to the handler when s grants an invocation to the subscription, which gets a
+// share of the invocations proportional to weight. Messages are not read from the stream faster than they are handed
+// over.
+func WithFairScheduling(s *FairScheduler, weight int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if weight < 1 {
+			weight = 1
+		}
+		o.scheduler = s
+		o.weight = weight
+	}
+}
+
+// WithPriority sets the priority of the messages of topics, or of all the topics of the subscription if none are given,
+// when competing for the invocations of the FairScheduler set by WithFairScheduling. The default priority is 0; higher
+// priority streams are drained before lower priority ones when the scheduler is saturated.
+func WithPriority(priority int, topics ...string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if len(topics) == 0 {
+			o.priority = priority
+			return
+		}
+		if o.priorities == nil {
+			o.priorities = map[string]int{}
+		}
+		for _, topic := range topics {
+			o.priorities[topic] = priority
+		}
+	}
+}
+
+// fairShare tracks the invocations granted to a subscription, its pass growing by the inverse of its weight with each
+// invocation.
+type fairShare struct {
+	weight   float64
+	priority int
+	pass     float64
+}
+
+// fairWaiter is a message waiting for an invocation.
+type fairWaiter struct {
+	share   *fairShare
+	granted chan struct{}
+}
+
+// acquire waits until an invocation is granted to share.
+func (s *FairScheduler) acquire(ctx context.Context, share *fairShare) error {
+	s.mu.Lock()
+	if s.available > 0 && len(s.waiting) == 0 {
+		s.available--
+		s.grant(share)
+		s.mu.Unlock()
+		return nil
+	}
+	for i, r := range s.reserved {
+		if share.priority >= r.priority {
+			r.timer.Stop()
+			s.reserved = append(s.reserved[:i], s.reserved[i+1:]...)
+			s.grant(share)
+			s.mu.Unlock()
+			return nil
+		}
+	}
+	w := &fairWaiter{share: share, granted: make(chan struct{})}
+	s.waiting = append(s.waiting, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.granted:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for i, other := range s.waiting {
+			if other == w {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				s.mu.Unlock()
+				return ctx.Err()
+			}
+		}
+		s.mu.Unlock()
+		// granted in the meantime
+		s.release(share)
+		return ctx.Err()
+	}
+}
+
+// release returns the invocation granted to share, reserving it for the priority of share if only lower priority
+// messages are waiting.
+func (s *FairScheduler) release(share *fairShare) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if next := s.next(); next >= 0 && s.waiting[next].share.priority < share.priority {
+		r := &reservation{priority: share.priority}
+		r.timer = time.AfterFunc(priorityAnticipation, func() { s.expire(r) })
+		s.reserved = append(s.reserved, r)
+		return
+	}
+	s.handOver()
+}
+
+// expire hands the invocation kept by r over, unless it has been taken.
+func (s *FairScheduler) expire(r *reservation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, other := range s.reserved {
+		if other == r {
+			s.reserved = append(s.reserved[:i], s.reserved[i+1:]...)
+			s.handOver()
+			return
+		}
+	}
+}
+
+// handOver makes an invocation available again, granting it to the next waiting share, if any.
+func (s *FairScheduler) handOver() {
+	next := s.next()
+	if next < 0 {
+		s.available++
+		return
+	}
+	w := s.waiting[next]
+	s.waiting = append(s.waiting[:next], s.waiting[next+1:]...)
+	s.grant(w.share)
+	close(w.granted)
+}
+
+// next returns the index of the waiting share with the highest priority, then the lowest pass, or -1 if none is
+// waiting.
+func (s *FairScheduler) next() int {
+	if len(s.waiting) == 0 {
+		return -1
+	}
+	next := 0
+	for i, w := range s.waiting {
+		if first := s.waiting[next].share; w.share.priority > first.priority ||
+			w.share.priority == first.priority && s.passOf(w.share) < s.passOf(first) {
+			next = i
+		}
+	}
+	return next
+}
+
+// passOf returns the pass of share, not lagging behind the clock, so that idle subscriptions don't accumulate credit.
+func (s *FairScheduler) passOf(share *fairShare) float64 {
+	if share.pass < s.clock {
+		return s.clock
+	}
+	return share.pass
+}
+
+// grant records that an invocation has been granted to share.
+func (s *FairScheduler) grant(share *fairShare) {
+	share.pass = s.passOf(share) + 1/share.weight
+	s.clock = share.pass - 1/share.weight
+}
+
+// regulate applies the rate limit and fair scheduling of the subscription, if any, to consume.
+func (o *subscribeOptions) regulate(consume consumer) consumer {
+	if o.limiter == nil && o.scheduler == nil {
+		return consume
+	}
+	// one share per priority, topics of the same priority sharing the weight of the subscription
+	shares := map[int]*fairShare{o.priority: {weight: float64(o.weight), priority: o.priority}}
+	for _, priority := range o.priorities {
+		if _, ok := shares[priority]; !ok {
+			shares[priority] = &fairShare{weight: float64(o.weight), priority: priority}
+		}
+	}
+	return func(ctx context.Context, sub *Subscription, msg Message) error {
+		if o.limiter != nil {
+			if err := o.limiter.wait(ctx); err != nil {
+				sub.release(1)
+				return errStreamInterrupted
+			}
+		}
+		if o.scheduler != nil {
+			priority, ok := o.priorities[msg.Topic]
+			if !ok {
+				priority = o.priority
+			}
+			share := shares[priority]
+			if err := o.scheduler.acquire(ctx, share); err != nil {
+				sub.release(1)
+				return errStreamInterrupted
+			}
+			defer o.scheduler.release(share)
+		}
+		return consume(ctx, sub, msg)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// PublishRaw publishes payload to the stream as a plain record, under key if not nil, without wrapping it in an event
+// envelope. This is useful to write to topics shared with producers and consumers foreign to riff. Raw records carry
+// no content type, so they are not checked against the content type of the stream, nor validated.
+func (lc *StreamClient) PublishRaw(ctx context.Context, payload io.Reader, key io.Reader) (PublishResult, error) {
+	value, err := ioutil.ReadAll(payload)
+	if err != nil {
+		return PublishResult{}, err
+	}
+	var kValue []byte
+	if key != nil {
+		if kValue, err = ioutil.ReadAll(key); err != nil {
+			return PublishResult{}, err
+		}
+	}
+	return lc.send(ctx, &liiklus.PublishRequest{
+		Topic: lc.TopicName,
+		Key:   kValue,
+		Value: value,
+	})
+}
+
+// SubscribeRaw is like SubscribeMessages, but reads the stream as plain records, without decoding an event envelope.
+// This is useful to read topics holding records written by producers foreign to riff. Messages handed over to f only
+// have their Payload, Key and position in the stream set.
+func (lc *StreamClient) SubscribeRaw(ctx context.Context, group string, fromBeginning bool, f MessageHandler, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	options := newSubscribeOptions(opts)
+	options.raw = true
+	return lc.subscribe(ctx, []string{lc.TopicName}, group, fromBeginning, options.oneByOne(f), e, options)
+}
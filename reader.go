@@ -0,0 +1,129 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Reader allows pulling events from a stream at the caller's own pace, as an alternative to the callback driven
+// Subscribe. A message returned by Next is acknowledged once Next is called again or the Reader is closed.
+//
+// A Reader is not safe for concurrent use by multiple goroutines.
+type Reader struct {
+	sub *Subscription
+	// deliveries hands messages over from the subscription goroutines to Next.
+	deliveries chan delivery
+	// pending acknowledges the message last returned by Next, if any.
+	pending chan<- error
+	// closing is closed when Close is called, to release subscription goroutines waiting on deliveries.
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+type delivery struct {
+	msg  Message
+	done chan<- error
+}
+
+// errReaderClosed aborts the delivery of messages that have been fetched but not returned by Next when the Reader is
+// closed, so that they are not acknowledged.
+var errReaderClosed = errors.New("reader closed")
+
+// NewReader creates a Reader consuming the stream as part of the given consumer group. Optional behavior may be
+// configured by passing SubscribeOptions, as for Subscribe.
+func (lc *StreamClient) NewReader(group string, fromBeginning bool, opts ...SubscribeOption) (*Reader, error) {
+	r := &Reader{
+		deliveries: make(chan delivery),
+		closing:    make(chan struct{}),
+	}
+	options := newSubscribeOptions(opts)
+	sub, err := lc.subscribe(context.Background(), []string{lc.TopicName}, group, fromBeginning, options.oneByOne(r.deliver), func(cancel context.CancelFunc, err error) {
+		cancel()
+	}, options)
+	if err != nil {
+		return nil, err
+	}
+	r.sub = sub
+	return r, nil
+}
+
+// deliver hands msg over to Next and waits for the caller to be done with it.
+func (r *Reader) deliver(ctx context.Context, msg Message) error {
+	done := make(chan error, 1)
+	select {
+	case r.deliveries <- delivery{msg: msg, done: done}:
+	case <-r.closing:
+		return errReaderClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Next acknowledges the previously returned message, if any, and blocks until the next message is available or ctx is
+// done. Once the Reader has been closed, Next returns io.EOF. If the underlying subscription failed, the cause of the
+// failure is returned.
+func (r *Reader) Next(ctx context.Context) (Message, error) {
+	r.release()
+	select {
+	case <-r.closing:
+		return Message{}, io.EOF
+	default:
+	}
+	select {
+	case d := <-r.deliveries:
+		r.pending = d.done
+		return d.msg, nil
+	case <-r.closing:
+		return Message{}, io.EOF
+	case <-r.sub.Done():
+		if err := r.sub.Err(); err != nil {
+			return Message{}, err
+		}
+		return Message{}, io.EOF
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// release acknowledges the message last returned by Next, if any.
+func (r *Reader) release() {
+	if r.pending != nil {
+		r.pending <- nil
+		r.pending = nil
+	}
+}
+
+// Close acknowledges the message last returned by Next, if any, and gracefully stops the Reader.
+func (r *Reader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closing)
+	})
+	r.release()
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return r.sub.Drain(ctx)
+}
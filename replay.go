@@ -0,0 +1,186 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OffsetRange designates the messages of a partition whose offset is at least From and less than To.
+type OffsetRange struct {
+	From uint64
+	To   uint64
+}
+
+// ReadRange calls f for every message within the given per-partition offset ranges and returns once they have all
+// been handled, or as soon as an error occurs. Ranges are read by an anonymous subscription, hence no offsets are
+// committed. Ranges are expected to designate existing messages, ctx may be used to bound the time spent waiting
+// for messages that are not in the stream yet.
+func (lc *StreamClient) ReadRange(ctx context.Context, ranges map[uint32]OffsetRange, f MessageHandler, opts ...SubscribeOption) error {
+	return lc.readRange(ctx, lc.TopicName, ranges, f, newSubscribeOptions(opts))
+}
+
+func (lc *StreamClient) readRange(ctx context.Context, topic string, ranges map[uint32]OffsetRange, f MessageHandler, options *subscribeOptions) error {
+	f = options.wrap(f)
+	options.lastKnownOffsets = make(map[uint32]uint64, len(ranges))
+	remaining := make(map[uint32]bool, len(ranges))
+	for partition, r := range ranges {
+		if r.From >= r.To {
+			continue
+		}
+		remaining[partition] = true
+		if r.From > 0 {
+			options.lastKnownOffsets[partition] = r.From - 1
+		}
+	}
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	var mu sync.Mutex
+	finished := make(chan struct{})
+	consume := func(ctx context.Context, sub *Subscription, msg Message) error {
+		defer sub.release(1)
+		r := ranges[msg.Partition]
+		mu.Lock()
+		wanted := remaining[msg.Partition] && msg.Offset >= r.From && msg.Offset < r.To && options.accept(msg)
+		mu.Unlock()
+		if wanted {
+			if err := options.handle(ctx, func(ctx context.Context) error {
+				return f(ctx, msg)
+			}); err != nil {
+				return err
+			}
+			atomic.AddUint64(&sub.processed, 1)
+		}
+		if msg.Offset+1 >= r.To {
+			mu.Lock()
+			defer mu.Unlock()
+			if remaining[msg.Partition] {
+				delete(remaining, msg.Partition)
+				if len(remaining) == 0 {
+					close(finished)
+				}
+			}
+		}
+		return nil
+	}
+
+	sub, err := lc.subscribe(ctx, []string{topic}, "", true, consume, func(cancel context.CancelFunc, err error) {
+		cancel()
+	}, options)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-finished:
+		sub.Cancel()
+		<-sub.Done()
+		return nil
+	case <-sub.Done():
+		select {
+		case <-finished:
+			return nil
+		default:
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return sub.Err()
+	}
+}
+
+// Tail calls f for the last n messages of every partition of the stream, as of the time Tail is called, and returns
+// once they have all been handled. As for ReadRange, no offsets are committed.
+func (lc *StreamClient) Tail(ctx context.Context, n uint64, f MessageHandler, opts ...SubscribeOption) error {
+	endOffsets, err := lc.endOffsets(ctx, lc.TopicName)
+	if err != nil {
+		return err
+	}
+	ranges := make(map[uint32]OffsetRange, len(endOffsets))
+	for partition, end := range endOffsets {
+		r := OffsetRange{To: end + 1}
+		if r.To > n {
+			r.From = r.To - n
+		}
+		ranges[partition] = r
+	}
+	return lc.ReadRange(ctx, ranges, f, opts...)
+}
+
+// errResolved stops reading once OffsetsForTime has found all the offsets it needs.
+var errResolved = errors.New("offsets resolved")
+
+// OffsetsForTime returns, for each non empty partition of the stream, the offset of the first message recorded at or
+// after t, or the offset following the last message if there is none. As the gateway offers no way to look offsets up
+// by time, partitions are scanned from the beginning.
+func (lc *StreamClient) OffsetsForTime(ctx context.Context, t time.Time) (map[uint32]uint64, error) {
+	return lc.offsetsForTime(ctx, lc.TopicName, t)
+}
+
+// startOffsets resolves t to the offset of the first message recorded at or after t in each non empty partition of
+// topics.
+func (lc *StreamClient) startOffsets(ctx context.Context, topics []string, t time.Time) (map[topicPartition]uint64, error) {
+	startOffsets := make(map[topicPartition]uint64)
+	for _, topic := range topics {
+		offsets, err := lc.offsetsForTime(ctx, topic, t)
+		if err != nil {
+			return nil, err
+		}
+		for partition, offset := range offsets {
+			startOffsets[topicPartition{topic: topic, partition: partition}] = offset
+		}
+	}
+	return startOffsets, nil
+}
+
+func (lc *StreamClient) offsetsForTime(ctx context.Context, topic string, t time.Time) (map[uint32]uint64, error) {
+	endOffsets, err := lc.endOffsets(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	ranges := make(map[uint32]OffsetRange, len(endOffsets))
+	offsets := make(map[uint32]uint64, len(endOffsets))
+	for partition, end := range endOffsets {
+		ranges[partition] = OffsetRange{From: 0, To: end + 1}
+		offsets[partition] = end + 1
+	}
+
+	var mu sync.Mutex
+	found := make(map[uint32]bool, len(ranges))
+	err = lc.readRange(ctx, topic, ranges, func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if found[msg.Partition] || msg.Timestamp.Before(t) {
+			return nil
+		}
+		found[msg.Partition] = true
+		offsets[msg.Partition] = msg.Offset
+		if len(found) == len(ranges) {
+			return errResolved
+		}
+		return nil
+	}, newSubscribeOptions(nil))
+	if err != nil && !errors.Is(err, errResolved) {
+		return nil, err
+	}
+	return offsets, nil
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+const (
+	// CorrelationIDHeader is the header correlating replies with requests.
+	CorrelationIDHeader = "correlationid"
+	// ReplyToHeader is the header holding the topic replies to a request are to be published to.
+	ReplyToHeader = "replyto"
+	// ReplyContentTypeHeader is the header holding the content type accepted by the topic replies are to be published
+	// to, which the content type of replies must be compatible with.
+	ReplyContentTypeHeader = "replycontenttype"
+	// ReplyErrorHeader is the header describing why a request failed, set on error replies.
+	ReplyErrorHeader = "replyerror"
+	// ReplyTypeSuffix is appended to the type of requests to make the type of their replies.
+	ReplyTypeSuffix = ".reply"
+)
+
+// RequestError is returned by Requester.Request when the responder failed to handle the request.
+type RequestError struct {
+	// Message describes the failure, as reported by the responder.
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return "request failed: " + e.Message
+}
+
+// Requester sends requests over a stream and awaits the matching replies over another one, implementing RPC over
+// streams along with Respond.
+type Requester struct {
+	requests *StreamClient
+	replies  *StreamClient
+	sub      *Subscription
+
+	mu      sync.Mutex
+	pending map[string]chan<- Message
+}
+
+// NewRequester creates a Requester publishing requests to the stream of requests and listening for replies on the
+// stream of replies. Replies are read by an anonymous subscription, starting after the end of the stream of replies as
+// of the time the Requester is created. The context only bounds the creation of the Requester, which listens for
+// replies until it is closed.
+func NewRequester(ctx context.Context, requests *StreamClient, replies *StreamClient) (*Requester, error) {
+	r := &Requester{
+		requests: requests,
+		replies:  replies,
+		pending:  make(map[string]chan<- Message),
+	}
+	// start from the end offsets rather than from the latest messages, which are only determined once receive streams
+	// are open, possibly after the replies to the first requests have been recorded
+	ends, err := replies.endOffsets(ctx, replies.TopicName)
+	if err != nil {
+		return nil, err
+	}
+	options := newSubscribeOptions(nil)
+	options.lastKnownOffsets = ends
+	sub, err := replies.subscribe(context.Background(), []string{replies.TopicName}, "", true, options.oneByOne(r.receive), func(cancel context.CancelFunc, err error) {}, options)
+	if err != nil {
+		return nil, err
+	}
+	r.sub = sub
+	return r, nil
+}
+
+// Request publishes a request and waits for the matching reply, until ctx is done. If the responder failed to handle
+// the request, a RequestError is returned.
+func (r *Requester) Request(ctx context.Context, payload []byte, contentType string, headers map[string]string) (Message, error) {
+	correlationID := uuid.New().String()
+	replies := make(chan Message, 1)
+	r.mu.Lock()
+	r.pending[correlationID] = replies
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, correlationID)
+		r.mu.Unlock()
+	}()
+
+	requestHeaders := make(map[string]string, len(headers)+2)
+	for k, v := range headers {
+		requestHeaders[k] = v
+	}
+	requestHeaders[CorrelationIDHeader] = correlationID
+	requestHeaders[ReplyToHeader] = r.replies.TopicName
+	requestHeaders[ReplyContentTypeHeader] = r.replies.acceptableContentType
+	if _, err := r.requests.Publish(ctx, bytes.NewReader(payload), nil, contentType, requestHeaders); err != nil {
+		return Message{}, err
+	}
+
+	select {
+	case reply := <-replies:
+		if reason, ok := reply.Headers[ReplyErrorHeader]; ok {
+			return Message{}, &RequestError{Message: reason}
+		}
+		return reply, nil
+	case <-r.sub.Done():
+		if err := r.sub.Err(); err != nil {
+			return Message{}, err
+		}
+		return Message{}, fmt.Errorf("requester closed: %w", ErrSubscriptionClosed)
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// receive hands reply over to the pending request it correlates with, if any.
+func (r *Requester) receive(ctx context.Context, reply Message) error {
+	r.mu.Lock()
+	replies, ok := r.pending[reply.Headers[CorrelationIDHeader]]
+	r.mu.Unlock()
+	if ok {
+		select {
+		case replies <- reply:
+		default:
+			// a reply has been received already
+		}
+	}
+	return nil
+}
+
+// Close stops listening for replies. Pending requests fail.
+func (r *Requester) Close() {
+	r.sub.Cancel()
+	<-r.sub.Done()
+}
+
+// RequestHandler computes the reply to a request, returning its payload and content type.
+type RequestHandler = func(ctx context.Context, request Message) ([]byte, string, error)
+
+// Respond handles the requests published to the stream by Requesters, as part of the given consumer group. The reply
+// computed by h is published to the reply topic of each request. If h fails, or computes a reply whose content type is
+// not compatible with the one the requester announced for its stream of replies, an error reply is published instead,
+// so that the requester doesn't have to wait for a timeout. Replies have the stream of requests as their source, and
+// the type of their request followed by ReplyTypeSuffix as their type.
+func (lc *StreamClient) Respond(ctx context.Context, group string, fromBeginning bool, h RequestHandler, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	return lc.SubscribeMessages(ctx, group, fromBeginning, func(ctx context.Context, request Message) error {
+		replyTo, ok := request.Headers[ReplyToHeader]
+		if !ok {
+			// not a request, nobody to reply to
+			return nil
+		}
+		reply := &liiklus.LiiklusEvent{
+			Id:         uuid.New().String(),
+			Source:     request.Topic,
+			Type:       request.Type + ReplyTypeSuffix,
+			Extensions: map[string]string{CorrelationIDHeader: request.Headers[CorrelationIDHeader]},
+		}
+		payload, contentType, err := h(ctx, request)
+		if accepted, ok := request.Headers[ReplyContentTypeHeader]; ok && err == nil {
+			err = checkContentType(contentType, accepted)
+		}
+		if err != nil {
+			reply.Extensions[ReplyErrorHeader] = err.Error()
+		} else {
+			reply.Data, reply.DataContentType = payload, contentType
+		}
+		_, err = lc.publishTo(ctx, replyTo, reply, nil)
+		return err
+	}, e, opts...)
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Extension attributes set on the events republished to retry and dead letter topics, recording where they come from
+// and why they failed.
+const (
+	// SourceTopicHeader is the topic the event was first read from.
+	SourceTopicHeader = "sourcetopic"
+	// SourcePartitionHeader is the partition the event was first read from.
+	SourcePartitionHeader = "sourcepartition"
+	// SourceOffsetHeader is the offset of the event in the partition it was first read from.
+	SourceOffsetHeader = "sourceoffset"
+	// FailureHeader is the error the event was last handled with.
+	FailureHeader = "failure"
+	// RetryAttemptHeader is the number of times the event has been escalated.
+	RetryAttemptHeader = "retryattempt"
+	// NotBeforeHeader is the time before which the event is not to be redelivered, in RFC 3339 format.
+	NotBeforeHeader = "notbefore"
+)
+
+// RetryTier is a topic events which failed are republished to, to be retried once Delay has elapsed.
+type RetryTier struct {
+	// Topic is the name of the retry topic.
+	Topic string
+	// Delay is the time to wait before retrying events republished to Topic.
+	Delay time.Duration
+}
+
+// RetryTopics implements the tiered retry topic pattern: events whose handling failed are republished to a first retry
+// topic, say retry-1m, then to the following ones, say retry-10m, as they keep failing, and finally to a dead letter
+// topic. The main subscription moves on in the meantime, rather than blocking its partition until the event can be
+// handled. Start consumes the retry topics, redelivering each event to the handler once its delay has elapsed.
+type RetryTopics struct {
+	client          *StreamClient
+	tiers           []RetryTier
+	deadLetterTopic string
+}
+
+// NewRetryTopics creates RetryTopics republishing events with lc to the given tiers, in order, and finally to
+// deadLetterTopic. Events which failed in the last tier are dropped if deadLetterTopic is empty. All topics must be
+// available through the gateway of lc.
+func NewRetryTopics(lc *StreamClient, deadLetterTopic string, tiers ...RetryTier) *RetryTopics {
+	return &RetryTopics{
+		client:          lc,
+		tiers:           tiers,
+		deadLetterTopic: deadLetterTopic,
+	}
+}
+
+// Middleware returns a Middleware escalating the messages the handler fails to handle, rather than returning the
+// error, so that the subscription moves on. Errors are only returned when escalating fails.
+func (r *RetryTopics) Middleware() Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg Message) error {
+			if err := next(ctx, msg); err != nil {
+				return r.Escalate(ctx, msg, err)
+			}
+			return nil
+		}
+	}
+}
+
+// Escalate republishes msg, whose handling failed with cause, to the tier following the one it was read from, or to
+// the dead letter topic once it went through every tier.
+func (r *RetryTopics) Escalate(ctx context.Context, msg Message, cause error) error {
+	next := 0
+	for i, tier := range r.tiers {
+		if tier.Topic == msg.Topic {
+			next = i + 1
+		}
+	}
+	headers := make(map[string]string, len(msg.Headers)+6)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	if _, ok := headers[SourceTopicHeader]; !ok {
+		headers[SourceTopicHeader] = msg.Topic
+		headers[SourcePartitionHeader] = strconv.FormatUint(uint64(msg.Partition), 10)
+		headers[SourceOffsetHeader] = strconv.FormatUint(msg.Offset, 10)
+	}
+	headers[FailureHeader] = cause.Error()
+	headers[RetryAttemptHeader] = strconv.Itoa(next + 1)
+
+	topic := r.deadLetterTopic
+	if next < len(r.tiers) {
+		topic = r.tiers[next].Topic
+		headers[NotBeforeHeader] = time.Now().Add(r.tiers[next].Delay).UTC().Format(time.RFC3339Nano)
+	} else {
+		delete(headers, NotBeforeHeader)
+		delete(headers, RetryAttemptHeader)
+		if topic == "" {
+			r.client.log.Info("dropping event which failed in every retry topic", "topic", msg.Topic, "id", msg.ID, "error", cause)
+			return nil
+		}
+	}
+	msg.Headers = headers
+	if _, err := r.client.publishTo(ctx, topic, msg.event(), msg.Key); err != nil {
+		return fmt.Errorf("%w, and republishing it to %s failed: %v", cause, topic, err)
+	}
+	return nil
+}
+
+// Start consumes the retry topics as part of group, redelivering each event to f once the delay of its tier has
+// elapsed. Events f fails to handle are escalated to the next tier. This goes on until the returned Subscription is
+// stopped. Optional behavior of the underlying subscription may be configured by passing SubscribeOptions, as for
+// Subscribe.
+func (r *RetryTopics) Start(ctx context.Context, group string, f MessageHandler, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	topics := make([]string, len(r.tiers))
+	for i, tier := range r.tiers {
+		topics[i] = tier.Topic
+	}
+	handler := r.Middleware()(f)
+	return r.client.MultiSubscribe(ctx, topics, group, true, func(ctx context.Context, msg Message) error {
+		if notBefore, err := time.Parse(time.RFC3339Nano, msg.Headers[NotBeforeHeader]); err == nil {
+			// events of a tier are due in the order they were escalated, so waiting doesn't delay later ones
+			timer := time.NewTimer(time.Until(notBefore))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		return handler(ctx, msg)
+	}, e, opts...)
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"sync"
+)
+
+// Router republishes the events of a stream to other topics available through the same gateway, depending on
+// predicates on their attributes or payload, so that simple fan-out topologies don't require a stream processing
+// framework. Predicates may be compiled filter expressions, see package filter.
+type Router struct {
+	from  *StreamClient
+	group string
+
+	mu       sync.RWMutex
+	routes   []route
+	fallback string
+}
+
+// route sends the messages matching a predicate to a topic.
+type route struct {
+	match func(Message) bool
+	topic string
+}
+
+// NewRouter creates a Router for the events of from, tracking its position as part of group. It has no routes.
+func NewRouter(from *StreamClient, group string) *Router {
+	return &Router{
+		from:  from,
+		group: group,
+	}
+}
+
+// Route republishes the messages for which match returns true to topic. A message matching several routes is
+// republished to each of their topics, once per topic.
+func (r *Router) Route(match func(Message) bool, topic string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = append(r.routes, route{match: match, topic: topic})
+}
+
+// Fallback republishes the messages which match no route to topic. Without a fallback topic, such messages are
+// dropped.
+func (r *Router) Fallback(topic string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = topic
+}
+
+// Start starts routing events, which goes on until the returned Subscription is stopped. Events are routed at least
+// once, in order within each partition of the stream, starting from the beginning of the stream when the group has no
+// position yet. Optional behavior of the underlying subscription may be configured by passing SubscribeOptions, as for
+// Subscribe.
+func (r *Router) Start(ctx context.Context, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	return r.from.SubscribeMessages(ctx, r.group, true, r.route, e, opts...)
+}
+
+// route republishes msg to the topics of the routes it matches.
+func (r *Router) route(ctx context.Context, msg Message) error {
+	r.mu.RLock()
+	var topics []string
+	seen := make(map[string]bool)
+	for _, route := range r.routes {
+		if !seen[route.topic] && route.match(msg) {
+			seen[route.topic] = true
+			topics = append(topics, route.topic)
+		}
+	}
+	if len(topics) == 0 && r.fallback != "" {
+		topics = append(topics, r.fallback)
+	}
+	r.mu.RUnlock()
+	for _, topic := range topics {
+		if _, err := r.from.publishTo(ctx, topic, msg.event(), msg.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
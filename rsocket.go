@@ -0,0 +1,599 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// rsocketScheme prefixes the host:port of gateways reached through the RSocket API of liiklus.
+const rsocketScheme = "rsocket://"
+
+// isRSocket reports whether gateway is the address of an RSocket server rather than of a gRPC server.
+func isRSocket(gateway string) bool {
+	return strings.HasPrefix(gateway, rsocketScheme)
+}
+
+// Types and flags of the RSocket frames, see https://rsocket.io/about/protocol.
+const (
+	rsocketSetup           = 0x01
+	rsocketKeepalive       = 0x03
+	rsocketRequestResponse = 0x04
+	rsocketRequestStream   = 0x06
+	rsocketRequestN        = 0x08
+	rsocketCancel          = 0x09
+	rsocketPayload         = 0x0A
+	rsocketError           = 0x0B
+
+	rsocketFlagMetadata = 0x100
+	rsocketFlagFollows  = 0x80
+	rsocketFlagRespond  = 0x80
+	rsocketFlagComplete = 0x40
+	rsocketFlagNext     = 0x20
+)
+
+const (
+	// rsocketKeepaliveInterval is the interval between the keepalive frames sent to the server.
+	rsocketKeepaliveInterval = 20 * time.Second
+	// rsocketMaxLifetime is the time after which a connection the server hasn't sent anything on is deemed lost.
+	rsocketMaxLifetime = 90 * time.Second
+	// rsocketCredits is the number of messages of a stream requested ahead of their consumption.
+	rsocketCredits = 256
+)
+
+// rsocketClient calls liiklus through its RSocket API, exposing the methods of the gRPC service with RSocket-RPC over
+// TCP. Calls are multiplexed over a single connection, which is established again by the next call once lost.
+type rsocketClient struct {
+	address string
+	// mu guards conn and closed.
+	mu     sync.Mutex
+	conn   *rsocketConn
+	closed bool
+}
+
+// dialRSocket connects to gateway, an rsocket:// address.
+func dialRSocket(ctx context.Context, gateway string) (*rsocketClient, error) {
+	c := &rsocketClient{address: strings.TrimPrefix(gateway, rsocketScheme)}
+	if _, err := c.connection(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *rsocketClient) Publish(ctx context.Context, in *liiklus.PublishRequest, opts ...grpc.CallOption) (*liiklus.PublishReply, error) {
+	out := new(liiklus.PublishReply)
+	return out, c.invoke(ctx, "Publish", in, out)
+}
+
+func (c *rsocketClient) Subscribe(ctx context.Context, in *liiklus.SubscribeRequest, opts ...grpc.CallOption) (liiklus.LiiklusService_SubscribeClient, error) {
+	stream, err := c.call(ctx, rsocketRequestStream, "Subscribe", in)
+	if err != nil {
+		return nil, err
+	}
+	return &rsocketSubscribeClient{stream}, nil
+}
+
+func (c *rsocketClient) Receive(ctx context.Context, in *liiklus.ReceiveRequest, opts ...grpc.CallOption) (liiklus.LiiklusService_ReceiveClient, error) {
+	stream, err := c.call(ctx, rsocketRequestStream, "Receive", in)
+	if err != nil {
+		return nil, err
+	}
+	return &rsocketReceiveClient{stream}, nil
+}
+
+func (c *rsocketClient) Ack(ctx context.Context, in *liiklus.AckRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	return out, c.invoke(ctx, "Ack", in, out)
+}
+
+func (c *rsocketClient) GetOffsets(ctx context.Context, in *liiklus.GetOffsetsRequest, opts ...grpc.CallOption) (*liiklus.GetOffsetsReply, error) {
+	out := new(liiklus.GetOffsetsReply)
+	return out, c.invoke(ctx, "GetOffsets", in, out)
+}
+
+func (c *rsocketClient) GetEndOffsets(ctx context.Context, in *liiklus.GetEndOffsetsRequest, opts ...grpc.CallOption) (*liiklus.GetEndOffsetsReply, error) {
+	out := new(liiklus.GetEndOffsetsReply)
+	return out, c.invoke(ctx, "GetEndOffsets", in, out)
+}
+
+// Close closes the connection to the server, ending the pending calls.
+func (c *rsocketClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn != nil {
+		c.conn.fail(status.Error(codes.Canceled, "rsocket: client closed"))
+	}
+	return nil
+}
+
+// connection returns the connection to the server, establishing it again if it was lost.
+func (c *rsocketClient) connection(ctx context.Context) (*rsocketConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, status.Error(codes.Canceled, "rsocket: client closed")
+	}
+	if c.conn != nil && c.conn.failed() == nil {
+		return c.conn, nil
+	}
+	conn, err := openRSocketConn(ctx, c.address)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// invoke calls a unary method.
+func (c *rsocketClient) invoke(ctx context.Context, method string, in proto.Message, out proto.Message) error {
+	stream, err := c.call(ctx, rsocketRequestResponse, method, in)
+	if err != nil {
+		return err
+	}
+	defer stream.cancel()
+	if err := stream.RecvMsg(out); err != nil {
+		if err == io.EOF {
+			return status.Error(codes.Internal, "rsocket: no response message")
+		}
+		return err
+	}
+	return nil
+}
+
+// call sends a request of the given frame type to method, returning the stream of responses.
+func (c *rsocketClient) call(ctx context.Context, frameType uint16, method string, in proto.Message) (*rsocketStream, error) {
+	data, err := proto.Marshal(in)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn.request(ctx, frameType, method, data)
+}
+
+// rsocketConn is a connection to an RSocket server, on which the client sends requests and the server responds.
+type rsocketConn struct {
+	conn net.Conn
+	// writeMu serializes the frames written to conn.
+	writeMu sync.Mutex
+	// lastReceived is the time, in Unix nanoseconds, the last frame was received at.
+	lastReceived int64
+	// done is closed once the connection failed.
+	done chan struct{}
+
+	// mu guards streams, nextID and err.
+	mu      sync.Mutex
+	streams map[uint32]*rsocketStream
+	nextID  uint32
+	// err is the error the connection failed with, if it did.
+	err error
+}
+
+// openRSocketConn connects to the RSocket server listening on address, the context bounding the time spent
+// connecting.
+func openRSocketConn(ctx context.Context, address string) (*rsocketConn, error) {
+	var dialer net.Dialer
+	nc, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	c := &rsocketConn{
+		conn:         nc,
+		lastReceived: time.Now().UnixNano(),
+		done:         make(chan struct{}),
+		streams:      make(map[uint32]*rsocketStream),
+		nextID:       1,
+	}
+	// protocol version 1.0, followed by the keepalive settings and the MIME types of the metadata and data
+	setup := make([]byte, 12)
+	binary.BigEndian.PutUint16(setup, 1)
+	binary.BigEndian.PutUint32(setup[4:], uint32(rsocketKeepaliveInterval/time.Millisecond))
+	binary.BigEndian.PutUint32(setup[8:], uint32(rsocketMaxLifetime/time.Millisecond))
+	mimeType := []byte("application/binary")
+	mime := append([]byte{byte(len(mimeType))}, mimeType...)
+	if err := c.write(rsocketFrame(0, rsocketSetup, 0, setup, mime, mime)); err != nil {
+		return nil, err
+	}
+	go c.read()
+	go c.keepalive()
+	return c, nil
+}
+
+// request starts a stream sending a request of the given frame type to method.
+func (c *rsocketConn) request(ctx context.Context, frameType uint16, method string, data []byte) (*rsocketStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &rsocketStream{
+		conn:     c,
+		ctx:      ctx,
+		cancel:   cancel,
+		messages: make(chan []byte, rsocketCredits),
+		done:     make(chan struct{}),
+	}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		cancel()
+		return nil, c.err
+	}
+	s.id = c.nextID
+	c.nextID += 2
+	c.streams[s.id] = s
+	c.mu.Unlock()
+
+	var fields [][]byte
+	if frameType == rsocketRequestStream {
+		fields = append(fields, rsocketUint32(rsocketCredits))
+	}
+	md := rsocketRPCMetadata(ctx, method)
+	fields = append(fields, rsocketUint24(len(md)), md, data)
+	if err := c.write(rsocketFrame(s.id, frameType, rsocketFlagMetadata, fields...)); err != nil {
+		c.remove(s.id)
+		cancel()
+		return nil, err
+	}
+	go s.watch()
+	return s, nil
+}
+
+// write sends frame to the server, failing the connection if it can't.
+func (c *rsocketConn) write(frame []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.failed(); err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		err = status.Error(codes.Unavailable, err.Error())
+		c.fail(err)
+		return err
+	}
+	return nil
+}
+
+// read dispatches the frames received from the server to their streams, until the connection fails.
+func (c *rsocketConn) read() {
+	reader := bufio.NewReader(c.conn)
+	var length [3]byte
+	for {
+		if _, err := io.ReadFull(reader, length[:]); err != nil {
+			c.fail(status.Error(codes.Unavailable, err.Error()))
+			return
+		}
+		frame := make([]byte, int(length[0])<<16|int(length[1])<<8|int(length[2]))
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			c.fail(status.Error(codes.Unavailable, err.Error()))
+			return
+		}
+		atomic.StoreInt64(&c.lastReceived, time.Now().UnixNano())
+		if len(frame) < 6 {
+			c.fail(status.Error(codes.Internal, "rsocket: malformed frame"))
+			return
+		}
+		c.dispatch(frame)
+	}
+}
+
+// dispatch handles a frame received from the server.
+func (c *rsocketConn) dispatch(frame []byte) {
+	id := binary.BigEndian.Uint32(frame) & 0x7fffffff
+	typeAndFlags := binary.BigEndian.Uint16(frame[4:])
+	frameType, flags, body := typeAndFlags>>10, typeAndFlags&0x3ff, frame[6:]
+	switch frameType {
+	case rsocketKeepalive:
+		if flags&rsocketFlagRespond != 0 && len(body) >= 8 {
+			c.write(rsocketFrame(0, rsocketKeepalive, 0, body))
+		}
+	case rsocketError:
+		err := rsocketErrorStatus(body)
+		if id == 0 {
+			c.fail(err)
+		} else if s := c.remove(id); s != nil {
+			s.end(err)
+		}
+	case rsocketPayload:
+		c.mu.Lock()
+		s := c.streams[id]
+		c.mu.Unlock()
+		if s == nil {
+			return
+		}
+		data, ok := rsocketData(body, flags)
+		if !ok {
+			c.remove(id)
+			c.write(rsocketFrame(id, rsocketCancel, 0))
+			s.end(status.Error(codes.Internal, "rsocket: malformed payload"))
+			return
+		}
+		// fragments are only accessed by this goroutine
+		s.fragments = append(s.fragments, data...)
+		if flags&rsocketFlagFollows != 0 {
+			return
+		}
+		data, s.fragments = s.fragments, nil
+		if flags&rsocketFlagNext != 0 {
+			select {
+			case s.messages <- data:
+			default:
+				c.fail(status.Error(codes.Internal, "rsocket: server sent more messages than requested"))
+				return
+			}
+		}
+		if flags&rsocketFlagComplete != 0 {
+			c.remove(id)
+			s.end(io.EOF)
+		}
+	}
+}
+
+// keepalive sends keepalive frames to the server, failing the connection if the server stopped sending anything.
+func (c *rsocketConn) keepalive() {
+	ticker := time.NewTicker(rsocketKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		if time.Since(time.Unix(0, atomic.LoadInt64(&c.lastReceived))) > rsocketMaxLifetime {
+			c.fail(status.Error(codes.Unavailable, "rsocket: connection timed out"))
+			return
+		}
+		c.write(rsocketFrame(0, rsocketKeepalive, rsocketFlagRespond, make([]byte, 8)))
+	}
+}
+
+// failed returns the error the connection failed with, if any.
+func (c *rsocketConn) failed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// fail closes the connection, ending its streams with err.
+func (c *rsocketConn) fail(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = err
+	streams := c.streams
+	c.streams = nil
+	c.mu.Unlock()
+	close(c.done)
+	c.conn.Close()
+	for _, s := range streams {
+		s.end(err)
+	}
+}
+
+// remove forgets the stream with the given id, returning it unless it was already removed.
+func (c *rsocketConn) remove(id uint32) *rsocketStream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.streams[id]
+	delete(c.streams, id)
+	return s
+}
+
+// rsocketStream implements grpc.ClientStream over the payloads the server responds to a request with.
+type rsocketStream struct {
+	conn   *rsocketConn
+	id     uint32
+	ctx    context.Context
+	cancel context.CancelFunc
+	// messages buffers the messages received, up to the number requested.
+	messages chan []byte
+	// fragments accumulates the fragments of the message being received.
+	fragments []byte
+	// consumed counts the messages received since more were last requested.
+	consumed int
+	// done is closed once the stream ended, err holding why.
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func (s *rsocketStream) Header() (metadata.MD, error) { return metadata.MD{}, nil }
+func (s *rsocketStream) Trailer() metadata.MD         { return metadata.MD{} }
+func (s *rsocketStream) CloseSend() error             { return nil }
+func (s *rsocketStream) Context() context.Context     { return s.ctx }
+func (s *rsocketStream) SendMsg(m interface{}) error {
+	return status.Error(codes.Unimplemented, "rsocket: client streaming is not supported")
+}
+
+// RecvMsg reads the next message of the stream into m. It returns io.EOF once the stream ended successfully.
+func (s *rsocketStream) RecvMsg(m interface{}) error {
+	if s.ctx.Err() != nil {
+		return status.FromContextError(s.ctx.Err()).Err()
+	}
+	var data []byte
+	select {
+	case data = <-s.messages:
+	case <-s.done:
+		// the messages received before the stream ended are delivered first
+		select {
+		case data = <-s.messages:
+		default:
+			return s.err
+		}
+	}
+	s.consumed++
+	if s.consumed == rsocketCredits/2 {
+		s.consumed = 0
+		s.conn.write(rsocketFrame(s.id, rsocketRequestN, 0, rsocketUint32(rsocketCredits/2)))
+	}
+	if err := proto.Unmarshal(data, m.(proto.Message)); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// watch cancels the stream on the server once its context is done, unless it ended before.
+func (s *rsocketStream) watch() {
+	select {
+	case <-s.done:
+	case <-s.ctx.Done():
+		if s.conn.remove(s.id) != nil {
+			s.conn.write(rsocketFrame(s.id, rsocketCancel, 0))
+		}
+		s.end(status.FromContextError(s.ctx.Err()).Err())
+	}
+}
+
+// end ends the stream with err, unless it already ended.
+func (s *rsocketStream) end(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+type rsocketSubscribeClient struct {
+	*rsocketStream
+}
+
+func (x *rsocketSubscribeClient) Recv() (*liiklus.SubscribeReply, error) {
+	m := new(liiklus.SubscribeReply)
+	if err := x.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type rsocketReceiveClient struct {
+	*rsocketStream
+}
+
+func (x *rsocketReceiveClient) Recv() (*liiklus.ReceiveReply, error) {
+	m := new(liiklus.ReceiveReply)
+	if err := x.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// rsocketFrame encodes a frame of the given type and flags for stream id, its fields following the header, prefixed
+// by its length as frames are on TCP connections.
+func rsocketFrame(id uint32, frameType uint16, flags uint16, fields ...[]byte) []byte {
+	length := 6
+	for _, field := range fields {
+		length += len(field)
+	}
+	frame := make([]byte, 9, 3+length)
+	copy(frame, rsocketUint24(length))
+	binary.BigEndian.PutUint32(frame[3:], id)
+	binary.BigEndian.PutUint16(frame[7:], frameType<<10|flags)
+	for _, field := range fields {
+		frame = append(frame, field...)
+	}
+	return frame
+}
+
+// rsocketRPCMetadata encodes the RSocket-RPC metadata routing a request to method of the liiklus service, carrying
+// the outgoing gRPC metadata of ctx as tracing metadata.
+func rsocketRPCMetadata(ctx context.Context, method string) []byte {
+	service := strings.Trim(liiklusService, "/")
+	var tracing []byte
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for k, values := range md {
+		for _, v := range values {
+			tracing = append(tracing, rsocketUint16(len(k))...)
+			tracing = append(tracing, k...)
+			tracing = append(tracing, rsocketUint16(len(v))...)
+			tracing = append(tracing, v...)
+		}
+	}
+	m := rsocketUint16(1)
+	for _, field := range [][]byte{[]byte(service), []byte(method), tracing} {
+		m = append(m, rsocketUint16(len(field))...)
+		m = append(m, field...)
+	}
+	return m
+}
+
+// rsocketData returns the data of a payload frame, skipping its metadata.
+func rsocketData(body []byte, flags uint16) ([]byte, bool) {
+	if flags&rsocketFlagMetadata == 0 {
+		return body, true
+	}
+	if len(body) < 3 {
+		return nil, false
+	}
+	length := int(body[0])<<16 | int(body[1])<<8 | int(body[2])
+	if len(body) < 3+length {
+		return nil, false
+	}
+	return body[3+length:], true
+}
+
+// rsocketErrorStatus converts the body of an error frame to an error with the closest gRPC status.
+func rsocketErrorStatus(body []byte) error {
+	if len(body) < 4 {
+		return status.Error(codes.Internal, "rsocket: malformed error")
+	}
+	code := codes.Unknown
+	switch binary.BigEndian.Uint32(body) {
+	case 0x001, 0x002, 0x003, 0x004, 0x101, 0x102: // setup and connection errors
+		code = codes.Unavailable
+	case 0x202: // REJECTED
+		code = codes.Unavailable
+	case 0x203: // CANCELED
+		code = codes.Canceled
+	case 0x204: // INVALID
+		code = codes.InvalidArgument
+	}
+	return status.Error(code, string(body[4:]))
+}
+
+func rsocketUint16(v int) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+func rsocketUint24(v int) []byte {
+	return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func rsocketUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// SchemaVersionHeader is the header recording the version of the schema of the payload of events, see
+// StreamClient.SetSchemaVersion.
+const SchemaVersionHeader = "schemaversion"
+
+// SetSchemaVersion makes the client record version in the SchemaVersionHeader header of the events it publishes,
+// unless the header is set explicitly. A version of 0 stops recording it.
+func (lc *StreamClient) SetSchemaVersion(version int) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.schemaVersion = version
+}
+
+// SchemaVersion returns the schema version recorded by msg, defaulting to 1 for events published without one.
+func SchemaVersion(msg Message) (int, error) {
+	v, ok := msg.Headers[SchemaVersionHeader]
+	if !ok {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid schema version %q for event %s", v, msg.ID)
+	}
+	return version, nil
+}
+
+// Schema decodes payloads of any known schema version into values of type T, the shape of the latest version. Each
+// version has a decoder, and older versions are upcast one version at a time until they reach the latest, so that
+// producers and consumers can evolve payload shapes independently. Decoders and upcasters must be registered before
+// the schema is used.
+type Schema[T any] struct {
+	decoders  map[int]func(msg Message) (interface{}, error)
+	upcasters map[int]func(v interface{}) (interface{}, error)
+	latest    int
+}
+
+// NewSchema creates a Schema without any known version.
+func NewSchema[T any]() *Schema[T] {
+	return &Schema[T]{
+		decoders:  make(map[int]func(msg Message) (interface{}, error)),
+		upcasters: make(map[int]func(v interface{}) (interface{}, error)),
+	}
+}
+
+// Decoder registers decode as the decoder of payloads of the given schema version. It returns the schema, for
+// chaining.
+func (s *Schema[T]) Decoder(version int, decode func(msg Message) (interface{}, error)) *Schema[T] {
+	s.decoders[version] = decode
+	if version > s.latest {
+		s.latest = version
+	}
+	return s
+}
+
+// Upcaster registers upcast as the function converting values of the given schema version to the next one. It
+// returns the schema, for chaining.
+func (s *Schema[T]) Upcaster(from int, upcast func(v interface{}) (interface{}, error)) *Schema[T] {
+	s.upcasters[from] = upcast
+	if from+1 > s.latest {
+		s.latest = from + 1
+	}
+	return s
+}
+
+// Decode decodes the payload of msg with the decoder of its schema version, then upcasts it to the latest version.
+func (s *Schema[T]) Decode(msg Message) (T, error) {
+	var zero T
+	version, err := SchemaVersion(msg)
+	if err != nil {
+		return zero, err
+	}
+	decode, ok := s.decoders[version]
+	if !ok {
+		return zero, fmt.Errorf("no decoder for schema version %d of event %s", version, msg.ID)
+	}
+	v, err := decode(msg)
+	if err != nil {
+		return zero, fmt.Errorf("unable to decode event %s: %w", msg.ID, err)
+	}
+	for ; version < s.latest; version++ {
+		upcast, ok := s.upcasters[version]
+		if !ok {
+			return zero, fmt.Errorf("no upcaster from schema version %d of event %s", version, msg.ID)
+		}
+		if v, err = upcast(v); err != nil {
+			return zero, fmt.Errorf("unable to upcast event %s from schema version %d: %w", msg.ID, version, err)
+		}
+	}
+	t, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("schema version %d of event %s decoded to %T, not %T", version, msg.ID, v, zero)
+	}
+	return t, nil
+}
+
+// Handler adapts f to a MessageHandler decoding the payload of each message before handing it over to f, along with
+// the message it was read from.
+func (s *Schema[T]) Handler(f func(ctx context.Context, value T, msg Message) error) MessageHandler {
+	return func(ctx context.Context, msg Message) error {
+		value, err := s.Decode(msg)
+		if err != nil {
+			return err
+		}
+		return f(ctx, value, msg)
+	}
+}
+
+// CodecDecoder returns a decoder unmarshaling payloads into values of type V, with the Codec registered with lc for
+// their content type. It is meant to be registered with Schema.Decoder.
+func CodecDecoder[V any](lc *StreamClient) func(msg Message) (interface{}, error) {
+	return func(msg Message) (interface{}, error) {
+		var v V
+		if err := lc.Decode(msg, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
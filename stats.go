@@ -0,0 +1,159 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// endOffsetsRefreshInterval is how often the end offsets used to estimate lag may be fetched by Stats.
+const endOffsetsRefreshInterval = 5 * time.Second
+
+// SubscriptionStats is a point in time snapshot of the activity of a Subscription.
+type SubscriptionStats struct {
+	// Processed is the number of events successfully handled.
+	Processed uint64
+	// Errors is the number of errors reported to the EventErrHandler.
+	Errors uint64
+	// LastReceived is the time the last message was received, or the zero time if none was.
+	LastReceived time.Time
+	// Partitions describe the position of the subscription in each partition it has handled events of, ordered by
+	// topic and partition.
+	Partitions []PartitionStats
+}
+
+// PartitionStats describes the position of a subscription in a partition.
+type PartitionStats struct {
+	// Topic is the topic the partition belongs to.
+	Topic string
+	// Partition is the partition number.
+	Partition uint32
+	// LastHandled is the offset of the last event handled in the partition.
+	LastHandled uint64
+	// LastCommitted is the last offset committed in the partition. It is only meaningful if Committed is set.
+	LastCommitted uint64
+	Committed     bool
+	// Lag is an estimate of the number of events of the partition that remain to be handled. It is based on the end
+	// offsets of the partition, which are fetched in the background, no more than every 5 seconds, when Stats is
+	// called. It is only meaningful if LagKnown is set.
+	Lag      uint64
+	LagKnown bool
+}
+
+// position is the progress of a subscription in a partition.
+type position struct {
+	handled uint64
+	// hasHandled is set once an event has been handled.
+	hasHandled bool
+	committed  uint64
+	// hasCommitted is set once an offset has been committed.
+	hasCommitted bool
+}
+
+// Stats returns a snapshot of the activity of the subscription.
+func (s *Subscription) Stats() SubscriptionStats {
+	stats := SubscriptionStats{
+		Processed: atomic.LoadUint64(&s.processed),
+		Errors:    atomic.LoadUint64(&s.errors),
+	}
+	if last := atomic.LoadInt64(&s.lastReceived); last != 0 {
+		stats.LastReceived = time.Unix(0, last)
+	}
+
+	s.mu.Lock()
+	for tp, p := range s.positions {
+		if !p.hasHandled {
+			continue
+		}
+		ps := PartitionStats{
+			Topic:         tp.topic,
+			Partition:     tp.partition,
+			LastHandled:   p.handled,
+			LastCommitted: p.committed,
+			Committed:     p.hasCommitted,
+		}
+		if end, ok := s.endOffsets[tp]; ok {
+			ps.LagKnown = true
+			if end > p.handled {
+				ps.Lag = end - p.handled
+			}
+		}
+		stats.Partitions = append(stats.Partitions, ps)
+	}
+	refresh := time.Since(s.endOffsetsFetched) >= endOffsetsRefreshInterval
+	if refresh {
+		s.endOffsetsFetched = time.Now()
+	}
+	s.mu.Unlock()
+
+	if refresh && s.ctx.Err() == nil && s.client != nil {
+		go s.fetchEndOffsets()
+	}
+	sort.Slice(stats.Partitions, func(i, j int) bool {
+		a, b := stats.Partitions[i], stats.Partitions[j]
+		return a.Topic < b.Topic || a.Topic == b.Topic && a.Partition < b.Partition
+	})
+	return stats
+}
+
+// fetchEndOffsets refreshes the end offsets used to estimate lag.
+func (s *Subscription) fetchEndOffsets() {
+	ctx, cancel := context.WithTimeout(s.ctx, endOffsetsRefreshInterval)
+	defer cancel()
+	for _, topic := range s.topics {
+		offsets, err := s.client.endOffsets(ctx, topic)
+		if err != nil {
+			// lag remains unknown or stale, which Stats users can live with
+			return
+		}
+		s.mu.Lock()
+		for partition, offset := range offsets {
+			s.endOffsets[topicPartition{topic: topic, partition: partition}] = offset
+		}
+		s.mu.Unlock()
+	}
+}
+
+// recordHandled records that msg has been handled.
+func (s *Subscription) recordHandled(msg Message) {
+	tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.positions[tp]
+	if !ok {
+		p = &position{}
+		s.positions[tp] = p
+	}
+	p.handled, p.hasHandled = msg.Offset, true
+}
+
+// recordCommitted records that offset has been committed in the partition of topic.
+func (s *Subscription) recordCommitted(topic string, partition uint32, offset uint64) {
+	tp := topicPartition{topic: topic, partition: partition}
+	s.mu.Lock()
+	p, ok := s.positions[tp]
+	if !ok {
+		p = &position{}
+		s.positions[tp] = p
+	}
+	p.committed, p.hasCommitted = offset, true
+	s.mu.Unlock()
+	s.options.committed(topic, partition, offset)
+}
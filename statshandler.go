@@ -0,0 +1,186 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// StatsHandler is notified of the activity of a StreamClient and its subscriptions, so that metrics can be shipped to
+// any monitoring system, such as Prometheus (see package pkg/prometheus), statsd, OTLP or expvar. Implementations must
+// be safe for concurrent use, and should embed BaseStatsHandler to only implement the notifications they care about.
+// Anonymous subscriptions are reported with an empty group, so that their random group names don't leak into metric
+// labels.
+type StatsHandler interface {
+	// OnPublish is called once an event has been published, or failed to be.
+	OnPublish(stats PublishStats)
+	// OnReceive is called for each message read by a subscription.
+	OnReceive(stats ReceiveStats)
+	// OnHandle is called once a handler invocation has returned.
+	OnHandle(stats HandleStats)
+	// OnAck is called once an offset has been committed, or failed to be.
+	OnAck(stats AckStats)
+	// OnReconnect is called when a receive stream is re-established, after a seek, a receive deadline or an
+	// unavailable gateway.
+	OnReconnect(stats ReconnectStats)
+	// OnError is called for each error reported to the EventErrHandler of a subscription.
+	OnError(stats ErrorStats)
+}
+
+// PublishStats describes the publication of an event.
+type PublishStats struct {
+	Topic string
+	// Partition and Offset are the position of the event in the stream, if it was published successfully.
+	Partition uint32
+	Offset    uint64
+	Duration  time.Duration
+	Err       error
+}
+
+// ReceiveStats describes a message read by a subscription.
+type ReceiveStats struct {
+	Topic     string
+	Group     string
+	Partition uint32
+	Offset    uint64
+}
+
+// HandleStats describes a handler invocation, for a single message or a batch.
+type HandleStats struct {
+	Topic string
+	Group string
+	// Count is the number of messages handed over to the handler.
+	Count    int
+	Duration time.Duration
+	Err      error
+}
+
+// AckStats describes the commit of an offset.
+type AckStats struct {
+	Topic     string
+	Group     string
+	Partition uint32
+	Offset    uint64
+	Duration  time.Duration
+	Err       error
+}
+
+// ReconnectStats describes the re-establishment of a receive stream.
+type ReconnectStats struct {
+	Topic     string
+	Group     string
+	Partition uint32
+}
+
+// ErrorStats describes an error reported to the EventErrHandler of a subscription.
+type ErrorStats struct {
+	Group string
+	Err   error
+}
+
+// BaseStatsHandler is a StatsHandler ignoring every notification, meant to be embedded by implementations.
+type BaseStatsHandler struct{}
+
+func (BaseStatsHandler) OnPublish(PublishStats)     {}
+func (BaseStatsHandler) OnReceive(ReceiveStats)     {}
+func (BaseStatsHandler) OnHandle(HandleStats)       {}
+func (BaseStatsHandler) OnAck(AckStats)             {}
+func (BaseStatsHandler) OnReconnect(ReconnectStats) {}
+func (BaseStatsHandler) OnError(ErrorStats)         {}
+
+// MultiStatsHandler returns a StatsHandler notifying each of handlers in turn.
+func MultiStatsHandler(handlers ...StatsHandler) StatsHandler {
+	return multiStatsHandler(handlers)
+}
+
+type multiStatsHandler []StatsHandler
+
+func (m multiStatsHandler) OnPublish(stats PublishStats) {
+	for _, h := range m {
+		h.OnPublish(stats)
+	}
+}
+
+func (m multiStatsHandler) OnReceive(stats ReceiveStats) {
+	for _, h := range m {
+		h.OnReceive(stats)
+	}
+}
+
+func (m multiStatsHandler) OnHandle(stats HandleStats) {
+	for _, h := range m {
+		h.OnHandle(stats)
+	}
+}
+
+func (m multiStatsHandler) OnAck(stats AckStats) {
+	for _, h := range m {
+		h.OnAck(stats)
+	}
+}
+
+func (m multiStatsHandler) OnReconnect(stats ReconnectStats) {
+	for _, h := range m {
+		h.OnReconnect(stats)
+	}
+}
+
+func (m multiStatsHandler) OnError(stats ErrorStats) {
+	for _, h := range m {
+		h.OnError(stats)
+	}
+}
+
+// SetStatsHandler makes the client notify h of its activity. A nil h disables notifications.
+func (lc *StreamClient) SetStatsHandler(h StatsHandler) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.statsHandler = h
+}
+
+// stats returns the stats handler of the client, which ignores everything if none is set.
+func (lc *StreamClient) stats() StatsHandler {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.statsHandler == nil {
+		return BaseStatsHandler{}
+	}
+	return lc.statsHandler
+}
+
+// statsGroup is the group the subscription is reported under to the stats handler.
+func (s *Subscription) statsGroup() string {
+	if s.anonymous {
+		return ""
+	}
+	return s.group
+}
+
+// instrument runs handle for msgs, within a consumer span, and reports its duration and outcome to the stats handler.
+func (s *Subscription) instrument(ctx context.Context, msgs []Message, handle func(ctx context.Context) error) error {
+	start := time.Now()
+	err := s.trace(ctx, msgs, handle)
+	s.client.stats().OnHandle(HandleStats{
+		Topic:    msgs[0].Topic,
+		Group:    s.statsGroup(),
+		Count:    len(msgs),
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	return err
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+)
+
+// StreamWriter is an io.WriteCloser publishing what is written to it as events, so that code speaking io interfaces
+// can be piped onto streams. It is not safe for concurrent use by multiple goroutines.
+type StreamWriter struct {
+	client      *StreamClient
+	ctx         context.Context
+	contentType string
+	split       bufio.SplitFunc
+	buf         []byte
+	closed      bool
+}
+
+// NewStreamWriter creates a StreamWriter publishing events of the given content type, within ctx. If split is nil,
+// each call to Write publishes an event. Otherwise, written data is framed into events by split, for example
+// bufio.ScanLines publishes an event per line, and data left over is published when the writer is closed.
+func (lc *StreamClient) NewStreamWriter(ctx context.Context, contentType string, split bufio.SplitFunc) *StreamWriter {
+	return &StreamWriter{
+		client:      lc,
+		ctx:         ctx,
+		contentType: contentType,
+		split:       split,
+	}
+}
+
+// Write publishes p, or the events framed from the data written so far.
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed StreamWriter")
+	}
+	if w.split == nil {
+		if err := w.publish(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	w.buf = append(w.buf, p...)
+	if err := w.flush(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close publishes data left over by framing, if any.
+func (w *StreamWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.split == nil {
+		return nil
+	}
+	return w.flush(true)
+}
+
+// flush publishes the events framed from the buffered data.
+func (w *StreamWriter) flush(atEOF bool) error {
+	for len(w.buf) > 0 {
+		advance, token, err := w.split(w.buf, atEOF)
+		if err != nil && err != bufio.ErrFinalToken {
+			return err
+		}
+		if advance == 0 && token == nil {
+			// more data is needed
+			return nil
+		}
+		if token != nil {
+			if err := w.publish(token); err != nil {
+				return err
+			}
+		}
+		w.buf = w.buf[advance:]
+		if err == bufio.ErrFinalToken {
+			w.buf = nil
+		}
+	}
+	return nil
+}
+
+// publish publishes payload as an event.
+func (w *StreamWriter) publish(payload []byte) error {
+	_, err := w.client.Publish(w.ctx, bytes.NewReader(payload), nil, w.contentType, nil)
+	return err
+}
+
+// StreamReader is an io.ReadCloser concatenating the payloads of the messages returned by a Reader, so that code
+// speaking io interfaces can be fed from streams. Each message is acknowledged once its payload has been entirely read.
+type StreamReader struct {
+	r         *Reader
+	delimiter []byte
+	buf       []byte
+}
+
+// NewStreamReader creates a StreamReader reading the messages of r, following each payload with delimiter, which may
+// be nil. Read returns io.EOF once r has been closed.
+func NewStreamReader(r *Reader, delimiter []byte) *StreamReader {
+	return &StreamReader{
+		r:         r,
+		delimiter: delimiter,
+	}
+}
+
+// Read reads the payloads of messages, blocking until a message is available if none is being read.
+func (s *StreamReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(s.buf) == 0 {
+		msg, err := s.r.Next(context.Background())
+		if err != nil {
+			return 0, err
+		}
+		s.buf = append(append(s.buf, msg.Payload...), s.delimiter...)
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Close closes the underlying Reader.
+func (s *StreamReader) Close() error {
+	return s.r.Close()
+}
@@ -0,0 +1,405 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// Subscription is a handle on an active subscription, as returned by StreamClient.Subscribe. It allows
+// cancelling the subscription, waiting for it to terminate and inspecting why it stopped. Its methods are safe for
+// concurrent use.
+type Subscription struct {
+	// client is the client this subscription was created from, or nil for stubs.
+	client *StreamClient
+	// topics are the topics consumed by this subscription.
+	topics []string
+	// group is the consumer group of this subscription.
+	group string
+	// anonymous is set when the group was generated for this subscription only, in which case offsets are not
+	// committed.
+	anonymous bool
+	// startOffsets are the offsets of the first messages recorded at or after the start time of the subscription, if
+	// any, in each partition of its topics.
+	startOffsets map[topicPartition]uint64
+	// ctx is the context shared by all goroutines of this subscription, used for handling events.
+	ctx context.Context
+	// cancel cancels ctx.
+	cancel context.CancelFunc
+	// fetchCtx is the context used to fetch events.
+	fetchCtx context.Context
+	// stopFetching cancels fetchCtx, leaving events being handled unaffected.
+	stopFetching context.CancelFunc
+	// consume processes each message read from the stream.
+	consume consumer
+	// errs is notified of errors.
+	errs EventErrHandler
+	// options are the options this subscription was created with.
+	options *subscribeOptions
+	// draining is set to 1 once Drain has been called.
+	draining int32
+	// done is closed once every goroutine of this subscription has returned.
+	done chan struct{}
+	// wg tracks the goroutines of this subscription.
+	wg sync.WaitGroup
+	// inFlight holds a token per message read but not yet committed, if the number of such messages is bounded.
+	inFlight chan struct{}
+
+	mu  sync.Mutex
+	err error
+	// partitions are the partitions currently assigned to this subscription.
+	partitions map[topicPartition]*partitionReader
+	// pendingAcks are the failed commits being retried, retryingAcks being set while they are.
+	pendingAcks  map[topicPartition]*pendingAck
+	retryingAcks bool
+
+	// positions are the offsets handled and committed in each partition, guarded by mu.
+	positions map[topicPartition]*position
+	// endOffsets are the offsets of the last messages of each partition, as last fetched for estimating lag, guarded
+	// by mu.
+	endOffsets map[topicPartition]uint64
+	// endOffsetsFetched is when endOffsets were last fetched, guarded by mu.
+	endOffsetsFetched time.Time
+
+	processed uint64
+	errors    uint64
+	// created is when this subscription was created.
+	created time.Time
+	// lastReceived is the time a message was last received, in nanoseconds since the epoch, or zero if none was.
+	lastReceived int64
+}
+
+func newSubscription(client *StreamClient, topics []string, group string, ctx context.Context, cancel context.CancelFunc, fetchCtx context.Context, stopFetching context.CancelFunc, consume consumer, errs EventErrHandler, options *subscribeOptions) *Subscription {
+	s := &Subscription{
+		client:       client,
+		topics:       topics,
+		group:        group,
+		ctx:          ctx,
+		cancel:       cancel,
+		fetchCtx:     fetchCtx,
+		stopFetching: stopFetching,
+		consume:      consume,
+		errs:         errs,
+		options:      options,
+		done:         make(chan struct{}),
+		partitions:   make(map[topicPartition]*partitionReader),
+		pendingAcks:  make(map[topicPartition]*pendingAck),
+		positions:    make(map[topicPartition]*position),
+		endOffsets:   make(map[topicPartition]uint64),
+		created:      time.Now(),
+	}
+	if options.maxInFlight > 0 {
+		s.inFlight = make(chan struct{}, options.maxInFlight)
+	}
+	return s
+}
+
+// markReceived records that a message has just been received.
+func (s *Subscription) markReceived() {
+	atomic.StoreInt64(&s.lastReceived, time.Now().UnixNano())
+}
+
+// watchIdle calls the idle callback once per period of time without receiving messages, until fetching stops.
+func (s *Subscription) watchIdle() {
+	var notified int64
+	for {
+		last := atomic.LoadInt64(&s.lastReceived)
+		if last == 0 {
+			last = s.created.UnixNano()
+		}
+		wait := s.options.idleTimeout - time.Since(time.Unix(0, last))
+		if wait <= 0 {
+			if notified != last {
+				notified = last
+				s.options.onIdle(time.Unix(0, last))
+			}
+			wait = s.options.idleTimeout
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.fetchCtx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Cancel stops the subscription, abandoning any event being handled. It does not wait for the subscription to
+// terminate, use Done for that.
+func (s *Subscription) Cancel() {
+	s.cancel()
+}
+
+// Drain gracefully stops the subscription: no more events are fetched, events being handled are allowed to complete
+// and their offsets are committed. Drain blocks until the subscription has terminated, or until ctx is done in which
+// case the subscription is cancelled and the context error is returned.
+func (s *Subscription) Drain(ctx context.Context) error {
+	atomic.StoreInt32(&s.draining, 1)
+	s.stopFetching()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return ctx.Err()
+	}
+}
+
+// Run blocks until ctx is done or the subscription terminates on its own, simplifying shutdown wiring in main
+// functions. Once ctx is done, the subscription is drained, for as long as Close waits for subscriptions, and Run
+// returns nil, or the error returned by Drain if it timed out. Otherwise Run returns the error the subscription
+// terminated with, as returned by Err. Run is meant to be combined with signal.NotifyContext, and fits in an errgroup:
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+//	defer stop()
+//	g, ctx := errgroup.WithContext(ctx)
+//	g.Go(func() error { return orders.Run(ctx) })
+//	g.Go(func() error { return payments.Run(ctx) })
+//	err := g.Wait()
+func (s *Subscription) Run(ctx context.Context) error {
+	select {
+	case <-s.done:
+		return s.Err()
+	case <-ctx.Done():
+	}
+	drainCtx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return s.Drain(drainCtx)
+}
+
+// isDraining reports whether Drain has been called, in which case errors caused by the fetch context being
+// cancelled are expected and not reported.
+func (s *Subscription) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// Done returns a channel that is closed once the subscription has terminated.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the first error encountered by the subscription, or nil if none occurred. It is typically
+// consulted after Done is closed to find out why the subscription stopped.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// goroutine runs f in a new goroutine tracked by this subscription.
+func (s *Subscription) goroutine(f func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		f()
+	}()
+}
+
+// wait closes the done channel once all tracked goroutines have returned. It must be called after the first
+// goroutine has been started.
+func (s *Subscription) wait() {
+	go func() {
+		s.wg.Wait()
+		s.cancel()
+		for _, finalize := range s.options.finalizers {
+			finalize()
+		}
+		close(s.done)
+		s.options.stopped(s.Err())
+	}()
+}
+
+// fail records err as the cause of termination, if none was recorded already, and forwards it to e.
+func (s *Subscription) fail(e EventErrHandler, err error) {
+	atomic.AddUint64(&s.errors, 1)
+	s.client.stats().OnError(ErrorStats{Group: s.statsGroup(), Err: err})
+	s.client.log.Error(err, "subscription error", "topics", s.topics, "group", s.group)
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	e(s.cancel, err)
+}
+
+// acquire blocks until another message may be read from the stream without exceeding the in-flight limit, or ctx is
+// done.
+func (s *Subscription) acquire(ctx context.Context) error {
+	if s.inFlight == nil {
+		return nil
+	}
+	select {
+	case s.inFlight <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release signals that count messages are no longer in flight, whether they have been committed or abandoned.
+func (s *Subscription) release(count int) {
+	if s.inFlight == nil {
+		return
+	}
+	for i := 0; i < count; i++ {
+		<-s.inFlight
+	}
+}
+
+// commit acknowledges that every event up to offset in the partition of topic has been handled, count of them since
+// the last commit. Unless configured otherwise, failures are retried in the background rather than returned.
+func (s *Subscription) commit(ctx context.Context, topic string, partition uint32, offset uint64, count int) error {
+	s.client.committed(s.group, topic, partition, offset)
+	if s.anonymous || s.options.offsetStore != nil {
+		atomic.AddUint64(&s.processed, uint64(count))
+		return nil
+	}
+	if err := s.ack(ctx, topic, partition, offset); err != nil {
+		if s.options.ackAttempts <= 1 || ctx.Err() != nil {
+			return s.errorAt(PhaseAck, topic, partition, offset, err)
+		}
+		s.client.log.Info("unable to commit offset, retrying in the background", "topic", topic, "group", s.group, "partition", partition, "offset", offset, "error", err)
+		s.retryAck(topic, partition, offset, count)
+		return nil
+	}
+	s.acked(topic, partition, offset)
+	s.recordCommitted(topic, partition, offset)
+	atomic.AddUint64(&s.processed, uint64(count))
+	return nil
+}
+
+// handled records that count events have been handled after their offsets were committed.
+func (s *Subscription) handled(count int) {
+	atomic.AddUint64(&s.processed, uint64(count))
+}
+
+// ack commits offset in the partition of topic for the group of the subscription.
+func (s *Subscription) ack(ctx context.Context, topic string, partition uint32, offset uint64) error {
+	ackRequest := liiklus.AckRequest{
+		Topic:        topic,
+		Group:        s.group,
+		GroupVersion: s.options.groupVersion,
+		Partition:    partition,
+		Offset:       offset,
+	}
+	start := time.Now()
+	_, err := s.client.client.Ack(ctx, &ackRequest)
+	if err == nil {
+		s.client.log.Debug("committed offset", "topic", topic, "group", s.group, "partition", partition, "offset", offset)
+	}
+	s.client.stats().OnAck(AckStats{
+		Topic:     topic,
+		Group:     s.statsGroup(),
+		Partition: partition,
+		Offset:    offset,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+	return gatewayError("ack", err)
+}
+
+// consumer processes a message read from the stream, including committing its offset once appropriate.
+type consumer = func(ctx context.Context, sub *Subscription, msg Message) error
+
+// oneByOne returns a consumer invoking h for each message and committing its offset right after, or right before in
+// at-most-once mode.
+func (o *subscribeOptions) oneByOne(h MessageHandler) consumer {
+	h = o.wrap(h)
+	return func(ctx context.Context, sub *Subscription, msg Message) error {
+		defer sub.release(1)
+		if !o.accept(msg) {
+			if o.ackFiltered {
+				return sub.commit(ctx, msg.Topic, msg.Partition, msg.Offset, 0)
+			}
+			return nil
+		}
+		if sub.reportUndecodable(ctx, msg) || o.duplicate(msg) || o.expired(msg) || sub.reportInvalid(msg) {
+			return sub.commit(ctx, msg.Topic, msg.Partition, msg.Offset, 0)
+		}
+		if o.atMostOnce {
+			if err := sub.commit(ctx, msg.Topic, msg.Partition, msg.Offset, 0); err != nil {
+				return err
+			}
+		}
+		o.observeLatency(msg)
+		if err := o.handle(ctx, func(ctx context.Context) error {
+			return sub.instrument(ctx, []Message{msg}, func(ctx context.Context) error {
+				return h(ctx, msg)
+			})
+		}); err != nil {
+			return sub.errorAt(PhaseHandle, msg.Topic, msg.Partition, msg.Offset, err)
+		}
+		o.handled(msg)
+		sub.recordHandled(msg)
+		if o.atMostOnce {
+			sub.handled(1)
+			return nil
+		}
+		return sub.commit(ctx, msg.Topic, msg.Partition, msg.Offset, 1)
+	}
+}
+
+// PanicError is reported to the EventErrHandler when the EventHandler panics.
+type PanicError struct {
+	// Value is the value the handler panicked with.
+	Value interface{}
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// handle invokes a handler through call, enforcing the handler timeout if one is configured.
+func (o *subscribeOptions) handle(ctx context.Context, call func(context.Context) error) error {
+	if o.handlerTimeout <= 0 {
+		return o.invoke(ctx, call)
+	}
+	ctx, cancel := context.WithTimeout(ctx, o.handlerTimeout)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- o.invoke(ctx, call)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("handler did not complete within %s: %w", o.handlerTimeout, ctx.Err())
+	}
+}
+
+// invoke invokes a handler through call, converting a panic into a PanicError unless panic recovery is disabled.
+func (o *subscribeOptions) invoke(ctx context.Context, call func(context.Context) error) (err error) {
+	if o.recoverPanics {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+	}
+	return call(ctx)
+}
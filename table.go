@@ -0,0 +1,297 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// TableStore holds the entries of a Table, the latest message of each key. Implementations must be safe for concurrent
+// use. NewMemoryTableStore returns one holding entries in memory, other implementations may persist them, for example
+// to an embedded database.
+type TableStore interface {
+	// Get returns the entry of key, and whether there is one.
+	Get(key string) (Message, bool, error)
+	// Put sets the entry of key.
+	Put(key string, msg Message) error
+	// Delete removes the entry of key, if any.
+	Delete(key string) error
+	// Range calls f for each entry, in no particular order, until f returns false.
+	Range(f func(key string, msg Message) bool) error
+}
+
+// NewMemoryTableStore returns a TableStore holding entries in memory.
+func NewMemoryTableStore() TableStore {
+	return &memoryTableStore{entries: make(map[string]Message)}
+}
+
+type memoryTableStore struct {
+	mu      sync.RWMutex
+	entries map[string]Message
+}
+
+func (s *memoryTableStore) Get(key string) (Message, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	msg, ok := s.entries[key]
+	return msg, ok, nil
+}
+
+func (s *memoryTableStore) Put(key string, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = msg
+	return nil
+}
+
+func (s *memoryTableStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memoryTableStore) Range(f func(key string, msg Message) bool) error {
+	s.mu.RLock()
+	entries := make(map[string]Message, len(s.entries))
+	for k, v := range s.entries {
+		entries[k] = v
+	}
+	s.mu.RUnlock()
+	for k, v := range entries {
+		if !f(k, v) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// TableChange describes the change of the entry of a key of a Table.
+type TableChange struct {
+	Key string
+	// Previous is the previous entry of the key, or nil if there was none.
+	Previous *Message
+	// Current is the new entry of the key, or nil if the key was deleted by a tombstone.
+	Current *Message
+}
+
+// TableOption configures optional behavior of a Table.
+type TableOption func(*Table)
+
+// WithTableStore holds the entries of the table in store, rather than in memory.
+func WithTableStore(store TableStore) TableOption {
+	return func(t *Table) {
+		t.store = store
+	}
+}
+
+// WithTableListener calls f for each change of the table, once it has been applied. Changes of a key are notified in
+// order, changes of keys of different partitions may be notified concurrently.
+func WithTableListener(f func(change TableChange)) TableOption {
+	return func(t *Table) {
+		t.listeners = append(t.listeners, f)
+	}
+}
+
+// Table is a local read model of a stream, typically a compacted one, holding the latest message of each key. Messages
+// without a key are ignored, and tombstones, as published by PublishTombstone, delete their key. Tables can be
+// snapshotted and restored from a snapshot, so that applications don't need to read the whole stream when restarting.
+type Table struct {
+	client    *StreamClient
+	store     TableStore
+	listeners []func(change TableChange)
+
+	// mu guards the consistency of positions with the store, so that snapshots are consistent.
+	mu        sync.Mutex
+	positions map[uint32]uint64
+	started   bool
+}
+
+// NewTable creates a Table materializing the stream of lc.
+func NewTable(lc *StreamClient, opts ...TableOption) *Table {
+	t := &Table{
+		client:    lc,
+		store:     NewMemoryTableStore(),
+		positions: make(map[uint32]uint64),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Start starts materializing the stream, from the beginning or from the position of the snapshot the table was
+// restored from, which goes on until the returned Subscription is stopped. Optional behavior of the underlying
+// anonymous subscription may be configured by passing SubscribeOptions, as for Subscribe.
+func (t *Table) Start(ctx context.Context, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	t.mu.Lock()
+	t.started = true
+	t.mu.Unlock()
+	opts = append(opts, WithTombstones(), WithOffsetStore(tablePositions{t}))
+	return t.client.SubscribeMessages(ctx, "", true, t.apply, e, opts...)
+}
+
+// Get returns the entry of key, and whether there is one.
+func (t *Table) Get(key string) (Message, bool, error) {
+	return t.store.Get(key)
+}
+
+// Range calls f for each entry of the table, in no particular order, until f returns false.
+func (t *Table) Range(f func(key string, msg Message) bool) error {
+	return t.store.Range(f)
+}
+
+// apply updates the table with msg.
+func (t *Table) apply(ctx context.Context, msg Message) error {
+	if len(msg.Key) == 0 {
+		t.advance(msg)
+		return nil
+	}
+	key := string(msg.Key)
+	t.mu.Lock()
+	previous, existed, err := t.store.Get(key)
+	if err == nil {
+		if IsTombstone(msg) {
+			err = t.store.Delete(key)
+		} else {
+			err = t.store.Put(key, msg)
+		}
+	}
+	if err != nil {
+		t.mu.Unlock()
+		return fmt.Errorf("unable to update the entry of key %q: %w", key, err)
+	}
+	t.positions[msg.Partition] = msg.Offset
+	t.mu.Unlock()
+
+	if !existed && IsTombstone(msg) {
+		return nil
+	}
+	change := TableChange{Key: key}
+	if existed {
+		change.Previous = &previous
+	}
+	if !IsTombstone(msg) {
+		change.Current = &msg
+	}
+	for _, f := range t.listeners {
+		f(change)
+	}
+	return nil
+}
+
+// advance records that msg has been applied, without changing any entry.
+func (t *Table) advance(msg Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.positions[msg.Partition] = msg.Offset
+}
+
+// tableSnapshotHeader is the first line of a snapshot, holding the position of the table in each partition.
+type tableSnapshotHeader struct {
+	Offsets map[uint32]uint64 `json:"offsets"`
+}
+
+// Snapshot writes the entries of the table to w, along with the position of the table in the stream, so that it can
+// be restored from that position. Entries are written as JSON encoded CloudEvents, one per line, following a line
+// holding the position, and may be inspected as such. Updates are blocked while the snapshot is written.
+func (t *Table) Snapshot(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(tableSnapshotHeader{Offsets: t.positions}); err != nil {
+		return err
+	}
+	var err error
+	if rangeErr := t.store.Range(func(key string, msg Message) bool {
+		err = encoder.Encode(encodeEvent(msg))
+		return err == nil
+	}); rangeErr != nil {
+		return rangeErr
+	}
+	return err
+}
+
+// Restore replaces the entries of the table with those of a snapshot written by Snapshot, and resumes the table from
+// the position of the snapshot once started. Restore must be called before Start.
+func (t *Table) Restore(r io.Reader) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.started {
+		return errors.New("table already started")
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return errors.New("empty snapshot")
+	}
+	var header tableSnapshotHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	var keys []string
+	if err := t.store.Range(func(key string, msg Message) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := t.store.Delete(key); err != nil {
+			return err
+		}
+	}
+	for scanner.Scan() {
+		msg, err := ParseCloudEventJSON(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("invalid snapshot entry: %w", err)
+		}
+		msg.Topic = t.client.TopicName
+		if err := t.store.Put(string(msg.Key), msg); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	t.positions = header.Offsets
+	if t.positions == nil {
+		t.positions = make(map[uint32]uint64)
+	}
+	return nil
+}
+
+// tablePositions resumes the subscription of a Table from the position of the table.
+type tablePositions struct {
+	table *Table
+}
+
+func (p tablePositions) Load(ctx context.Context, topic string, group string, partition uint32) (uint64, bool, error) {
+	p.table.mu.Lock()
+	defer p.table.mu.Unlock()
+	offset, ok := p.table.positions[partition]
+	return offset, ok, nil
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+)
+
+// TopicCreator creates topics, for gateways whose backing storage doesn't create topics on first use.
+type TopicCreator interface {
+	CreateTopic(ctx context.Context, topic string) error
+}
+
+// WithAutoCreateTopic makes publishing to a topic which doesn't exist create it with c, then try again once. Without
+// it, publishing to such a topic fails with an error matching ErrTopicNotFound.
+func WithAutoCreateTopic(c TopicCreator) ClientOption {
+	return func(lc *StreamClient) {
+		lc.topicCreator = c
+	}
+}
+
+// createTopicIfMissing creates topic if err tells it doesn't exist and a TopicCreator is set, returning whether the
+// failed operation may be attempted again.
+func (lc *StreamClient) createTopicIfMissing(ctx context.Context, topic string, err error) (bool, error) {
+	if lc.topicCreator == nil || !errors.Is(err, ErrTopicNotFound) {
+		return false, nil
+	}
+	lc.log.Info("creating missing topic", "topic", lc.topicPrefix+topic)
+	if err := lc.topicCreator.CreateTopic(ctx, lc.topicPrefix+topic); err != nil {
+		lc.log.Error(err, "unable to create topic", "topic", topic)
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// tracerName is the name of the tracer instrumenting the client.
+const tracerName = "github.com/projectriff/stream-client-go"
+
+// tracePropagator propagates trace context through the traceparent and tracestate extension attributes of events, as
+// specified by the CloudEvents distributed tracing extension.
+var tracePropagator = propagation.TraceContext{}
+
+// SetTracerProvider sets the provider of the tracer creating producer spans when publishing, and consumer spans around
+// each handler invocation. Trace context is propagated from producers to consumers through the traceparent and
+// tracestate extension attributes of events. The global provider of OpenTelemetry is used by default.
+func (lc *StreamClient) SetTracerProvider(tp trace.TracerProvider) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.tracerProvider = tp
+}
+
+// tracer returns the tracer instrumenting the client.
+func (lc *StreamClient) tracer() trace.Tracer {
+	lc.mu.Lock()
+	tp := lc.tracerProvider
+	lc.mu.Unlock()
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// startPublishSpan starts a producer span for publishing event to topic, and records its context in the extensions
+// of event, which are copied first so that the caller's headers are left untouched.
+func (lc *StreamClient) startPublishSpan(ctx context.Context, topic string, event *liiklus.LiiklusEvent) (context.Context, trace.Span) {
+	ctx, span := lc.tracer().Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "liiklus"),
+			attribute.String("messaging.destination", topic),
+			attribute.String("messaging.message_id", event.Id),
+		),
+	)
+	extensions := make(map[string]string, len(event.Extensions)+2)
+	for k, v := range event.Extensions {
+		extensions[k] = v
+	}
+	tracePropagator.Inject(ctx, propagation.MapCarrier(extensions))
+	event.Extensions = extensions
+	return ctx, span
+}
+
+// trace runs handle within a consumer span for msgs. A span handling a single message is a child of the span the
+// message was published in, if any, while a span handling a batch links to the spans of its messages.
+func (s *Subscription) trace(ctx context.Context, msgs []Message, handle func(ctx context.Context) error) error {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "liiklus"),
+			attribute.String("messaging.destination", msgs[0].Topic),
+			attribute.String("messaging.consumer_group", s.group),
+		),
+	}
+	if len(msgs) == 1 {
+		msg := msgs[0]
+		ctx = tracePropagator.Extract(ctx, propagation.MapCarrier(msg.Headers))
+		opts = append(opts, trace.WithAttributes(
+			attribute.String("messaging.message_id", msg.ID),
+			attribute.Int64("messaging.partition", int64(msg.Partition)),
+			attribute.Int64("messaging.offset", int64(msg.Offset)),
+		))
+	} else {
+		links := make([]trace.Link, 0, len(msgs))
+		for _, msg := range msgs {
+			sc := trace.SpanContextFromContext(tracePropagator.Extract(context.Background(), propagation.MapCarrier(msg.Headers)))
+			if sc.IsValid() {
+				links = append(links, trace.Link{SpanContext: sc})
+			}
+		}
+		opts = append(opts, trace.WithLinks(links...), trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(msgs))))
+	}
+	ctx, span := s.client.tracer().Start(ctx, msgs[0].Topic+" process", opts...)
+	err := handle(ctx)
+	endSpan(span, err)
+	return err
+}
+
+// endSpan ends span, recording err if not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"io"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// Transport carries the calls of a StreamClient to the gateway, for gateways which are not reached through a gRPC
+// connection, such as the RSocket API of liiklus. The gRPC call options passed to its methods may be ignored, and
+// errors should carry a gRPC status, as returned by status.Error, for the client to classify them: in particular,
+// errors caused by the gateway being unreachable should have the Unavailable code. Close is called when the client is
+// closed.
+type Transport interface {
+	liiklus.LiiklusServiceClient
+	io.Closer
+}
+
+// TransportDialer connects to gateway, as passed to NewStreamClient. The context bounds the time spent connecting.
+type TransportDialer func(ctx context.Context, gateway string) (Transport, error)
+
+// WithTransport connects to the gateway with dial, rather than with gRPC or grpc-web.
+func WithTransport(dial TransportDialer) ClientOption {
+	return func(lc *StreamClient) {
+		lc.dialTransport = dial
+	}
+}
+
+var _ Transport = (*grpcWebClient)(nil)
+var _ Transport = (*rsocketClient)(nil)
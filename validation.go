@@ -0,0 +1,100 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"fmt"
+)
+
+// Validator checks that payloads are well formed, for example against a JSON Schema, so that malformed events are
+// rejected before they pollute the stream.
+type Validator interface {
+	// Validate returns an error describing why payload, of the given content type, is invalid, or nil if it is valid.
+	Validate(contentType string, payload []byte) error
+}
+
+// ValidatorFunc adapts a function to a Validator.
+type ValidatorFunc func(contentType string, payload []byte) error
+
+func (f ValidatorFunc) Validate(contentType string, payload []byte) error {
+	return f(contentType, payload)
+}
+
+// ValidationError is returned when publishing an invalid payload, and reported to the EventErrHandler of subscriptions
+// validating the messages they read.
+type ValidationError struct {
+	// ID is the ID of the invalid event, if known.
+	ID string
+	// Err describes why the payload is invalid.
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("invalid payload: %v", e.Err)
+	}
+	return fmt.Sprintf("invalid payload for event %s: %v", e.ID, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// SetValidator makes the client validate the payloads it publishes with v, rejecting invalid ones with a
+// ValidationError. Subscriptions created with WithValidation also validate the payloads they read. A nil validator
+// disables validation.
+func (lc *StreamClient) SetValidator(v Validator) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.validator = v
+}
+
+// validate checks the payload of an event with the validator of the client, if any.
+func (lc *StreamClient) validate(id string, contentType string, payload []byte) error {
+	lc.mu.Lock()
+	v := lc.validator
+	lc.mu.Unlock()
+	if v == nil {
+		return nil
+	}
+	if err := v.Validate(contentType, payload); err != nil {
+		return &ValidationError{ID: id, Err: err}
+	}
+	return nil
+}
+
+// WithValidation validates the payloads of messages read by the subscription with the validator of the client, see
+// StreamClient.SetValidator. Invalid messages are reported to the EventErrHandler as a ValidationError, then skipped
+// and committed.
+func WithValidation() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.validate = true
+	}
+}
+
+// reportInvalid reports whether msg is invalid, in which case it has been reported to the EventErrHandler.
+func (s *Subscription) reportInvalid(msg Message) bool {
+	if !s.options.validate {
+		return false
+	}
+	err := s.client.validate(msg.ID, msg.ContentType, msg.Payload)
+	if err == nil {
+		return false
+	}
+	s.fail(s.errs, s.errorAt(PhaseDecode, msg.Topic, msg.Partition, msg.Offset, err))
+	return true
+}
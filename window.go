@@ -0,0 +1,243 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Windows describes how events are grouped into time windows, according to the time they happened at, or the time
+// they were recorded by the broker if they have none. Windows of Size start every Slide, tumbling windows having the
+// same size and slide, hence not overlapping, and sliding windows having a slide smaller than their size, hence
+// overlapping. Windows are aligned on the zero time, so that windows of an hour start on the hour.
+type Windows struct {
+	Size  time.Duration
+	Slide time.Duration
+	// Grace is the time during which events are accepted after the end of their window, to account for events
+	// published out of order.
+	Grace time.Duration
+}
+
+// TumblingWindows returns non overlapping windows of the given size.
+func TumblingWindows(size time.Duration) Windows {
+	return Windows{Size: size, Slide: size}
+}
+
+// SlidingWindows returns windows of the given size starting every slide.
+func SlidingWindows(size time.Duration, slide time.Duration) Windows {
+	return Windows{Size: size, Slide: slide}
+}
+
+// startsOf returns the start of the windows t belongs to, the latest one first.
+func (w Windows) startsOf(t time.Time) []time.Time {
+	var starts []time.Time
+	for start := t.Truncate(w.Slide); start.Add(w.Size).After(t); start = start.Add(-w.Slide) {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// Aggregator folds the messages of a window into an accumulator of type A.
+type Aggregator[A any] struct {
+	// Initial returns the accumulator of a window before any message was added to it.
+	Initial func() A
+	// Add returns the accumulator of a window once msg has been added to it.
+	Add func(acc A, msg Message) (A, error)
+}
+
+// Count returns an Aggregator counting messages.
+func Count() Aggregator[int] {
+	return Reduce(0, func(count int, msg Message) (int, error) {
+		return count + 1, nil
+	})
+}
+
+// Sum returns an Aggregator summing the values extracted from messages by value.
+func Sum(value func(msg Message) (float64, error)) Aggregator[float64] {
+	return Reduce(0, func(sum float64, msg Message) (float64, error) {
+		v, err := value(msg)
+		return sum + v, err
+	})
+}
+
+// Reduce returns an Aggregator folding messages with f, starting from initial.
+func Reduce[A any](initial A, f func(acc A, msg Message) (A, error)) Aggregator[A] {
+	return Aggregator[A]{
+		Initial: func() A {
+			return initial
+		},
+		Add: f,
+	}
+}
+
+// WindowResult is the result of the aggregation of the messages of a key within a window, as published by a
+// WindowedAggregation.
+type WindowResult[A any] struct {
+	// Key is the key of the aggregated messages, or empty for messages without a key.
+	Key   string    `json:"key,omitempty"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Value A         `json:"value"`
+}
+
+// windowKey designates the window of a key.
+type windowKey struct {
+	key   string
+	start time.Time
+}
+
+// WindowedAggregation aggregates the messages of an input stream per key and time window, and publishes the result of
+// each window to an output stream as JSON, under the same key, once the window is over. A window is over once an
+// event which happened after its end, plus the grace period, has been read, or once the input stream has been idle
+// for the size of a window plus the grace period. Events read once their window is over are dropped. This suits
+// simple analytics, such as counting events per minute: windows are held in memory, and offsets are committed as
+// messages are aggregated, so windows in progress are lost when the aggregation is stopped, as are the results which
+// fail to be published.
+type WindowedAggregation[A any] struct {
+	in      *StreamClient
+	out     *StreamClient
+	group   string
+	windows Windows
+	agg     Aggregator[A]
+
+	mu        sync.Mutex
+	open      map[windowKey]A
+	watermark time.Time
+	lastRead  time.Time
+}
+
+// NewWindowedAggregation creates a WindowedAggregation of the messages of in, tracking its position in in as part of
+// group, and publishing results to out.
+func NewWindowedAggregation[A any](in *StreamClient, out *StreamClient, group string, windows Windows, agg Aggregator[A]) *WindowedAggregation[A] {
+	return &WindowedAggregation[A]{
+		in:      in,
+		out:     out,
+		group:   group,
+		windows: windows,
+		agg:     agg,
+		open:    make(map[windowKey]A),
+	}
+}
+
+// Start starts aggregating messages, which goes on until the returned Subscription is stopped. Aggregation starts from
+// the beginning of the input stream when the group has no position yet. Optional behavior of the underlying
+// subscription may be configured by passing SubscribeOptions, as for Subscribe. Failures to publish results of windows
+// over because the input stream is idle are reported to e.
+func (a *WindowedAggregation[A]) Start(ctx context.Context, e EventErrHandler, opts ...SubscribeOption) (*Subscription, error) {
+	if a.windows.Size <= 0 || a.windows.Slide <= 0 {
+		return nil, errors.New("windows must have a positive size and slide")
+	}
+	sub, err := a.in.SubscribeMessages(ctx, a.group, true, a.aggregate, e, opts...)
+	if err != nil {
+		return nil, err
+	}
+	go a.flushIdle(sub, e)
+	return sub, nil
+}
+
+// aggregate adds msg to the windows it belongs to, and publishes the results of the windows it closes.
+func (a *WindowedAggregation[A]) aggregate(ctx context.Context, msg Message) error {
+	happened := msg.happened()
+	a.mu.Lock()
+	a.lastRead = time.Now()
+	for _, start := range a.windows.startsOf(happened) {
+		if a.over(start, a.watermark) {
+			break
+		}
+		wk := windowKey{key: string(msg.Key), start: start}
+		acc, ok := a.open[wk]
+		if !ok {
+			acc = a.agg.Initial()
+		}
+		acc, err := a.agg.Add(acc, msg)
+		if err != nil {
+			a.mu.Unlock()
+			return err
+		}
+		a.open[wk] = acc
+	}
+	if happened.After(a.watermark) {
+		a.watermark = happened
+	}
+	results := a.close(a.watermark)
+	a.mu.Unlock()
+	return a.publish(ctx, results)
+}
+
+// flushIdle publishes the results of the windows over because the input stream has been idle, until sub terminates.
+func (a *WindowedAggregation[A]) flushIdle(sub *Subscription, e EventErrHandler) {
+	ticker := time.NewTicker(a.windows.Slide)
+	defer ticker.Stop()
+	idle := a.windows.Size + a.windows.Grace
+	for {
+		select {
+		case <-sub.Done():
+			return
+		case <-ticker.C:
+		}
+		a.mu.Lock()
+		var results []WindowResult[A]
+		if !a.lastRead.IsZero() && time.Since(a.lastRead) >= idle {
+			results = a.close(a.watermark.Add(idle))
+		}
+		a.mu.Unlock()
+		if err := a.publish(sub.ctx, results); err != nil && sub.ctx.Err() == nil {
+			e(sub.Cancel, err)
+		}
+	}
+}
+
+// over reports whether the window starting at start is over, as of watermark.
+func (a *WindowedAggregation[A]) over(start time.Time, watermark time.Time) bool {
+	return !start.Add(a.windows.Size + a.windows.Grace).After(watermark)
+}
+
+// close removes the windows over as of watermark, returning their results ordered by end and key.
+func (a *WindowedAggregation[A]) close(watermark time.Time) []WindowResult[A] {
+	var results []WindowResult[A]
+	for wk, acc := range a.open {
+		if a.over(wk.start, watermark) {
+			results = append(results, WindowResult[A]{Key: wk.key, Start: wk.start, End: wk.start.Add(a.windows.Size), Value: acc})
+			delete(a.open, wk)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		r, s := results[i], results[j]
+		return r.End.Before(s.End) || r.End.Equal(s.End) && r.Key < s.Key
+	})
+	return results
+}
+
+// publish publishes results to the output stream.
+func (a *WindowedAggregation[A]) publish(ctx context.Context, results []WindowResult[A]) error {
+	for _, result := range results {
+		var key io.Reader
+		if result.Key != "" {
+			key = strings.NewReader(result.Key)
+		}
+		if _, err := a.out.PublishValue(ctx, result, key, "application/json", nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
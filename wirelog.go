@@ -0,0 +1,137 @@
+/*
+ * Copyright 2019 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+
+	"github.com/projectriff/stream-client-go/pkg/liiklus"
+)
+
+// Redactor returns a version of a message exchanged with the gateway fit for logging, for example with sensitive
+// payloads masked. It must not modify m.
+type Redactor func(m proto.Message) proto.Message
+
+// redacted replaces the redacted bytes of messages.
+var redacted = []byte("REDACTED")
+
+// RedactPayloads is a Redactor masking the keys and payloads of events and records.
+func RedactPayloads(m proto.Message) proto.Message {
+	switch m := m.(type) {
+	case *liiklus.PublishRequest:
+		c := proto.Clone(m).(*liiklus.PublishRequest)
+		c.Key, c.Value = redact(c.Key), redact(c.Value)
+		if event := c.GetLiiklusEvent(); event != nil {
+			event.Data = redact(event.Data)
+		}
+		return c
+	case *liiklus.ReceiveReply:
+		c := proto.Clone(m).(*liiklus.ReceiveReply)
+		if record := c.GetRecord(); record != nil {
+			record.Key, record.Value = redact(record.Key), redact(record.Value)
+		}
+		if record := c.GetLiiklusEventRecord(); record != nil {
+			record.Key = redact(record.Key)
+			if record.Event != nil {
+				record.Event.Data = redact(record.Event.Data)
+			}
+		}
+		return c
+	}
+	return m
+}
+
+// redact returns the replacement of b, keeping empty values as is.
+func redact(b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	return redacted
+}
+
+// WithDebugLogging logs each message sent to or received from the gateway, such as publish requests, receive replies
+// and acks, at debug level. Messages are passed through redact first, if not nil, which can be RedactPayloads.
+func WithDebugLogging(redact Redactor) ClientOption {
+	return func(lc *StreamClient) {
+		wire := &wireLogger{client: lc, redact: redact}
+		lc.dialOptions = append(lc.dialOptions,
+			grpc.WithChainUnaryInterceptor(wire.unary),
+			grpc.WithChainStreamInterceptor(wire.stream),
+		)
+	}
+}
+
+// wireLogger logs the messages exchanged with the gateway.
+type wireLogger struct {
+	client *StreamClient
+	redact Redactor
+}
+
+// logMessage logs m, exchanged in the given direction over method.
+func (w *wireLogger) logMessage(direction string, method string, m interface{}) {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return
+	}
+	if w.redact != nil {
+		pm = w.redact(pm)
+	}
+	w.client.log.Debug(direction, "method", method, "message", proto.CompactTextString(pm))
+}
+
+func (w *wireLogger) unary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	w.logMessage("sent", method, req)
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err != nil {
+		w.client.log.Debug("call failed", "method", method, "error", err)
+		return err
+	}
+	w.logMessage("received", method, reply)
+	return nil
+}
+
+func (w *wireLogger) stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		w.client.log.Debug("call failed", "method", method, "error", err)
+		return nil, err
+	}
+	return &loggedStream{ClientStream: s, wire: w, method: method}, nil
+}
+
+// loggedStream is a grpc.ClientStream logging the messages it exchanges.
+type loggedStream struct {
+	grpc.ClientStream
+	wire   *wireLogger
+	method string
+}
+
+func (s *loggedStream) SendMsg(m interface{}) error {
+	s.wire.logMessage("sent", s.method, m)
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *loggedStream) RecvMsg(m interface{}) error {
+	if err := s.ClientStream.RecvMsg(m); err != nil {
+		return err
+	}
+	s.wire.logMessage("received", s.method, m)
+	return nil
+}